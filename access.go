@@ -0,0 +1,121 @@
+// Package userprefs provides actor-aware access control for preference writes and listings.
+package userprefs
+
+import (
+	"context"
+	"fmt"
+)
+
+// ActorRole identifies the kind of caller performing an operation.
+type ActorRole string
+
+// Supported actor roles.
+const (
+	// ActorUser is an end user changing their own preferences, e.g. through a settings page.
+	ActorUser ActorRole = "user"
+	// ActorService is a trusted backend process, such as a billing or provisioning service.
+	ActorService ActorRole = "service"
+	// ActorAdmin is an administrator acting on behalf of a user or the organization.
+	ActorAdmin ActorRole = "admin"
+)
+
+// Actor describes who is performing an operation. It is attached to a context with
+// WithActor and checked against a definition's ReadOnly, WritableBy, and Hidden flags.
+type Actor struct {
+	// ID optionally identifies the actor, e.g. a user or service account ID. It is not used
+	// for access decisions but is available to callers reading the actor back from a context.
+	ID string `json:"id,omitempty"`
+	// Role is the kind of actor. An empty Role is treated as ActorUser.
+	Role ActorRole `json:"role"`
+}
+
+// actorContextKey is the context key under which the current Actor is stored.
+type actorContextKey struct{}
+
+// WithActor returns a copy of ctx carrying the given actor.
+// Operations without an actor are treated as coming from a trusted service; an actor with an
+// empty Role is treated as an ActorUser.
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorContextKey{}, actor)
+}
+
+// ActorFromContext returns the actor stored in ctx by WithActor. The boolean result is
+// false if ctx carries no actor.
+func ActorFromContext(ctx context.Context) (Actor, bool) {
+	actor, ok := ctx.Value(actorContextKey{}).(Actor)
+	return actor, ok
+}
+
+// actorRole returns the role of the actor in ctx, defaulting to ActorService when none is set.
+// An actor without a Role is treated as an ActorUser, so that a partially filled Actor never
+// gains the privileges of a service.
+func actorRole(ctx context.Context) ActorRole {
+	actor, ok := ActorFromContext(ctx)
+	if !ok {
+		return ActorService
+	}
+	if actor.Role == "" {
+		return ActorUser
+	}
+	return actor.Role
+}
+
+// WithHiddenFiltering is a functional option that makes GetAll and GetByCategory omit
+// preferences whose definition is Hidden when the actor in the context is an ActorUser.
+// Without it, hidden preferences are returned to every caller and Hidden is left for
+// presentation layers to interpret.
+func WithHiddenFiltering() Option {
+	return func(c *Config) {
+		c.filterHidden = true
+	}
+}
+
+// validateAccess checks that def's WritableBy lists only known roles.
+func validateAccess(def PreferenceDefinition) error {
+	for _, role := range def.WritableBy {
+		switch role {
+		case ActorUser, ActorService, ActorAdmin:
+		default:
+			return fmt.Errorf("%w: preference '%s' has unknown WritableBy role '%s'", ErrInvalidInput, def.Key, role)
+		}
+	}
+	return nil
+}
+
+// checkWriteAccess reports whether the actor in ctx may set or delete def.
+// ReadOnly preferences cannot be changed by end users; WritableBy, when set,
+// restricts writes to the listed roles.
+func checkWriteAccess(ctx context.Context, def PreferenceDefinition) error {
+	role := actorRole(ctx)
+	if def.ReadOnly && role == ActorUser {
+		return fmt.Errorf("%w: preference '%s' is read-only", ErrForbidden, def.Key)
+	}
+	if len(def.WritableBy) == 0 {
+		return nil
+	}
+	for _, allowed := range def.WritableBy {
+		if allowed == role {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: preference '%s' is not writable by %s actors", ErrForbidden, def.Key, role)
+}
+
+// CanWrite reports whether the actor in ctx may set or delete def, e.g. to render a read-only
+// preference as disabled on a settings screen. Manager.Set and Delete enforce the same rules.
+func CanWrite(ctx context.Context, def PreferenceDefinition) bool {
+	return checkWriteAccess(ctx, def) == nil
+}
+
+// omitHidden removes Hidden preferences from prefs when hidden filtering is enabled and
+// the actor in ctx is an end user. prefs is modified in place.
+func (m *Manager) omitHidden(ctx context.Context, prefs map[string]*Preference) {
+	if !m.config.filterHidden || actorRole(ctx) != ActorUser {
+		return
+	}
+	for key := range prefs {
+		if def, ok := m.contextDefinition(ctx, key); ok && def.Hidden {
+			delete(prefs, key)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package userprefs
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// accessDefinitions returns a writable, a read-only, an admin-managed, and a hidden preference.
+func accessDefinitions() []PreferenceDefinition {
+	return []PreferenceDefinition{
+		{Key: "theme", Type: StringType, DefaultValue: "light"},
+		{Key: "plan_tier", Type: StringType, DefaultValue: "free", ReadOnly: true},
+		{Key: "data_retention_days", Type: IntType, DefaultValue: 30, WritableBy: []ActorRole{ActorAdmin}},
+		{Key: "experiment_bucket", Type: StringType, DefaultValue: "a", Hidden: true},
+	}
+}
+
+func TestManager_Access_Writes(t *testing.T) {
+	mgr := newTestManager(t, accessDefinitions())
+	userCtx := WithActor(context.Background(), Actor{ID: "u1", Role: ActorUser})
+	serviceCtx := WithActor(context.Background(), Actor{ID: "billing", Role: ActorService})
+	adminCtx := WithActor(context.Background(), Actor{ID: "root", Role: ActorAdmin})
+	// An actor without a role is an unprivileged user, not a service.
+	noRoleCtx := WithActor(context.Background(), Actor{ID: "u1"})
+
+	testCases := []struct {
+		name    string
+		ctx     context.Context
+		key     string
+		value   interface{}
+		allowed bool
+	}{
+		{"user writes regular key", userCtx, "theme", "dark", true},
+		{"user writes read-only key", userCtx, "plan_tier", "pro", false},
+		{"service writes read-only key", serviceCtx, "plan_tier", "pro", true},
+		{"admin writes read-only key", adminCtx, "plan_tier", "team", true},
+		{"user writes admin-only key", userCtx, "data_retention_days", 7, false},
+		{"service writes admin-only key", serviceCtx, "data_retention_days", 7, false},
+		{"admin writes admin-only key", adminCtx, "data_retention_days", 90, true},
+		{"no actor writes read-only key", context.Background(), "plan_tier", "free", true},
+		{"actor without role writes regular key", noRoleCtx, "theme", "dark", true},
+		{"actor without role writes read-only key", noRoleCtx, "plan_tier", "pro", false},
+		{"actor without role writes admin-only key", noRoleCtx, "data_retention_days", 7, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			def, _ := mgr.GetDefinition(tc.key)
+			if got := CanWrite(tc.ctx, def); got != tc.allowed {
+				t.Errorf("Expected CanWrite to report %v, got %v", tc.allowed, got)
+			}
+
+			err := mgr.Set(tc.ctx, "u1", tc.key, tc.value)
+			if tc.allowed && err != nil {
+				t.Fatalf("Expected Set to succeed, got %v", err)
+			}
+			if !tc.allowed && !errors.Is(err, ErrForbidden) {
+				t.Fatalf("Expected ErrForbidden from Set, got %v", err)
+			}
+
+			err = mgr.Delete(tc.ctx, "u1", tc.key)
+			if tc.allowed && err != nil {
+				t.Fatalf("Expected Delete to succeed, got %v", err)
+			}
+			if !tc.allowed && !errors.Is(err, ErrForbidden) {
+				t.Fatalf("Expected ErrForbidden from Delete, got %v", err)
+			}
+		})
+	}
+
+	err := mgr.SetMany(userCtx, "u1", map[string]interface{}{"theme": "dark", "plan_tier": "pro"})
+	if !errors.Is(err, ErrForbidden) {
+		t.Fatalf("Expected ErrForbidden from SetMany, got %v", err)
+	}
+	if pref, _ := mgr.Get(userCtx, "u1", "theme"); pref.Value != "light" {
+		t.Errorf("Expected forbidden SetMany to write nothing, got theme %v", pref.Value)
+	}
+}
+
+func TestManager_Access_HiddenFiltering(t *testing.T) {
+	ctx := context.Background()
+	userCtx := WithActor(ctx, Actor{Role: ActorUser})
+
+	testCases := []struct {
+		name       string
+		opts       []Option
+		ctx        context.Context
+		wantHidden bool
+	}{
+		{"filtering disabled", nil, userCtx, true},
+		{"filtering for user", []Option{WithHiddenFiltering()}, userCtx, false},
+		{"filtering for admin", []Option{WithHiddenFiltering()}, WithActor(ctx, Actor{Role: ActorAdmin}), true},
+		{"filtering without actor", []Option{WithHiddenFiltering()}, ctx, true},
+		{"filtering for actor without role", []Option{WithHiddenFiltering()}, WithActor(ctx, Actor{ID: "u1"}), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mgr := newTestManager(t, accessDefinitions(), tc.opts...)
+			prefs, err := mgr.GetAll(tc.ctx, "u1")
+			if err != nil {
+				t.Fatalf("GetAll failed: %v", err)
+			}
+			if _, ok := prefs["experiment_bucket"]; ok != tc.wantHidden {
+				t.Errorf("Expected hidden key present=%v, got %v", tc.wantHidden, ok)
+			}
+			if _, ok := prefs["theme"]; !ok {
+				t.Error("Expected visible key to be returned")
+			}
+		})
+	}
+}
+
+func TestManager_DefinePreference_InvalidWritableBy(t *testing.T) {
+	mgr := newTestManager(t, nil)
+	err := mgr.DefinePreference(PreferenceDefinition{Key: "k", Type: StringType, WritableBy: []ActorRole{"superuser"}})
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("Expected ErrInvalidInput, got %v", err)
+	}
+}
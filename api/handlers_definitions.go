@@ -5,6 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/CreativeUnicorns/userprefs"
 	"github.com/go-chi/chi/v5"
@@ -25,8 +28,9 @@ func (s *Server) handleDefinePreference(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := s.manager.DefinePreference(def); err != nil {
-		if errors.Is(err, userprefs.ErrInvalidType) || errors.Is(err, userprefs.ErrValidation) {
+	if err := s.tenant(r).DefinePreference(def); err != nil {
+		if errors.Is(err, userprefs.ErrInvalidType) || errors.Is(err, userprefs.ErrValidation) ||
+			errors.Is(err, userprefs.ErrInvalidKey) || errors.Is(err, userprefs.ErrInvalidInput) {
 			s.respondWithError(w, r, http.StatusBadRequest, "Invalid preference definition", err)
 		} else if errors.Is(err, userprefs.ErrAlreadyExists) {
 			// As per DESIGN.MD, POST to /definitions should return 409 if key exists.
@@ -44,18 +48,36 @@ func (s *Server) handleDefinePreference(w http.ResponseWriter, r *http.Request)
 	s.respondWithJSON(w, r, http.StatusCreated, def)
 }
 
-// handleGetDefinition handles fetching a specific preference definition.
+// handleGetDefinition handles fetching a specific preference definition. With an
+// Accept-Language header, its presentation metadata is localized (see localizeDefinition).
 func (s *Server) handleGetDefinition(w http.ResponseWriter, r *http.Request) {
 	key := chi.URLParam(r, "key")
-	def, found := s.manager.GetDefinition(key)
+	def, found := s.tenant(r).GetDefinition(key)
 	if !found {
 		s.respondWithError(w, r, http.StatusNotFound, "Preference definition not found", nil)
 		return
 	}
-	s.respondWithJSON(w, r, http.StatusOK, def)
+	localized, tag := localizeDefinition(r, &def)
+	w.Header().Add("Vary", "Accept-Language")
+	if tag != "" {
+		w.Header().Set("Content-Language", tag)
+	}
+	s.respondWithJSON(w, r, http.StatusOK, localized)
+}
+
+// handleDeleteDefinition handles removing a preference definition. Stored values of the key
+// are kept (see Manager.DeleteDefinition).
+func (s *Server) handleDeleteDefinition(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	if err := s.tenant(r).DeleteDefinition(key); err != nil {
+		s.respondWithPreferenceError(w, r, "Failed to delete preference definition", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
-// handleListDefinitions handles fetching all preference definitions.
+// handleListDefinitions handles fetching all preference definitions, in display order. With an
+// Accept-Language header, their presentation metadata is localized (see localizeDefinition).
 func (s *Server) handleListDefinitions(w http.ResponseWriter, r *http.Request) {
 	defs, err := s.manager.GetAllDefinitions(r.Context()) // Pass context
 	if err != nil {
@@ -68,9 +90,88 @@ func (s *Server) handleListDefinitions(w http.ResponseWriter, r *http.Request) {
 		// but good for safety.
 		defs = []*userprefs.PreferenceDefinition{}
 	}
+	for i, def := range defs {
+		defs[i], _ = localizeDefinition(r, def)
+	}
+	w.Header().Add("Vary", "Accept-Language")
 	s.respondWithJSON(w, r, http.StatusOK, defs)
 }
 
+// localizeDefinition applies the translation of def best matching the request's
+// Accept-Language header, returning the localized copy and the tag of the translation used.
+// Without the header, def is returned unchanged with all of its Translations, e.g. for admin
+// tools editing them. A header naming no acceptable language, such as "*", yields the
+// untranslated metadata.
+func localizeDefinition(r *http.Request, def *userprefs.PreferenceDefinition) (*userprefs.PreferenceDefinition, string) {
+	header := r.Header.Get("Accept-Language")
+	if strings.TrimSpace(header) == "" {
+		return def, ""
+	}
+	localized, tag := def.Localize(acceptedLanguages(header)...)
+	return &localized, tag
+}
+
+// acceptedLanguages returns the language tags of an Accept-Language header, most preferred
+// first. The wildcard and tags with a quality of 0 or a malformed quality are left out.
+func acceptedLanguages(header string) []string {
+	type weightedTag struct {
+		tag     string
+		quality float64
+	}
+	var tags []weightedTag
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		tag := strings.TrimSpace(params[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+		quality, ok := languageQuality(params[1:])
+		if ok && quality > 0 {
+			tags = append(tags, weightedTag{tag: tag, quality: quality})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].quality > tags[j].quality })
+
+	locales := make([]string, len(tags))
+	for i, t := range tags {
+		locales[i] = t.tag
+	}
+	return locales
+}
+
+// languageQuality returns the quality weight among the parameters of an Accept-Language
+// entry, 1 if there is none, and false if it is malformed or outside 0..1.
+func languageQuality(params []string) (float64, bool) {
+	for _, param := range params {
+		name, value, _ := strings.Cut(param, "=")
+		if !strings.EqualFold(strings.TrimSpace(name), "q") {
+			continue
+		}
+		quality, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || quality < 0 || quality > 1 {
+			return 0, false
+		}
+		return quality, true
+	}
+	return 1, true
+}
+
+// handleGetPreferenceStats handles fetching the value distribution of a preference.
+func (s *Server) handleGetPreferenceStats(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	stats, err := s.manager.Stats(r.Context(), key)
+	if err != nil {
+		s.respondWithPreferenceError(w, r, "Failed to get preference statistics", err)
+		return
+	}
+	s.respondWithJSON(w, r, http.StatusOK, stats)
+}
+
+// tenant returns the Manager scoped to the request's tenant (see TenantMiddleware).
+func (s *Server) tenant(r *http.Request) *userprefs.TenantManager {
+	return s.manager.Tenant(userprefs.TenantFromContext(r.Context()))
+}
+
 // respondWithError is a helper to send JSON error responses.
 func (s *Server) respondWithError(w http.ResponseWriter, r *http.Request, status int, message string, err error) {
 	resp := map[string]interface{}{
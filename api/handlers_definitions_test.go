@@ -0,0 +1,170 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/CreativeUnicorns/userprefs"
+	"github.com/CreativeUnicorns/userprefs/storage"
+)
+
+func TestGetPreferenceStats(t *testing.T) {
+	srv, mgr := newTestServer(t, constrainedDefinitions())
+	for userID, values := range map[string]map[string]interface{}{
+		"u1": {"theme": "dark"},
+		"u2": {"theme": "dark", "volume": 3},
+		"u3": {"nickname": "ada"},
+	} {
+		for key, value := range values {
+			if err := mgr.Set(t.Context(), userID, key, value); err != nil {
+				t.Fatalf("Set(%s, %s) failed: %v", userID, key, err)
+			}
+		}
+	}
+
+	rec := do(srv, http.MethodGet, "/api/v1/definitions/theme/stats", "", nil)
+	expectStatus(t, rec, http.StatusOK)
+	var stats userprefs.PreferenceStats
+	decodeBody(t, rec, &stats)
+	want := userprefs.PreferenceStats{
+		Key:           "theme",
+		DefaultValue:  "light",
+		ExplicitCount: 2,
+		DefaultCount:  1,
+		Values:        []userprefs.ValueCount{{Value: "dark", Count: 2}},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("Expected %+v, got %+v", want, stats)
+	}
+
+	expectStatus(t, do(srv, http.MethodGet, "/api/v1/definitions/missing/stats", "", nil), http.StatusNotFound)
+}
+
+func TestGetPreferenceStats_NotSupported(t *testing.T) {
+	// Hiding the MemoryStorage behind the Storage interface leaves out StatsProvider.
+	srv, _ := newTestServer(t, constrainedDefinitions(),
+		userprefs.WithStorage(struct{ userprefs.Storage }{storage.NewMemoryStorage()}))
+
+	rec := do(srv, http.MethodGet, "/api/v1/definitions/theme/stats", "", nil)
+	expectStatus(t, rec, http.StatusNotImplemented)
+	var resp errorResponse
+	decodeBody(t, rec, &resp)
+	if resp.Error.Message != "Failed to get preference statistics" {
+		t.Errorf("Expected the stats error message, got %q", resp.Error.Message)
+	}
+}
+
+func TestDeleteDefinition(t *testing.T) {
+	srv, mgr := newTestServer(t, []userprefs.PreferenceDefinition{
+		{Key: "notifications", Type: userprefs.BoolType, DefaultValue: true},
+		{Key: "sound", Type: userprefs.StringType, DefaultValue: "chime", DependsOn: &userprefs.Dependency{Key: "notifications"}},
+		{Key: "theme", Type: userprefs.StringType, DefaultValue: "light"},
+	})
+	if err := mgr.Set(t.Context(), "u1", "theme", "dark"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	expectStatus(t, do(srv, http.MethodDelete, "/api/v1/definitions/theme", "", nil), http.StatusNoContent)
+	expectStatus(t, do(srv, http.MethodGet, "/api/v1/definitions/theme", "", nil), http.StatusNotFound)
+	expectStatus(t, do(srv, http.MethodGet, "/api/v1/users/u1/preferences/theme", "", nil), http.StatusNotFound)
+
+	expectStatus(t, do(srv, http.MethodDelete, "/api/v1/definitions/theme", "", nil), http.StatusNotFound)
+
+	rec := do(srv, http.MethodDelete, "/api/v1/definitions/notifications", "", nil)
+	expectStatus(t, rec, http.StatusBadRequest)
+	expectStatus(t, do(srv, http.MethodGet, "/api/v1/definitions/notifications", "", nil), http.StatusOK)
+
+	// Stored values are kept and visible again once the key is redefined.
+	rec = do(srv, http.MethodPost, "/api/v1/definitions", `{"key": "theme", "type": "string", "default_value": "light"}`, nil)
+	expectStatus(t, rec, http.StatusCreated)
+	var pref userprefs.Preference
+	decodeBody(t, do(srv, http.MethodGet, "/api/v1/users/u1/preferences/theme", "", nil), &pref)
+	if pref.Value != "dark" {
+		t.Errorf("Expected the stored value to be kept, got %v", pref.Value)
+	}
+}
+
+func TestAcceptedLanguages(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   []string
+	}{
+		{name: "empty", header: "", want: []string{}},
+		{name: "single", header: "de-AT", want: []string{"de-AT"}},
+		{name: "ordered by quality", header: "en;q=0.5, de-AT, fr;q=0.8", want: []string{"de-AT", "fr", "en"}},
+		{name: "equal quality keeps order", header: "fr;q=0.7, de;q=0.7", want: []string{"fr", "de"}},
+		{name: "zero quality", header: "de, en;q=0, fr;q=0.000", want: []string{"de"}},
+		{name: "wildcard", header: "*, de;q=0.5, *;q=0.9", want: []string{"de"}},
+		{name: "malformed weights", header: "de;q=high, en;q=1.5, fr;q=-0.1, es;q=, it;q=0.4", want: []string{"it"}},
+		{name: "other parameters", header: "de;level=1;q=0.3, en;q=0.6;level=2, fr;level=1", want: []string{"fr", "en", "de"}},
+		{name: "case and spacing", header: " de ; Q = 0.2 ,en ;q=0.9", want: []string{"en", "de"}},
+		{name: "empty entries", header: ",de,,", want: []string{"de"}},
+		{name: "parameter after zero quality", header: "de;q=0;level=1, en", want: []string{"en"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := acceptedLanguages(tt.header); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("acceptedLanguages(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalizeDefinition(t *testing.T) {
+	def := &userprefs.PreferenceDefinition{
+		Key:          "theme",
+		Type:         userprefs.EnumType,
+		DefaultValue: "light",
+		Label:        "Theme",
+		Translations: map[string]userprefs.Translation{
+			"de":    {Label: "Design"},
+			"fr-CA": {Label: "Thème (Canada)"},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		header    string
+		wantLabel string
+		wantTag   string
+	}{
+		{name: "no header", header: "", wantLabel: "Theme"},
+		{name: "exact", header: "de", wantLabel: "Design", wantTag: "de"},
+		{name: "region fallback", header: "de-AT", wantLabel: "Design", wantTag: "de"},
+		{name: "case-insensitive", header: "FR-ca", wantLabel: "Thème (Canada)", wantTag: "fr-CA"},
+		{name: "no fallback to a region", header: "fr", wantLabel: "Theme"},
+		{name: "preferred translation", header: "de;q=0.5, fr-CA;q=0.9", wantLabel: "Thème (Canada)", wantTag: "fr-CA"},
+		{name: "zero quality skipped", header: "de;q=0, es", wantLabel: "Theme"},
+		{name: "wildcard only", header: "*", wantLabel: "Theme"},
+		{name: "malformed weight skipped", header: "de;q=x, fr-CA;q=0.1", wantLabel: "Thème (Canada)", wantTag: "fr-CA"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptestRequest(tt.header)
+			localized, tag := localizeDefinition(r, def)
+			if localized.Label != tt.wantLabel || tag != tt.wantTag {
+				t.Errorf("Expected label %q with tag %q, got %q with %q", tt.wantLabel, tt.wantTag, localized.Label, tag)
+			}
+			if tt.header == "" && len(localized.Translations) != 2 {
+				t.Errorf("Expected the translations without Accept-Language, got %v", localized.Translations)
+			}
+			if tt.header != "" && localized.Translations != nil {
+				t.Errorf("Expected no translations in a localized definition, got %v", localized.Translations)
+			}
+		})
+	}
+}
+
+// httptestRequest returns a GET request with the given Accept-Language header, if any.
+func httptestRequest(acceptLanguage string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/definitions/theme", nil)
+	if acceptLanguage != "" {
+		r.Header.Set("Accept-Language", acceptLanguage)
+	}
+	return r
+}
@@ -0,0 +1,264 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/CreativeUnicorns/userprefs"
+	"github.com/go-chi/chi/v5"
+)
+
+// setPreferenceRequest is the body of PUT /users/{userID}/preferences/{key}.
+type setPreferenceRequest struct {
+	Value json.RawMessage `json:"value"`
+}
+
+// handleGetAllUserPreferences handles fetching all preferences of a user.
+func (s *Server) handleGetAllUserPreferences(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userID")
+	prefs, err := s.manager.GetAll(r.Context(), userID)
+	if err != nil {
+		s.respondWithPreferenceError(w, r, "Failed to get preferences", err)
+		return
+	}
+	s.respondWithJSON(w, r, http.StatusOK, prefs)
+}
+
+// handleGetUserPreference handles fetching a single preference of a user.
+func (s *Server) handleGetUserPreference(w http.ResponseWriter, r *http.Request) {
+	userID, key := chi.URLParam(r, "userID"), chi.URLParam(r, "key")
+	pref, err := s.manager.Get(r.Context(), userID, key)
+	if err != nil && pref == nil {
+		s.respondWithPreferenceError(w, r, "Failed to get preference", err)
+		return
+	}
+	// A non-nil preference alongside an error is a default served during a cache failure.
+	s.respondWithJSON(w, r, http.StatusOK, pref)
+}
+
+// handleSetUserPreference handles setting a single preference of a user.
+func (s *Server) handleSetUserPreference(w http.ResponseWriter, r *http.Request) {
+	userID, key := chi.URLParam(r, "userID"), chi.URLParam(r, "key")
+
+	if _, found := s.tenant(r).GetDefinition(key); !found {
+		s.respondWithError(w, r, http.StatusNotFound, "Preference definition not found", nil)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1024*1024)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	var req setPreferenceRequest
+	if err := decoder.Decode(&req); err != nil {
+		s.respondWithError(w, r, http.StatusBadRequest, "Invalid request payload", err)
+		return
+	}
+	value, err := decodePreferenceValue(req.Value)
+	if err != nil {
+		s.respondWithError(w, r, http.StatusBadRequest, "Invalid request payload", err)
+		return
+	}
+
+	if err := s.manager.Set(r.Context(), userID, key, value); err != nil {
+		s.respondWithPreferenceError(w, r, "Failed to set preference", err)
+		return
+	}
+
+	pref, err := s.manager.Get(r.Context(), userID, key)
+	if err != nil && pref == nil {
+		s.respondWithPreferenceError(w, r, "Failed to get preference", err)
+		return
+	}
+	s.respondWithJSON(w, r, http.StatusOK, pref)
+}
+
+// handleSetUserPreferences handles setting several preferences of a user at once.
+// The body is a JSON object mapping preference keys to values.
+func (s *Server) handleSetUserPreferences(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userID")
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1024*1024)
+	var raw map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		s.respondWithError(w, r, http.StatusBadRequest, "Invalid request payload", err)
+		return
+	}
+
+	values := make(map[string]interface{}, len(raw))
+	for key, data := range raw {
+		if _, found := s.tenant(r).GetDefinition(key); !found {
+			s.respondWithError(w, r, http.StatusNotFound, "Preference definition not found", userprefs.ErrPreferenceNotDefined)
+			return
+		}
+		value, err := decodePreferenceValue(data)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusBadRequest, "Invalid request payload", err)
+			return
+		}
+		values[key] = value
+	}
+
+	if err := s.manager.SetMany(r.Context(), userID, values); err != nil {
+		s.respondWithPreferenceError(w, r, "Failed to set preferences", err)
+		return
+	}
+	s.handleGetAllUserPreferences(w, r)
+}
+
+// handleDeleteUserPreference handles deleting a single preference of a user.
+func (s *Server) handleDeleteUserPreference(w http.ResponseWriter, r *http.Request) {
+	userID, key := chi.URLParam(r, "userID"), chi.URLParam(r, "key")
+	if err := s.manager.Delete(r.Context(), userID, key); err != nil {
+		s.respondWithPreferenceError(w, r, "Failed to delete preference", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDeleteUser handles erasing every preference of a user and responds with the erasure receipt.
+func (s *Server) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userID")
+	receipt, err := s.manager.DeleteUser(r.Context(), userID)
+	if err != nil {
+		s.respondWithPreferenceError(w, r, "Failed to delete user preferences", err)
+		return
+	}
+	s.respondWithJSON(w, r, http.StatusOK, receipt)
+}
+
+// resetPreferencesRequest is the body of POST /users/{userID}/preferences/reset. At most one
+// of Keys and Category may be set; an empty body resets all of the user's preferences.
+type resetPreferencesRequest struct {
+	Keys     []string `json:"keys,omitempty"`
+	Category string   `json:"category,omitempty"`
+}
+
+// handleResetUserPreferences handles restoring a user's preferences to their defaults and
+// responds with the user's preferences after the reset.
+func (s *Server) handleResetUserPreferences(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userID")
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1024*1024)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	var req resetPreferencesRequest
+	if err := decoder.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		s.respondWithError(w, r, http.StatusBadRequest, "Invalid request payload", err)
+		return
+	}
+	if len(req.Keys) > 0 && req.Category != "" {
+		s.respondWithError(w, r, http.StatusBadRequest, "Specify either keys or category, not both", nil)
+		return
+	}
+
+	var err error
+	switch {
+	case len(req.Keys) > 0:
+		err = s.manager.Reset(r.Context(), userID, req.Keys...)
+	case req.Category != "":
+		err = s.manager.ResetCategory(r.Context(), userID, req.Category)
+	default:
+		err = s.manager.ResetAll(r.Context(), userID)
+	}
+	if err != nil {
+		s.respondWithPreferenceError(w, r, "Failed to reset preferences", err)
+		return
+	}
+	s.handleGetAllUserPreferences(w, r)
+}
+
+// respondWithPreferenceError maps Manager errors to HTTP responses. Validation failures are
+// reported with a "fields" list holding one entry per failed rule.
+func (s *Server) respondWithPreferenceError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	var verrs userprefs.ValidationErrors
+	var verr *userprefs.ValidationError
+	switch {
+	case errors.As(err, &verrs):
+		s.respondWithValidationErrors(w, r, verrs)
+	case errors.As(err, &verr):
+		s.respondWithValidationErrors(w, r, userprefs.ValidationErrors{verr})
+	case errors.Is(err, userprefs.ErrPreferenceNotDefined):
+		s.respondWithError(w, r, http.StatusNotFound, "Preference definition not found", err)
+	case errors.Is(err, userprefs.ErrForbidden):
+		s.respondWithError(w, r, http.StatusForbidden, message, err)
+	case errors.Is(err, userprefs.ErrInvalidInput), errors.Is(err, userprefs.ErrInvalidValue):
+		s.respondWithError(w, r, http.StatusBadRequest, message, err)
+	case errors.Is(err, userprefs.ErrNotSupported):
+		s.respondWithError(w, r, http.StatusNotImplemented, message, err)
+	case errors.Is(err, userprefs.ErrCursorExpired):
+		s.respondWithError(w, r, http.StatusGone, message, err)
+	default:
+		s.respondWithError(w, r, http.StatusInternalServerError, message, err)
+	}
+}
+
+// fieldError is one entry of the "fields" list of a validation error response.
+type fieldError struct {
+	// Field is the preference key, followed by the path of the failing element, if any.
+	Field string `json:"field"`
+	// Code is the rule that failed, e.g. "type", "max", or "dependency".
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// respondWithValidationErrors sends a 400 response in the field-error format:
+//
+//	{"error": {"message": "Validation failed", "fields": [{"field": "volume", "code": "max", "message": "..."}]}}
+func (s *Server) respondWithValidationErrors(w http.ResponseWriter, r *http.Request, verrs userprefs.ValidationErrors) {
+	fields := make([]fieldError, len(verrs))
+	for i, verr := range verrs {
+		fields[i] = fieldError{Field: verr.Key + verr.Path, Code: verr.Rule, Message: verr.Message}
+	}
+	resp := map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": "Validation failed",
+			"fields":  fields,
+		},
+	}
+	s.logger.Debug("API validation error", "path", r.URL.Path, "error", verrs)
+	respondWithJSONRaw(w, http.StatusBadRequest, resp)
+}
+
+// decodePreferenceValue decodes a JSON value from a request body. JSON numbers become int for
+// integral values and float64 otherwise; the Manager converts them to the preference's type.
+func decodePreferenceValue(data json.RawMessage) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return convertJSONNumbers(value), nil
+}
+
+// convertJSONNumbers replaces json.Number values, recursively, with int or float64.
+func convertJSONNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return int(i)
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i := range v {
+			v[i] = convertJSONNumbers(v[i])
+		}
+		return v
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = convertJSONNumbers(v[k])
+		}
+		return v
+	default:
+		return value
+	}
+}
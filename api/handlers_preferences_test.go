@@ -0,0 +1,144 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/CreativeUnicorns/userprefs"
+)
+
+func floatPtr(f float64) *float64 { return &f }
+
+// constrainedDefinitions returns a bounded int, a string, and an enum preference.
+func constrainedDefinitions() []userprefs.PreferenceDefinition {
+	return []userprefs.PreferenceDefinition{
+		{Key: "volume", Type: userprefs.IntType, DefaultValue: 5, Min: floatPtr(0), Max: floatPtr(10), Category: "audio"},
+		{Key: "nickname", Type: userprefs.StringType, DefaultValue: "", Category: "profile"},
+		{Key: "theme", Type: userprefs.EnumType, DefaultValue: "light", AllowedValues: []interface{}{"light", "dark"}, Category: "appearance"},
+	}
+}
+
+func TestSetUserPreference_ConstraintFieldErrors(t *testing.T) {
+	srv, _ := newTestServer(t, constrainedDefinitions())
+
+	rec := do(srv, http.MethodPut, "/api/v1/users/u1/preferences/volume", `{"value": 50}`, nil)
+	expectStatus(t, rec, http.StatusBadRequest)
+	var resp errorResponse
+	decodeBody(t, rec, &resp)
+	want := []fieldError{{Field: "volume", Code: userprefs.RuleMax, Message: "50 is greater than maximum 10"}}
+	if resp.Error.Message != "Validation failed" || !reflect.DeepEqual(resp.Error.Fields, want) {
+		t.Errorf("Expected %+v, got %q with %+v", want, resp.Error.Message, resp.Error.Fields)
+	}
+}
+
+func TestSetUserPreferences_MultiFieldErrors(t *testing.T) {
+	srv, mgr := newTestServer(t, constrainedDefinitions())
+
+	rec := do(srv, http.MethodPatch, "/api/v1/users/u1/preferences", `{"volume": 50, "theme": "sepia", "nickname": 3}`, nil)
+	expectStatus(t, rec, http.StatusBadRequest)
+	var resp errorResponse
+	decodeBody(t, rec, &resp)
+	want := []fieldError{
+		{Field: "nickname", Code: userprefs.RuleType, Message: "expected string"},
+		{Field: "theme", Code: userprefs.RuleAllowedValues, Message: "value not in allowed values"},
+		{Field: "volume", Code: userprefs.RuleMax, Message: "50 is greater than maximum 10"},
+	}
+	if !reflect.DeepEqual(resp.Error.Fields, want) {
+		t.Errorf("Expected %+v, got %+v", want, resp.Error.Fields)
+	}
+
+	pref, err := mgr.Get(t.Context(), "u1", "volume")
+	if err != nil || pref.Value != 5 {
+		t.Errorf("Expected nothing to be written, got %v (%v)", pref, err)
+	}
+}
+
+func TestResetUserPreferences(t *testing.T) {
+	defs := append(constrainedDefinitions(),
+		userprefs.PreferenceDefinition{Key: "plan", Type: userprefs.StringType, DefaultValue: "free", ReadOnly: true})
+
+	tests := []struct {
+		name       string
+		body       string
+		header     http.Header
+		wantStatus int
+		// want holds the values expected after the request, which are all stored values
+		// when the request fails.
+		want map[string]interface{}
+	}{
+		{
+			name:       "keys",
+			body:       `{"keys": ["volume", "nickname"]}`,
+			wantStatus: http.StatusOK,
+			want:       map[string]interface{}{"volume": 5.0, "nickname": "", "theme": "dark", "plan": "pro"},
+		},
+		{
+			name:       "category",
+			body:       `{"category": "appearance"}`,
+			wantStatus: http.StatusOK,
+			want:       map[string]interface{}{"volume": 7.0, "nickname": "ada", "theme": "light", "plan": "pro"},
+		},
+		{
+			name:       "all",
+			wantStatus: http.StatusOK,
+			want:       map[string]interface{}{"volume": 5.0, "nickname": "", "theme": "light", "plan": "free"},
+		},
+		{
+			name:       "keys and category",
+			body:       `{"keys": ["volume"], "category": "audio"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "malformed body",
+			body:       `{"keys": "volume"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown key",
+			body:       `{"keys": ["volume", "missing"]}`,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "read-only key",
+			body:       `{"keys": ["volume", "plan"]}`,
+			header:     asActor(userprefs.ActorUser),
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, mgr := newTestServer(t, defs)
+			stored := map[string]interface{}{"volume": 7, "nickname": "ada", "theme": "dark", "plan": "pro"}
+			for key, value := range stored {
+				if err := mgr.Set(t.Context(), "u1", key, value); err != nil {
+					t.Fatalf("Set(%s) failed: %v", key, err)
+				}
+			}
+
+			rec := do(srv, http.MethodPost, "/api/v1/users/u1/preferences/reset", tt.body, tt.header)
+			expectStatus(t, rec, tt.wantStatus)
+
+			want := tt.want
+			if want == nil {
+				want = map[string]interface{}{"volume": 7.0, "nickname": "ada", "theme": "dark", "plan": "pro"}
+			} else {
+				var prefs map[string]userprefs.Preference
+				decodeBody(t, rec, &prefs)
+				for key, value := range want {
+					if prefs[key].Value != value {
+						t.Errorf("Expected response value %v for %s, got %v", value, key, prefs[key].Value)
+					}
+				}
+			}
+			var prefs map[string]userprefs.Preference
+			decodeBody(t, do(srv, http.MethodGet, "/api/v1/users/u1/preferences", "", nil), &prefs)
+			for key, value := range want {
+				if prefs[key].Value != value {
+					t.Errorf("Expected stored value %v for %s, got %v", value, key, prefs[key].Value)
+				}
+			}
+		})
+	}
+}
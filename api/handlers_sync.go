@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/CreativeUnicorns/userprefs"
+	"github.com/go-chi/chi/v5"
+)
+
+// syncRequest is the body of POST /users/{userID}/sync.
+type syncRequest struct {
+	Cursor  string              `json:"cursor"`
+	Changes []clientChangeEntry `json:"changes"`
+}
+
+// clientChangeEntry is one change in a syncRequest. Value is decoded like the value of
+// PUT /users/{userID}/preferences/{key}.
+type clientChangeEntry struct {
+	Key       string          `json:"key"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	Deleted   bool            `json:"deleted,omitempty"`
+	ChangedAt time.Time       `json:"changed_at"`
+}
+
+// handleGetUserChanges handles fetching the changes of a user's preferences since the
+// "cursor" query parameter; without it, every stored value is returned. A cursor older than
+// the tombstone retention is answered with 410 Gone, telling the client to sync from scratch.
+func (s *Server) handleGetUserChanges(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userID")
+	changes, err := s.manager.ChangesSince(r.Context(), userID, r.URL.Query().Get("cursor"))
+	if err != nil {
+		s.respondWithPreferenceError(w, r, "Failed to get preference changes", err)
+		return
+	}
+	s.respondWithJSON(w, r, http.StatusOK, changes)
+}
+
+// handleSyncUserPreferences handles applying a client's offline changes and responds with the
+// sync result, including the server's changes since the client's cursor.
+func (s *Server) handleSyncUserPreferences(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userID")
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1024*1024)
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	var req syncRequest
+	if err := decoder.Decode(&req); err != nil {
+		s.respondWithError(w, r, http.StatusBadRequest, "Invalid request payload", err)
+		return
+	}
+
+	changes := make([]userprefs.ClientChange, len(req.Changes))
+	for i, entry := range req.Changes {
+		value, err := decodePreferenceValue(entry.Value)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusBadRequest, "Invalid request payload", err)
+			return
+		}
+		changes[i] = userprefs.ClientChange{Key: entry.Key, Value: value, Deleted: entry.Deleted, ChangedAt: entry.ChangedAt}
+	}
+
+	result, err := s.manager.ApplyClientChanges(r.Context(), userID, req.Cursor, changes)
+	if err != nil {
+		s.respondWithPreferenceError(w, r, "Failed to sync preferences", err)
+		return
+	}
+	s.respondWithJSON(w, r, http.StatusOK, result)
+}
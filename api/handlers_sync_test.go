@@ -0,0 +1,187 @@
+package api
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CreativeUnicorns/userprefs"
+	"github.com/CreativeUnicorns/userprefs/storage"
+)
+
+// syncDefinitions returns a user-wide and a PerDevice preference.
+func syncDefinitions() []userprefs.PreferenceDefinition {
+	return []userprefs.PreferenceDefinition{
+		{Key: "theme", Type: userprefs.EnumType, DefaultValue: "light", AllowedValues: []interface{}{"light", "dark"}},
+		{Key: "font_size", Type: userprefs.IntType, DefaultValue: 12, PerDevice: true},
+	}
+}
+
+func TestGetUserChanges(t *testing.T) {
+	srv, mgr := newTestServer(t, syncDefinitions())
+	if err := mgr.Set(t.Context(), "u1", "theme", "dark"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := mgr.Set(userprefs.WithDevice(t.Context(), "phone"), "u1", "font_size", 16); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	rec := do(srv, http.MethodGet, "/api/v1/users/u1/sync", "", http.Header{DeviceHeader: {"phone"}})
+	expectStatus(t, rec, http.StatusOK)
+	var changes userprefs.SyncChanges
+	decodeBody(t, rec, &changes)
+	if len(changes.Changed) != 2 || changes.Cursor == "" {
+		t.Fatalf("Expected the theme and the device's font size with a cursor, got %+v", changes)
+	}
+
+	// Without the device header, only the user's own values are reported.
+	decodeBody(t, do(srv, http.MethodGet, "/api/v1/users/u1/sync", "", nil), &changes)
+	if len(changes.Changed) != 1 || changes.Changed[0].Key != "theme" {
+		t.Errorf("Expected only the theme, got %+v", changes.Changed)
+	}
+
+	// Nothing changed since the cursor.
+	cursor := changes.Cursor
+	decodeBody(t, do(srv, http.MethodGet, "/api/v1/users/u1/sync?cursor="+url.QueryEscape(cursor), "", nil), &changes)
+	if len(changes.Changed) != 0 || len(changes.Deleted) != 0 || changes.Cursor != cursor {
+		t.Errorf("Expected no changes since the cursor, got %+v", changes)
+	}
+
+	expectStatus(t, do(srv, http.MethodDelete, "/api/v1/users/u1/preferences/theme", "", nil), http.StatusNoContent)
+	decodeBody(t, do(srv, http.MethodGet, "/api/v1/users/u1/sync?cursor="+url.QueryEscape(cursor), "", nil), &changes)
+	if len(changes.Deleted) != 1 || changes.Deleted[0].Key != "theme" {
+		t.Errorf("Expected the deleted theme, got %+v", changes)
+	}
+}
+
+func TestGetUserChanges_Errors(t *testing.T) {
+	srv, _ := newTestServer(t, syncDefinitions())
+	notTime := base64.RawURLEncoding.EncodeToString([]byte("not-a-time"))
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{name: "cursor not base64", path: "/api/v1/users/u1/sync?cursor=%21%21%21", wantStatus: http.StatusBadRequest},
+		{name: "cursor not a time", path: "/api/v1/users/u1/sync?cursor=" + notTime, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(srv, http.MethodGet, tt.path, "", nil)
+			expectStatus(t, rec, tt.wantStatus)
+			var resp errorResponse
+			decodeBody(t, rec, &resp)
+			if !strings.Contains(resp.Error.Details, "malformed cursor") {
+				t.Errorf("Expected a malformed cursor error, got %+v", resp.Error)
+			}
+		})
+	}
+
+	// MemoryStorage prunes tombstones, so cursors older than the default retention have expired.
+	expired := base64.RawURLEncoding.EncodeToString([]byte(`{"t":"` + time.Now().AddDate(0, -2, 0).UTC().Format(time.RFC3339Nano) + `"}`))
+	expectStatus(t, do(srv, http.MethodGet, "/api/v1/users/u1/sync?cursor="+expired, "", nil), http.StatusGone)
+	expectStatus(t, do(srv, http.MethodPost, "/api/v1/users/u1/sync", `{"cursor": "`+expired+`", "changes": []}`, nil), http.StatusGone)
+
+	// Hiding the MemoryStorage behind the Storage interface leaves out ChangeTracker.
+	unsupported, _ := newTestServer(t, syncDefinitions(),
+		userprefs.WithStorage(struct{ userprefs.Storage }{storage.NewMemoryStorage()}))
+	expectStatus(t, do(unsupported, http.MethodGet, "/api/v1/users/u1/sync", "", nil), http.StatusNotImplemented)
+	expectStatus(t, do(unsupported, http.MethodPost, "/api/v1/users/u1/sync", `{"changes": []}`, nil), http.StatusNotImplemented)
+}
+
+func TestSyncUserPreferences(t *testing.T) {
+	srv, mgr := newTestServer(t, syncDefinitions())
+	if err := mgr.Set(t.Context(), "u1", "theme", "dark"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	var initial userprefs.SyncChanges
+	decodeBody(t, do(srv, http.MethodGet, "/api/v1/users/u1/sync", "", nil), &initial)
+
+	later := time.Now().Add(time.Hour).UTC().Format(time.RFC3339Nano)
+	body := `{"cursor": "` + initial.Cursor + `", "changes": [
+		{"key": "theme", "deleted": true, "changed_at": "` + later + `"},
+		{"key": "font_size", "value": 18, "changed_at": "` + later + `"},
+		{"key": "removed", "value": true, "changed_at": "` + later + `"}
+	]}`
+	rec := do(srv, http.MethodPost, "/api/v1/users/u1/sync", body, http.Header{DeviceHeader: {"phone"}})
+	expectStatus(t, rec, http.StatusOK)
+	var result userprefs.SyncResult
+	decodeBody(t, rec, &result)
+	if !reflect.DeepEqual(result.Applied, []string{"font_size", "theme"}) || !reflect.DeepEqual(result.Unknown, []string{"removed"}) {
+		t.Errorf("Expected font_size and theme applied and removed unknown, got %+v", result)
+	}
+	if result.Changes == nil || result.Changes.Cursor == "" || len(result.Changes.Deleted) != 1 {
+		t.Errorf("Expected the server's changes with the deleted theme, got %+v", result.Changes)
+	}
+
+	phone := userprefs.WithDevice(t.Context(), "phone")
+	if pref, err := mgr.Get(phone, "u1", "font_size"); err != nil || pref.Value != 18 {
+		t.Errorf("Expected the phone's font size override, got %v (%v)", pref, err)
+	}
+	if pref, err := mgr.Get(t.Context(), "u1", "font_size"); err != nil || pref.Value != 12 {
+		t.Errorf("Expected the user's own font size to be unchanged, got %v (%v)", pref, err)
+	}
+	if pref, err := mgr.Get(t.Context(), "u1", "theme"); err != nil || pref.Value != "light" {
+		t.Errorf("Expected the theme to be deleted, got %v (%v)", pref, err)
+	}
+}
+
+func TestSyncUserPreferences_Errors(t *testing.T) {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+
+	tests := []struct {
+		name       string
+		body       string
+		header     http.Header
+		wantStatus int
+		wantFields []fieldError
+	}{
+		{name: "malformed body", body: `{"changes": `, wantStatus: http.StatusBadRequest},
+		{name: "unknown field", body: `{"changes": [], "since": "yesterday"}`, wantStatus: http.StatusBadRequest},
+		{name: "malformed cursor", body: `{"cursor": "!!!", "changes": []}`, wantStatus: http.StatusBadRequest},
+		{name: "missing changed_at", body: `{"changes": [{"key": "theme", "value": "dark"}]}`, wantStatus: http.StatusBadRequest},
+		{
+			name:       "invalid value",
+			body:       `{"changes": [{"key": "theme", "value": "sepia", "changed_at": "` + now + `"}]}`,
+			wantStatus: http.StatusBadRequest,
+			wantFields: []fieldError{{Field: "theme", Code: userprefs.RuleAllowedValues, Message: "value not in allowed values"}},
+		},
+		{
+			name:       "read-only deletion",
+			body:       `{"changes": [{"key": "plan", "deleted": true, "changed_at": "` + now + `"}]}`,
+			header:     asActor(userprefs.ActorUser),
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, mgr := newTestServer(t, append(syncDefinitions(),
+				userprefs.PreferenceDefinition{Key: "plan", Type: userprefs.StringType, DefaultValue: "free", ReadOnly: true}))
+			if err := mgr.SetMany(t.Context(), "u1", map[string]interface{}{"theme": "dark", "plan": "pro"}); err != nil {
+				t.Fatalf("SetMany failed: %v", err)
+			}
+
+			rec := do(srv, http.MethodPost, "/api/v1/users/u1/sync", tt.body, tt.header)
+			expectStatus(t, rec, tt.wantStatus)
+			if tt.wantFields != nil {
+				var resp errorResponse
+				decodeBody(t, rec, &resp)
+				if !reflect.DeepEqual(resp.Error.Fields, tt.wantFields) {
+					t.Errorf("Expected %+v, got %+v", tt.wantFields, resp.Error.Fields)
+				}
+			}
+
+			for key, value := range map[string]interface{}{"theme": "dark", "plan": "pro"} {
+				if pref, err := mgr.Get(t.Context(), "u1", key); err != nil || pref.Value != value {
+					t.Errorf("Expected %s to be unchanged, got %v (%v)", key, pref, err)
+				}
+			}
+		})
+	}
+}
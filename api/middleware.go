@@ -5,7 +5,12 @@ import (
 	"time"
 
 	"github.com/CreativeUnicorns/userprefs"
+	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // LoggerMiddleware returns a middleware that logs requests using the provided logger.
@@ -28,3 +33,75 @@ func LoggerMiddleware(logger userprefs.Logger) func(next http.Handler) http.Hand
 		return http.HandlerFunc(fn)
 	}
 }
+
+// TracingMiddleware returns a middleware that continues the trace of each incoming request,
+// as carried in its headers and read by propagator, with a server span. Handlers pass the
+// request context to the Manager, so Manager spans become children of the request's span.
+// The span is named after the matched route pattern, so user IDs and keys in the path are not recorded.
+func TracingMiddleware(tp trace.TracerProvider, propagator propagation.TextMapPropagator) func(next http.Handler) http.Handler {
+	tracer := tp.Tracer(userprefs.TracerName + "/api")
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := tracer.Start(ctx, r.Method,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(semconv.HTTPRequestMethodKey.String(r.Method)))
+			defer span.End()
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			// The route is only known once the router has matched the request.
+			if pattern := chi.RouteContext(r.Context()).RoutePattern(); pattern != "" {
+				span.SetName(r.Method + " " + pattern)
+				span.SetAttributes(semconv.HTTPRoute(pattern))
+			}
+			span.SetAttributes(semconv.HTTPResponseStatusCode(ww.Status()))
+			if ww.Status() >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(ww.Status()))
+			}
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// ActorMiddleware returns a middleware that attaches the actor identified by resolve to the
+// request context, so that the Manager can enforce ReadOnly, WritableBy, and Hidden.
+// Requests for which resolve returns false carry no actor and are treated as trusted services.
+func ActorMiddleware(resolve func(r *http.Request) (userprefs.Actor, bool)) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if actor, ok := resolve(r); ok {
+				r = r.WithContext(userprefs.WithActor(r.Context(), actor))
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// TenantMiddleware scopes requests to the tenant named by the {tenantID} URL parameter,
+// so that handlers operate on that tenant's definitions and data.
+func TenantMiddleware(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		tenantID := chi.URLParam(r, "tenantID")
+		next.ServeHTTP(w, r.WithContext(userprefs.WithTenant(r.Context(), tenantID)))
+	}
+	return http.HandlerFunc(fn)
+}
+
+// DeviceHeader is the request header that names the client device of a request.
+const DeviceHeader = "X-Device-ID"
+
+// DeviceMiddleware scopes requests to the device named by the DeviceHeader header, so that
+// preferences defined with PerDevice are read and written as that device's overrides.
+// Requests without the header operate on the users' own values.
+func DeviceMiddleware(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if deviceID := r.Header.Get(DeviceHeader); deviceID != "" {
+			r = r.WithContext(userprefs.WithDevice(r.Context(), deviceID))
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
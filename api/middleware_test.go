@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/CreativeUnicorns/userprefs"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	t.Cleanup(func() { _ = tp.Shutdown(context.Background()) })
+
+	mgr := newTestManager(t, []userprefs.PreferenceDefinition{
+		{Key: "theme", Type: userprefs.StringType, DefaultValue: "light"},
+	}, userprefs.WithTracerProvider(tp))
+	srv, err := NewServer(Config{Manager: mgr, Logger: discardLogger{}, TracerProvider: tp, Propagator: propagation.TraceContext{}})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	const (
+		traceID      = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentSpanID = "00f067aa0ba902b7"
+	)
+	setupSpans := len(recorder.Ended()) // spans recorded while defining preferences
+	header := http.Header{"Traceparent": []string{"00-" + traceID + "-" + parentSpanID + "-01"}}
+	expectStatus(t, do(srv, http.MethodPut, "/api/v1/users/u1/preferences/theme", `{"value": "dark"}`, header), http.StatusOK)
+
+	var server sdktrace.ReadOnlySpan
+	var children []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended()[setupSpans:] {
+		switch {
+		case span.SpanKind() == trace.SpanKindServer:
+			server = span
+		case span.Name() == "userprefs.GetDefinition":
+			// GetDefinition takes no context, so its span cannot join the request's trace.
+		case strings.HasPrefix(span.Name(), "userprefs."):
+			children = append(children, span)
+		}
+	}
+	if server == nil {
+		t.Fatal("Expected a server span")
+	}
+	if want := "PUT /api/v1/users/{userID}/preferences/{key}"; server.Name() != want {
+		t.Errorf("Expected the server span to be named %q, got %q", want, server.Name())
+	}
+	if got := server.SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("Expected the server span to continue trace %s, got %s", traceID, got)
+	}
+	if parent := server.Parent(); !parent.IsRemote() || parent.SpanID().String() != parentSpanID {
+		t.Errorf("Expected the server span's parent to be the remote span %s, got %s (remote=%v)", parentSpanID, parent.SpanID(), parent.IsRemote())
+	}
+
+	// userprefs.Set is the server span's child; nested spans such as userprefs.storage.Get hang below it.
+	ids := map[trace.SpanID]string{server.SpanContext().SpanID(): server.Name()}
+	for _, span := range children {
+		ids[span.SpanContext().SpanID()] = span.Name()
+	}
+	var sawSet bool
+	for _, span := range children {
+		if span.Name() == "userprefs.Set" {
+			sawSet = true
+			if span.Parent().SpanID() != server.SpanContext().SpanID() {
+				t.Errorf("Expected userprefs.Set to be a child of the server span, got parent %s", span.Parent().SpanID())
+			}
+		}
+		if _, ok := ids[span.Parent().SpanID()]; !ok || span.SpanContext().TraceID().String() != traceID {
+			t.Errorf("Expected %s to descend from the server span in trace %s", span.Name(), traceID)
+		}
+	}
+	if !sawSet {
+		t.Errorf("Expected a userprefs.Set span among %d Manager spans", len(children))
+	}
+}
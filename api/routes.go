@@ -11,8 +11,14 @@ func (s *Server) setupRoutes() {
 	// Middleware stack
 	s.router.Use(middleware.RequestID)
 	s.router.Use(middleware.RealIP)
+	if s.tracerProvider != nil {
+		s.router.Use(TracingMiddleware(s.tracerProvider, s.propagator))
+	}
 	s.router.Use(LoggerMiddleware(s.logger)) // Custom logger middleware
 	s.router.Use(middleware.Recoverer)
+	if s.resolveActor != nil {
+		s.router.Use(ActorMiddleware(s.resolveActor))
+	}
 	s.router.Use(middleware.SetHeader("Content-Type", "application/json"))
 
 	// API versioning group
@@ -23,22 +29,45 @@ func (s *Server) setupRoutes() {
 			_, _ = w.Write([]byte("OK")) // Best effort write
 		})
 
-		// Preference Definitions Endpoints
-		r.Route("/definitions", func(r chi.Router) {
-			r.Post("/", s.handleDefinePreference)  // POST /api/v1/definitions
-			r.Get("/{key}", s.handleGetDefinition) // GET /api/v1/definitions/{key}
-			r.Get("/", s.handleListDefinitions)    // GET /api/v1/definitions
-			// r.Put("/{key}", s.handleUpdateDefinition)    // PUT /api/v1/definitions/{key} (To be implemented)
-			// r.Delete("/{key}", s.handleDeleteDefinition)  // DELETE /api/v1/definitions/{key} (To be implemented)
+		s.mountPreferenceRoutes(r)
+
+		// Tenant-scoped endpoints mirror the routes above for a single tenant's definitions and data,
+		// e.g. GET /api/v1/tenants/{tenantID}/users/{userID}/preferences
+		r.Route("/tenants/{tenantID}", func(r chi.Router) {
+			r.Use(TenantMiddleware)
+			s.mountPreferenceRoutes(r)
 		})
+	})
+}
+
+// mountPreferenceRoutes registers the definition and user preference endpoints on r.
+func (s *Server) mountPreferenceRoutes(r chi.Router) {
+	// Preference Definitions Endpoints
+	r.Route("/definitions", func(r chi.Router) {
+		r.Post("/", s.handleDefinePreference)             // POST /api/v1/definitions
+		r.Get("/{key}", s.handleGetDefinition)            // GET /api/v1/definitions/{key}
+		r.Get("/{key}/stats", s.handleGetPreferenceStats) // GET /api/v1/definitions/{key}/stats
+		r.Get("/", s.handleListDefinitions)               // GET /api/v1/definitions
+		r.Delete("/{key}", s.handleDeleteDefinition)      // DELETE /api/v1/definitions/{key}
+		// r.Put("/{key}", s.handleUpdateDefinition)    // PUT /api/v1/definitions/{key} (To be implemented)
+	})
+
+	// User Preferences Endpoints
+	r.Route("/users/{userID}/preferences", func(r chi.Router) {
+		r.Use(DeviceMiddleware)
+		r.Get("/{key}", s.handleGetUserPreference)       // GET /api/v1/users/{userID}/preferences/{key}
+		r.Put("/{key}", s.handleSetUserPreference)       // PUT /api/v1/users/{userID}/preferences/{key}
+		r.Delete("/{key}", s.handleDeleteUserPreference) // DELETE /api/v1/users/{userID}/preferences/{key}
+		r.Get("/", s.handleGetAllUserPreferences)        // GET /api/v1/users/{userID}/preferences
+		r.Patch("/", s.handleSetUserPreferences)         // PATCH /api/v1/users/{userID}/preferences
+		r.Post("/reset", s.handleResetUserPreferences)   // POST /api/v1/users/{userID}/preferences/reset
+		r.Delete("/", s.handleDeleteUser)                // DELETE /api/v1/users/{userID}/preferences
+	})
 
-		// User Preferences Endpoints (To be implemented)
-		// r.Route("/users/{userID}/preferences", func(r chi.Router) {
-		// 	r.Get("/{key}", s.handleGetUserPreference)
-		// 	r.Put("/{key}", s.handleSetUserPreference)
-		// 	r.Delete("/{key}", s.handleDeleteUserPreference)
-		// 	r.Get("/", s.handleGetAllUserPreferences)
-		// 	r.Delete("/", s.handleDeleteAllUserPreferences)
-		// })
+	// Offline Sync Endpoints
+	r.Route("/users/{userID}/sync", func(r chi.Router) {
+		r.Use(DeviceMiddleware)
+		r.Get("/", s.handleGetUserChanges)       // GET /api/v1/users/{userID}/sync?cursor=...
+		r.Post("/", s.handleSyncUserPreferences) // POST /api/v1/users/{userID}/sync
 	})
 }
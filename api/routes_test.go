@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/CreativeUnicorns/userprefs"
+)
+
+func TestTenantRoutes_IsolateDefinitionsAndData(t *testing.T) {
+	srv, _ := newTestServer(t, []userprefs.PreferenceDefinition{
+		{Key: "theme", Type: userprefs.StringType, DefaultValue: "light"},
+	})
+
+	rec := do(srv, http.MethodPost, "/api/v1/tenants/acme/definitions", `{"key": "theme", "type": "string", "default_value": "blue"}`, nil)
+	expectStatus(t, rec, http.StatusCreated)
+	rec = do(srv, http.MethodPost, "/api/v1/tenants/acme/definitions", `{"key": "volume", "type": "int", "default_value": 5}`, nil)
+	expectStatus(t, rec, http.StatusCreated)
+
+	var defs []userprefs.PreferenceDefinition
+	decodeBody(t, do(srv, http.MethodGet, "/api/v1/tenants/acme/definitions", "", nil), &defs)
+	if len(defs) != 2 || defs[0].Key != "theme" || defs[0].DefaultValue != "blue" {
+		t.Errorf("Expected acme's two definitions, got %+v", defs)
+	}
+	expectStatus(t, do(srv, http.MethodGet, "/api/v1/definitions/volume", "", nil), http.StatusNotFound)
+
+	rec = do(srv, http.MethodPut, "/api/v1/tenants/acme/users/u1/preferences/theme", `{"value": "green"}`, nil)
+	expectStatus(t, rec, http.StatusOK)
+
+	var pref userprefs.Preference
+	decodeBody(t, do(srv, http.MethodGet, "/api/v1/tenants/acme/users/u1/preferences/theme", "", nil), &pref)
+	if pref.Value != "green" {
+		t.Errorf("Expected acme's value, got %v", pref.Value)
+	}
+	decodeBody(t, do(srv, http.MethodGet, "/api/v1/users/u1/preferences/theme", "", nil), &pref)
+	if pref.Value != "light" {
+		t.Errorf("Expected the default tenant to keep its default, got %v", pref.Value)
+	}
+	decodeBody(t, do(srv, http.MethodGet, "/api/v1/tenants/other/users/u1/preferences", "", nil), &map[string]interface{}{})
+
+	rec = do(srv, http.MethodPut, "/api/v1/tenants/other/users/u1/preferences/theme", `{"value": "green"}`, nil)
+	expectStatus(t, rec, http.StatusNotFound)
+}
+
+func TestTenantRoutes_DeleteUserIsScoped(t *testing.T) {
+	srv, mgr := newTestServer(t, []userprefs.PreferenceDefinition{
+		{Key: "theme", Type: userprefs.StringType, DefaultValue: "light"},
+	})
+	if err := mgr.Tenant("acme").DefinePreference(userprefs.PreferenceDefinition{Key: "theme", Type: userprefs.StringType, DefaultValue: "light"}); err != nil {
+		t.Fatalf("DefinePreference failed: %v", err)
+	}
+	expectStatus(t, do(srv, http.MethodPut, "/api/v1/users/u1/preferences/theme", `{"value": "dark"}`, nil), http.StatusOK)
+	expectStatus(t, do(srv, http.MethodPut, "/api/v1/tenants/acme/users/u1/preferences/theme", `{"value": "dark"}`, nil), http.StatusOK)
+
+	expectStatus(t, do(srv, http.MethodDelete, "/api/v1/tenants/acme/users/u1/preferences", "", nil), http.StatusOK)
+
+	var pref userprefs.Preference
+	decodeBody(t, do(srv, http.MethodGet, "/api/v1/tenants/acme/users/u1/preferences/theme", "", nil), &pref)
+	if pref.Value != "light" {
+		t.Errorf("Expected acme's value to be erased, got %v", pref.Value)
+	}
+	decodeBody(t, do(srv, http.MethodGet, "/api/v1/users/u1/preferences/theme", "", nil), &pref)
+	if pref.Value != "dark" {
+		t.Errorf("Expected the default tenant's value to be kept, got %v", pref.Value)
+	}
+}
@@ -8,14 +8,20 @@ import (
 
 	"github.com/CreativeUnicorns/userprefs"
 	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Server holds the dependencies for the HTTP server.
 type Server struct {
-	manager    *userprefs.Manager
-	logger     userprefs.Logger
-	router     *chi.Mux
-	httpServer *http.Server
+	manager        *userprefs.Manager
+	logger         userprefs.Logger
+	resolveActor   func(r *http.Request) (userprefs.Actor, bool)
+	tracerProvider trace.TracerProvider
+	propagator     propagation.TextMapPropagator
+	router         *chi.Mux
+	httpServer     *http.Server
 }
 
 // Config holds configuration for the API server.
@@ -24,6 +30,16 @@ type Config struct {
 	ListenAddress string
 	Manager       *userprefs.Manager
 	Logger        userprefs.Logger
+	// ResolveActor, if set, identifies the actor behind each request (e.g. from an
+	// authentication token). Requests without an actor are treated as trusted services.
+	ResolveActor func(r *http.Request) (userprefs.Actor, bool)
+	// TracerProvider, if set, enables OpenTelemetry tracing of requests (see TracingMiddleware).
+	// Pass the same provider to userprefs.WithTracerProvider to trace Manager operations as
+	// children of the request spans.
+	TracerProvider trace.TracerProvider
+	// Propagator extracts the trace context of incoming requests. It defaults to the global
+	// propagator (otel.GetTextMapPropagator) and is only used with a TracerProvider.
+	Propagator propagation.TextMapPropagator
 }
 
 // NewServer creates and configures a new API server instance.
@@ -38,11 +54,17 @@ func NewServer(cfg Config) (*Server, error) {
 	if cfg.ListenAddress == "" {
 		cfg.ListenAddress = ":8080" // Default listen address
 	}
+	if cfg.Propagator == nil {
+		cfg.Propagator = otel.GetTextMapPropagator()
+	}
 
 	s := &Server{
-		manager: cfg.Manager,
-		logger:  cfg.Logger,
-		router:  chi.NewRouter(),
+		manager:        cfg.Manager,
+		logger:         cfg.Logger,
+		resolveActor:   cfg.ResolveActor,
+		tracerProvider: cfg.TracerProvider,
+		propagator:     cfg.Propagator,
+		router:         chi.NewRouter(),
 	}
 
 	s.setupRoutes()
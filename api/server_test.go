@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CreativeUnicorns/userprefs"
+	"github.com/CreativeUnicorns/userprefs/storage"
+)
+
+// discardLogger implements userprefs.Logger without output.
+type discardLogger struct{}
+
+func (discardLogger) Debug(string, ...any)        {}
+func (discardLogger) Info(string, ...any)         {}
+func (discardLogger) Warn(string, ...any)         {}
+func (discardLogger) Error(string, ...any)        {}
+func (discardLogger) SetLevel(userprefs.LogLevel) {}
+
+// actorHeader names the test request header carrying the role of the request's actor.
+// Requests without it carry no actor and are treated as trusted services.
+const actorHeader = "X-Test-Actor-Role"
+
+// newTestServer returns a Server for a Manager created by newTestManager. The actor of a
+// request is read from actorHeader.
+func newTestServer(t *testing.T, defs []userprefs.PreferenceDefinition, opts ...userprefs.Option) (*Server, *userprefs.Manager) {
+	t.Helper()
+	mgr := newTestManager(t, defs, opts...)
+	srv, err := NewServer(Config{Manager: mgr, Logger: discardLogger{}, ResolveActor: func(r *http.Request) (userprefs.Actor, bool) {
+		role := r.Header.Get(actorHeader)
+		return userprefs.Actor{Role: userprefs.ActorRole(role)}, role != ""
+	}})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	return srv, mgr
+}
+
+// newTestManager returns a Manager on a MemoryStorage with defs defined in the DefaultTenant's
+// catalogue. opts are applied after the storage and logger.
+func newTestManager(t *testing.T, defs []userprefs.PreferenceDefinition, opts ...userprefs.Option) *userprefs.Manager {
+	t.Helper()
+	mgr := userprefs.New(append([]userprefs.Option{userprefs.WithStorage(storage.NewMemoryStorage()), userprefs.WithLogger(discardLogger{})}, opts...)...)
+	for _, def := range defs {
+		if err := mgr.DefinePreference(def); err != nil {
+			t.Fatalf("DefinePreference(%s) failed: %v", def.Key, err)
+		}
+	}
+	return mgr
+}
+
+// do sends a request with an optional JSON body through the server's router.
+func do(srv *Server, method, path, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for name, values := range header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	rec := httptest.NewRecorder()
+	srv.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// decodeBody decodes the JSON body of rec into v.
+func decodeBody(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("Failed to decode response %q: %v", rec.Body.String(), err)
+	}
+}
+
+// asActor returns the header of a request by an actor with role.
+func asActor(role userprefs.ActorRole) http.Header {
+	return http.Header{actorHeader: []string{string(role)}}
+}
+
+// errorResponse is the body of an error response.
+type errorResponse struct {
+	Error struct {
+		Message string       `json:"message"`
+		Details string       `json:"details"`
+		Fields  []fieldError `json:"fields"`
+	} `json:"error"`
+}
+
+// expectStatus fails the test unless rec has the status want.
+func expectStatus(t *testing.T, rec *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if rec.Code != want {
+		t.Fatalf("Expected status %d, got %d: %s", want, rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,601 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CreativeUnicorns/userprefs"
+)
+
+const (
+	// csrfTokenTTL is how long a rendered settings form can be submitted.
+	csrfTokenTTL = 12 * time.Hour
+	// csrfField is the name of the form field carrying the CSRF token.
+	csrfField = "_csrf"
+	// settingsFieldPrefix prefixes preference keys in form field names, so that keys cannot
+	// collide with csrfField.
+	settingsFieldPrefix = "pref:"
+	// defaultSettingsCategory names the group of preferences without a Category.
+	defaultSettingsCategory = "General"
+)
+
+// SettingsConfig holds the configuration of a settings page handler.
+type SettingsConfig struct {
+	// Manager provides the definitions and values shown on the page. Required.
+	Manager *userprefs.Manager
+	// Logger reports failures to read or save preferences. Defaults to userprefs.NewDefaultLogger().
+	Logger userprefs.Logger
+	// UserID identifies the user whose settings a request shows, e.g. from a session cookie.
+	// Requests for which it reports false are answered with 401 Unauthorized. Required.
+	UserID func(r *http.Request) (string, bool)
+	// CSRFKey signs the CSRF tokens embedded in the form. It must be at least 32 bytes of
+	// secret, random data, shared by all instances serving the page. Required.
+	CSRFKey []byte
+	// Title is the heading of the page. Defaults to "Settings".
+	Title string
+}
+
+// SettingsHandler serves an HTML settings form for a user, generated from the registered
+// definitions: GET renders the form and POST saves it through Manager.SetMany. It responds to
+// the path it is mounted at, so it can be registered on an http.ServeMux or a chi router:
+//
+//	mux.Handle("/settings", settings)
+//	r.With(api.TenantMiddleware).Handle("/tenants/{tenantID}/settings", settings)
+//
+// Preferences are grouped by Category and ordered by DisplayOrder, with controls chosen from
+// each definition's Type, AllowedValues, and Widget, and labels localized from the request's
+// Accept-Language header. Deprecated and Hidden preferences, and those hidden by a DependsOn
+// parent, are not shown; preferences the actor cannot write are shown disabled, and changes
+// submitted for them anyway are rejected.
+//
+// Requests without an actor in their context (see userprefs.WithActor and ActorMiddleware) act
+// as the user themselves, an ActorUser. Submitted forms must carry the CSRF token of a form
+// rendered for the same user and tenant within the last 12 hours; others are rejected with
+// 403 Forbidden.
+type SettingsHandler struct {
+	manager *userprefs.Manager
+	logger  userprefs.Logger
+	userID  func(r *http.Request) (string, bool)
+	csrfKey []byte
+	title   string
+}
+
+// NewSettingsHandler creates a settings page handler.
+//
+// Returns:
+//   - (*SettingsHandler, nil): On success.
+//   - (nil, error): If Manager or UserID is missing, or CSRFKey is shorter than 32 bytes.
+func NewSettingsHandler(cfg SettingsConfig) (*SettingsHandler, error) {
+	if cfg.Manager == nil {
+		return nil, fmt.Errorf("manager is required")
+	}
+	if cfg.UserID == nil {
+		return nil, fmt.Errorf("user ID resolver is required")
+	}
+	if len(cfg.CSRFKey) < 32 {
+		return nil, fmt.Errorf("CSRF key must be at least 32 bytes")
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = userprefs.NewDefaultLogger()
+	}
+	if cfg.Title == "" {
+		cfg.Title = "Settings"
+	}
+	return &SettingsHandler{
+		manager: cfg.Manager,
+		logger:  cfg.Logger,
+		userID:  cfg.UserID,
+		csrfKey: cfg.CSRFKey,
+		title:   cfg.Title,
+	}, nil
+}
+
+// settingsPage is the data rendered by settingsTemplate.
+type settingsPage struct {
+	Title  string
+	Token  string
+	Saved  bool
+	Error  string
+	Groups []*settingsGroup
+}
+
+// settingsGroup is the fields of one Category.
+type settingsGroup struct {
+	Name   string
+	Fields []*settingsField
+}
+
+// settingsField is the form control of one preference.
+type settingsField struct {
+	Name        string // Form field name.
+	Key         string
+	Label       string
+	Description string
+	Control     string // "checkbox", "select", "radio", "textarea", or "input".
+	InputType   string // The type attribute of an "input" control.
+	Value       string
+	Options     []settingsOption
+	Min         string
+	Max         string
+	Step        string
+	Disabled    bool
+	Error       string
+
+	def userprefs.PreferenceDefinition
+}
+
+// settingsOption is an entry of a select or radio control.
+type settingsOption struct {
+	Value    string
+	Label    string
+	Selected bool
+}
+
+// ServeHTTP implements http.Handler.
+func (h *SettingsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.userID(r)
+	if !ok || userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	ctx := r.Context()
+	if _, ok := userprefs.ActorFromContext(ctx); !ok {
+		ctx = userprefs.WithActor(ctx, userprefs.Actor{ID: userID, Role: userprefs.ActorUser})
+		r = r.WithContext(ctx)
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		page, err := h.page(r, userID)
+		if err != nil {
+			h.logger.Error("Failed to load settings", "userID", userID, "error", err)
+			http.Error(w, "Failed to load settings", http.StatusInternalServerError)
+			return
+		}
+		h.render(w, http.StatusOK, page)
+	case http.MethodPost:
+		h.submit(w, r, userID)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// submit saves the changed fields of a posted form with a single SetMany, so that dependencies
+// between fields are checked against the whole submission and either every change is saved or
+// none is. It renders the saved form, or the submitted values with the errors of their fields.
+func (h *SettingsHandler) submit(w http.ResponseWriter, r *http.Request, userID string) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1024*1024)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	if !h.validToken(r.PostForm.Get(csrfField), r, userID, time.Now()) {
+		http.Error(w, "The form has expired or is invalid; reload the page and try again", http.StatusForbidden)
+		return
+	}
+
+	page, err := h.page(r, userID)
+	if err != nil {
+		h.logger.Error("Failed to load settings", "userID", userID, "error", err)
+		http.Error(w, "Failed to load settings", http.StatusInternalServerError)
+		return
+	}
+
+	failed := false
+	changes := make(map[string]interface{})
+	fields := make(map[string]*settingsField)
+	for _, group := range page.Groups {
+		for _, field := range group.Fields {
+			// Browsers do not submit disabled fields; values posted for them anyway are passed
+			// to SetMany, which rejects those the actor may not write.
+			submitted, ok := r.PostForm[field.Name]
+			if !ok {
+				continue
+			}
+			// Checkboxes submit a hidden "false" followed by "true" when checked.
+			raw := strings.ReplaceAll(submitted[len(submitted)-1], "\r\n", "\n")
+			if raw == field.Value {
+				// Unchanged values are not written, so that defaults are not pinned for the user.
+				continue
+			}
+			field.Value = raw
+			for i := range field.Options {
+				field.Options[i].Selected = field.Options[i].Value == raw
+			}
+			value, err := parseFormValue(raw, field.def)
+			if err == nil && !userprefs.CanWrite(r.Context(), field.def) {
+				// SetMany reports only the first key the actor may not write.
+				err = userprefs.ErrForbidden
+			}
+			if err != nil {
+				failed = true
+				field.Error = settingsErrorMessage(err)
+				continue
+			}
+			changes[field.Key] = value
+			fields[field.Key] = field
+		}
+	}
+
+	if !failed && len(changes) > 0 {
+		if err := h.manager.SetMany(r.Context(), userID, changes); err != nil {
+			failed = true
+			attributed := false
+			var verrs userprefs.ValidationErrors
+			if errors.As(err, &verrs) {
+				for _, verr := range verrs {
+					if field, ok := fields[verr.Key]; ok && field.Error == "" {
+						field.Error = verr.Message
+						attributed = true
+					}
+				}
+			}
+			switch {
+			case attributed:
+			case isUserError(err):
+				// Cross-field failures without a key are shown above the form.
+				page.Error = "Settings could not be saved: " + settingsErrorMessage(err)
+			default:
+				h.logger.Error("Failed to save settings", "userID", userID, "error", err)
+				page.Error = "Settings could not be saved."
+			}
+		}
+	}
+
+	if failed {
+		if page.Error == "" {
+			page.Error = "Some settings could not be saved."
+		}
+		page.Error = "Some settings could not be saved."
+		h.render(w, http.StatusUnprocessableEntity, page)
+		return
+	}
+	saved, err := h.page(r, userID)
+	if err != nil {
+		h.logger.Error("Failed to load settings", "userID", userID, "error", err)
+		http.Error(w, "Failed to load settings", http.StatusInternalServerError)
+		return
+	}
+	saved.Saved = true
+	h.render(w, http.StatusOK, saved)
+}
+
+// page builds the settings form of userID from the definitions and values of the tenant in
+// the request context.
+func (h *SettingsHandler) page(r *http.Request, userID string) (*settingsPage, error) {
+	ctx := r.Context()
+	defs, err := h.manager.GetAllDefinitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prefs, err := h.manager.GetAll(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &settingsPage{Title: h.title, Token: h.token(r, userID, time.Now())}
+	groups := make(map[string]*settingsGroup)
+	for _, def := range defs {
+		pref, ok := prefs[def.Key]
+		if !ok || def.Deprecated || def.ReplacedBy != "" || def.Hidden {
+			continue
+		}
+		localized, _ := localizeDefinition(r, def)
+		field := newSettingsField(*localized, pref)
+		field.Disabled = pref.Disabled || !userprefs.CanWrite(ctx, *def)
+
+		name := def.Category
+		if name == "" {
+			name = defaultSettingsCategory
+		}
+		group, ok := groups[name]
+		if !ok {
+			group = &settingsGroup{Name: name}
+			groups[name] = group
+			page.Groups = append(page.Groups, group)
+		}
+		group.Fields = append(group.Fields, field)
+	}
+	return page, nil
+}
+
+// newSettingsField chooses the control of def and fills it with the value of pref.
+func newSettingsField(def userprefs.PreferenceDefinition, pref *userprefs.Preference) *settingsField {
+	field := &settingsField{
+		Name:        settingsFieldPrefix + def.Key,
+		Key:         def.Key,
+		Label:       def.Label,
+		Description: def.Description,
+		Value:       formValue(pref.Value),
+		def:         def,
+	}
+	if field.Label == "" {
+		field.Label = def.Key
+	}
+
+	switch {
+	case def.Type == userprefs.BoolType:
+		field.Control = "checkbox"
+	case len(def.AllowedValues) > 0:
+		field.Control = "select"
+		if def.Widget == userprefs.WidgetRadio {
+			field.Control = "radio"
+		}
+		for _, allowed := range def.AllowedValues {
+			option := settingsOption{Value: formValue(allowed)}
+			option.Label = def.ValueLabels[fmt.Sprint(allowed)]
+			if option.Label == "" {
+				option.Label = option.Value
+			}
+			option.Selected = option.Value == field.Value
+			field.Options = append(field.Options, option)
+		}
+	case def.Widget == userprefs.WidgetTextarea || def.Type == userprefs.JSONType ||
+		def.Type == userprefs.StringListType || def.Type == userprefs.IntListType:
+		field.Control = "textarea"
+	default:
+		field.Control = "input"
+		field.InputType = inputType(def)
+		if def.Min != nil {
+			field.Min = formValue(*def.Min)
+		}
+		if def.Max != nil {
+			field.Max = formValue(*def.Max)
+		}
+		switch def.Type {
+		case userprefs.IntType:
+			field.Step = "1"
+		case userprefs.FloatType:
+			field.Step = "any"
+		}
+	}
+	return field
+}
+
+// inputType returns the type attribute of the input element of def.
+func inputType(def userprefs.PreferenceDefinition) string {
+	switch {
+	case def.Widget == userprefs.WidgetColor:
+		return "color"
+	case def.Widget == userprefs.WidgetSlider && def.Min != nil && def.Max != nil:
+		return "range"
+	case def.Type == userprefs.IntType || def.Type == userprefs.FloatType:
+		return "number"
+	case def.Type == userprefs.EmailType:
+		return "email"
+	case def.Type == userprefs.URLType:
+		return "url"
+	default:
+		return "text"
+	}
+}
+
+// formValue returns the text of value in a form field. parseFormValue reverses it.
+func formValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case []string:
+		return strings.Join(v, "\n")
+	case []int:
+		items := make([]string, len(v))
+		for i, n := range v {
+			items[i] = strconv.Itoa(n)
+		}
+		return strings.Join(items, ", ")
+	case map[string]interface{}, []interface{}:
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// formValueError reports a form field whose text cannot be parsed into a value of its type.
+type formValueError string
+
+// Error implements the error interface.
+func (e formValueError) Error() string { return string(e) }
+
+// parseFormValue converts the text of a form field into a value of def's type, to be
+// validated by Manager.Set. Types not parsed here are passed as strings, which Set accepts
+// for the rich built-in types.
+func parseFormValue(raw string, def userprefs.PreferenceDefinition) (interface{}, error) {
+	for _, allowed := range def.AllowedValues {
+		if formValue(allowed) == raw {
+			return allowed, nil
+		}
+	}
+
+	switch def.Type {
+	case userprefs.BoolType:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, formValueError("must be on or off")
+		}
+		return b, nil
+	case userprefs.IntType:
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, formValueError("must be a whole number")
+		}
+		return n, nil
+	case userprefs.FloatType:
+		f, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return nil, formValueError("must be a number")
+		}
+		return f, nil
+	case userprefs.StringListType:
+		items := []string{}
+		for _, line := range strings.Split(raw, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				items = append(items, line)
+			}
+		}
+		return items, nil
+	case userprefs.IntListType:
+		items := []int{}
+		for _, item := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == '\n' || r == ' ' }) {
+			n, err := strconv.Atoi(item)
+			if err != nil {
+				return nil, formValueError("must be a list of whole numbers separated by commas")
+			}
+			items = append(items, n)
+		}
+		return items, nil
+	case userprefs.JSONType:
+		var value interface{}
+		if err := json.Unmarshal([]byte(raw), &value); err != nil {
+			return nil, formValueError("must be valid JSON")
+		}
+		return value, nil
+	default:
+		return raw, nil
+	}
+}
+
+// isUserError reports whether err was caused by the submitted value rather than a failure.
+func isUserError(err error) bool {
+	var ferr formValueError
+	return errors.As(err, &ferr) || errors.Is(err, userprefs.ErrInvalidValue) ||
+		errors.Is(err, userprefs.ErrForbidden) || errors.Is(err, userprefs.ErrInvalidInput)
+}
+
+// settingsErrorMessage returns the message shown next to a field that could not be saved.
+func settingsErrorMessage(err error) string {
+	var ferr formValueError
+	var verrs userprefs.ValidationErrors
+	var verr *userprefs.ValidationError
+	switch {
+	case errors.As(err, &ferr):
+		return string(ferr)
+	case errors.As(err, &verrs) && len(verrs) > 0:
+		return verrs[0].Message
+	case errors.As(err, &verr):
+		return verr.Message
+	case errors.Is(err, userprefs.ErrForbidden):
+		return "cannot be changed"
+	case errors.Is(err, userprefs.ErrInvalidValue), errors.Is(err, userprefs.ErrInvalidInput):
+		return "is not a valid value"
+	default:
+		return "could not be saved"
+	}
+}
+
+// token returns a CSRF token for a form rendered for userID at issued. It carries the time
+// it was issued and a signature binding it to that time, the user, and the tenant.
+func (h *SettingsHandler) token(r *http.Request, userID string, issued time.Time) string {
+	timestamp := strconv.FormatInt(issued.Unix(), 10)
+	return timestamp + "." + base64.RawURLEncoding.EncodeToString(h.tokenSignature(r, userID, timestamp))
+}
+
+// tokenSignature returns the HMAC of a CSRF token issued at timestamp.
+func (h *SettingsHandler) tokenSignature(r *http.Request, userID, timestamp string) []byte {
+	mac := hmac.New(sha256.New, h.csrfKey)
+	_, _ = fmt.Fprintf(mac, "%s\x00%s\x00%s", userprefs.TenantFromContext(r.Context()), userID, timestamp) // hash.Hash.Write never returns an error
+	return mac.Sum(nil)
+}
+
+// validToken reports whether token was issued by token for userID less than csrfTokenTTL before now.
+func (h *SettingsHandler) validToken(token string, r *http.Request, userID string, now time.Time) bool {
+	timestamp, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	issued, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := now.Sub(time.Unix(issued, 0))
+	if age < -time.Minute || age > csrfTokenTTL {
+		return false
+	}
+	got, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(got, h.tokenSignature(r, userID, timestamp))
+}
+
+// render writes page as HTML with the given status.
+func (h *SettingsHandler) render(w http.ResponseWriter, status int, page *settingsPage) {
+	header := w.Header()
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	header.Set("Cache-Control", "no-store")
+	header.Set("X-Frame-Options", "DENY")
+	header.Add("Vary", "Accept-Language")
+	w.WriteHeader(status)
+	if err := settingsTemplate.Execute(w, page); err != nil {
+		h.logger.Error("Failed to render settings page", "error", err)
+	}
+}
+
+// settingsTemplate renders a settingsPage. The form posts back to the URL it was served from.
+var settingsTemplate = template.Must(template.New("settings").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 40rem; margin: 2rem auto; padding: 0 1rem; }
+fieldset { border: 1px solid #ccc; border-radius: 4px; margin-bottom: 1.5rem; }
+.field { margin: 0.75rem 0; }
+.field label { font-weight: 600; display: block; }
+.field .description { color: #555; font-size: 0.9em; margin: 0.2rem 0; }
+.field .error, .notice.error { color: #b00020; }
+.notice { padding: 0.5rem; border-radius: 4px; }
+.notice.saved { color: #1b5e20; }
+textarea { width: 100%; min-height: 5rem; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Saved}}<p class="notice saved" role="status">Settings saved.</p>{{end}}
+{{if .Error}}<p class="notice error" role="alert">{{.Error}}</p>{{end}}
+<form method="post">
+<input type="hidden" name="_csrf" value="{{.Token}}">
+{{range .Groups}}<fieldset>
+<legend>{{.Name}}</legend>
+{{range .Fields}}<div class="field">
+{{if eq .Control "checkbox"}}<input type="hidden" name="{{.Name}}" value="false"{{if .Disabled}} disabled{{end}}>
+<label><input type="checkbox" id="{{.Name}}" name="{{.Name}}" value="true"{{if eq .Value "true"}} checked{{end}}{{if .Disabled}} disabled{{end}}> {{.Label}}</label>
+{{else if eq .Control "radio"}}<label>{{.Label}}</label>
+{{$field := .}}{{range .Options}}<label style="font-weight: normal"><input type="radio" name="{{$field.Name}}" value="{{.Value}}"{{if .Selected}} checked{{end}}{{if $field.Disabled}} disabled{{end}}> {{.Label}}</label>
+{{end}}{{else if eq .Control "select"}}<label for="{{.Name}}">{{.Label}}</label>
+<select id="{{.Name}}" name="{{.Name}}"{{if .Disabled}} disabled{{end}}>
+{{range .Options}}<option value="{{.Value}}"{{if .Selected}} selected{{end}}>{{.Label}}</option>
+{{end}}</select>
+{{else if eq .Control "textarea"}}<label for="{{.Name}}">{{.Label}}</label>
+<textarea id="{{.Name}}" name="{{.Name}}"{{if .Disabled}} disabled{{end}}>{{.Value}}</textarea>
+{{else}}<label for="{{.Name}}">{{.Label}}</label>
+<input type="{{.InputType}}" id="{{.Name}}" name="{{.Name}}" value="{{.Value}}"{{if .Min}} min="{{.Min}}"{{end}}{{if .Max}} max="{{.Max}}"{{end}}{{if .Step}} step="{{.Step}}"{{end}}{{if .Disabled}} disabled{{end}}>
+{{end}}{{if .Description}}<p class="description">{{.Description}}</p>{{end}}
+{{if .Error}}<p class="error" role="alert">{{.Error}}</p>{{end}}
+</div>
+{{end}}</fieldset>
+{{end}}<button type="submit">Save</button>
+</form>
+</body>
+</html>
+`))
@@ -0,0 +1,362 @@
+package api
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CreativeUnicorns/userprefs"
+)
+
+// settingsUserHeader names the test request header carrying the signed-in user.
+const settingsUserHeader = "X-Test-User"
+
+// settingsDefinitions returns preferences of every control kind, across categories, with a
+// hidden, a read-only, and an admin-only preference.
+func settingsDefinitions() []userprefs.PreferenceDefinition {
+	return []userprefs.PreferenceDefinition{
+		{Key: "theme", Type: userprefs.EnumType, DefaultValue: "light", AllowedValues: []interface{}{"light", "dark"}, Category: "appearance", Label: "Theme"},
+		{Key: "layout", Type: userprefs.JSONType, DefaultValue: map[string]interface{}{"columns": 2.0}, Category: "appearance"},
+		{Key: "notifications", Type: userprefs.BoolType, DefaultValue: true, Category: "alerts"},
+		{Key: "volume", Type: userprefs.IntType, DefaultValue: 5, Min: floatPtr(0), Max: floatPtr(10), Category: "audio"},
+		{Key: "ratio", Type: userprefs.FloatType, DefaultValue: 1.5, Category: "audio"},
+		{Key: "nickname", Type: userprefs.StringType, DefaultValue: ""},
+		{Key: "secret", Type: userprefs.StringType, DefaultValue: "s3cret", Hidden: true},
+		{Key: "plan", Type: userprefs.StringType, DefaultValue: "free", Category: "account", ReadOnly: true},
+		{Key: "quota", Type: userprefs.IntType, DefaultValue: 10, Category: "account", WritableBy: []userprefs.ActorRole{userprefs.ActorAdmin}},
+	}
+}
+
+// newSettingsTestHandler returns a SettingsHandler for defs, defined in the DefaultTenant and
+// in tenant "acme", signing in the user named by settingsUserHeader.
+func newSettingsTestHandler(t *testing.T, defs []userprefs.PreferenceDefinition) (*SettingsHandler, *userprefs.Manager) {
+	t.Helper()
+	mgr := newTestManager(t, defs)
+	for _, def := range defs {
+		if err := mgr.Tenant("acme").DefinePreference(def); err != nil {
+			t.Fatalf("DefinePreference(%s) failed: %v", def.Key, err)
+		}
+	}
+	h, err := NewSettingsHandler(SettingsConfig{
+		Manager: mgr,
+		Logger:  discardLogger{},
+		UserID: func(r *http.Request) (string, bool) {
+			userID := r.Header.Get(settingsUserHeader)
+			return userID, userID != ""
+		},
+		CSRFKey: []byte(strings.Repeat("k", 32)),
+	})
+	if err != nil {
+		t.Fatalf("NewSettingsHandler failed: %v", err)
+	}
+	return h, mgr
+}
+
+// settingsRequest returns a request for the settings page of userID in tenantID, posting form
+// if it is not nil.
+func settingsRequest(tenantID, userID string, form url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/settings", nil)
+	if form != nil {
+		r = httptest.NewRequest(http.MethodPost, "/settings", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	r.Header.Set(settingsUserHeader, userID)
+	return r.WithContext(userprefs.WithTenant(r.Context(), tenantID))
+}
+
+// serveSettings sends r to h.
+func serveSettings(h *SettingsHandler, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+var csrfTokenPattern = regexp.MustCompile(`name="_csrf" value="([^"]+)"`)
+
+// settingsToken renders the settings page of userID in tenantID and returns its CSRF token.
+func settingsToken(t *testing.T, h *SettingsHandler, tenantID, userID string) string {
+	t.Helper()
+	rec := serveSettings(h, settingsRequest(tenantID, userID, nil))
+	expectStatus(t, rec, http.StatusOK)
+	match := csrfTokenPattern.FindStringSubmatch(rec.Body.String())
+	if match == nil {
+		t.Fatalf("No CSRF token in the settings page: %s", rec.Body.String())
+	}
+	return match[1]
+}
+
+// settingsForm returns a submitted form with the CSRF token and the preference values.
+func settingsForm(token string, values map[string][]string) url.Values {
+	form := url.Values{csrfField: {token}}
+	for key, value := range values {
+		form[settingsFieldPrefix+key] = value
+	}
+	return form
+}
+
+var (
+	settingsGroupPattern = regexp.MustCompile(`(?s)<legend>(.*?)</legend>(.*?)</fieldset>`)
+	settingsFieldPattern = regexp.MustCompile(`id="pref:([a-z]+)"`)
+)
+
+func TestSettingsHandler_Get(t *testing.T) {
+	h, _ := newSettingsTestHandler(t, settingsDefinitions())
+
+	rec := serveSettings(h, settingsRequest(userprefs.DefaultTenant, "u1", nil))
+	expectStatus(t, rec, http.StatusOK)
+	body := rec.Body.String()
+
+	// Groups appear in the order of their first preference by key; fields are ordered by key.
+	var groups []string
+	for _, match := range settingsGroupPattern.FindAllStringSubmatch(body, -1) {
+		var keys []string
+		for _, field := range settingsFieldPattern.FindAllStringSubmatch(match[2], -1) {
+			keys = append(keys, field[1])
+		}
+		groups = append(groups, match[1]+": "+strings.Join(keys, ","))
+	}
+	want := []string{
+		"appearance: layout,theme",
+		"General: nickname",
+		"alerts: notifications",
+		"account: plan,quota",
+		"audio: ratio,volume",
+	}
+	if strings.Join(groups, "; ") != strings.Join(want, "; ") {
+		t.Errorf("Expected groups %q, got %q", want, groups)
+	}
+	if strings.Contains(body, "secret") {
+		t.Errorf("Expected the hidden preference to be omitted, got %s", body)
+	}
+	for _, control := range []string{
+		`<input type="text" id="pref:plan" name="pref:plan" value="free" disabled>`,
+		`<input type="number" id="pref:quota" name="pref:quota" value="10" step="1" disabled>`,
+		`<input type="checkbox" id="pref:notifications" name="pref:notifications" value="true" checked>`,
+		`<option value="light" selected>light</option>`,
+	} {
+		if !strings.Contains(body, control) {
+			t.Errorf("Expected %s in the page, got %s", control, body)
+		}
+	}
+}
+
+func TestSettingsHandler_PostValidToken(t *testing.T) {
+	h, mgr := newSettingsTestHandler(t, settingsDefinitions())
+	token := settingsToken(t, h, userprefs.DefaultTenant, "u1")
+
+	form := settingsForm(token, map[string][]string{
+		"theme":         {"dark"},
+		"nickname":      {"ada"},
+		"volume":        {"7"},
+		"ratio":         {"2.25"},
+		"layout":        {`{"columns": 3}`},
+		"notifications": {"false"}, // An unchecked checkbox submits only its hidden field.
+		"plan":          {"free"},  // Unchanged values of disabled fields are ignored.
+	})
+	rec := serveSettings(h, settingsRequest(userprefs.DefaultTenant, "u1", form))
+	expectStatus(t, rec, http.StatusOK)
+	if !strings.Contains(rec.Body.String(), "Settings saved.") {
+		t.Errorf("Expected the saved notice, got %s", rec.Body.String())
+	}
+
+	want := map[string]interface{}{"theme": "dark", "nickname": "ada", "volume": 7, "ratio": 2.25, "notifications": false}
+	for key, value := range want {
+		pref, err := mgr.Get(t.Context(), "u1", key)
+		if err != nil || pref.Value != value {
+			t.Errorf("Expected %s to be %v, got %v (%v)", key, value, pref, err)
+		}
+	}
+	layout, err := mgr.Get(t.Context(), "u1", "layout")
+	if err != nil || layout.Value.(map[string]interface{})["columns"] != 3.0 {
+		t.Errorf("Expected layout to be saved, got %v (%v)", layout, err)
+	}
+
+	// A checked checkbox submits its hidden field followed by the checkbox.
+	token = settingsToken(t, h, userprefs.DefaultTenant, "u1")
+	form = settingsForm(token, map[string][]string{"notifications": {"false", "true"}})
+	expectStatus(t, serveSettings(h, settingsRequest(userprefs.DefaultTenant, "u1", form)), http.StatusOK)
+	if pref, err := mgr.Get(t.Context(), "u1", "notifications"); err != nil || pref.Value != true {
+		t.Errorf("Expected notifications to be on, got %v (%v)", pref, err)
+	}
+}
+
+func TestSettingsHandler_PostInvalidToken(t *testing.T) {
+	h, mgr := newSettingsTestHandler(t, settingsDefinitions())
+	token := settingsToken(t, h, userprefs.DefaultTenant, "u1")
+	timestamp, signature, _ := strings.Cut(token, ".")
+	tampered := []byte(signature)
+	tampered[0] ^= 1
+
+	tests := []struct {
+		name     string
+		tenantID string
+		userID   string
+		token    string
+	}{
+		{name: "missing", tenantID: userprefs.DefaultTenant, userID: "u1", token: ""},
+		{name: "malformed", tenantID: userprefs.DefaultTenant, userID: "u1", token: "not-a-token"},
+		{name: "tampered signature", tenantID: userprefs.DefaultTenant, userID: "u1", token: timestamp + "." + string(tampered)},
+		{name: "tampered timestamp", tenantID: userprefs.DefaultTenant, userID: "u1", token: "1" + timestamp + "." + signature},
+		{
+			name:     "expired",
+			tenantID: userprefs.DefaultTenant,
+			userID:   "u1",
+			token:    h.token(settingsRequest(userprefs.DefaultTenant, "u1", nil), "u1", time.Now().Add(-csrfTokenTTL-time.Minute)),
+		},
+		{
+			name:     "issued in the future",
+			tenantID: userprefs.DefaultTenant,
+			userID:   "u1",
+			token:    h.token(settingsRequest(userprefs.DefaultTenant, "u1", nil), "u1", time.Now().Add(time.Hour)),
+		},
+		{name: "other user", tenantID: userprefs.DefaultTenant, userID: "u2", token: token},
+		{name: "other tenant", tenantID: "acme", userID: "u1", token: token},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := settingsForm(tt.token, map[string][]string{"theme": {"dark"}})
+			rec := serveSettings(h, settingsRequest(tt.tenantID, tt.userID, form))
+			expectStatus(t, rec, http.StatusForbidden)
+
+			ctx := userprefs.WithTenant(t.Context(), tt.tenantID)
+			if pref, err := mgr.Get(ctx, tt.userID, "theme"); err != nil || pref.Value != "light" {
+				t.Errorf("Expected theme to be unchanged, got %v (%v)", pref, err)
+			}
+		})
+	}
+
+	// A token almost 12 hours old is still accepted.
+	token = h.token(settingsRequest(userprefs.DefaultTenant, "u1", nil), "u1", time.Now().Add(-csrfTokenTTL+time.Minute))
+	form := settingsForm(token, map[string][]string{"theme": {"dark"}})
+	expectStatus(t, serveSettings(h, settingsRequest(userprefs.DefaultTenant, "u1", form)), http.StatusOK)
+
+	// Tokens of tenant pages are accepted in that tenant.
+	token = settingsToken(t, h, "acme", "u1")
+	form = settingsForm(token, map[string][]string{"theme": {"dark"}})
+	expectStatus(t, serveSettings(h, settingsRequest("acme", "u1", form)), http.StatusOK)
+}
+
+func TestSettingsHandler_PostInvalidValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		raw     string
+		message string
+	}{
+		{name: "int", key: "volume", raw: "loud", message: "must be a whole number"},
+		{name: "int out of range", key: "volume", raw: "11", message: "11 is greater than maximum 10"},
+		{name: "float", key: "ratio", raw: "wide", message: "must be a number"},
+		{name: "json", key: "layout", raw: `{"columns": `, message: "must be valid JSON"},
+		{name: "enum", key: "theme", raw: "sepia", message: "value not in allowed values"},
+		{name: "read-only", key: "plan", raw: "pro", message: "cannot be changed"},
+		{name: "writable by admins", key: "quota", raw: "100", message: "cannot be changed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, mgr := newSettingsTestHandler(t, settingsDefinitions())
+			before, err := mgr.Get(t.Context(), "u1", tt.key)
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			token := settingsToken(t, h, userprefs.DefaultTenant, "u1")
+
+			form := settingsForm(token, map[string][]string{tt.key: {tt.raw}, "nickname": {"ada"}})
+			rec := serveSettings(h, settingsRequest(userprefs.DefaultTenant, "u1", form))
+			expectStatus(t, rec, http.StatusUnprocessableEntity)
+
+			body := rec.Body.String()
+			wants := []string{
+				"Some settings could not be saved.",
+				`<p class="error" role="alert">` + template.HTMLEscapeString(tt.message) + `</p>`,
+				`name="_csrf" value="`,
+			}
+			if tt.key != "theme" {
+				// Text controls keep the submitted text; a select has no option for it.
+				wants = append(wants, template.HTMLEscapeString(tt.raw))
+			}
+			for _, want := range wants {
+				if !strings.Contains(body, want) {
+					t.Errorf("Expected %s in the re-rendered form, got %s", want, body)
+				}
+			}
+
+			after, err := mgr.Get(t.Context(), "u1", tt.key)
+			if err != nil || !reflect.DeepEqual(after.Value, before.Value) {
+				t.Errorf("Expected %s to be unchanged at %v, got %v (%v)", tt.key, before.Value, after, err)
+			}
+			// The submission is saved as a whole, so its valid fields are not saved either.
+			if pref, err := mgr.Get(t.Context(), "u1", "nickname"); err != nil || pref.Value != "" {
+				t.Errorf("Expected nickname to be unchanged, got %v (%v)", pref, err)
+			}
+			if !strings.Contains(body, `value="ada"`) {
+				t.Errorf("Expected the valid field to keep its submitted value, got %s", body)
+			}
+		})
+	}
+}
+
+func TestSettingsHandler_PostDependentFields(t *testing.T) {
+	h, mgr := newSettingsTestHandler(t, []userprefs.PreferenceDefinition{
+		{Key: "forwarding", Type: userprefs.BoolType, DefaultValue: false},
+		{Key: "forward_to", Type: userprefs.StringType, DefaultValue: "", DependsOn: &userprefs.Dependency{Key: "forwarding", Required: true}},
+	})
+
+	// Turning forwarding on is only valid together with an address.
+	token := settingsToken(t, h, userprefs.DefaultTenant, "u1")
+	form := settingsForm(token, map[string][]string{"forwarding": {"false", "true"}})
+	rec := serveSettings(h, settingsRequest(userprefs.DefaultTenant, "u1", form))
+	expectStatus(t, rec, http.StatusUnprocessableEntity)
+	if pref, err := mgr.Get(t.Context(), "u1", "forwarding"); err != nil || pref.Value != false {
+		t.Errorf("Expected forwarding to stay off, got %v (%v)", pref, err)
+	}
+
+	token = settingsToken(t, h, userprefs.DefaultTenant, "u1")
+	form = settingsForm(token, map[string][]string{"forwarding": {"false", "true"}, "forward_to": {"ada@example.com"}})
+	expectStatus(t, serveSettings(h, settingsRequest(userprefs.DefaultTenant, "u1", form)), http.StatusOK)
+	for key, value := range map[string]interface{}{"forwarding": true, "forward_to": "ada@example.com"} {
+		if pref, err := mgr.Get(t.Context(), "u1", key); err != nil || pref.Value != value {
+			t.Errorf("Expected %s to be %v, got %v (%v)", key, value, pref, err)
+		}
+	}
+
+	// Clearing the address while forwarding stays on is rejected without touching either field.
+	token = settingsToken(t, h, userprefs.DefaultTenant, "u1")
+	form = settingsForm(token, map[string][]string{"forward_to": {""}, "forwarding": {"false", "true"}})
+	rec = serveSettings(h, settingsRequest(userprefs.DefaultTenant, "u1", form))
+	expectStatus(t, rec, http.StatusUnprocessableEntity)
+	if !strings.Contains(rec.Body.String(), `<p class="error" role="alert">`) {
+		t.Errorf("Expected the failing field to be marked, got %s", rec.Body.String())
+	}
+	if pref, err := mgr.Get(t.Context(), "u1", "forward_to"); err != nil || pref.Value != "ada@example.com" {
+		t.Errorf("Expected forward_to to be unchanged, got %v (%v)", pref, err)
+	}
+}
+
+func TestSettingsHandler_AdminMayWriteRestrictedFields(t *testing.T) {
+	h, mgr := newSettingsTestHandler(t, settingsDefinitions())
+	token := settingsToken(t, h, userprefs.DefaultTenant, "u1")
+
+	form := settingsForm(token, map[string][]string{"plan": {"pro"}, "quota": {"100"}})
+	r := settingsRequest(userprefs.DefaultTenant, "u1", form)
+	r = r.WithContext(userprefs.WithActor(r.Context(), userprefs.Actor{Role: userprefs.ActorAdmin}))
+	expectStatus(t, serveSettings(h, r), http.StatusOK)
+
+	for key, value := range map[string]interface{}{"plan": "pro", "quota": 100} {
+		if pref, err := mgr.Get(t.Context(), "u1", key); err != nil || pref.Value != value {
+			t.Errorf("Expected %s to be %v, got %v (%v)", key, value, pref, err)
+		}
+	}
+}
+
+func TestSettingsHandler_Unauthorized(t *testing.T) {
+	h, _ := newSettingsTestHandler(t, settingsDefinitions())
+	expectStatus(t, serveSettings(h, settingsRequest(userprefs.DefaultTenant, "", nil)), http.StatusUnauthorized)
+}
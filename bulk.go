@@ -0,0 +1,399 @@
+// Package userprefs provides bulk reads of the same preferences across many users.
+package userprefs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// bulkBatchSize is the number of users whose preferences GetManyForUsers reads from the cache
+// and storage at a time, keeping queries within database parameter limits.
+const bulkBatchSize = 500
+
+// GetForUsers retrieves the preference key of each of userIDs in the tenant in ctx (see
+// WithTenant), e.g. the notification settings of every member of a channel. It is the bulk
+// form of Get: values are read from the cache and storage in batches rather than one user at
+// a time, and users without a stored value receive the default.
+//
+// See GetManyForUsers for how values are read.
+//
+// Returns:
+//   - (map[string]*Preference, nil): The preference of each user, keyed by user ID. Every
+//     requested user is present, unless the key's DependsOn parent is off for the user and
+//     the dependency Mode is DependencyHide.
+//   - (nil, ErrInvalidInput): If key is empty, userIDs is empty, or a user ID is empty.
+//   - (nil, ErrPreferenceNotDefined): If the key has not been defined.
+//   - (nil, ErrEncryptionFailed): If decryption is required but fails.
+//   - (nil, ErrMigrationFailed): If a value written with an older definition Version cannot be upgraded.
+//   - (nil, wrapped storage error): If a storage read fails.
+//
+// This method is thread-safe.
+func (m *Manager) GetForUsers(ctx context.Context, key string, userIDs []string) (_ map[string]*Preference, err error) {
+	ctx, span := m.startOperationSpan(ctx, "GetForUsers", AttrKey.String(key))
+	defer func() { endSpan(span, err) }()
+	// Bulk reads return the users' own values, without device overrides.
+	ctx = withoutDevice(ctx)
+	if key == "" {
+		return nil, ErrInvalidInput
+	}
+
+	prefs, err := m.getManyForUsers(ctx, []string{key}, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]*Preference, len(prefs))
+	for userID, userPrefs := range prefs {
+		if pref, ok := userPrefs[key]; ok {
+			result[userID] = pref
+		}
+	}
+	return result, nil
+}
+
+// GetManyForUsers retrieves the preferences keys of each of userIDs in the tenant in ctx (see
+// WithTenant). It is the bulk form of Get for fan-out services that need the same few
+// preferences of many users at once.
+//
+// Operational Flow:
+//  1. Users are processed in batches of 500. Duplicate user IDs and keys are ignored.
+//  2. If the cache implements MultiGetter, each batch's entries are read in one round-trip.
+//  3. Entries missing from the cache are read from storage, with a single query per batch
+//     when the storage backend implements MultiUserGetter and one Get per value otherwise.
+//     Values of keys the requested keys replace (see ReplacedBy) are used as a fallback, like Get.
+//  4. Users without a stored value receive the definition's default, resolved per user.
+//  5. Values read from storage, and defaults not chosen by DefaultRules, are queued to be
+//     cached by the background worker pool (see WithCacheWarming).
+//  6. DependsOn parents are read along with the requested keys. Preferences whose parent is
+//     off are omitted when the dependency Mode is DependencyHide, and marked Disabled otherwise.
+//
+// Unlike Get, cache failures are not returned: the affected values are read from storage instead.
+//
+// Returns:
+//   - (map[string]map[string]*Preference, nil): The preferences of each user, keyed by user ID
+//     and then by key. Every requested user is present with every requested key, except keys
+//     hidden by a DependsOn dependency in DependencyHide mode.
+//   - (nil, ErrInvalidInput): If keys or userIDs is empty or contains an empty string.
+//   - (nil, ErrPreferenceNotDefined): If any of the keys has not been defined.
+//   - (nil, ErrEncryptionFailed): If decryption is required but fails.
+//   - (nil, ErrMigrationFailed): If a value written with an older definition Version cannot be upgraded.
+//   - (nil, wrapped storage error): If a storage read fails.
+//
+// This method is thread-safe.
+func (m *Manager) GetManyForUsers(ctx context.Context, keys, userIDs []string) (_ map[string]map[string]*Preference, err error) {
+	ctx, span := m.startOperationSpan(ctx, "GetManyForUsers", AttrKeyCount.Int(len(keys)))
+	defer func() { endSpan(span, err) }()
+	// Bulk reads return the users' own values, without device overrides.
+	ctx = withoutDevice(ctx)
+	return m.getManyForUsers(ctx, keys, userIDs)
+}
+
+// bulkRead describes the keys read by a GetManyForUsers call.
+type bulkRead struct {
+	// definitions holds the requested keys and their DependsOn parents.
+	definitions map[string]PreferenceDefinition
+	// readKeys are the keys of definitions, sorted.
+	readKeys []string
+	// replaced holds the definitions whose ReplacedBy is one of readKeys.
+	replaced map[string]PreferenceDefinition
+	// storageKeys are readKeys followed by the keys of replaced.
+	storageKeys []string
+}
+
+// getManyForUsers implements GetManyForUsers without an operation span.
+func (m *Manager) getManyForUsers(ctx context.Context, keys, userIDs []string) (map[string]map[string]*Preference, error) {
+	keys, ok := uniqueNonEmpty(keys)
+	if !ok {
+		return nil, ErrInvalidInput
+	}
+	userIDs, ok = uniqueNonEmpty(userIDs)
+	if !ok {
+		return nil, ErrInvalidInput
+	}
+
+	read, err := m.planBulkRead(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	prefs := make(map[string]map[string]*Preference, len(userIDs))
+	for start := 0; start < len(userIDs); start += bulkBatchSize {
+		batch := userIDs[start:min(start+bulkBatchSize, len(userIDs))]
+		if err := m.readUsersBatch(ctx, batch, read, prefs); err != nil {
+			return nil, err
+		}
+	}
+
+	for userID, userPrefs := range prefs {
+		states := make(map[string]bool)
+		result := make(map[string]*Preference, len(keys))
+		for _, key := range keys {
+			pref, def := userPrefs[key], read.definitions[key]
+			if def.DependsOn != nil {
+				active, err := m.dependencyActive(ctx, userID, def, userPrefs, states)
+				if err != nil {
+					return nil, err
+				}
+				if !active {
+					if def.DependsOn.Mode == DependencyHide {
+						continue
+					}
+					// Copy so that a Preference still being written to the cache is never mutated.
+					disabled := *pref
+					disabled.Disabled = true
+					pref = &disabled
+				}
+			}
+			result[key] = pref
+		}
+		prefs[userID] = result
+	}
+	return prefs, nil
+}
+
+// planBulkRead looks up the definitions of keys, their DependsOn parents, and the keys they replace.
+func (m *Manager) planBulkRead(ctx context.Context, keys []string) (*bulkRead, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	definitions := m.definitionsFor(TenantFromContext(ctx))
+
+	read := &bulkRead{
+		definitions: make(map[string]PreferenceDefinition),
+		replaced:    make(map[string]PreferenceDefinition),
+	}
+	for _, key := range keys {
+		def, exists := definitions[key]
+		if !exists {
+			return nil, fmt.Errorf("%w: '%s'", ErrPreferenceNotDefined, key)
+		}
+		for {
+			if _, seen := read.definitions[def.Key]; seen {
+				break
+			}
+			read.definitions[def.Key] = def
+			if def.DependsOn == nil {
+				break
+			}
+			parent, exists := definitions[def.DependsOn.Key]
+			if !exists {
+				// Dependencies on undefined keys are not enforced.
+				break
+			}
+			def = parent
+		}
+	}
+
+	for key := range read.definitions {
+		read.readKeys = append(read.readKeys, key)
+	}
+	sort.Strings(read.readKeys)
+	read.storageKeys = append(read.storageKeys, read.readKeys...)
+
+	var replacedKeys []string
+	for key, def := range definitions {
+		if _, target := read.definitions[def.ReplacedBy]; target && def.ReplacedBy != "" {
+			read.replaced[key] = def
+			if _, alsoRead := read.definitions[key]; !alsoRead {
+				replacedKeys = append(replacedKeys, key)
+			}
+		}
+	}
+	sort.Strings(replacedKeys)
+	read.storageKeys = append(read.storageKeys, replacedKeys...)
+	return read, nil
+}
+
+// readUsersBatch reads read.readKeys for userIDs from the cache and storage into prefs.
+func (m *Manager) readUsersBatch(ctx context.Context, userIDs []string, read *bulkRead, prefs map[string]map[string]*Preference) error {
+	for _, userID := range userIDs {
+		prefs[userID] = make(map[string]*Preference, len(read.readKeys))
+	}
+	if m.config.cache != nil {
+		m.readCachedUsers(ctx, userIDs, read, prefs)
+	}
+
+	var missing []string
+	for _, userID := range userIDs {
+		if len(prefs[userID]) < len(read.readKeys) {
+			missing = append(missing, userID)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	stored, err := m.storageGetForUsers(ctx, missing, read.storageKeys)
+	if err != nil {
+		return err
+	}
+
+	var prefsToCache []*Preference
+	for _, userID := range missing {
+		rows := stored[userID]
+		// Values of replaced keys are read before the loop below decrypts them in place.
+		replacedStored := make(map[string]Preference)
+		for key := range read.replaced {
+			if row, ok := rows[key]; ok {
+				replacedStored[key] = *row
+			}
+		}
+
+		for _, key := range read.readKeys {
+			if _, cached := prefs[userID][key]; cached {
+				continue
+			}
+			def := read.definitions[key]
+			pref, foundInStorage := rows[key]
+			if foundInStorage {
+				if err := m.decodeStored(ctx, pref, def); err != nil {
+					m.config.logger.Error("Failed to read stored preference", "userID", userID, "key", key, "error", err)
+					return err
+				}
+				pref.DefaultValue = def.DefaultValue
+				pref.Type = def.Type
+				pref.Category = def.Category
+			} else {
+				// Fall back to a value stored under a key this one replaces
+				replaced, err := m.adoptReplacedStored(ctx, userID, def, read.replaced, replacedStored)
+				if err != nil {
+					return err
+				}
+				if replaced != nil {
+					// Like Get, a value read through a replaced key is not cached under the new key.
+					prefs[userID][key] = replaced
+					continue
+				}
+				pref = m.defaultPreference(ctx, userID, def)
+			}
+			prefs[userID][key] = pref
+			// Rule-based and computed defaults are not cached per user, as in GetAll.
+			if m.config.cache != nil && (foundInStorage || cacheableDefault(def)) {
+				cached := *pref
+				prefsToCache = append(prefsToCache, &cached)
+			}
+		}
+	}
+
+	if len(prefsToCache) > 0 {
+		dropped := m.warmer.enqueue(ctx, prefsToCache)
+		m.config.logger.Debug("Cache warming queued for bulk read results", "users", len(missing), "count", len(prefsToCache), "dropped", dropped)
+	}
+	return nil
+}
+
+// readCachedUsers reads the cached entries of read.readKeys for userIDs into prefs with a
+// single multi-get. Entries that cannot be read are left to be read from storage; without a
+// MultiGetter cache, all of them are.
+func (m *Manager) readCachedUsers(ctx context.Context, userIDs []string, read *bulkRead, prefs map[string]map[string]*Preference) {
+	getter, ok := m.config.cache.(MultiGetter)
+	if !ok {
+		return
+	}
+
+	tenantID := TenantFromContext(ctx)
+	cacheKeys := make([]string, 0, len(userIDs)*len(read.readKeys))
+	for _, userID := range userIDs {
+		for _, key := range read.readKeys {
+			cacheKeys = append(cacheKeys, prefCacheKey(tenantID, userID, key))
+		}
+	}
+
+	spanCtx, span := m.startSpan(ctx, "userprefs.cache.GetMany", AttrKeyCount.Int(len(cacheKeys)))
+	cached, err := getter.GetMany(spanCtx, cacheKeys)
+	span.SetAttributes(AttrCacheHit.Bool(len(cached) > 0))
+	endSpan(span, err)
+	if err != nil {
+		m.config.logger.Warn("Failed to get preferences from cache, reading from storage", "users", len(userIDs), "error", err)
+		return
+	}
+
+	i := 0
+	for _, userID := range userIDs {
+		for _, key := range read.readKeys {
+			cacheKey := cacheKeys[i]
+			i++
+			data, ok := cached[cacheKey]
+			if !ok {
+				continue
+			}
+			var pref Preference
+			if err := json.Unmarshal(data, &pref); err != nil {
+				m.config.logger.Warn("Cache Unmarshal failed, reading from storage", "cacheKey", cacheKey, "error", err)
+				continue
+			}
+			def := read.definitions[key]
+			// Entries cached before the definition's version was bumped still need upgrading.
+			if err := migrateValue(&pref, def); err != nil {
+				m.config.logger.Warn("Failed to migrate cached value, reading from storage", "userID", userID, "key", key, "error", err)
+				continue
+			}
+			decoded, err := decodeValue(pref.Value, def)
+			if err != nil {
+				m.config.logger.Warn("Failed to decode cached value, reading from storage", "userID", userID, "key", key, "error", err)
+				continue
+			}
+			pref.Value = decoded
+			prefs[userID][key] = &pref
+		}
+	}
+}
+
+// storageGetForUsers reads the stored values of keys for userIDs, keyed by user ID and then
+// by key, within a "userprefs.storage.GetForUsers" span. Without a MultiUserGetter, each
+// value is read with Get.
+func (m *Manager) storageGetForUsers(ctx context.Context, userIDs, keys []string) (map[string]map[string]*Preference, error) {
+	if err := m.checkScope(ctx); err != nil {
+		return nil, err
+	}
+	if getter, ok := m.config.storage.(MultiUserGetter); ok {
+		spanCtx, span := m.startSpan(ctx, "userprefs.storage.GetForUsers", AttrKeyCount.Int(len(keys)))
+		stored, err := getter.GetForUsers(spanCtx, userIDs, keys)
+		endSpan(span, err)
+		if err != nil {
+			m.config.logger.Error("Storage GetForUsers failed", "users", len(userIDs), "error", err)
+			return nil, fmt.Errorf("storage.GetForUsers failed: %w", err)
+		}
+		return stored, nil
+	}
+
+	stored := make(map[string]map[string]*Preference, len(userIDs))
+	for _, userID := range userIDs {
+		for _, key := range keys {
+			pref, err := m.storageGet(ctx, userID, key)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				m.config.logger.Error("Storage Get failed", "userID", userID, "key", key, "error", err)
+				return nil, fmt.Errorf("storage.Get failed for key '%s': %w", key, err)
+			}
+			if stored[userID] == nil {
+				stored[userID] = make(map[string]*Preference)
+			}
+			stored[userID][key] = pref
+		}
+	}
+	return stored, nil
+}
+
+// uniqueNonEmpty returns values without duplicates, in their original order. It reports
+// false if values is empty or contains an empty string.
+func uniqueNonEmpty(values []string) ([]string, bool) {
+	if len(values) == 0 {
+		return nil, false
+	}
+	seen := make(map[string]bool, len(values))
+	unique := make([]string, 0, len(values))
+	for _, value := range values {
+		if value == "" {
+			return nil, false
+		}
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique, true
+}
@@ -0,0 +1,199 @@
+package userprefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// multiUserStorage adds MultiUserGetter to MockStorage and counts its calls.
+type multiUserStorage struct {
+	*MockStorage
+	mu    sync.Mutex
+	calls [][]string // User IDs of each GetForUsers call.
+}
+
+func (s *multiUserStorage) GetForUsers(ctx context.Context, userIDs, keys []string) (map[string]map[string]*Preference, error) {
+	s.mu.Lock()
+	s.calls = append(s.calls, userIDs)
+	s.mu.Unlock()
+
+	result := make(map[string]map[string]*Preference)
+	for _, userID := range userIDs {
+		for _, key := range keys {
+			pref, err := s.Get(ctx, userID, key)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if result[userID] == nil {
+				result[userID] = make(map[string]*Preference)
+			}
+			result[userID][key] = pref
+		}
+	}
+	return result, nil
+}
+
+// multiGetCache adds MultiGetter to MockCache.
+type multiGetCache struct {
+	*MockCache
+}
+
+func (c *multiGetCache) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	result := make(map[string][]byte)
+	for _, key := range keys {
+		if data, err := c.Get(ctx, key); err == nil {
+			result[key] = data
+		}
+	}
+	return result, nil
+}
+
+func TestManager_GetForUsers(t *testing.T) {
+	ctx := context.Background()
+	mgr := newTestManager(t, []PreferenceDefinition{{Key: "theme", Type: StringType, DefaultValue: "light"}})
+	if err := mgr.Set(ctx, "u1", "theme", "dark"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	prefs, err := mgr.GetForUsers(ctx, "theme", []string{"u1", "u2", "u1"})
+	if err != nil {
+		t.Fatalf("GetForUsers failed: %v", err)
+	}
+	if len(prefs) != 2 {
+		t.Fatalf("Expected 2 users, got %d", len(prefs))
+	}
+	if prefs["u1"].Value != "dark" || prefs["u2"].Value != "light" {
+		t.Errorf("Expected u1=dark and u2=light, got u1=%v and u2=%v", prefs["u1"].Value, prefs["u2"].Value)
+	}
+	if prefs["u2"].UserID != "u2" || prefs["u2"].Key != "theme" {
+		t.Errorf("Unexpected default preference: %+v", prefs["u2"])
+	}
+
+	if _, err := mgr.GetForUsers(ctx, "missing", []string{"u1"}); !errors.Is(err, ErrPreferenceNotDefined) {
+		t.Errorf("Expected ErrPreferenceNotDefined, got %v", err)
+	}
+	for _, userIDs := range [][]string{nil, {"u1", ""}} {
+		if _, err := mgr.GetForUsers(ctx, "theme", userIDs); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("Expected ErrInvalidInput for users %q, got %v", userIDs, err)
+		}
+	}
+	if _, err := mgr.GetForUsers(ctx, "", []string{"u1"}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for an empty key, got %v", err)
+	}
+}
+
+func TestManager_GetManyForUsers(t *testing.T) {
+	ctx := context.Background()
+	storage := &multiUserStorage{MockStorage: NewMockStorage()}
+	mgr := newTestManager(t, []PreferenceDefinition{
+		{Key: "notifications", Type: BoolType, DefaultValue: true},
+		{Key: "sound", Type: StringType, DefaultValue: "chime", DependsOn: &Dependency{Key: "notifications"}},
+		{Key: "digest", Type: StringType, DefaultValue: "daily", DependsOn: &Dependency{Key: "notifications", Mode: DependencyHide}},
+		{Key: "language", Type: StringType, DefaultValue: "en"},
+		{Key: "lang", Type: StringType, DefaultValue: "en", ReplacedBy: "language"},
+	}, WithStorage(storage))
+
+	userIDs := make([]string, bulkBatchSize+10)
+	for i := range userIDs {
+		userIDs[i] = fmt.Sprintf("user%04d", i)
+	}
+	if err := mgr.Set(ctx, "user0001", "notifications", false); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := mgr.Set(ctx, "user0002", "sound", "bell"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	storeRaw(t, storage.MockStorage, "user0003", "lang", "de")
+
+	prefs, err := mgr.GetManyForUsers(ctx, []string{"sound", "digest", "language"}, userIDs)
+	if err != nil {
+		t.Fatalf("GetManyForUsers failed: %v", err)
+	}
+	if len(storage.calls) != 2 || len(storage.calls[0]) != bulkBatchSize || len(storage.calls[1]) != 10 {
+		t.Errorf("Expected storage reads of %d and 10 users, got %d calls", bulkBatchSize, len(storage.calls))
+	}
+	if len(prefs) != len(userIDs) {
+		t.Fatalf("Expected %d users, got %d", len(userIDs), len(prefs))
+	}
+
+	if len(prefs["user0000"]) != 3 || prefs["user0000"]["sound"].Value != "chime" || prefs["user0000"]["sound"].Disabled {
+		t.Errorf("Expected defaults for user0000, got %v", prefs["user0000"])
+	}
+	if _, ok := prefs["user0000"]["notifications"]; ok {
+		t.Error("Parents read for DependsOn should not be returned unless requested")
+	}
+	off := prefs["user0001"]
+	if !off["sound"].Disabled {
+		t.Error("Expected sound to be disabled while notifications are off")
+	}
+	if _, ok := off["digest"]; ok {
+		t.Error("Expected digest to be hidden while notifications are off")
+	}
+	if got := prefs["user0002"]["sound"].Value; got != "bell" {
+		t.Errorf("Expected stored sound 'bell', got %v", got)
+	}
+	if got := prefs["user0003"]["language"].Value; got != "de" {
+		t.Errorf("Expected language 'de' read through the replaced key, got %v", got)
+	}
+
+	if _, err := mgr.GetManyForUsers(ctx, []string{"sound", "missing"}, userIDs); !errors.Is(err, ErrPreferenceNotDefined) {
+		t.Errorf("Expected ErrPreferenceNotDefined, got %v", err)
+	}
+}
+
+func TestManager_GetManyForUsers_Cache(t *testing.T) {
+	ctx := context.Background()
+	storage := &multiUserStorage{MockStorage: NewMockStorage()}
+	cache := &multiGetCache{MockCache: NewMockCache()}
+	mgr := newTestManager(t, []PreferenceDefinition{{Key: "theme", Type: StringType, DefaultValue: "light"}},
+		WithStorage(storage), WithCache(cache), WithCacheWarming(0, 0))
+	storeRaw(t, storage.MockStorage, "u1", "theme", "dark")
+	storeRaw(t, storage.MockStorage, "u2", "theme", "blue")
+
+	// Get caches u1's value; a later change made behind the Manager's back is not seen until it expires.
+	if _, err := mgr.Get(ctx, "u1", "theme"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	storeRaw(t, storage.MockStorage, "u1", "theme", "changed")
+
+	prefs, err := mgr.GetForUsers(ctx, "theme", []string{"u1", "u2"})
+	if err != nil {
+		t.Fatalf("GetForUsers failed: %v", err)
+	}
+	if prefs["u1"].Value != "dark" {
+		t.Errorf("Expected u1's cached value 'dark', got %v", prefs["u1"].Value)
+	}
+	if prefs["u2"].Value != "blue" {
+		t.Errorf("Expected u2's stored value 'blue', got %v", prefs["u2"].Value)
+	}
+	if len(storage.calls) != 1 || len(storage.calls[0]) != 1 || storage.calls[0][0] != "u2" {
+		t.Errorf("Expected a single storage read for u2 only, got %v", storage.calls)
+	}
+}
+
+func TestTenantManager_GetForUsers(t *testing.T) {
+	ctx := context.Background()
+	mgr := newTestManager(t, nil)
+	acme := mgr.Tenant("acme")
+	definePreferences(t, acme, []PreferenceDefinition{{Key: "theme", Type: StringType, DefaultValue: "light"}})
+	if err := acme.Set(ctx, "u1", "theme", "dark"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	prefs, err := acme.GetForUsers(ctx, "theme", []string{"u1"})
+	if err != nil {
+		t.Fatalf("GetForUsers failed: %v", err)
+	}
+	if prefs["u1"].Value != "dark" {
+		t.Errorf("Expected 'dark', got %v", prefs["u1"].Value)
+	}
+	if _, err := mgr.GetForUsers(ctx, "theme", []string{"u1"}); !errors.Is(err, ErrPreferenceNotDefined) {
+		t.Errorf("Expected the default tenant not to know the key, got %v", err)
+	}
+}
@@ -69,6 +69,29 @@ func (c *MemoryCache) Get(_ context.Context, key string) ([]byte, error) {
 	return it.value, nil
 }
 
+// GetMany retrieves the items stored under any of keys, keyed by cache key. It implements the
+// userprefs.MultiGetter interface. Keys that are not cached or have expired are absent from the result.
+// The 'ctx' parameter is present for interface compliance but is not used in this implementation.
+// Returns userprefs.ErrCacheClosed if the cache has been closed.
+func (c *MemoryCache) GetMany(_ context.Context, keys []string) (map[string][]byte, error) {
+	if c.isClosed() {
+		return nil, userprefs.ErrCacheClosed
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := time.Now()
+	result := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		it, exists := c.items[key]
+		if !exists || (!it.expiration.IsZero() && now.After(it.expiration)) {
+			continue
+		}
+		result[key] = it.value
+	}
+	return result, nil
+}
+
 // Set adds an item (as a byte slice) to the memory cache with the given key, applying an optional TTL.
 // The 'ctx' parameter is present for interface compliance but is not used in this implementation.
 // The 'value' parameter must be a byte slice.
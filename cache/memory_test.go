@@ -44,6 +44,40 @@ func TestMemoryCache_GetSet(t *testing.T) {
 	}
 }
 
+func TestMemoryCache_GetMany(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryCache()
+	defer func() {
+		if err := cache.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+	}()
+
+	if err := cache.Set(ctx, "a", []byte("1"), time.Minute); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := cache.Set(ctx, "b", []byte("2"), 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := cache.Set(ctx, "expired", []byte("3"), time.Nanosecond); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+
+	got, err := cache.GetMany(ctx, []string{"a", "b", "expired", "missing"})
+	if err != nil {
+		t.Fatalf("GetMany failed: %v", err)
+	}
+	if len(got) != 2 || !bytes.Equal(got["a"], []byte("1")) || !bytes.Equal(got["b"], []byte("2")) {
+		t.Errorf("GetMany returned %q, want only a=1 and b=2", got)
+	}
+
+	_ = cache.Close()
+	if _, err := cache.GetMany(ctx, []string{"a"}); !errors.Is(err, userprefs.ErrCacheClosed) {
+		t.Errorf("Expected ErrCacheClosed after Close, got: %v", err)
+	}
+}
+
 func TestMemoryCache_Delete(t *testing.T) {
 	ctx := context.Background()
 	cache := NewMemoryCache()
@@ -163,6 +163,12 @@ type RedisClient interface {
 	Ping(ctx context.Context) *redis.StatusCmd // Add Ping for connection check
 }
 
+// redisMultiGetter is implemented by RedisClients that support MGET, such as the client
+// created by NewRedisCache. RedisCache.GetMany falls back to one GET per key for others.
+type redisMultiGetter interface {
+	MGet(ctx context.Context, keys ...string) *redis.SliceCmd
+}
+
 // RedisCache implements the userprefs.Cache interface using a Redis backend.
 // It leverages the github.com/redis/go-redis/v9 library for Redis communication.
 type RedisCache struct {
@@ -245,6 +251,43 @@ func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, error) {
 	return data, nil // Return the []byte
 }
 
+// GetMany retrieves the items stored under any of keys with a single MGET command, keyed by
+// cache key. It implements the userprefs.MultiGetter interface. Keys that do not exist in Redis
+// are absent from the result. If the RedisClient does not support MGET, the keys are read one by one.
+// Returns an error if the Redis operation fails.
+func (c *RedisCache) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	result := make(map[string][]byte, len(keys))
+	if len(keys) == 0 {
+		return result, nil
+	}
+
+	getter, ok := c.client.(redisMultiGetter)
+	if !ok {
+		for _, key := range keys {
+			data, err := c.Get(ctx, key)
+			if err == userprefs.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			result[key] = data
+		}
+		return result, nil
+	}
+
+	values, err := getter.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to mget from redis: %w", err)
+	}
+	for i, value := range values {
+		if s, ok := value.(string); ok && i < len(keys) {
+			result[keys[i]] = []byte(s)
+		}
+	}
+	return result, nil
+}
+
 // Set stores an item (as a byte slice) in Redis.
 // The 'value' parameter must be a byte slice, typically pre-marshalled by the caller (e.g., the Manager).
 // If 'ttl' (time-to-live) is greater than zero, the item will expire in Redis after that duration.
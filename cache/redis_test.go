@@ -52,6 +52,17 @@ func (m *MockRedisClient) Del(ctx context.Context, keys ...string) *redis.IntCmd
 	return redis.NewIntResult(int64(count), nil)
 }
 
+func (m *MockRedisClient) MGet(ctx context.Context, keys ...string) *redis.SliceCmd {
+	_, _ = ctx.Deadline()
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		if val, exists := m.data[key]; exists {
+			values[i] = val
+		}
+	}
+	return redis.NewSliceResult(values, nil)
+}
+
 func (m *MockRedisClient) Ping(ctx context.Context) *redis.StatusCmd {
 	_, _ = ctx.Deadline()
 	if m.PingErr != nil {
@@ -118,6 +129,40 @@ func TestRedisCache_GetSetDelete(t *testing.T) {
 	}
 }
 
+// getOnlyRedisClient hides the MGet method of a RedisClient.
+type getOnlyRedisClient struct {
+	RedisClient
+}
+
+func TestRedisCache_GetMany(t *testing.T) {
+	ctx := context.Background()
+	mockClient := NewMockRedisClient()
+	mockClient.data["a"] = "1"
+	mockClient.data["b"] = "2"
+
+	clients := map[string]RedisClient{
+		"mget":     mockClient,
+		"fallback": getOnlyRedisClient{mockClient},
+	}
+	for name, client := range clients {
+		t.Run(name, func(t *testing.T) {
+			redisCache := &RedisCache{client: client}
+			got, err := redisCache.GetMany(ctx, []string{"a", "missing", "b"})
+			if err != nil {
+				t.Fatalf("GetMany failed: %v", err)
+			}
+			if len(got) != 2 || !bytes.Equal(got["a"], []byte("1")) || !bytes.Equal(got["b"], []byte("2")) {
+				t.Errorf("GetMany returned %q, want only a=1 and b=2", got)
+			}
+
+			empty, err := redisCache.GetMany(ctx, nil)
+			if err != nil || len(empty) != 0 {
+				t.Errorf("GetMany(nil) = %q, %v; want empty result", empty, err)
+			}
+		})
+	}
+}
+
 func TestRedisCache_Close(t *testing.T) {
 	mockClient := NewMockRedisClient()
 
@@ -0,0 +1,102 @@
+// Package userprefs provides change notifications for preference writes.
+package userprefs
+
+import (
+	"context"
+	"time"
+)
+
+// ChangeKind describes how a preference was changed.
+type ChangeKind string
+
+const (
+	// ChangeSet reports a value written by Set or SetMany.
+	ChangeSet ChangeKind = "set"
+	// ChangeDelete reports a stored value removed by Delete.
+	ChangeDelete ChangeKind = "delete"
+	// ChangeReset reports a stored value removed by Reset, ResetCategory, or ResetAll.
+	ChangeReset ChangeKind = "reset"
+)
+
+// ChangeEvent describes a change to one of a user's preferences.
+type ChangeEvent struct {
+	// TenantID is the tenant the change was made in (see WithTenant).
+	TenantID string `json:"tenant_id,omitempty"`
+	// UserID is the user whose preference changed.
+	UserID string `json:"user_id"`
+	// Key is the preference key that changed.
+	Key string `json:"key"`
+	// DeviceID is the device whose override changed (see WithDevice). It is empty for changes
+	// to the user's own value.
+	DeviceID string `json:"device_id,omitempty"`
+	// Kind describes the operation that caused the change.
+	Kind ChangeKind `json:"kind"`
+	// Value is the preference's new effective value: the written value for ChangeSet, and
+	// the default the user now falls back to for ChangeDelete and ChangeReset. When a device
+	// override is removed, it is the user's own value the device falls back to.
+	Value interface{} `json:"value"`
+	// Time is when the change was made.
+	Time time.Time `json:"time"`
+}
+
+// ChangeListener is notified after a preference change has been persisted. Listeners run
+// synchronously in the goroutine that made the change, so they should return quickly and
+// hand slow work off elsewhere.
+type ChangeListener func(ctx context.Context, event ChangeEvent)
+
+// WithChangeListener is a functional option that registers a ChangeListener with the Manager.
+// It may be supplied more than once; listeners run in registration order.
+// This option is optional.
+func WithChangeListener(l ChangeListener) Option {
+	return func(c *Config) {
+		c.changeListeners = append(c.changeListeners, l)
+	}
+}
+
+// notifyChange sends a ChangeEvent for def to every registered listener.
+func (m *Manager) notifyChange(ctx context.Context, userID string, def PreferenceDefinition, kind ChangeKind, value interface{}) {
+	if len(m.config.changeListeners) == 0 {
+		return
+	}
+	_, deviceID := deviceScope(ctx, def)
+	event := ChangeEvent{
+		TenantID: TenantFromContext(ctx),
+		UserID:   userID,
+		Key:      def.Key,
+		DeviceID: deviceID,
+		Kind:     kind,
+		Value:    value,
+		Time:     time.Now(),
+	}
+	for _, listener := range m.config.changeListeners {
+		listener(ctx, event)
+	}
+}
+
+// notifyDefault sends a ChangeEvent whose Value is the default the user now falls back to, or,
+// for a removed device override, the user's own value. The value is only computed when there
+// are listeners to receive it.
+func (m *Manager) notifyDefault(ctx context.Context, userID string, def PreferenceDefinition, kind ChangeKind) {
+	if len(m.config.changeListeners) == 0 {
+		return
+	}
+	value, err := m.fallbackValue(ctx, userID, def)
+	if err != nil {
+		m.config.logger.Warn("Failed to read the value a device falls back to", "userID", userID, "key", def.Key, "error", err)
+		value = m.defaultPreference(ctx, userID, def).Value
+	}
+	m.notifyChange(ctx, userID, def, kind, value)
+}
+
+// fallbackValue returns the value userID sees for def once the value stored in the scope of ctx
+// is removed: the user's own value for a device override, and the default otherwise.
+func (m *Manager) fallbackValue(ctx context.Context, userID string, def PreferenceDefinition) (interface{}, error) {
+	if _, deviceID := deviceScope(ctx, def); deviceID != "" {
+		pref, err := m.get(withoutDevice(ctx), userID, def.Key)
+		if err != nil {
+			return nil, err
+		}
+		return pref.Value, nil
+	}
+	return m.defaultPreference(ctx, userID, def).Value, nil
+}
@@ -20,6 +20,7 @@ import (
 func main() {
 	// Basic flag for listen address
 	listenAddr := flag.String("listen-addr", ":8080", "HTTP listen address")
+	definitionsPoll := flag.Duration("definitions-poll", 30*time.Second, "How often to reload definitions saved by other instances (0 disables polling)")
 	// TODO: Add flags for storage type (postgres, sqlite, memory), DSNs, cache type (redis, memory), etc.
 	flag.Parse()
 
@@ -42,6 +43,7 @@ func main() {
 		userprefs.WithStorage(store),
 		userprefs.WithCache(cacher),
 		userprefs.WithLogger(logger),
+		userprefs.WithDefinitionStore(s, *definitionsPoll),
 	)
 
 	// Define some sample preferences (for testing/demonstration)
@@ -87,12 +89,9 @@ func main() {
 		logger.Error("Server shutdown failed", "error", err)
 	}
 
-	// Close storage and cache
-	// Assuming MemoryStorage might not have a Close() method or it's not needed for this simple type.
-	// If it did, and it returned an error: if err := s.Close(); err != nil { logger.Error(...) }
-
-	if err := ca.Close(); err != nil { // *cache.MemoryCache has a Close() error method
-		logger.Error("Failed to close cache", "error", err)
+	// Drain background cache warming, then close storage and cache
+	if err := mgr.Close(ctx); err != nil {
+		logger.Error("Failed to close preferences manager", "error", err)
 	}
 
 	logger.Info("Server exited gracefully")
@@ -0,0 +1,388 @@
+// Package userprefs provides declarative value constraints and a JSON Schema subset validator.
+package userprefs
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// patternCache holds compiled Pattern expressions, keyed by source.
+	patternCache sync.Map
+	// schemaCache holds parsed Schema documents, keyed by source.
+	schemaCache sync.Map
+)
+
+// validateConstraints checks that a definition's constraints are well-formed.
+func validateConstraints(def PreferenceDefinition) error {
+	if def.Min != nil && def.Max != nil && *def.Min > *def.Max {
+		return fmt.Errorf("%w: preference '%s' has min %v greater than max %v", ErrInvalidInput, def.Key, *def.Min, *def.Max)
+	}
+	for _, bound := range []*int{def.MinLength, def.MaxLength, def.MaxItems} {
+		if bound != nil && *bound < 0 {
+			return fmt.Errorf("%w: preference '%s' has a negative length constraint", ErrInvalidInput, def.Key)
+		}
+	}
+	if def.MinLength != nil && def.MaxLength != nil && *def.MinLength > *def.MaxLength {
+		return fmt.Errorf("%w: preference '%s' has min_length greater than max_length", ErrInvalidInput, def.Key)
+	}
+	if def.Pattern != "" {
+		if _, err := compilePattern(def.Pattern); err != nil {
+			return fmt.Errorf("%w: preference '%s' has an invalid pattern: %v", ErrInvalidInput, def.Key, err)
+		}
+	}
+	if len(def.Schema) > 0 {
+		if def.Type != JSONType {
+			return fmt.Errorf("%w: preference '%s' has a schema but is not of type '%s'", ErrInvalidInput, def.Key, JSONType)
+		}
+		if _, err := parseSchema(def.Schema); err != nil {
+			return fmt.Errorf("%w: preference '%s' has an invalid schema: %v", ErrInvalidInput, def.Key, err)
+		}
+	}
+	return nil
+}
+
+// checkConstraints evaluates def's declarative constraints against a type-checked value
+// and returns every violation.
+func checkConstraints(value interface{}, def PreferenceDefinition) ValidationErrors {
+	var violations ValidationErrors
+
+	switch v := value.(type) {
+	case time.Duration:
+		// Durations are int64 nanoseconds; numeric bounds are not applied to them.
+	case string:
+		violations = append(violations, checkString(v, "", def)...)
+	case []string:
+		violations = append(violations, checkItems(len(v), def)...)
+		for i, s := range v {
+			violations = append(violations, checkString(s, fmt.Sprintf("/%d", i), def)...)
+		}
+	case []int:
+		violations = append(violations, checkItems(len(v), def)...)
+		for i, n := range v {
+			violations = append(violations, checkNumber(float64(n), fmt.Sprintf("/%d", i), def)...)
+		}
+	case []int64:
+		violations = append(violations, checkItems(len(v), def)...)
+		for i, n := range v {
+			violations = append(violations, checkNumber(float64(n), fmt.Sprintf("/%d", i), def)...)
+		}
+	case []interface{}:
+		violations = append(violations, checkItems(len(v), def)...)
+		for i, item := range v {
+			path := fmt.Sprintf("/%d", i)
+			if s, ok := item.(string); ok {
+				violations = append(violations, checkString(s, path, def)...)
+			} else if n, ok := numericValue(item); ok {
+				violations = append(violations, checkNumber(n, path, def)...)
+			}
+		}
+	default:
+		if n, ok := numericValue(value); ok {
+			violations = append(violations, checkNumber(n, "", def)...)
+		}
+	}
+
+	if len(def.Schema) > 0 {
+		schema, err := parseSchema(def.Schema)
+		if err != nil {
+			// Definitions are checked on registration, so this only happens for hand-built defs.
+			violations = append(violations, &ValidationError{Rule: RuleSchema, Message: fmt.Sprintf("invalid schema: %v", err)})
+		} else {
+			violations = append(violations, schema.validate(normalizeJSON(value), "")...)
+		}
+	}
+
+	for _, v := range violations {
+		v.Key = def.Key
+		if v.Actual == "" {
+			v.Actual = actualType(value)
+		}
+	}
+	return violations
+}
+
+// checkString applies MinLength, MaxLength, and Pattern to s. Lengths count runes.
+func checkString(s, path string, def PreferenceDefinition) ValidationErrors {
+	var violations ValidationErrors
+	length := utf8.RuneCountInString(s)
+	if def.MinLength != nil && length < *def.MinLength {
+		violations = append(violations, &ValidationError{Rule: RuleMinLength, Path: path, Expected: *def.MinLength,
+			Message: fmt.Sprintf("length %d is shorter than %d", length, *def.MinLength)})
+	}
+	if def.MaxLength != nil && length > *def.MaxLength {
+		violations = append(violations, &ValidationError{Rule: RuleMaxLength, Path: path, Expected: *def.MaxLength,
+			Message: fmt.Sprintf("length %d is longer than %d", length, *def.MaxLength)})
+	}
+	if def.Pattern != "" {
+		if re, err := compilePattern(def.Pattern); err == nil && !re.MatchString(s) {
+			violations = append(violations, &ValidationError{Rule: RulePattern, Path: path, Expected: def.Pattern,
+				Message: fmt.Sprintf("%q does not match pattern %q", s, def.Pattern)})
+		}
+	}
+	return violations
+}
+
+// checkNumber applies Min and Max to n.
+func checkNumber(n float64, path string, def PreferenceDefinition) ValidationErrors {
+	var violations ValidationErrors
+	if def.Min != nil && n < *def.Min {
+		violations = append(violations, &ValidationError{Rule: RuleMin, Path: path, Expected: *def.Min,
+			Message: fmt.Sprintf("%v is less than minimum %v", n, *def.Min)})
+	}
+	if def.Max != nil && n > *def.Max {
+		violations = append(violations, &ValidationError{Rule: RuleMax, Path: path, Expected: *def.Max,
+			Message: fmt.Sprintf("%v is greater than maximum %v", n, *def.Max)})
+	}
+	return violations
+}
+
+// checkItems applies MaxItems to a list of the given length.
+func checkItems(count int, def PreferenceDefinition) ValidationErrors {
+	if def.MaxItems != nil && count > *def.MaxItems {
+		return ValidationErrors{{Rule: RuleMaxItems, Expected: *def.MaxItems,
+			Message: fmt.Sprintf("%d items exceed the maximum of %d", count, *def.MaxItems)}}
+	}
+	return nil
+}
+
+// numericValue converts Go numeric types to float64. Strings are not parsed, so constraints and
+// DefaultRule conditions treat "12" as a string rather than a number.
+func numericValue(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+// compilePattern compiles and caches a Pattern expression.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := patternCache.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	patternCache.Store(pattern, re)
+	return re, nil
+}
+
+// jsonSchema is the supported subset of JSON Schema: type, enum, properties, required,
+// additionalProperties (boolean only), items, minimum, maximum, minLength, maxLength,
+// pattern, minItems, and maxItems. Unknown keywords are ignored.
+type jsonSchema struct {
+	Type                 schemaTypes            `json:"type,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	MaxItems             *int                   `json:"maxItems,omitempty"`
+}
+
+// schemaTypes holds a schema's "type" keyword, which may be a string or a list of strings.
+type schemaTypes []string
+
+// UnmarshalJSON accepts both "string" and ["string", "null"] forms.
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("type must be a string or a list of strings")
+	}
+	*t = list
+	return nil
+}
+
+// parseSchema parses, checks, and caches a Schema document.
+func parseSchema(raw json.RawMessage) (*jsonSchema, error) {
+	if s, ok := schemaCache.Load(string(raw)); ok {
+		return s.(*jsonSchema), nil
+	}
+	var schema jsonSchema
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return nil, err
+	}
+	if err := schema.check(); err != nil {
+		return nil, err
+	}
+	schemaCache.Store(string(raw), &schema)
+	return &schema, nil
+}
+
+// check rejects unknown type names and invalid patterns anywhere in the schema.
+func (s *jsonSchema) check() error {
+	for _, t := range s.Type {
+		switch t {
+		case "object", "array", "string", "number", "integer", "boolean", "null":
+		default:
+			return fmt.Errorf("unsupported type %q", t)
+		}
+	}
+	if s.Pattern != "" {
+		if _, err := compilePattern(s.Pattern); err != nil {
+			return err
+		}
+	}
+	for _, prop := range s.Properties {
+		if err := prop.check(); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.check()
+	}
+	return nil
+}
+
+// validate checks a JSON-normalized value against the schema, reporting violations under path.
+func (s *jsonSchema) validate(value interface{}, path string) ValidationErrors {
+	fail := func(format string, args ...interface{}) ValidationErrors {
+		return ValidationErrors{{Rule: RuleSchema, Path: path, Actual: jsonTypeName(value), Message: fmt.Sprintf(format, args...)}}
+	}
+
+	if len(s.Type) > 0 && !s.Type.matches(value) {
+		return fail("expected %s, got %s", strings.Join(s.Type, " or "), jsonTypeName(value))
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, candidate := range s.Enum {
+			if reflect.DeepEqual(normalizeJSON(candidate), value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fail("value is not one of the enumerated values")
+		}
+	}
+
+	var violations ValidationErrors
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				violations = append(violations, &ValidationError{Rule: RuleSchema, Path: path + "/" + name, Message: fmt.Sprintf("missing required property %q", name)})
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop, ok := s.Properties[name]; ok {
+				violations = append(violations, prop.validate(v[name], path+"/"+name)...)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				violations = append(violations, &ValidationError{Rule: RuleSchema, Path: path + "/" + name, Message: fmt.Sprintf("unexpected property %q", name)})
+			}
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			violations = append(violations, fail("expected at least %d items, got %d", *s.MinItems, len(v))...)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			violations = append(violations, fail("expected at most %d items, got %d", *s.MaxItems, len(v))...)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				violations = append(violations, s.Items.validate(item, fmt.Sprintf("%s/%d", path, i))...)
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			violations = append(violations, fail("expected at least %d characters, got %d", *s.MinLength, length)...)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			violations = append(violations, fail("expected at most %d characters, got %d", *s.MaxLength, length)...)
+		}
+		if s.Pattern != "" {
+			if re, err := compilePattern(s.Pattern); err == nil && !re.MatchString(v) {
+				violations = append(violations, fail("%q does not match pattern %q", v, s.Pattern)...)
+			}
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			violations = append(violations, fail("%v is less than minimum %v", v, *s.Minimum)...)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			violations = append(violations, fail("%v is greater than maximum %v", v, *s.Maximum)...)
+		}
+	}
+	return violations
+}
+
+// matches reports whether value has one of the listed JSON types.
+func (t schemaTypes) matches(value interface{}) bool {
+	actual := jsonTypeName(value)
+	for _, want := range t {
+		if want == actual || (want == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonTypeName returns the JSON Schema type name of a JSON-normalized value.
+func jsonTypeName(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// normalizeJSON converts a value into its generic JSON form (maps, slices, float64) so that
+// structs and typed slices can be validated against a schema.
+func normalizeJSON(value interface{}) interface{} {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return value
+	}
+	return normalized
+}
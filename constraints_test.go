@@ -0,0 +1,141 @@
+package userprefs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func floatPtr(f float64) *float64 { return &f }
+func intPtr(i int) *int           { return &i }
+
+func TestValidateValue_Constraints(t *testing.T) {
+	testCases := []struct {
+		name      string
+		def       PreferenceDefinition
+		value     interface{}
+		wantRules []string
+	}{
+		{"int in range", PreferenceDefinition{Type: IntType, Min: floatPtr(1), Max: floatPtr(10)}, 5, nil},
+		{"int below min", PreferenceDefinition{Type: IntType, Min: floatPtr(1)}, 0, []string{RuleMin}},
+		{"float above max", PreferenceDefinition{Type: FloatType, Max: floatPtr(1.5)}, 2.0, []string{RuleMax}},
+		{"string length ok", PreferenceDefinition{Type: StringType, MinLength: intPtr(2), MaxLength: intPtr(4)}, "abc", nil},
+		{"string counts runes", PreferenceDefinition{Type: StringType, MaxLength: intPtr(2)}, "äö", nil},
+		{"string too short and mismatched", PreferenceDefinition{Type: StringType, MinLength: intPtr(3), Pattern: "^[a-z]+$"}, "A", []string{RuleMinLength, RulePattern}},
+		{"string list items", PreferenceDefinition{Type: StringListType, MaxItems: intPtr(2), Pattern: "^#"}, []string{"#a", "b", "#c"}, []string{RuleMaxItems, RulePattern}},
+		{"int list elements", PreferenceDefinition{Type: IntListType, Max: floatPtr(9)}, []int{1, 10}, []string{RuleMax}},
+		{"duration ignores numeric bounds", PreferenceDefinition{Type: DurationType, Max: floatPtr(1)}, "1h", nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.def.Key = "k"
+			err := validateValue(tc.value, tc.def)
+			if len(tc.wantRules) == 0 {
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidValue) {
+				t.Fatalf("Expected ErrInvalidValue, got %v", err)
+			}
+			verrs, _ := toValidationErrors(err, "", "", "")
+			if len(verrs) != len(tc.wantRules) {
+				t.Fatalf("Expected %d violations, got %v", len(tc.wantRules), verrs)
+			}
+			for i, rule := range tc.wantRules {
+				if verrs[i].Rule != rule || verrs[i].Key != "k" {
+					t.Errorf("Violation %d: expected rule %s on key k, got %s on %q", i, rule, verrs[i].Rule, verrs[i].Key)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateValue_Schema(t *testing.T) {
+	def := PreferenceDefinition{
+		Key:  "layout",
+		Type: JSONType,
+		Schema: json.RawMessage(`{
+			"type": "object",
+			"required": ["mode"],
+			"additionalProperties": false,
+			"properties": {
+				"mode": {"type": "string", "enum": ["compact", "cozy"]},
+				"columns": {"type": "integer", "minimum": 1, "maximum": 4},
+				"panels": {"type": "array", "maxItems": 2, "items": {"type": "string", "minLength": 1}}
+			}
+		}`),
+	}
+
+	if err := validateValue(map[string]interface{}{"mode": "cozy", "columns": 2, "panels": []string{"a"}}, def); err != nil {
+		t.Fatalf("Expected valid value, got %v", err)
+	}
+
+	type layout struct {
+		Columns int      `json:"columns"`
+		Panels  []string `json:"panels"`
+		Theme   string   `json:"theme"`
+	}
+	err := validateValue(layout{Columns: 7, Panels: []string{"a", "", "c"}, Theme: "dark"}, def)
+	var verrs ValidationErrors
+	if !errors.As(err, &verrs) {
+		t.Fatalf("Expected ValidationErrors, got %v", err)
+	}
+	paths := make(map[string]bool)
+	for _, v := range verrs {
+		if v.Rule != RuleSchema {
+			t.Errorf("Expected schema rule, got %s", v.Rule)
+		}
+		paths[v.Path] = true
+	}
+	for _, want := range []string{"/mode", "/columns", "/panels", "/panels/1", "/theme"} {
+		if !paths[want] {
+			t.Errorf("Expected a violation at %s, got %v", want, verrs)
+		}
+	}
+}
+
+func TestManager_DefinePreference_InvalidConstraints(t *testing.T) {
+	mgr := newTestManager(t, nil)
+
+	invalid := []PreferenceDefinition{
+		{Key: "range", Type: IntType, Min: floatPtr(5), Max: floatPtr(1)},
+		{Key: "length", Type: StringType, MinLength: intPtr(5), MaxLength: intPtr(1)},
+		{Key: "negative", Type: StringListType, MaxItems: intPtr(-1)},
+		{Key: "pattern", Type: StringType, Pattern: "("},
+		{Key: "schema_type", Type: StringType, Schema: json.RawMessage(`{"type": "string"}`)},
+		{Key: "schema_json", Type: JSONType, Schema: json.RawMessage(`{"type": 5}`)},
+		{Key: "schema_keyword", Type: JSONType, Schema: json.RawMessage(`{"type": "dict"}`)},
+	}
+	for _, def := range invalid {
+		if err := mgr.DefinePreference(def); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("DefinePreference(%s): expected ErrInvalidInput, got %v", def.Key, err)
+		}
+	}
+}
+
+func TestManager_Set_Constraints(t *testing.T) {
+	ctx := context.Background()
+	mgr := newTestManager(t, nil)
+
+	// Constraints survive a JSON round trip, as for definitions created through the REST API.
+	var def PreferenceDefinition
+	if err := json.Unmarshal([]byte(`{"key": "volume", "type": "int", "min": 0, "max": 100}`), &def); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if err := mgr.DefinePreference(def); err != nil {
+		t.Fatalf("DefinePreference failed: %v", err)
+	}
+
+	if err := mgr.Set(ctx, "u1", "volume", 80); err != nil {
+		t.Errorf("Set failed: %v", err)
+	}
+	err := mgr.Set(ctx, "u1", "volume", 120)
+	var verr *ValidationError
+	if !errors.As(err, &verr) || verr.Key != "volume" || verr.Rule != RuleMax || verr.Actual != "int" {
+		t.Errorf("Expected max violation for volume, got %v", err)
+	}
+}
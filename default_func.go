@@ -0,0 +1,96 @@
+// Package userprefs provides defaults computed per user by a definition's DefaultFunc.
+package userprefs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// defaultFuncCacheTTL is how long DefaultFunc results are cached unless WithDefaultFuncTTL says otherwise.
+const defaultFuncCacheTTL = 5 * time.Minute
+
+// WithDefaultFuncTTL is a functional option that sets how long the results of a definition's
+// DefaultFunc are cached, separately from the cached preferences themselves, so that a
+// profile service is not called on every read while changes to it still show up promptly.
+// Results are cached in the Manager's Cache (see WithCache); without one, DefaultFunc is
+// called on every read of a user without a stored value. A ttl of 0 or less disables the
+// caching. Without this option, results are cached for 5 minutes.
+// This option is optional.
+func WithDefaultFuncTTL(ttl time.Duration) Option {
+	return func(c *Config) {
+		c.defaultFuncTTL = ttl
+	}
+}
+
+// defaultCacheKey returns the cache key under which the DefaultFunc result of key for
+// userID in tenantID is stored.
+func defaultCacheKey(tenantID, userID, key string) string {
+	if tenantID == DefaultTenant {
+		return fmt.Sprintf("default:%s:%s", userID, key)
+	}
+	return fmt.Sprintf("tenant:%s:default:%s:%s", tenantID, userID, key)
+}
+
+// cacheableDefault reports whether the default of def is the same for every user and
+// context, so that it may be cached as the user's preference.
+func cacheableDefault(def PreferenceDefinition) bool {
+	return len(def.DefaultRules) == 0 && def.DefaultFunc == nil
+}
+
+// computedDefault returns the default def.DefaultFunc computes for userID, from the cache
+// when possible. It reports false when the function fails or returns nil or an invalid
+// value, in which case the caller falls back to def.DefaultValue. Failures are not cached,
+// so the function is called again on the next read.
+func (m *Manager) computedDefault(ctx context.Context, userID string, def PreferenceDefinition) (interface{}, bool) {
+	cacheKey := defaultCacheKey(TenantFromContext(ctx), userID, def.Key)
+	caching := m.config.cache != nil && m.config.defaultFuncTTL > 0
+
+	if caching {
+		spanCtx, span := m.startSpan(ctx, "userprefs.cache.Get", AttrKey.String(def.Key))
+		data, err := m.config.cache.Get(spanCtx, cacheKey)
+		span.SetAttributes(AttrCacheHit.Bool(err == nil))
+		endSpan(span, err)
+		if err == nil {
+			var value interface{}
+			if err := json.Unmarshal(data, &value); err != nil {
+				m.config.logger.Warn("Failed to unmarshal cached computed default", "cacheKey", cacheKey, "error", err)
+			} else if value == nil {
+				return nil, false
+			} else if decoded, err := decodeValue(value, def); err == nil {
+				return decoded, true
+			}
+		}
+	}
+
+	spanCtx, span := m.startSpan(ctx, "userprefs.DefaultFunc", AttrKey.String(def.Key))
+	value, err := def.DefaultFunc(spanCtx, userID)
+	endSpan(span, err)
+	if err != nil {
+		m.config.logger.Warn("DefaultFunc failed, using DefaultValue", "userID", userID, "key", def.Key, "error", err)
+		return nil, false
+	}
+	if value != nil {
+		if value, err = checkValue(value, def); err != nil {
+			m.config.logger.Warn("DefaultFunc returned an invalid value, using DefaultValue", "userID", userID, "key", def.Key, "error", err)
+			return nil, false
+		}
+	}
+
+	if caching {
+		// A nil result is cached too, so users without e.g. a profile locale do not hit the provider on every read.
+		data, err := json.Marshal(value)
+		if err != nil {
+			m.config.logger.Error("Failed to marshal computed default for cache", "userID", userID, "key", def.Key, "error", err)
+		} else {
+			spanCtx, span := m.startSpan(ctx, "userprefs.cache.Set", AttrKey.String(def.Key))
+			err = m.config.cache.Set(spanCtx, cacheKey, data, m.config.defaultFuncTTL)
+			endSpan(span, err)
+			if err != nil {
+				m.config.logger.Error("Failed to cache computed default", "cacheKey", cacheKey, "error", err)
+			}
+		}
+	}
+	return value, value != nil
+}
@@ -0,0 +1,205 @@
+package userprefs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ttlCache records the TTL of every entry written to a MockCache.
+type ttlCache struct {
+	*MockCache
+	mu   sync.Mutex
+	ttls map[string]time.Duration
+}
+
+func (c *ttlCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	c.ttls[key] = ttl
+	c.mu.Unlock()
+	return c.MockCache.Set(ctx, key, value, ttl)
+}
+
+func (c *ttlCache) ttl(key string) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ttl, ok := c.ttls[key]
+	return ttl, ok
+}
+
+// profileDefault is a DefaultFunc returning the locale of a user from a map, counting its calls.
+type profileDefault struct {
+	mu      sync.Mutex
+	locales map[string]interface{}
+	err     error
+	calls   int
+}
+
+func (p *profileDefault) locale(_ context.Context, userID string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p.locales[userID], nil
+}
+
+func (p *profileDefault) callCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+func TestManager_DefaultFunc(t *testing.T) {
+	ctx := context.Background()
+	profile := &profileDefault{locales: map[string]interface{}{"u1": "de-DE", "u2": "fr-FR"}}
+	cache := &ttlCache{MockCache: NewMockCache(), ttls: make(map[string]time.Duration)}
+	mgr := newTestManager(t, []PreferenceDefinition{{
+		Key:          "locale",
+		Type:         StringType,
+		DefaultValue: "en-US",
+		DefaultFunc:  profile.locale,
+	}}, WithCache(cache), WithDefaultFuncTTL(time.Minute), WithCacheWarming(0, 0))
+
+	for i := 0; i < 2; i++ {
+		pref, err := mgr.Get(ctx, "u1", "locale")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if pref.Value != "de-DE" || pref.DefaultValue != "de-DE" {
+			t.Errorf("Expected the computed default 'de-DE', got value %v and default %v", pref.Value, pref.DefaultValue)
+		}
+	}
+	if calls := profile.callCount(); calls != 1 {
+		t.Errorf("Expected the computed default to be cached after 1 call, got %d calls", calls)
+	}
+	if ttl, ok := cache.ttl(defaultCacheKey(DefaultTenant, "u1", "locale")); !ok || ttl != time.Minute {
+		t.Errorf("Expected the computed default to be cached for 1m, got %v (cached: %v)", ttl, ok)
+	}
+	if _, ok := cache.ttl(prefCacheKey(DefaultTenant, "u1", "locale")); ok {
+		t.Error("Computed defaults should not be cached as the user's preference")
+	}
+
+	prefs, err := mgr.GetAll(ctx, "u2")
+	if err != nil {
+		t.Fatalf("GetAll failed: %v", err)
+	}
+	if prefs["locale"].Value != "fr-FR" {
+		t.Errorf("Expected GetAll to return the computed default 'fr-FR', got %v", prefs["locale"].Value)
+	}
+
+	if err := mgr.Set(ctx, "u1", "locale", "it-IT"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if pref, err := mgr.Get(ctx, "u1", "locale"); err != nil || pref.Value != "it-IT" {
+		t.Errorf("Expected the stored value 'it-IT' to win over the computed default, got %v (err: %v)", pref, err)
+	}
+}
+
+func TestManager_DefaultFunc_Fallback(t *testing.T) {
+	ctx := context.Background()
+	profile := &profileDefault{locales: map[string]interface{}{"u1": 42}}
+	mgr := newTestManager(t, []PreferenceDefinition{{
+		Key:          "locale",
+		Type:         StringType,
+		DefaultValue: "en-US",
+		DefaultFunc:  profile.locale,
+		DefaultRules: []DefaultRule{{
+			Name:       "swiss",
+			Conditions: []RuleCondition{{Attribute: "country", Operator: OpEquals, Value: "CH"}},
+			Value:      "de-CH",
+		}},
+	}})
+
+	testCases := []struct {
+		name   string
+		ctx    context.Context
+		userID string
+		err    error
+		want   interface{}
+	}{
+		{"invalid value", ctx, "u1", nil, "en-US"},
+		{"nil value", ctx, "u2", nil, "en-US"},
+		{"provider error", ctx, "u3", errors.New("profile service unavailable"), "en-US"},
+		{"rule wins", WithAttributes(ctx, Attributes{"country": "CH"}), "u4", nil, "de-CH"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			profile.mu.Lock()
+			profile.err = tc.err
+			profile.mu.Unlock()
+			pref, err := mgr.Get(tc.ctx, tc.userID, "locale")
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			if pref.Value != tc.want {
+				t.Errorf("Expected %v, got %v", tc.want, pref.Value)
+			}
+		})
+	}
+
+	// Errors are not cached, so the provider is asked again once it recovers.
+	profile.mu.Lock()
+	profile.err = nil
+	profile.locales["u3"] = "nl-NL"
+	profile.mu.Unlock()
+	if pref, err := mgr.Get(ctx, "u3", "locale"); err != nil || pref.Value != "nl-NL" {
+		t.Errorf("Expected 'nl-NL' after the provider recovered, got %v (err: %v)", pref, err)
+	}
+	// Nil results are cached like any other.
+	calls := profile.callCount()
+	if _, err := mgr.Get(ctx, "u2", "locale"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if profile.callCount() != calls {
+		t.Error("Expected the nil result for u2 to be served from the cache")
+	}
+}
+
+func TestManager_DefaultFunc_NoCaching(t *testing.T) {
+	ctx := context.Background()
+	profile := &profileDefault{locales: map[string]interface{}{"u1": "de-DE"}}
+	cache := NewMockCache()
+	mgr := newTestManager(t, []PreferenceDefinition{{Key: "locale", Type: StringType, DefaultValue: "en-US", DefaultFunc: profile.locale}}, WithCache(cache), WithDefaultFuncTTL(0))
+
+	for i := 0; i < 2; i++ {
+		if _, err := mgr.Get(ctx, "u1", "locale"); err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+	}
+	if calls := profile.callCount(); calls != 2 {
+		t.Errorf("Expected a call per read with caching disabled, got %d", calls)
+	}
+	if _, err := cache.Get(ctx, defaultCacheKey(DefaultTenant, "u1", "locale")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected no cached computed default, got %v", err)
+	}
+}
+
+func TestManager_DefaultFunc_Erasure(t *testing.T) {
+	ctx := context.Background()
+	profile := &profileDefault{locales: map[string]interface{}{"u1": "de-DE"}}
+	cache := NewMockCache()
+	mgr := newTestManager(t, []PreferenceDefinition{{Key: "locale", Type: StringType, DefaultValue: "en-US", DefaultFunc: profile.locale}}, WithCache(cache))
+	if _, err := mgr.Get(ctx, "u1", "locale"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	receipt, err := mgr.DeleteUser(ctx, "u1")
+	if err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+	cacheKey := defaultCacheKey(DefaultTenant, "u1", "locale")
+	found := false
+	for _, key := range receipt.CacheKeys {
+		found = found || key == cacheKey
+	}
+	if !found {
+		t.Errorf("Expected %s in the receipt, got %v", cacheKey, receipt.CacheKeys)
+	}
+	if _, err := cache.Get(ctx, cacheKey); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the computed default to be erased from the cache, got %v", err)
+	}
+}
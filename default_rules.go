@@ -0,0 +1,287 @@
+// Package userprefs provides rule-based default values with percentage rollouts and attribute targeting.
+package userprefs
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+// rolloutBuckets is the number of buckets users are hashed into for percentage rollouts.
+// 10000 buckets give a rollout granularity of 0.01%.
+const rolloutBuckets = 10000
+
+// attributesContextKey is the context key under which request Attributes are stored.
+type attributesContextKey struct{}
+
+// Attributes holds caller-supplied facts about the current user, such as their plan,
+// country, or signup date. DefaultRules match against these values when choosing the
+// default for a user without an explicitly stored value.
+type Attributes map[string]interface{}
+
+// WithAttributes returns a copy of ctx carrying the given user attributes.
+// Attributes are read by the Manager when evaluating a definition's DefaultRules.
+func WithAttributes(ctx context.Context, attrs Attributes) context.Context {
+	return context.WithValue(ctx, attributesContextKey{}, attrs)
+}
+
+// AttributesFromContext returns the user attributes stored in ctx by WithAttributes,
+// or nil if none are present.
+func AttributesFromContext(ctx context.Context) Attributes {
+	attrs, _ := ctx.Value(attributesContextKey{}).(Attributes)
+	return attrs
+}
+
+// RuleOperator identifies how a RuleCondition compares a user attribute with its Value.
+type RuleOperator string
+
+// Supported rule operators. Ordering operators (gt, gte, lt, lte) compare numbers numerically
+// and times chronologically; times may be given as time.Time or as RFC 3339 / "2006-01-02" strings.
+const (
+	// OpEquals matches when the attribute equals the condition value.
+	OpEquals RuleOperator = "eq"
+	// OpNotEquals matches when the attribute is absent or differs from the condition value.
+	OpNotEquals RuleOperator = "neq"
+	// OpIn matches when the attribute equals any element of the condition value, which must be a list.
+	OpIn RuleOperator = "in"
+	// OpNotIn matches when the attribute is absent or equals no element of the condition value list.
+	OpNotIn RuleOperator = "not_in"
+	// OpGreaterThan matches when the attribute is greater than (or after) the condition value.
+	OpGreaterThan RuleOperator = "gt"
+	// OpGreaterOrEqual matches when the attribute is greater than or equal to the condition value.
+	OpGreaterOrEqual RuleOperator = "gte"
+	// OpLessThan matches when the attribute is less than (or before) the condition value.
+	OpLessThan RuleOperator = "lt"
+	// OpLessOrEqual matches when the attribute is less than or equal to the condition value.
+	OpLessOrEqual RuleOperator = "lte"
+)
+
+// RuleCondition is a single attribute match within a DefaultRule.
+type RuleCondition struct {
+	// Attribute is the name of the user attribute to test, as supplied via WithAttributes.
+	Attribute string `json:"attribute"`
+	// Operator is the comparison to apply.
+	Operator RuleOperator `json:"operator"`
+	// Value is the operand compared against the attribute. For OpIn and OpNotIn it must be a list.
+	Value interface{} `json:"value"`
+}
+
+// DefaultRule selects an alternative default value for users that match its conditions
+// and fall inside its rollout percentage. Rules are evaluated in order; the first rule
+// that applies determines the default.
+type DefaultRule struct {
+	// Name identifies the rule. It is reported in Preference.AppliedRule and salts the rollout
+	// hash, so renaming a rule reshuffles which users fall inside its percentage.
+	Name string `json:"name"`
+	// Conditions must all match the user's Attributes for the rule to apply.
+	// A rule without conditions matches every user.
+	Conditions []RuleCondition `json:"conditions,omitempty"`
+	// Percentage, if set, limits the rule to a stable share of matching users (0-100).
+	// Users are bucketed by a hash of the preference key, rule name, and user ID, so a
+	// user who is inside a rollout stays inside as the percentage grows.
+	// A nil Percentage applies the rule to all matching users.
+	Percentage *float64 `json:"percentage,omitempty"`
+	// Value is the default returned when the rule applies.
+	Value interface{} `json:"value"`
+}
+
+// validateDefaultRules checks that a definition's DefaultRules are well-formed.
+func validateDefaultRules(def PreferenceDefinition) error {
+	names := make(map[string]bool, len(def.DefaultRules))
+	for _, rule := range def.DefaultRules {
+		if rule.Name == "" {
+			return fmt.Errorf("%w: preference '%s' has a default rule without a name", ErrInvalidInput, def.Key)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("%w: preference '%s' has duplicate default rule '%s'", ErrInvalidInput, def.Key, rule.Name)
+		}
+		names[rule.Name] = true
+
+		if rule.Percentage != nil && (*rule.Percentage < 0 || *rule.Percentage > 100) {
+			return fmt.Errorf("%w: default rule '%s' of preference '%s' has percentage %v outside [0, 100]", ErrInvalidInput, rule.Name, def.Key, *rule.Percentage)
+		}
+
+		for _, cond := range rule.Conditions {
+			if cond.Attribute == "" {
+				return fmt.Errorf("%w: default rule '%s' of preference '%s' has a condition without an attribute", ErrInvalidInput, rule.Name, def.Key)
+			}
+			switch cond.Operator {
+			case OpEquals, OpNotEquals, OpGreaterThan, OpGreaterOrEqual, OpLessThan, OpLessOrEqual:
+			case OpIn, OpNotIn:
+				if _, ok := asList(cond.Value); !ok {
+					return fmt.Errorf("%w: default rule '%s' of preference '%s' uses '%s' with a non-list value", ErrInvalidInput, rule.Name, def.Key, cond.Operator)
+				}
+			default:
+				return fmt.Errorf("%w: default rule '%s' of preference '%s' has unsupported operator '%s'", ErrInvalidInput, rule.Name, def.Key, cond.Operator)
+			}
+		}
+	}
+	return nil
+}
+
+// normalizeDefaultRules returns a copy of def's DefaultRules with each Value normalized and
+// checked against def's type, constraints, and AllowedValues, so that a rule cannot make Get
+// return a value that Set would reject. def.AllowedValues must already be normalized.
+func normalizeDefaultRules(def PreferenceDefinition) ([]DefaultRule, error) {
+	if len(def.DefaultRules) == 0 {
+		return def.DefaultRules, nil
+	}
+	rules := make([]DefaultRule, len(def.DefaultRules))
+	copy(rules, def.DefaultRules)
+	for i, rule := range rules {
+		value, err := normalizeValue(rule.Value, def)
+		if err == nil {
+			err = validateValue(value, def)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: default rule '%s' of preference '%s' has an invalid value: %v", ErrInvalidInput, rule.Name, def.Key, err)
+		}
+		rules[i].Value = value
+	}
+	return rules, nil
+}
+
+// resolveDefault returns the default value for userID and the name of the rule that produced it.
+// If no rule applies, def.DefaultValue is returned with an empty rule name.
+func resolveDefault(ctx context.Context, userID string, def PreferenceDefinition) (interface{}, string) {
+	if len(def.DefaultRules) == 0 {
+		return def.DefaultValue, ""
+	}
+
+	attrs := AttributesFromContext(ctx)
+	for _, rule := range def.DefaultRules {
+		if ruleApplies(rule, def.Key, userID, attrs) {
+			return rule.Value, rule.Name
+		}
+	}
+	return def.DefaultValue, ""
+}
+
+// ruleApplies reports whether every condition of rule matches attrs and userID falls inside its rollout.
+func ruleApplies(rule DefaultRule, key, userID string, attrs Attributes) bool {
+	for _, cond := range rule.Conditions {
+		if !conditionMatches(cond, attrs) {
+			return false
+		}
+	}
+	if rule.Percentage == nil {
+		return true
+	}
+	return float64(rolloutBucket(key, rule.Name, userID)) < *rule.Percentage*rolloutBuckets/100
+}
+
+// rolloutBucket deterministically maps a user into [0, rolloutBuckets) for a given key and rule.
+func rolloutBucket(key, ruleName, userID string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key + "\x00" + ruleName + "\x00" + userID)) // hash.Hash.Write never returns an error
+	return h.Sum32() % rolloutBuckets
+}
+
+// conditionMatches evaluates a single condition against attrs.
+// Absent attributes only satisfy the negative operators.
+func conditionMatches(cond RuleCondition, attrs Attributes) bool {
+	actual, present := attrs[cond.Attribute]
+
+	switch cond.Operator {
+	case OpEquals:
+		return present && attributeEquals(actual, cond.Value)
+	case OpNotEquals:
+		return !present || !attributeEquals(actual, cond.Value)
+	case OpIn, OpNotIn:
+		found := false
+		if list, ok := asList(cond.Value); ok && present {
+			for _, candidate := range list {
+				if attributeEquals(actual, candidate) {
+					found = true
+					break
+				}
+			}
+		}
+		return found == (cond.Operator == OpIn)
+	case OpGreaterThan, OpGreaterOrEqual, OpLessThan, OpLessOrEqual:
+		if !present {
+			return false
+		}
+		cmp, ok := compareAttributes(actual, cond.Value)
+		if !ok {
+			return false
+		}
+		switch cond.Operator {
+		case OpGreaterThan:
+			return cmp > 0
+		case OpGreaterOrEqual:
+			return cmp >= 0
+		case OpLessThan:
+			return cmp < 0
+		default:
+			return cmp <= 0
+		}
+	default:
+		return false
+	}
+}
+
+// attributeEquals compares two attribute values, treating numbers and times by value
+// and everything else by its string form.
+func attributeEquals(a, b interface{}) bool {
+	if cmp, ok := compareAttributes(a, b); ok {
+		return cmp == 0
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+// compareAttributes orders two values when both are numbers or both are times.
+// The boolean result is false when the values are not comparable.
+func compareAttributes(a, b interface{}) (int, bool) {
+	if af, ok := numericValue(a); ok {
+		if bf, ok := numericValue(b); ok {
+			switch {
+			case af < bf:
+				return -1, true
+			case af > bf:
+				return 1, true
+			default:
+				return 0, true
+			}
+		}
+	}
+
+	if at, ok := asTime(a); ok {
+		if bt, ok := asTime(b); ok {
+			return at.Compare(bt), true
+		}
+	}
+	return 0, false
+}
+
+// asTime converts time.Time values and RFC 3339 or date-only strings to time.Time.
+func asTime(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, time.DateOnly} {
+			if parsed, err := time.Parse(layout, t); err == nil {
+				return parsed, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// asList converts a condition value into a slice for the list operators.
+func asList(v interface{}) ([]interface{}, bool) {
+	switch l := v.(type) {
+	case []interface{}:
+		return l, true
+	case []string:
+		out := make([]interface{}, len(l))
+		for i, s := range l {
+			out[i] = s
+		}
+		return out, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,192 @@
+package userprefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func percentage(p float64) *float64 { return &p }
+
+func TestManager_DefaultRules_AttributeTargeting(t *testing.T) {
+	ctx := context.Background()
+	def := PreferenceDefinition{
+		Key:          "editor.beta",
+		Type:         BoolType,
+		DefaultValue: false,
+		DefaultRules: []DefaultRule{
+			{
+				Name: "pro-in-de",
+				Conditions: []RuleCondition{
+					{Attribute: "plan", Operator: OpEquals, Value: "pro"},
+					{Attribute: "country", Operator: OpIn, Value: []interface{}{"DE", "AT"}},
+				},
+				Value: true,
+			},
+			{
+				Name:       "recent-signups",
+				Conditions: []RuleCondition{{Attribute: "signup_date", Operator: OpGreaterOrEqual, Value: "2025-01-01"}},
+				Value:      true,
+			},
+		},
+	}
+	mgr := newTestManager(t, []PreferenceDefinition{def})
+
+	testCases := []struct {
+		name         string
+		attrs        Attributes
+		expectedVal  bool
+		expectedRule string
+	}{
+		{name: "no attributes", attrs: nil, expectedVal: false, expectedRule: ""},
+		{name: "pro in DE", attrs: Attributes{"plan": "pro", "country": "DE"}, expectedVal: true, expectedRule: "pro-in-de"},
+		{name: "pro in US", attrs: Attributes{"plan": "pro", "country": "US"}, expectedVal: false, expectedRule: ""},
+		{name: "recent signup as time", attrs: Attributes{"signup_date": time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)}, expectedVal: true, expectedRule: "recent-signups"},
+		{name: "old signup as string", attrs: Attributes{"signup_date": "2023-06-30"}, expectedVal: false, expectedRule: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pref, err := mgr.Get(WithAttributes(ctx, tc.attrs), "user1", def.Key)
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			if pref.Value != tc.expectedVal {
+				t.Errorf("Expected value %v, got %v", tc.expectedVal, pref.Value)
+			}
+			if pref.AppliedRule != tc.expectedRule {
+				t.Errorf("Expected applied rule %q, got %q", tc.expectedRule, pref.AppliedRule)
+			}
+		})
+	}
+
+	// An explicitly stored value always wins over rules and reports no rule.
+	if err := mgr.Set(ctx, "user1", def.Key, false); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	pref, err := mgr.Get(WithAttributes(ctx, Attributes{"plan": "pro", "country": "DE"}), "user1", def.Key)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if pref.Value != false || pref.AppliedRule != "" {
+		t.Errorf("Expected stored value false without rule, got %v (rule %q)", pref.Value, pref.AppliedRule)
+	}
+}
+
+func TestManager_DefaultRules_PercentageRollout(t *testing.T) {
+	ctx := context.Background()
+	def := PreferenceDefinition{
+		Key:          "theme",
+		Type:         StringType,
+		DefaultValue: "light",
+		DefaultRules: []DefaultRule{{Name: "dark-rollout", Percentage: percentage(25), Value: "dark"}},
+	}
+	mgr := newTestManager(t, []PreferenceDefinition{def})
+
+	const users = 2000
+	inRollout := make(map[string]bool)
+	for i := 0; i < users; i++ {
+		userID := fmt.Sprintf("user%d", i)
+		pref, err := mgr.Get(ctx, userID, def.Key)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if pref.Value == "dark" {
+			inRollout[userID] = true
+			if pref.AppliedRule != "dark-rollout" {
+				t.Errorf("Expected applied rule 'dark-rollout', got %q", pref.AppliedRule)
+			}
+		}
+	}
+
+	share := float64(len(inRollout)) / users
+	if share < 0.20 || share > 0.30 {
+		t.Errorf("Expected roughly 25%% of users in rollout, got %.1f%%", share*100)
+	}
+
+	// Widening the rollout keeps everyone who was already included.
+	def.DefaultRules[0].Percentage = percentage(60)
+	if err := mgr.DefinePreference(def); err != nil {
+		t.Fatalf("DefinePreference failed: %v", err)
+	}
+	for userID := range inRollout {
+		pref, err := mgr.Get(ctx, userID, def.Key)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if pref.Value != "dark" {
+			t.Fatalf("User %s dropped out of the rollout after it was widened", userID)
+		}
+	}
+}
+
+func TestManager_DefaultRules_GetAll(t *testing.T) {
+	ctx := WithAttributes(context.Background(), Attributes{"plan": "enterprise"})
+	mgr := newTestManager(t, []PreferenceDefinition{{
+		Key:          "retention_days",
+		Type:         IntType,
+		DefaultValue: 30,
+		DefaultRules: []DefaultRule{{Name: "enterprise", Conditions: []RuleCondition{{Attribute: "plan", Operator: OpEquals, Value: "enterprise"}}, Value: 365}},
+	}})
+
+	all, err := mgr.GetAll(ctx, "user1")
+	if err != nil {
+		t.Fatalf("GetAll failed: %v", err)
+	}
+	pref := all["retention_days"]
+	if pref.Value != 365 || pref.DefaultValue != 365 || pref.AppliedRule != "enterprise" {
+		t.Errorf("Expected enterprise default 365, got value %v default %v rule %q", pref.Value, pref.DefaultValue, pref.AppliedRule)
+	}
+}
+
+func TestManager_DefinePreference_InvalidDefaultRules(t *testing.T) {
+	mgr := newTestManager(t, nil)
+
+	invalid := []PreferenceDefinition{
+		{Key: "unnamed", Type: BoolType, DefaultRules: []DefaultRule{{Value: true}}},
+		{Key: "duplicate", Type: BoolType, DefaultRules: []DefaultRule{{Name: "a", Value: true}, {Name: "a", Value: false}}},
+		{Key: "percentage", Type: BoolType, DefaultRules: []DefaultRule{{Name: "a", Percentage: percentage(150), Value: true}}},
+		{Key: "operator", Type: BoolType, DefaultRules: []DefaultRule{{Name: "a", Conditions: []RuleCondition{{Attribute: "plan", Operator: "like", Value: "p"}}}}},
+		{Key: "in_scalar", Type: BoolType, DefaultRules: []DefaultRule{{Name: "a", Conditions: []RuleCondition{{Attribute: "plan", Operator: OpIn, Value: "pro"}}}}},
+		{Key: "no_attribute", Type: BoolType, DefaultRules: []DefaultRule{{Name: "a", Conditions: []RuleCondition{{Operator: OpEquals, Value: "pro"}}}}},
+		{Key: "value_type", Type: BoolType, DefaultRules: []DefaultRule{{Name: "a", Value: "yes"}}},
+		{Key: "value_not_allowed", Type: EnumType, DefaultValue: "light", AllowedValues: []interface{}{"light", "dark"}, DefaultRules: []DefaultRule{{Name: "a", Value: "sepia"}}},
+		{Key: "value_out_of_range", Type: IntType, Min: floatPtr(0), Max: floatPtr(10), DefaultRules: []DefaultRule{{Name: "a", Value: 11}}},
+	}
+	for _, def := range invalid {
+		if err := mgr.DefinePreference(def); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("DefinePreference(%s): expected ErrInvalidInput, got %v", def.Key, err)
+		}
+	}
+}
+
+func TestConditionMatches(t *testing.T) {
+	attrs := Attributes{"plan": "pro", "seats": 12, "quota": uint16(500), "code": "42", "signup": "2024-05-01T10:00:00Z"}
+
+	testCases := []struct {
+		cond     RuleCondition
+		expected bool
+	}{
+		{RuleCondition{Attribute: "plan", Operator: OpNotEquals, Value: "free"}, true},
+		{RuleCondition{Attribute: "missing", Operator: OpNotEquals, Value: "free"}, true},
+		{RuleCondition{Attribute: "missing", Operator: OpEquals, Value: "free"}, false},
+		{RuleCondition{Attribute: "plan", Operator: OpNotIn, Value: []string{"free", "trial"}}, true},
+		{RuleCondition{Attribute: "seats", Operator: OpEquals, Value: 12.0}, true},
+		{RuleCondition{Attribute: "seats", Operator: OpGreaterThan, Value: 10}, true},
+		{RuleCondition{Attribute: "seats", Operator: OpLessOrEqual, Value: 11}, false},
+		{RuleCondition{Attribute: "signup", Operator: OpLessThan, Value: "2024-06-01"}, true},
+		{RuleCondition{Attribute: "plan", Operator: OpGreaterThan, Value: 1}, false},
+		// Numbers are converted as by the Min and Max constraints: any Go numeric type, no strings.
+		{RuleCondition{Attribute: "quota", Operator: OpGreaterOrEqual, Value: int64(500)}, true},
+		{RuleCondition{Attribute: "seats", Operator: OpLessThan, Value: float32(12.5)}, true},
+		{RuleCondition{Attribute: "code", Operator: OpGreaterThan, Value: 10}, false},
+		{RuleCondition{Attribute: "code", Operator: OpEquals, Value: "42"}, true},
+	}
+	for _, tc := range testCases {
+		if got := conditionMatches(tc.cond, attrs); got != tc.expected {
+			t.Errorf("conditionMatches(%+v) = %v, expected %v", tc.cond, got, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+// Package userprefs provides persistence of the definition catalogue in a DefinitionStore.
+package userprefs
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// WithDefinitionStore is a functional option that persists definitions in store, so that
+// Manager instances sharing it share their catalogue and definitions survive restarts.
+//
+// New loads the stored definitions of every tenant, and DefinePreference and DeleteDefinition
+// write through to store before changing the catalogue. With a positive pollInterval, the
+// catalogue is reloaded from store at that interval, so that each instance picks up the
+// definitions other instances add, change, or delete; 0 disables polling (see
+// ReloadDefinitions). Polling stops on Close.
+//
+// Function fields (ValidateFunc, NormalizeFunc, Migrations, Transform, DefaultFunc, and
+// MergeFunc) are not stored. An instance keeps those of its own definitions when it reloads
+// them, so every instance should define the preferences that need them at startup, as before.
+// This option is optional.
+func WithDefinitionStore(store DefinitionStore, pollInterval time.Duration) Option {
+	return func(c *Config) {
+		c.definitionStore = store
+		c.definitionPollInterval = pollInterval
+	}
+}
+
+// ReloadDefinitions replaces the catalogue of every tenant with the definitions stored in the
+// DefinitionStore. Local definitions that are no longer stored are removed, and the function
+// fields of local definitions are kept on their reloaded versions. A stored definition that
+// this instance cannot register, e.g. an encrypted one without an encryption manager, is
+// skipped with a logged error and its local version, if any, is kept. Dependencies are
+// checked once the whole catalogue is loaded, so a stored definition that closes a cycle with
+// other stored definitions is handled the same way.
+//
+// It is called by New and, with a poll interval, periodically; call it directly to pick up
+// changes sooner, e.g. on a notification from another instance.
+//
+// Returns:
+//   - nil: On success, or if no DefinitionStore is configured.
+//   - A wrapped store error: If loading the definitions fails. The catalogue is unchanged.
+//
+// This method is thread-safe.
+func (m *Manager) ReloadDefinitions(ctx context.Context) (err error) {
+	store := m.config.definitionStore
+	if store == nil {
+		return nil
+	}
+	ctx, span := m.startOperationSpan(ctx, "ReloadDefinitions")
+	defer func() { endSpan(span, err) }()
+
+	stored, err := store.LoadDefinitions(ctx)
+	if err != nil {
+		m.config.logger.Error("DefinitionStore LoadDefinitions failed", "error", err)
+		return fmt.Errorf("definitionStore.LoadDefinitions failed: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tenants := make(map[string]bool, len(stored)+len(m.config.tenantDefinitions)+1)
+	tenants[DefaultTenant] = true
+	for tenantID := range stored {
+		tenants[tenantID] = true
+	}
+	for tenantID := range m.config.tenantDefinitions {
+		tenants[tenantID] = true
+	}
+
+	for tenantID := range tenants {
+		local := m.definitionsFor(tenantID)
+		catalogue := make(map[string]PreferenceDefinition, len(stored[tenantID]))
+		fromStore := make(map[string]bool, len(stored[tenantID]))
+		for key, def := range stored[tenantID] {
+			def = loadedDefinition(def)
+			if localDef, ok := local[key]; ok {
+				def = withFunctions(def, localDef)
+			}
+			prepared, err := m.prepareDefinition(def)
+			if err != nil {
+				m.config.logger.Error("Failed to register stored definition", "tenantID", tenantID, "key", key, "error", err)
+				if localDef, ok := local[key]; ok {
+					catalogue[key] = localDef
+				}
+				continue
+			}
+			catalogue[key] = prepared
+			fromStore[key] = true
+		}
+		m.resolveDependencies(tenantID, catalogue, fromStore, local)
+
+		if tenantID == DefaultTenant {
+			m.config.definitions = catalogue
+		} else if len(catalogue) > 0 {
+			if m.config.tenantDefinitions == nil {
+				m.config.tenantDefinitions = make(map[string]map[string]PreferenceDefinition)
+			}
+			m.config.tenantDefinitions[tenantID] = catalogue
+		} else {
+			delete(m.config.tenantDefinitions, tenantID)
+		}
+	}
+	return nil
+}
+
+// resolveDependencies checks the dependencies of a reloaded catalogue against the catalogue
+// itself, so that the result does not depend on the order definitions were loaded in. Each
+// pass validates every definition against the same candidate catalogue. Failing stored
+// definitions fall back to their local version first; only when none can, the failing
+// definitions are dropped. Passes repeat until the catalogue is consistent. m.mu must be held.
+func (m *Manager) resolveDependencies(tenantID string, catalogue map[string]PreferenceDefinition, fromStore map[string]bool, local map[string]PreferenceDefinition) {
+	for {
+		var failed []string
+		for key, def := range catalogue {
+			if err := validateDependency(catalogue, def); err != nil {
+				m.config.logger.Error("Failed to register stored definition", "tenantID", tenantID, "key", key, "error", err)
+				failed = append(failed, key)
+			}
+		}
+		if len(failed) == 0 {
+			return
+		}
+		reverted := false
+		for _, key := range failed {
+			if localDef, ok := local[key]; ok && fromStore[key] {
+				catalogue[key] = localDef
+				fromStore[key] = false
+				reverted = true
+			}
+		}
+		if reverted {
+			// Reverting may have broken the cycle the other failures were part of.
+			continue
+		}
+		for _, key := range failed {
+			delete(catalogue, key)
+		}
+	}
+}
+
+// saveDefinition writes def through to the DefinitionStore, if one is configured.
+func (m *Manager) saveDefinition(ctx context.Context, tenantID string, def PreferenceDefinition) error {
+	store := m.config.definitionStore
+	if store == nil {
+		return nil
+	}
+	stored, err := storableDefinition(def)
+	if err != nil {
+		return err
+	}
+	if err := store.SaveDefinition(ctx, tenantID, stored); err != nil {
+		m.config.logger.Error("DefinitionStore SaveDefinition failed", "tenantID", tenantID, "key", def.Key, "error", err)
+		return fmt.Errorf("definitionStore.SaveDefinition failed for key '%s': %w", def.Key, err)
+	}
+	return nil
+}
+
+// storableDefinition returns a copy of def whose values are in their stored encoding, like
+// preference values, so that rich types survive a JSON round-trip.
+func storableDefinition(def PreferenceDefinition) (PreferenceDefinition, error) {
+	var err error
+	if def.DefaultValue, err = encodeValue(def.DefaultValue, def); err != nil {
+		return def, err
+	}
+	if len(def.AllowedValues) > 0 {
+		allowed := make([]interface{}, len(def.AllowedValues))
+		for i, value := range def.AllowedValues {
+			if allowed[i], err = encodeValue(value, def); err != nil {
+				return def, err
+			}
+		}
+		def.AllowedValues = allowed
+	}
+	if len(def.DefaultRules) > 0 {
+		rules := make([]DefaultRule, len(def.DefaultRules))
+		copy(rules, def.DefaultRules)
+		for i := range rules {
+			if rules[i].Value, err = encodeValue(rules[i].Value, def); err != nil {
+				return def, err
+			}
+		}
+		def.DefaultRules = rules
+	}
+	return def, nil
+}
+
+// loadedDefinition reverses storableDefinition for a definition read from a DefinitionStore.
+// Values that cannot be decoded are kept as stored, for prepareDefinition to report.
+func loadedDefinition(def PreferenceDefinition) PreferenceDefinition {
+	decode := func(value interface{}) interface{} {
+		if decoded, err := decodeValue(value, def); err == nil {
+			return decoded
+		}
+		return value
+	}
+	def.DefaultValue = decode(def.DefaultValue)
+	for i, value := range def.AllowedValues {
+		def.AllowedValues[i] = decode(value)
+	}
+	for i := range def.DefaultRules {
+		def.DefaultRules[i].Value = decode(def.DefaultRules[i].Value)
+	}
+	return def
+}
+
+// withFunctions returns def with the function fields of local, which a DefinitionStore
+// does not persist.
+func withFunctions(def, local PreferenceDefinition) PreferenceDefinition {
+	def.ValidateFunc = local.ValidateFunc
+	def.NormalizeFunc = local.NormalizeFunc
+	def.Migrations = local.Migrations
+	def.Transform = local.Transform
+	def.DefaultFunc = local.DefaultFunc
+	def.MergeFunc = local.MergeFunc
+	return def
+}
+
+// pollDefinitions reloads the catalogue every interval until stop is closed.
+func (m *Manager) pollDefinitions(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// Failures are logged by ReloadDefinitions; the next tick tries again.
+			_ = m.ReloadDefinitions(context.Background())
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package userprefs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockDefinitionStore implements DefinitionStore for testing. Definitions are kept as JSON,
+// like the bundled backends keep them, and the next call of each method can be made to fail.
+type mockDefinitionStore struct {
+	mu          sync.Mutex
+	definitions map[string]map[string][]byte
+	saveErr     error
+	deleteErr   error
+	loadErr     error
+}
+
+func newMockDefinitionStore() *mockDefinitionStore {
+	return &mockDefinitionStore{definitions: make(map[string]map[string][]byte)}
+}
+
+func (s *mockDefinitionStore) SaveDefinition(ctx context.Context, tenantID string, def PreferenceDefinition) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.saveErr; err != nil {
+		s.saveErr = nil
+		return err
+	}
+	data, err := json.Marshal(def)
+	if err != nil {
+		return err
+	}
+	if s.definitions[tenantID] == nil {
+		s.definitions[tenantID] = make(map[string][]byte)
+	}
+	s.definitions[tenantID][def.Key] = data
+	return nil
+}
+
+func (s *mockDefinitionStore) DeleteDefinition(ctx context.Context, tenantID, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.deleteErr; err != nil {
+		s.deleteErr = nil
+		return err
+	}
+	delete(s.definitions[tenantID], key)
+	return nil
+}
+
+func (s *mockDefinitionStore) LoadDefinitions(ctx context.Context) (map[string]map[string]PreferenceDefinition, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.loadErr; err != nil {
+		s.loadErr = nil
+		return nil, err
+	}
+	result := make(map[string]map[string]PreferenceDefinition)
+	for tenantID, definitions := range s.definitions {
+		for key, data := range definitions {
+			var def PreferenceDefinition
+			if err := json.Unmarshal(data, &def); err != nil {
+				return nil, err
+			}
+			if result[tenantID] == nil {
+				result[tenantID] = make(map[string]PreferenceDefinition)
+			}
+			result[tenantID][key] = def
+		}
+	}
+	return result, nil
+}
+
+// stored reports whether tenantID's key is in the store.
+func (s *mockDefinitionStore) stored(tenantID, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.definitions[tenantID][key]
+	return ok
+}
+
+func TestManager_DefinitionStore_WriteThrough(t *testing.T) {
+	store := newMockDefinitionStore()
+	mgr := newTestManager(t, []PreferenceDefinition{{Key: "theme", Type: StringType, DefaultValue: "light"}}, WithDefinitionStore(store, 0))
+	if err := mgr.Tenant("acme").DefinePreference(PreferenceDefinition{Key: "volume", Type: IntType, DefaultValue: 5}); err != nil {
+		t.Fatalf("Tenant DefinePreference failed: %v", err)
+	}
+	if !store.stored(DefaultTenant, "theme") || !store.stored("acme", "volume") {
+		t.Fatalf("Expected both definitions to be stored, got %v", store.definitions)
+	}
+
+	if err := mgr.Tenant("acme").DeleteDefinition("volume"); err != nil {
+		t.Fatalf("DeleteDefinition failed: %v", err)
+	}
+	if store.stored("acme", "volume") {
+		t.Error("Expected the deleted definition to be removed from the store")
+	}
+	if _, ok := mgr.Tenant("acme").GetDefinition("volume"); ok {
+		t.Error("Expected the deleted definition to be removed from the catalogue")
+	}
+
+	storeErr := errors.New("store unavailable")
+	store.saveErr = storeErr
+	err := mgr.DefinePreference(PreferenceDefinition{Key: "language", Type: StringType, DefaultValue: "en"})
+	if !errors.Is(err, storeErr) {
+		t.Fatalf("Expected the store error, got %v", err)
+	}
+	if _, ok := mgr.GetDefinition("language"); ok {
+		t.Error("A definition the store failed to save should not be registered")
+	}
+
+	store.deleteErr = storeErr
+	if err := mgr.DeleteDefinition("theme"); !errors.Is(err, storeErr) {
+		t.Fatalf("Expected the store error, got %v", err)
+	}
+	if _, ok := mgr.GetDefinition("theme"); !ok {
+		t.Error("A definition the store failed to delete should be kept")
+	}
+}
+
+func TestManager_ReloadDefinitions(t *testing.T) {
+	ctx := context.Background()
+	store := newMockDefinitionStore()
+	storage := NewMockStorage()
+	first := newTestManager(t, []PreferenceDefinition{{Key: "volume", Type: IntType, DefaultValue: 5, AllowedValues: []interface{}{0, 5, 10}}}, WithStorage(storage), WithDefinitionStore(store, 0))
+
+	// A new instance loads the catalogue at startup.
+	second := newTestManager(t, nil, WithStorage(storage), WithDefinitionStore(store, 0))
+	pref, err := second.Get(ctx, "u1", "volume")
+	if err != nil {
+		t.Fatalf("Get failed on the second instance: %v", err)
+	}
+	if pref.Value != 5 {
+		t.Errorf("Expected the int default 5, got %v (%T)", pref.Value, pref.Value)
+	}
+	if err := second.Set(ctx, "u1", "volume", 7); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("Expected AllowedValues to be loaded, got %v", err)
+	}
+
+	// Function fields stay with the instance that defined them.
+	rejectLoud := func(value interface{}) error {
+		if value == 10 {
+			return errors.New("too loud")
+		}
+		return nil
+	}
+	if err := second.DefinePreference(PreferenceDefinition{Key: "volume", Type: IntType, DefaultValue: 5, AllowedValues: []interface{}{0, 5, 10}, ValidateFunc: rejectLoud}); err != nil {
+		t.Fatalf("DefinePreference failed: %v", err)
+	}
+	if err := first.DefinePreference(PreferenceDefinition{Key: "volume", Type: IntType, DefaultValue: 0, AllowedValues: []interface{}{0, 5, 10}}); err != nil {
+		t.Fatalf("DefinePreference failed: %v", err)
+	}
+	if err := first.Tenant("acme").DefinePreference(PreferenceDefinition{Key: "theme", Type: StringType, DefaultValue: "light"}); err != nil {
+		t.Fatalf("Tenant DefinePreference failed: %v", err)
+	}
+	if err := second.ReloadDefinitions(ctx); err != nil {
+		t.Fatalf("ReloadDefinitions failed: %v", err)
+	}
+	def, _ := second.GetDefinition("volume")
+	if def.DefaultValue != 0 {
+		t.Errorf("Expected the changed default 0, got %v", def.DefaultValue)
+	}
+	if err := second.Set(ctx, "u1", "volume", 10); err == nil {
+		t.Error("Expected the local ValidateFunc to be kept across reloads")
+	}
+	if _, ok := second.Tenant("acme").GetDefinition("theme"); !ok {
+		t.Error("Expected the tenant definition to be loaded")
+	}
+
+	// Deletions propagate too.
+	if err := first.Tenant("acme").DeleteDefinition("theme"); err != nil {
+		t.Fatalf("DeleteDefinition failed: %v", err)
+	}
+	if err := second.ReloadDefinitions(ctx); err != nil {
+		t.Fatalf("ReloadDefinitions failed: %v", err)
+	}
+	if _, ok := second.Tenant("acme").GetDefinition("theme"); ok {
+		t.Error("Expected the deleted tenant definition to be removed on reload")
+	}
+
+	// A failed load leaves the catalogue unchanged.
+	storeErr := errors.New("store unavailable")
+	store.loadErr = storeErr
+	if err := second.ReloadDefinitions(ctx); !errors.Is(err, storeErr) {
+		t.Fatalf("Expected the store error, got %v", err)
+	}
+	if _, ok := second.GetDefinition("volume"); !ok {
+		t.Error("A failed reload should keep the catalogue")
+	}
+}
+
+func TestManager_ReloadDefinitions_Invalid(t *testing.T) {
+	store := newMockDefinitionStore()
+	if err := store.SaveDefinition(context.Background(), DefaultTenant, PreferenceDefinition{Key: "secret", Type: StringType, Encrypted: true}); err != nil {
+		t.Fatalf("SaveDefinition failed: %v", err)
+	}
+	if err := store.SaveDefinition(context.Background(), DefaultTenant, PreferenceDefinition{Key: "theme", Type: StringType}); err != nil {
+		t.Fatalf("SaveDefinition failed: %v", err)
+	}
+
+	// Without an encryption manager, the encrypted definition cannot be registered here.
+	mgr := newTestManager(t, nil, WithDefinitionStore(store, 0))
+	if _, ok := mgr.GetDefinition("secret"); ok {
+		t.Error("Expected the invalid stored definition to be skipped")
+	}
+	if _, ok := mgr.GetDefinition("theme"); !ok {
+		t.Error("Expected the valid stored definition to be loaded")
+	}
+}
+
+func TestManager_ReloadDefinitions_DependencyCycle(t *testing.T) {
+	ctx := context.Background()
+	store := newMockDefinitionStore()
+	for _, def := range []PreferenceDefinition{
+		{Key: "a", Type: BoolType, DependsOn: &Dependency{Key: "b"}},
+		{Key: "b", Type: BoolType, DependsOn: &Dependency{Key: "a"}},
+		{Key: "c", Type: BoolType, DependsOn: &Dependency{Key: "d"}},
+		{Key: "d", Type: BoolType},
+	} {
+		if err := store.SaveDefinition(ctx, DefaultTenant, def); err != nil {
+			t.Fatalf("SaveDefinition failed: %v", err)
+		}
+	}
+
+	// Neither half of the stored cycle may be registered, whatever order they load in.
+	for i := 0; i < 20; i++ {
+		mgr := newTestManager(t, nil, WithDefinitionStore(store, 0))
+		for key, want := range map[string]bool{"a": false, "b": false, "c": true, "d": true} {
+			if _, ok := mgr.GetDefinition(key); ok != want {
+				t.Fatalf("Expected definition %s loaded=%v, got %v", key, want, ok)
+			}
+		}
+	}
+
+	// A local version without the dependency is kept instead of the stored one.
+	mgr := newTestManager(t, []PreferenceDefinition{{Key: "a", Type: BoolType}}, WithDefinitionStore(newMockDefinitionStore(), 0))
+	mgr.config.definitionStore = store
+	if err := mgr.ReloadDefinitions(ctx); err != nil {
+		t.Fatalf("ReloadDefinitions failed: %v", err)
+	}
+	if def, ok := mgr.GetDefinition("a"); !ok || def.DependsOn != nil {
+		t.Errorf("Expected the local definition of a to be kept, got %+v (found %v)", def, ok)
+	}
+	if def, ok := mgr.GetDefinition("b"); !ok || def.DependsOn == nil || def.DependsOn.Key != "a" {
+		t.Errorf("Expected the stored definition of b to be loaded once a is local, got %+v (found %v)", def, ok)
+	}
+}
+
+func TestManager_DefinitionStore_Polling(t *testing.T) {
+	store := newMockDefinitionStore()
+	first := newTestManager(t, nil, WithDefinitionStore(store, 0))
+	second := newTestManager(t, nil, WithDefinitionStore(store, 5*time.Millisecond))
+
+	if err := first.DefinePreference(PreferenceDefinition{Key: "theme", Type: StringType, DefaultValue: "light"}); err != nil {
+		t.Fatalf("DefinePreference failed: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := second.GetDefinition("theme"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the polling instance to pick up the new definition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := second.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func TestManager_DeleteDefinition(t *testing.T) {
+	mgr := newTestManager(t, []PreferenceDefinition{
+		{Key: "notifications", Type: BoolType, DefaultValue: true},
+		{Key: "sound", Type: StringType, DefaultValue: "chime", DependsOn: &Dependency{Key: "notifications"}},
+		{Key: "language", Type: StringType, DefaultValue: "en"},
+		{Key: "lang", Type: StringType, DefaultValue: "en", ReplacedBy: "language"},
+	})
+
+	if err := mgr.DeleteDefinition(""); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Expected ErrInvalidKey, got %v", err)
+	}
+	if err := mgr.DeleteDefinition("missing"); !errors.Is(err, ErrPreferenceNotDefined) {
+		t.Errorf("Expected ErrPreferenceNotDefined, got %v", err)
+	}
+	for _, key := range []string{"notifications", "language"} {
+		if err := mgr.DeleteDefinition(key); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("Expected ErrInvalidInput deleting referenced key %s, got %v", key, err)
+		}
+	}
+	if err := mgr.DeleteDefinition("sound"); err != nil {
+		t.Fatalf("DeleteDefinition failed: %v", err)
+	}
+	if err := mgr.DeleteDefinition("notifications"); err != nil {
+		t.Errorf("Expected the parent to be deletable once unreferenced, got %v", err)
+	}
+	if err := mgr.Tenant("acme").DeleteDefinition("language"); !errors.Is(err, ErrPreferenceNotDefined) {
+		t.Errorf("Expected other tenants not to know the key, got %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+// Package userprefs provides cross-key dependencies and cross-field validation for preferences.
+package userprefs
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// DependencyMode controls how a dependent preference is presented while its parent is off.
+type DependencyMode string
+
+const (
+	// DependencyDisable keeps the dependent preference in read results but marks it Disabled.
+	// This is the default when Dependency.Mode is empty.
+	DependencyDisable DependencyMode = "disable"
+	// DependencyHide omits the dependent preference from GetAll and GetByCategory results.
+	DependencyHide DependencyMode = "hide"
+)
+
+// Dependency makes a preference conditional on the value of another (parent) preference.
+// While the parent is off, the dependent preference cannot be written and is hidden or
+// disabled on reads according to Mode. While the parent is on, Required makes the dependent
+// preference mandatory.
+type Dependency struct {
+	// Key is the key of the parent preference.
+	Key string `json:"key"`
+	// Value is the parent value that turns the dependent preference on. When nil, any truthy
+	// parent value (true, a non-empty string, a non-zero number, a non-empty collection) does.
+	Value interface{} `json:"value,omitempty"`
+	// Required makes the dependent preference mandatory while the parent is on: its effective
+	// value (stored, proposed, or default) must not be nil or empty.
+	Required bool `json:"required,omitempty"`
+	// Mode controls how the dependent preference is presented while the parent is off.
+	Mode DependencyMode `json:"mode,omitempty"`
+}
+
+// CrossFieldValidator validates a write against the user's complete set of preferences.
+// current holds the user's effective values (stored or default) before the write, keyed by
+// preference key; proposed holds the same values with the write applied. Returning a non-nil
+// error rejects the write. Validators run in Manager.Set and Manager.SetMany after per-value
+// validation has passed. A validator may return *ValidationError or ValidationErrors to point
+// at specific keys; any other error is reported with RuleCrossField and no key.
+type CrossFieldValidator func(ctx context.Context, userID string, current, proposed map[string]interface{}) error
+
+// WithCrossFieldValidator is a functional option that registers a CrossFieldValidator with the Manager.
+// It may be supplied more than once; validators run in registration order and all of their
+// failures are reported.
+// This option is optional.
+func WithCrossFieldValidator(v CrossFieldValidator) Option {
+	return func(c *Config) {
+		c.crossFieldValidators = append(c.crossFieldValidators, v)
+	}
+}
+
+// validateDependencyKey checks def.DependsOn on its own: the key must name another
+// preference and the mode must be supported. Cycles are checked by validateDependency.
+func validateDependencyKey(def PreferenceDefinition) error {
+	dep := def.DependsOn
+	if dep == nil {
+		return nil
+	}
+	if dep.Key == "" || dep.Key == def.Key {
+		return fmt.Errorf("%w: preference '%s' has an invalid dependency key '%s'", ErrInvalidInput, def.Key, dep.Key)
+	}
+	switch dep.Mode {
+	case "", DependencyDisable, DependencyHide:
+	default:
+		return fmt.Errorf("%w: preference '%s' has unsupported dependency mode '%s'", ErrInvalidInput, def.Key, dep.Mode)
+	}
+	return nil
+}
+
+// validateDependency checks that def.DependsOn does not close a dependency cycle in
+// catalogue. def must already have passed validateDependencyKey.
+func validateDependency(catalogue map[string]PreferenceDefinition, def PreferenceDefinition) error {
+	if def.DependsOn == nil {
+		return nil
+	}
+	// Walk up the parent chain; reaching def.Key again means the definition closes a cycle.
+	seen := map[string]bool{def.Key: true}
+	for parent := def.DependsOn.Key; parent != ""; {
+		if seen[parent] {
+			return fmt.Errorf("%w: preference '%s' introduces a dependency cycle through '%s'", ErrInvalidInput, def.Key, parent)
+		}
+		seen[parent] = true
+		parentDef, ok := catalogue[parent]
+		if !ok || parentDef.DependsOn == nil {
+			break
+		}
+		parent = parentDef.DependsOn.Key
+	}
+	return nil
+}
+
+// validateWrite runs dependency checks and cross-field validators for a write of changes
+// (key to new value) on behalf of userID. It only loads the user's current preferences when
+// a validator is registered or a dependency involves one of the changed keys.
+// Validation failures are returned as ValidationErrors; other errors come from loading preferences.
+func (m *Manager) validateWrite(ctx context.Context, userID string, changes map[string]interface{}) error {
+	return m.validateChanges(ctx, userID, changes, nil)
+}
+
+// validateChanges is validateWrite for a write that also removes stored values: resets maps
+// each removed key to the value it falls back to (see fallbackValue). Removed keys may fall
+// back while their dependency is off, but are checked against Required and the validators.
+func (m *Manager) validateChanges(ctx context.Context, userID string, changes, resets map[string]interface{}) error {
+	m.mu.RLock()
+	validators := m.config.crossFieldValidators
+	var dependents []PreferenceDefinition
+	for _, def := range m.definitionsFor(TenantFromContext(ctx)) {
+		if def.DependsOn == nil {
+			continue
+		}
+		_, selfChanged := changes[def.Key]
+		_, parentChanged := changes[def.DependsOn.Key]
+		_, selfReset := resets[def.Key]
+		_, parentReset := resets[def.DependsOn.Key]
+		if selfChanged || parentChanged || selfReset || parentReset {
+			dependents = append(dependents, def)
+		}
+	}
+	m.mu.RUnlock()
+
+	if len(validators) == 0 && len(dependents) == 0 {
+		return nil
+	}
+
+	currentPrefs, err := m.getAll(ctx, userID)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]interface{}, len(currentPrefs))
+	proposed := make(map[string]interface{}, len(currentPrefs)+len(changes))
+	for key, pref := range currentPrefs {
+		current[key] = pref.Value
+		proposed[key] = pref.Value
+	}
+	for key, value := range resets {
+		proposed[key] = value
+	}
+	for key, value := range changes {
+		proposed[key] = value
+	}
+
+	var verrs ValidationErrors
+	sort.Slice(dependents, func(i, j int) bool { return dependents[i].Key < dependents[j].Key })
+	for _, def := range dependents {
+		dep := def.DependsOn
+		enabled := dependencyEnabled(dep, proposed[dep.Key])
+		if _, changed := changes[def.Key]; changed && !enabled {
+			verrs = append(verrs, &ValidationError{Key: def.Key, Rule: RuleDependency, Expected: dep.Key, Actual: actualType(changes[def.Key]),
+				Message: fmt.Sprintf("cannot be set while '%s' is off", dep.Key)})
+		}
+		if enabled && dep.Required && isEmptyValue(proposed[def.Key]) {
+			verrs = append(verrs, &ValidationError{Key: def.Key, Rule: RuleRequired, Expected: dep.Key, Actual: actualType(proposed[def.Key]),
+				Message: fmt.Sprintf("is required while '%s' is on", dep.Key)})
+		}
+	}
+
+	for _, validate := range validators {
+		if err := validate(ctx, userID, current, proposed); err != nil {
+			validatorErrs, _ := toValidationErrors(err, "", RuleCrossField, "")
+			verrs = append(verrs, validatorErrs...)
+		}
+	}
+	if len(verrs) > 0 {
+		return verrs
+	}
+	return nil
+}
+
+// applyDependencies hides or disables entries of prefs whose dependency chain is off.
+// Parents missing from prefs (e.g. in another category) are resolved through get.
+func (m *Manager) applyDependencies(ctx context.Context, userID string, prefs map[string]*Preference) error {
+	states := make(map[string]bool)
+	for key, pref := range prefs {
+		def, exists := m.contextDefinition(ctx, key)
+		if !exists || def.DependsOn == nil {
+			continue
+		}
+		active, err := m.dependencyActive(ctx, userID, def, prefs, states)
+		if err != nil {
+			return err
+		}
+		if active {
+			continue
+		}
+		if def.DependsOn.Mode == DependencyHide {
+			delete(prefs, key)
+		} else {
+			// Copy so that a Preference still being written to the cache is never mutated.
+			disabled := *pref
+			disabled.Disabled = true
+			prefs[key] = &disabled
+		}
+	}
+	return nil
+}
+
+// dependencyActive reports whether every parent in def's dependency chain is on.
+// Parent values are taken from known when present and fetched otherwise; results are memoized in states.
+func (m *Manager) dependencyActive(ctx context.Context, userID string, def PreferenceDefinition, known map[string]*Preference, states map[string]bool) (bool, error) {
+	if def.DependsOn == nil {
+		return true, nil
+	}
+	if active, ok := states[def.Key]; ok {
+		return active, nil
+	}
+
+	parentDef, exists := m.contextDefinition(ctx, def.DependsOn.Key)
+	if !exists {
+		// Dependencies on undefined keys are not enforced.
+		states[def.Key] = true
+		return true, nil
+	}
+
+	parent, ok := known[parentDef.Key]
+	if !ok {
+		var err error
+		parent, err = m.get(ctx, userID, parentDef.Key)
+		if parent == nil {
+			// get returns a usable default alongside cache errors; only fail without a value.
+			return false, err
+		}
+	}
+
+	active := dependencyEnabled(def.DependsOn, parent.Value)
+	if active {
+		var err error
+		active, err = m.dependencyActive(ctx, userID, parentDef, known, states)
+		if err != nil {
+			return false, err
+		}
+	}
+	states[def.Key] = active
+	return active, nil
+}
+
+// dependencyEnabled reports whether parentValue turns the dependent preference on.
+func dependencyEnabled(dep *Dependency, parentValue interface{}) bool {
+	if dep.Value != nil {
+		return attributeEquals(parentValue, dep.Value)
+	}
+	return !isEmptyValue(parentValue) && parentValue != false
+}
+
+// isEmptyValue reports whether v is nil, zero, or an empty string or collection.
+func isEmptyValue(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return rv.Len() == 0
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return rv.IsZero()
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	default:
+		return false
+	}
+}
@@ -0,0 +1,209 @@
+package userprefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// notificationDefinitions returns a toggle with one disabled-mode and one hidden-mode dependent.
+func notificationDefinitions() []PreferenceDefinition {
+	return []PreferenceDefinition{
+		{Key: "notifications", Type: BoolType, Category: "notifications", DefaultValue: false},
+		{
+			Key:          "notification_email",
+			Type:         StringType,
+			Category:     "notifications",
+			DefaultValue: "",
+			DependsOn:    &Dependency{Key: "notifications", Required: true},
+		},
+		{
+			Key:          "digest_frequency",
+			Type:         StringType,
+			Category:     "digest",
+			DefaultValue: "weekly",
+			DependsOn:    &Dependency{Key: "notifications", Mode: DependencyHide},
+		},
+	}
+}
+
+func TestManager_Dependencies_Writes(t *testing.T) {
+	ctx := context.Background()
+	mgr := newTestManager(t, notificationDefinitions())
+
+	// The parent is off by default, so its dependents cannot be written.
+	if err := mgr.Set(ctx, "u1", "digest_frequency", "daily"); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("Expected ErrInvalidValue while parent is off, got %v", err)
+	}
+
+	// Turning the parent on alone would leave the required email empty.
+	if err := mgr.Set(ctx, "u1", "notifications", true); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("Expected ErrInvalidValue for missing required dependent, got %v", err)
+	}
+
+	// Changing both together satisfies the dependency.
+	if err := mgr.SetMany(ctx, "u1", map[string]interface{}{
+		"notifications":      true,
+		"notification_email": "u1@example.com",
+	}); err != nil {
+		t.Fatalf("SetMany failed: %v", err)
+	}
+	if err := mgr.Set(ctx, "u1", "digest_frequency", "daily"); err != nil {
+		t.Errorf("Set of enabled dependent failed: %v", err)
+	}
+
+	// A rejected SetMany writes nothing.
+	err := mgr.SetMany(ctx, "u1", map[string]interface{}{"notifications": false, "notification_email": "other@example.com"})
+	if !errors.Is(err, ErrInvalidValue) {
+		t.Fatalf("Expected ErrInvalidValue, got %v", err)
+	}
+	pref, _ := mgr.Get(ctx, "u1", "notifications")
+	if pref.Value != true {
+		t.Errorf("Expected notifications to remain true after rejected SetMany, got %v", pref.Value)
+	}
+}
+
+func TestManager_Dependencies_Reads(t *testing.T) {
+	ctx := context.Background()
+	mgr := newTestManager(t, notificationDefinitions())
+
+	all, err := mgr.GetAll(ctx, "u1")
+	if err != nil {
+		t.Fatalf("GetAll failed: %v", err)
+	}
+	if _, ok := all["digest_frequency"]; ok {
+		t.Errorf("Expected hidden dependent to be omitted from GetAll")
+	}
+	if email, ok := all["notification_email"]; !ok || !email.Disabled {
+		t.Errorf("Expected disabled dependent in GetAll, got %+v", email)
+	}
+	if all["notifications"].Disabled {
+		t.Errorf("Expected parent not to be disabled")
+	}
+
+	pref, err := mgr.Get(ctx, "u1", "digest_frequency")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if !pref.Disabled {
+		t.Errorf("Expected Get to mark hidden dependent Disabled")
+	}
+
+	if err := mgr.SetMany(ctx, "u1", map[string]interface{}{"notifications": true, "notification_email": "u1@example.com"}); err != nil {
+		t.Fatalf("SetMany failed: %v", err)
+	}
+	if err := mgr.Set(ctx, "u1", "digest_frequency", "daily"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	// The parent lives in another category and is resolved on demand.
+	digest, err := mgr.GetByCategory(ctx, "u1", "digest")
+	if err != nil {
+		t.Fatalf("GetByCategory failed: %v", err)
+	}
+	if pref, ok := digest["digest_frequency"]; !ok || pref.Disabled {
+		t.Errorf("Expected enabled dependent in GetByCategory, got %+v", pref)
+	}
+
+	// Turning the parent back off hides the stored dependent again.
+	if err := mgr.Set(ctx, "u1", "notifications", false); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	digest, err = mgr.GetByCategory(ctx, "u1", "digest")
+	if err != nil {
+		t.Fatalf("GetByCategory failed: %v", err)
+	}
+	if _, ok := digest["digest_frequency"]; ok {
+		t.Errorf("Expected hidden dependent to be omitted from GetByCategory")
+	}
+}
+
+func TestManager_CrossFieldValidator(t *testing.T) {
+	ctx := context.Background()
+
+	var sawCurrent, sawProposed interface{}
+	quietHours := func(_ context.Context, _ string, current, proposed map[string]interface{}) error {
+		sawCurrent, sawProposed = current["quiet_start"], proposed["quiet_start"]
+		start, _ := proposed["quiet_start"].(int)
+		end, _ := proposed["quiet_end"].(int)
+		if start >= end {
+			return fmt.Errorf("quiet_start (%d) must be before quiet_end (%d)", start, end)
+		}
+		return nil
+	}
+
+	mgr := newTestManager(t, []PreferenceDefinition{
+		{Key: "quiet_start", Type: IntType, DefaultValue: 22},
+		{Key: "quiet_end", Type: IntType, DefaultValue: 23},
+	}, WithCrossFieldValidator(quietHours))
+
+	if err := mgr.Set(ctx, "u1", "quiet_start", 23); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("Expected ErrInvalidValue, got %v", err)
+	}
+	if sawCurrent != 22 || sawProposed != 23 {
+		t.Errorf("Expected validator to see current 22 and proposed 23, got %v and %v", sawCurrent, sawProposed)
+	}
+
+	if err := mgr.SetMany(ctx, "u1", map[string]interface{}{"quiet_start": 1, "quiet_end": 7}); err != nil {
+		t.Errorf("SetMany failed: %v", err)
+	}
+}
+
+func TestManager_DefinePreference_InvalidDependency(t *testing.T) {
+	mgr := newTestManager(t, []PreferenceDefinition{{Key: "a", Type: BoolType, DependsOn: &Dependency{Key: "b"}}})
+
+	invalid := []PreferenceDefinition{
+		{Key: "self", Type: BoolType, DependsOn: &Dependency{Key: "self"}},
+		{Key: "empty", Type: BoolType, DependsOn: &Dependency{}},
+		{Key: "mode", Type: BoolType, DependsOn: &Dependency{Key: "a", Mode: "collapse"}},
+		{Key: "b", Type: BoolType, DependsOn: &Dependency{Key: "a"}},
+	}
+	for _, def := range invalid {
+		if err := mgr.DefinePreference(def); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("DefinePreference(%s): expected ErrInvalidInput, got %v", def.Key, err)
+		}
+	}
+}
+
+func TestManager_SetMany_Errors(t *testing.T) {
+	ctx := context.Background()
+	mgr := newTestManager(t, notificationDefinitions())
+
+	if err := mgr.SetMany(ctx, "", map[string]interface{}{"notifications": true}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for empty userID, got %v", err)
+	}
+	if err := mgr.SetMany(ctx, "u1", nil); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for empty values, got %v", err)
+	}
+	if err := mgr.SetMany(ctx, "u1", map[string]interface{}{"unknown": true}); !errors.Is(err, ErrPreferenceNotDefined) {
+		t.Errorf("Expected ErrPreferenceNotDefined, got %v", err)
+	}
+	if err := mgr.SetMany(ctx, "u1", map[string]interface{}{"notifications": "yes"}); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("Expected ErrInvalidValue for wrong type, got %v", err)
+	}
+}
+
+func TestDependencyEnabled(t *testing.T) {
+	testCases := []struct {
+		dep      Dependency
+		parent   interface{}
+		expected bool
+	}{
+		{Dependency{Key: "p"}, true, true},
+		{Dependency{Key: "p"}, false, false},
+		{Dependency{Key: "p"}, nil, false},
+		{Dependency{Key: "p"}, "", false},
+		{Dependency{Key: "p"}, 0.0, false},
+		{Dependency{Key: "p"}, []interface{}{"x"}, true},
+		{Dependency{Key: "p", Value: "custom"}, "custom", true},
+		{Dependency{Key: "p", Value: "custom"}, "light", false},
+		{Dependency{Key: "p", Value: 2}, 2.0, true},
+	}
+	for _, tc := range testCases {
+		dep := tc.dep
+		if got := dependencyEnabled(&dep, tc.parent); got != tc.expected {
+			t.Errorf("dependencyEnabled(%+v, %v) = %v, expected %v", tc.dep, tc.parent, got, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+// Package userprefs provides key deprecation, read fallback to replaced keys, and bulk renames.
+package userprefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// validateDeprecation checks a definition's Deprecated, ReplacedBy, Transform, and
+// MigrateOnRead settings. The replacement key does not need to be defined yet.
+func validateDeprecation(def PreferenceDefinition) error {
+	if def.ReplacedBy == def.Key {
+		return fmt.Errorf("%w: preference '%s' cannot be replaced by itself", ErrInvalidInput, def.Key)
+	}
+	if def.ReplacedBy == "" && (def.Transform != nil || def.MigrateOnRead) {
+		return fmt.Errorf("%w: preference '%s' sets Transform or MigrateOnRead without ReplacedBy", ErrInvalidInput, def.Key)
+	}
+	return nil
+}
+
+// isDeprecated reports whether def is deprecated, either explicitly or by naming a replacement.
+func isDeprecated(def PreferenceDefinition) bool {
+	return def.Deprecated || def.ReplacedBy != ""
+}
+
+// redirectWrite resolves a write to a deprecated key. Writes to a key whose ReplacedBy is
+// defined are redirected to the replacement, with value converted by the old definition's
+// Transform; the returned definition and value are those of the replacement. Writes to other
+// deprecated keys proceed unchanged with a logged warning.
+func (m *Manager) redirectWrite(ctx context.Context, def PreferenceDefinition, value interface{}) (PreferenceDefinition, interface{}, error) {
+	if !isDeprecated(def) {
+		return def, value, nil
+	}
+	if def.ReplacedBy == "" {
+		m.config.logger.Warn("Write to deprecated preference", "key", def.Key)
+		return def, value, nil
+	}
+
+	target, exists := m.contextDefinition(ctx, def.ReplacedBy)
+	if !exists {
+		m.config.logger.Warn("Write to deprecated preference whose replacement is not defined", "key", def.Key, "replacedBy", def.ReplacedBy)
+		return def, value, nil
+	}
+
+	transformed, err := transformValue(def, value)
+	if err != nil {
+		return def, nil, err
+	}
+	m.config.logger.Warn("Redirecting write from deprecated preference", "key", def.Key, "replacedBy", target.Key)
+	return target, transformed, nil
+}
+
+// transformValue converts a value of the deprecated definition old into a value for its
+// replacement using old.Transform. Without a Transform the value is returned unchanged.
+// Failures are reported as a *ValidationError with RuleTransform.
+func transformValue(old PreferenceDefinition, value interface{}) (interface{}, error) {
+	if old.Transform == nil || value == nil {
+		return value, nil
+	}
+	transformed, err := old.Transform(value)
+	if err != nil {
+		verrs, _ := toValidationErrors(err, old.Key, RuleTransform, actualType(value))
+		return nil, verrs.asError()
+	}
+	return transformed, nil
+}
+
+// replacedDefinitions returns the definitions of the tenant in ctx whose ReplacedBy is key,
+// sorted by key so that fallback reads are deterministic.
+func (m *Manager) replacedDefinitions(ctx context.Context, key string) []PreferenceDefinition {
+	m.mu.RLock()
+	var olds []PreferenceDefinition
+	for _, def := range m.definitionsFor(TenantFromContext(ctx)) {
+		if def.ReplacedBy == key {
+			olds = append(olds, def)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(olds, func(i, j int) bool { return olds[i].Key < olds[j].Key })
+	return olds
+}
+
+// readReplaced looks up the stored values of the keys def replaces, for a user without a
+// stored value for def itself. It returns nil if none of them has a usable value.
+func (m *Manager) readReplaced(ctx context.Context, userID string, def PreferenceDefinition) (*Preference, error) {
+	for _, old := range m.replacedDefinitions(ctx, def.Key) {
+		stored, err := m.storageGet(ctx, userID, old.Key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			m.config.logger.Error("Storage Get failed", "userID", userID, "key", old.Key, "error", err)
+			return nil, fmt.Errorf("storage.Get failed for key '%s': %w", old.Key, err)
+		}
+		if pref, err := m.adoptReplaced(ctx, userID, old, def, stored); pref != nil || err != nil {
+			return pref, err
+		}
+	}
+	return nil, nil
+}
+
+// adoptReplacedStored is readReplaced for GetAll: it looks up the keys def replaces in
+// definitions and their values in stored, a snapshot of the user's stored rows.
+func (m *Manager) adoptReplacedStored(ctx context.Context, userID string, def PreferenceDefinition, definitions map[string]PreferenceDefinition, stored map[string]Preference) (*Preference, error) {
+	var olds []PreferenceDefinition
+	for _, old := range definitions {
+		if old.ReplacedBy == def.Key {
+			olds = append(olds, old)
+		}
+	}
+	sort.Slice(olds, func(i, j int) bool { return olds[i].Key < olds[j].Key })
+
+	for _, old := range olds {
+		raw, ok := stored[old.Key]
+		if !ok {
+			continue
+		}
+		if pref, err := m.adoptReplaced(ctx, userID, old, def, &raw); pref != nil || err != nil {
+			return pref, err
+		}
+	}
+	return nil, nil
+}
+
+// decodeStored decrypts, migrates, and decodes the value of a raw stored preference of def in place.
+func (m *Manager) decodeStored(ctx context.Context, pref *Preference, def PreferenceDefinition) error {
+	decrypted, err := m.decryptValue(ctx, pref.Value, def)
+	if err != nil {
+		return err
+	}
+	pref.Value = decrypted
+
+	if err := migrateValue(pref, def); err != nil {
+		return err
+	}
+
+	decoded, err := decodeValue(pref.Value, def)
+	if err != nil {
+		return err
+	}
+	pref.Value = decoded
+	return nil
+}
+
+// adoptReplaced converts the raw stored preference of the deprecated definition old into a
+// Preference for its replacement def. If old.MigrateOnRead is set, the value is also written
+// under def.Key and the old row is removed. A value that does not satisfy def after its
+// Transform is ignored with a warning, and nil is returned.
+func (m *Manager) adoptReplaced(ctx context.Context, userID string, old, def PreferenceDefinition, stored *Preference) (*Preference, error) {
+	if err := m.decodeStored(ctx, stored, old); err != nil {
+		m.config.logger.Error("Failed to read replaced preference", "userID", userID, "key", old.Key, "error", err)
+		return nil, err
+	}
+
+	value, err := transformValue(old, stored.Value)
+	if err == nil {
+		value, err = checkValue(value, def)
+	}
+	if err != nil {
+		m.config.logger.Warn("Ignoring replaced preference value that does not fit its replacement", "userID", userID, "key", old.Key, "replacedBy", def.Key, "error", err)
+		return nil, nil
+	}
+
+	if old.MigrateOnRead {
+		if err := m.write(ctx, userID, def, value); err != nil {
+			m.config.logger.Warn("Failed to migrate replaced preference on read", "userID", userID, "key", old.Key, "replacedBy", def.Key, "error", err)
+		} else if err := m.deleteStored(ctx, userID, old.Key); err != nil {
+			m.config.logger.Warn("Failed to remove migrated preference", "userID", userID, "key", old.Key, "error", err)
+		}
+	}
+
+	return &Preference{
+		UserID:       userID,
+		Key:          def.Key,
+		Value:        value,
+		DefaultValue: def.DefaultValue,
+		Type:         def.Type,
+		Category:     def.Category,
+		UpdatedAt:    stored.UpdatedAt,
+		Version:      def.Version,
+	}, nil
+}
+
+// RenameKey moves every stored value of oldKey to newKey, across all users of the tenant in
+// ctx (see WithTenant), and invalidates the affected cache entries. Values are converted with
+// the old definition's Transform when its ReplacedBy is newKey, then normalized and validated
+// against the new definition. Users who already have a value stored under newKey keep it;
+// their oldKey value is discarded.
+//
+// RenameKey requires the configured Storage to implement KeyLister. Rows are processed in
+// pages; the operation is not atomic, and a failure part-way leaves earlier users renamed.
+// Re-running it is safe.
+//
+// Returns:
+//   - (int, nil): The number of values moved to newKey.
+//   - (0, ErrInvalidInput): If either key is empty or they are equal.
+//   - (0, ErrPreferenceNotDefined): If either key has not been defined.
+//   - (0, ErrNotSupported): If the storage backend does not implement KeyLister.
+//   - (n, ErrInvalidValue): If a stored value does not satisfy the new definition.
+//   - (n, wrapped storage error): If a row could not be read, written, or deleted.
+//
+// This method is thread-safe.
+func (m *Manager) RenameKey(ctx context.Context, oldKey, newKey string) (_ int, err error) {
+	ctx, span := m.startOperationSpan(ctx, "RenameKey", AttrKey.String(oldKey))
+	defer func() { endSpan(span, err) }()
+	// Only the users' own values are moved; device overrides stay under oldKey.
+	ctx = withoutDevice(ctx)
+	if oldKey == "" || newKey == "" || oldKey == newKey {
+		return 0, ErrInvalidInput
+	}
+
+	oldDef, exists := m.contextDefinition(ctx, oldKey)
+	if !exists {
+		return 0, fmt.Errorf("%w: '%s'", ErrPreferenceNotDefined, oldKey)
+	}
+	newDef, exists := m.contextDefinition(ctx, newKey)
+	if !exists {
+		return 0, fmt.Errorf("%w: '%s'", ErrPreferenceNotDefined, newKey)
+	}
+	if oldDef.ReplacedBy != newKey {
+		// A Transform is specific to the replacement it was written for.
+		oldDef.Transform = nil
+	}
+
+	lister, ok := m.config.storage.(KeyLister)
+	if !ok {
+		return 0, fmt.Errorf("%w: storage does not implement KeyLister", ErrNotSupported)
+	}
+
+	renamed := 0
+	afterUserID := ""
+	for {
+		page, err := m.storageListByKey(ctx, lister, oldKey, afterUserID, migrateAllPageSize)
+		if err != nil {
+			return renamed, fmt.Errorf("storage.ListByKey failed for key '%s': %w", oldKey, err)
+		}
+
+		for _, stored := range page {
+			afterUserID = stored.UserID
+			moved, err := m.renameStored(ctx, oldDef, newDef, stored)
+			if err != nil {
+				return renamed, err
+			}
+			if moved {
+				renamed++
+			}
+		}
+
+		if len(page) < migrateAllPageSize {
+			return renamed, nil
+		}
+	}
+}
+
+// renameStored moves one stored value of oldDef to newDef, unless the user already has a
+// value under newDef.Key. It reports whether a value was written.
+func (m *Manager) renameStored(ctx context.Context, oldDef, newDef PreferenceDefinition, stored *Preference) (bool, error) {
+	userID := stored.UserID
+
+	_, err := m.storageGet(ctx, userID, newDef.Key)
+	switch {
+	case err == nil:
+		// The user already chose a value for the new key; it wins over the old one.
+		return false, m.deleteStored(ctx, userID, oldDef.Key)
+	case !errors.Is(err, ErrNotFound):
+		return false, fmt.Errorf("storage.Get failed for key '%s': %w", newDef.Key, err)
+	}
+
+	if err := m.decodeStored(ctx, stored, oldDef); err != nil {
+		return false, err
+	}
+	value, err := transformValue(oldDef, stored.Value)
+	if err != nil {
+		return false, err
+	}
+	if value, err = checkValue(value, newDef); err != nil {
+		return false, err
+	}
+
+	if err := m.write(ctx, userID, newDef, value); err != nil {
+		return false, err
+	}
+	return true, m.deleteStored(ctx, userID, oldDef.Key)
+}
+
+// deleteStored removes a user's stored value for key and its cache entry. A missing row is not an error.
+func (m *Manager) deleteStored(ctx context.Context, userID, key string) error {
+	if err := m.storageDelete(ctx, userID, key); err != nil && !errors.Is(err, ErrNotFound) {
+		m.config.logger.Error("Storage Delete failed", "userID", userID, "key", key, "error", err)
+		return fmt.Errorf("storage.Delete failed for key '%s': %w", key, err)
+	}
+	if m.config.cache != nil {
+		m.deleteFromCache(ctx, userID, key)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package userprefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// darkModeToTheme converts the deprecated "dark_mode" bool into a "theme" value.
+func darkModeToTheme(value interface{}) (interface{}, error) {
+	on, ok := value.(bool)
+	if !ok {
+		return nil, fmt.Errorf("expected bool, got %T", value)
+	}
+	if on {
+		return "dark", nil
+	}
+	return "light", nil
+}
+
+// deprecationDefinitions returns "theme" and the deprecated "dark_mode" bool it replaces.
+func deprecationDefinitions(migrateOnRead bool) []PreferenceDefinition {
+	return []PreferenceDefinition{
+		{Key: "theme", Type: StringType, DefaultValue: "system", AllowedValues: []interface{}{"system", "light", "dark"}},
+		{Key: "dark_mode", Type: BoolType, DefaultValue: false, ReplacedBy: "theme", Transform: darkModeToTheme, MigrateOnRead: migrateOnRead},
+	}
+}
+
+// storeRaw writes a row directly to storage, bypassing the Manager's redirection.
+func storeRaw(t *testing.T, storage *MockStorage, userID, key string, value interface{}) {
+	t.Helper()
+	if err := storage.Set(context.Background(), &Preference{UserID: userID, Key: key, Value: value}); err != nil {
+		t.Fatalf("storage.Set failed: %v", err)
+	}
+}
+
+func TestManager_DefinePreference_Deprecation(t *testing.T) {
+	mgr := newTestManager(t, nil)
+	identity := func(v interface{}) (interface{}, error) { return v, nil }
+
+	testCases := []struct {
+		name string
+		def  PreferenceDefinition
+	}{
+		{"replaced by itself", PreferenceDefinition{Key: "a", Type: StringType, ReplacedBy: "a"}},
+		{"transform without replacement", PreferenceDefinition{Key: "a", Type: StringType, Transform: identity}},
+		{"migrate on read without replacement", PreferenceDefinition{Key: "a", Type: StringType, MigrateOnRead: true}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := mgr.DefinePreference(tc.def); !errors.Is(err, ErrInvalidInput) {
+				t.Errorf("Expected ErrInvalidInput, got %v", err)
+			}
+		})
+	}
+}
+
+func TestManager_Deprecation_ReadFallback(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMockStorage()
+	mgr := newTestManager(t, deprecationDefinitions(false), WithStorage(storage))
+	storeRaw(t, storage, "u1", "dark_mode", true)
+
+	pref, err := mgr.Get(ctx, "u1", "theme")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if pref.Value != "dark" || pref.Key != "theme" {
+		t.Errorf("Expected theme 'dark' read from dark_mode, got %s=%v", pref.Key, pref.Value)
+	}
+
+	all, err := mgr.GetAll(ctx, "u1")
+	if err != nil {
+		t.Fatalf("GetAll failed: %v", err)
+	}
+	if all["theme"].Value != "dark" || all["dark_mode"].Value != true {
+		t.Errorf("Expected theme 'dark' and dark_mode true from GetAll, got %v and %v", all["theme"].Value, all["dark_mode"].Value)
+	}
+
+	if _, err := storage.Get(ctx, "u1", "dark_mode"); err != nil {
+		t.Errorf("Expected the deprecated row to be kept without MigrateOnRead, got %v", err)
+	}
+	if pref, _ := mgr.Get(ctx, "u2", "theme"); pref.Value != "system" {
+		t.Errorf("Expected the default for a user without either key, got %v", pref.Value)
+	}
+}
+
+func TestManager_Deprecation_MigrateOnRead(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMockStorage()
+	mgr := newTestManager(t, deprecationDefinitions(true), WithStorage(storage))
+	storeRaw(t, storage, "u1", "dark_mode", false)
+
+	pref, err := mgr.Get(ctx, "u1", "theme")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if pref.Value != "light" {
+		t.Errorf("Expected theme 'light', got %v", pref.Value)
+	}
+
+	stored, err := storage.Get(ctx, "u1", "theme")
+	if err != nil || stored.Value != "light" {
+		t.Errorf("Expected theme to be written on read, got %v (err %v)", stored, err)
+	}
+	if _, err := storage.Get(ctx, "u1", "dark_mode"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the deprecated row to be removed, got %v", err)
+	}
+}
+
+func TestManager_Deprecation_WriteRedirect(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMockStorage()
+	mgr := newTestManager(t, deprecationDefinitions(false), WithStorage(storage))
+
+	if err := mgr.Set(ctx, "u1", "dark_mode", true); err != nil {
+		t.Fatalf("Set on deprecated key failed: %v", err)
+	}
+	if _, err := storage.Get(ctx, "u1", "dark_mode"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected no row under the deprecated key, got %v", err)
+	}
+	if pref, _ := mgr.Get(ctx, "u1", "theme"); pref.Value != "dark" {
+		t.Errorf("Expected redirected write to set theme 'dark', got %v", pref.Value)
+	}
+
+	var verr *ValidationError
+	if err := mgr.Set(ctx, "u1", "dark_mode", "yes"); !errors.As(err, &verr) || verr.Rule != RuleTransform {
+		t.Errorf("Expected a transform ValidationError, got %v", err)
+	}
+
+	err := mgr.SetMany(ctx, "u1", map[string]interface{}{"dark_mode": true, "theme": "light"})
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput when setting a key directly and through its deprecated key, got %v", err)
+	}
+	if err := mgr.SetMany(ctx, "u1", map[string]interface{}{"dark_mode": false}); err != nil {
+		t.Fatalf("SetMany on deprecated key failed: %v", err)
+	}
+	if pref, _ := mgr.Get(ctx, "u1", "theme"); pref.Value != "light" {
+		t.Errorf("Expected redirected SetMany to set theme 'light', got %v", pref.Value)
+	}
+}
+
+func TestManager_RenameKey(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMockStorage()
+	mgr := newTestManager(t, deprecationDefinitions(false), WithStorage(storage))
+	storeRaw(t, storage, "u1", "dark_mode", true)
+	storeRaw(t, storage, "u2", "dark_mode", false)
+	storeRaw(t, storage, "u3", "dark_mode", true)
+	storeRaw(t, storage, "u3", "theme", "light")
+
+	renamed, err := mgr.RenameKey(ctx, "dark_mode", "theme")
+	if err != nil {
+		t.Fatalf("RenameKey failed: %v", err)
+	}
+	if renamed != 2 {
+		t.Errorf("Expected 2 renamed values, got %d", renamed)
+	}
+
+	expected := map[string]string{"u1": "dark", "u2": "light", "u3": "light"}
+	for userID, want := range expected {
+		stored, err := storage.Get(ctx, userID, "theme")
+		if err != nil || stored.Value != want {
+			t.Errorf("%s: expected theme %q, got %v (err %v)", userID, want, stored, err)
+		}
+		if _, err := storage.Get(ctx, userID, "dark_mode"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected dark_mode to be removed, got %v", userID, err)
+		}
+	}
+
+	if _, err := mgr.RenameKey(ctx, "dark_mode", "missing"); !errors.Is(err, ErrPreferenceNotDefined) {
+		t.Errorf("Expected ErrPreferenceNotDefined, got %v", err)
+	}
+	if _, err := mgr.RenameKey(ctx, "theme", "theme"); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput, got %v", err)
+	}
+}
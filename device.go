@@ -0,0 +1,115 @@
+// Package userprefs provides per-device overrides of preference values.
+package userprefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// deviceContextKey is the context key under which the current device ID is stored.
+type deviceContextKey struct{}
+
+// WithDevice returns a copy of ctx scoped to deviceID, a client or device identifier chosen by
+// the application (e.g. "mobile" or an installation ID). For preferences whose definition sets
+// PerDevice, Get, GetAll, and GetByCategory then resolve the device's override, then the user's
+// own value, then the default; Set and SetMany write the device's override, and Delete and the
+// Reset methods remove it. Other preferences, and all other operations, ignore the device and
+// work on the user's own values. An empty deviceID removes the device from ctx.
+//
+// Storage backends read the device with DeviceFromContext and keep each device's values apart
+// from the user's own values, which are stored under the empty device ID.
+func WithDevice(ctx context.Context, deviceID string) context.Context {
+	return context.WithValue(ctx, deviceContextKey{}, deviceID)
+}
+
+// DeviceFromContext returns the device ID stored in ctx by WithDevice, or "" if none is present.
+func DeviceFromContext(ctx context.Context) string {
+	deviceID, _ := ctx.Value(deviceContextKey{}).(string)
+	return deviceID
+}
+
+// withoutDevice returns ctx scoped to the user's own values.
+func withoutDevice(ctx context.Context) context.Context {
+	if DeviceFromContext(ctx) == "" {
+		return ctx
+	}
+	return WithDevice(ctx, "")
+}
+
+// deviceScope returns the context that reads and writes of def use, with the device of ctx if
+// def is PerDevice and without one otherwise, along with that device ID.
+func deviceScope(ctx context.Context, def PreferenceDefinition) (context.Context, string) {
+	deviceID := DeviceFromContext(ctx)
+	if deviceID == "" || !def.PerDevice {
+		return withoutDevice(ctx), ""
+	}
+	return ctx, deviceID
+}
+
+// getDeviceOverride reads the override of def for the device in ctx. It returns nil and no
+// error if the device has none. Overrides are read from storage and not cached, so that
+// DeleteUser, which cannot enumerate a user's devices, leaves no copies behind.
+func (m *Manager) getDeviceOverride(ctx context.Context, userID, deviceID string, def PreferenceDefinition) (*Preference, error) {
+	pref, err := m.storageGet(ctx, userID, def.Key)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		m.config.logger.Error("Storage Get failed", "userID", userID, "key", def.Key, "deviceID", deviceID, "error", err)
+		return nil, fmt.Errorf("storage.Get failed for key '%s' on device '%s': %w", def.Key, deviceID, err)
+	}
+	if err := m.decodeOverride(ctx, pref, deviceID, def); err != nil {
+		return nil, err
+	}
+	return pref, nil
+}
+
+// applyDeviceOverrides replaces the entries of PerDevice preferences in prefs with the
+// overrides of the device in ctx, as returned by read. It does nothing without a device.
+func (m *Manager) applyDeviceOverrides(ctx context.Context, userID string, prefs map[string]*Preference, read func(ctx context.Context) (map[string]*Preference, error)) error {
+	deviceID := DeviceFromContext(ctx)
+	if deviceID == "" {
+		return nil
+	}
+	overrides, err := read(ctx)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		m.config.logger.Error("Failed to read device overrides", "userID", userID, "deviceID", deviceID, "error", err)
+		return fmt.Errorf("storage read of overrides failed for device '%s': %w", deviceID, err)
+	}
+	for key, pref := range overrides {
+		def, exists := m.contextDefinition(ctx, key)
+		if !exists || !def.PerDevice {
+			continue
+		}
+		if err := m.decodeOverride(ctx, pref, deviceID, def); err != nil {
+			return err
+		}
+		prefs[key] = pref
+	}
+	return nil
+}
+
+// decodeOverride decrypts, migrates, and decodes a stored override of def in place, and
+// fills in the definition's DefaultValue, Type, and Category.
+func (m *Manager) decodeOverride(ctx context.Context, pref *Preference, deviceID string, def PreferenceDefinition) error {
+	value, err := m.decryptValue(ctx, pref.Value, def)
+	if err != nil {
+		m.config.logger.Error("Failed to decrypt device override", "userID", pref.UserID, "key", def.Key, "deviceID", deviceID, "error", err)
+		return err
+	}
+	pref.Value = value
+	if err := migrateValue(pref, def); err != nil {
+		m.config.logger.Error("Failed to migrate device override", "userID", pref.UserID, "key", def.Key, "deviceID", deviceID, "error", err)
+		return err
+	}
+	if pref.Value, err = decodeValue(pref.Value, def); err != nil {
+		m.config.logger.Error("Failed to decode device override", "userID", pref.UserID, "key", def.Key, "deviceID", deviceID, "error", err)
+		return err
+	}
+	pref.DeviceID = deviceID
+	pref.DefaultValue = def.DefaultValue
+	pref.Type = def.Type
+	pref.Category = def.Category
+	return nil
+}
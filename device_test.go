@@ -0,0 +1,169 @@
+package userprefs
+
+import (
+	"context"
+	"testing"
+)
+
+// deviceDefinitions returns a per-device "layout" and a shared "theme" preference.
+func deviceDefinitions() []PreferenceDefinition {
+	return []PreferenceDefinition{
+		{Key: "layout", Type: EnumType, DefaultValue: "comfortable", AllowedValues: []interface{}{"compact", "comfortable", "spacious"}, Category: "appearance", PerDevice: true},
+		{Key: "theme", Type: StringType, DefaultValue: "light", Category: "appearance"},
+	}
+}
+
+func TestManager_DeviceOverrides(t *testing.T) {
+	ctx := context.Background()
+	mobile := WithDevice(ctx, "mobile")
+	desktop := WithDevice(ctx, "desktop")
+	mgr := newTestManager(t, deviceDefinitions())
+
+	pref, err := mgr.Get(mobile, "u1", "layout")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if pref.Value != "comfortable" || pref.DeviceID != "" {
+		t.Errorf("Expected the default without a device, got %v on device %q", pref.Value, pref.DeviceID)
+	}
+
+	if err := mgr.Set(ctx, "u1", "layout", "spacious"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := mgr.Set(mobile, "u1", "layout", "compact"); err != nil {
+		t.Fatalf("Set on mobile failed: %v", err)
+	}
+	// Preferences that are not PerDevice ignore the device.
+	if err := mgr.Set(mobile, "u1", "theme", "dark"); err != nil {
+		t.Fatalf("Set theme on mobile failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		ctx      context.Context
+		key      string
+		want     interface{}
+		deviceID string
+	}{
+		{"mobile override", mobile, "layout", "compact", "mobile"},
+		{"desktop falls back to the user value", desktop, "layout", "spacious", ""},
+		{"no device", ctx, "layout", "spacious", ""},
+		{"shared preference", desktop, "theme", "dark", ""},
+	} {
+		pref, err := mgr.Get(tc.ctx, "u1", tc.key)
+		if err != nil {
+			t.Fatalf("%s: Get failed: %v", tc.name, err)
+		}
+		if pref.Value != tc.want || pref.DeviceID != tc.deviceID {
+			t.Errorf("%s: expected %v on device %q, got %v on device %q", tc.name, tc.want, tc.deviceID, pref.Value, pref.DeviceID)
+		}
+	}
+
+	all, err := mgr.GetAll(mobile, "u1")
+	if err != nil {
+		t.Fatalf("GetAll failed: %v", err)
+	}
+	if all["layout"].Value != "compact" || all["theme"].Value != "dark" {
+		t.Errorf("Expected GetAll on mobile to resolve layout=compact and theme=dark, got %v and %v", all["layout"].Value, all["theme"].Value)
+	}
+	all, err = mgr.GetAll(ctx, "u1")
+	if err != nil {
+		t.Fatalf("GetAll failed: %v", err)
+	}
+	if all["layout"].Value != "spacious" {
+		t.Errorf("Expected GetAll without a device to return the user value, got %v", all["layout"].Value)
+	}
+
+	category, err := mgr.GetByCategory(mobile, "u1", "appearance")
+	if err != nil {
+		t.Fatalf("GetByCategory failed: %v", err)
+	}
+	if category["layout"].Value != "compact" || category["layout"].Type != EnumType {
+		t.Errorf("Expected GetByCategory on mobile to return the override, got %+v", category["layout"])
+	}
+
+	if err := mgr.Delete(mobile, "u1", "layout"); err != nil {
+		t.Fatalf("Delete on mobile failed: %v", err)
+	}
+	pref, err = mgr.Get(mobile, "u1", "layout")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if pref.Value != "spacious" {
+		t.Errorf("Expected mobile to fall back to the user value after Delete, got %v", pref.Value)
+	}
+}
+
+func TestManager_DeviceOverrides_NotCached(t *testing.T) {
+	ctx := context.Background()
+	mobile := WithDevice(ctx, "mobile")
+	cache := NewMockCache()
+	mgr := newTestManager(t, deviceDefinitions(), WithCache(cache), WithCacheWarming(0, 0))
+
+	if err := mgr.Set(ctx, "u1", "layout", "spacious"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := mgr.Set(mobile, "u1", "layout", "compact"); err != nil {
+		t.Fatalf("Set on mobile failed: %v", err)
+	}
+	if _, err := mgr.Get(mobile, "u1", "layout"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	// The cache only ever holds the user's own value.
+	pref, err := mgr.Get(ctx, "u1", "layout")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if pref.Value != "spacious" {
+		t.Errorf("Expected the user value, got %v", pref.Value)
+	}
+	cached, err := mgr.getFromCache(ctx, "u1", "layout")
+	if err != nil || cached.Value != "spacious" {
+		t.Errorf("Expected the cached user value 'spacious', got %v (%v)", cached, err)
+	}
+}
+
+func TestManager_DeviceOverrides_ChangesAndReset(t *testing.T) {
+	ctx := context.Background()
+	mobile := WithDevice(ctx, "mobile")
+	var events []ChangeEvent
+	mgr := newTestManager(t, deviceDefinitions(), WithChangeListener(func(_ context.Context, event ChangeEvent) {
+		events = append(events, event)
+	}))
+
+	if err := mgr.Set(ctx, "u1", "layout", "spacious"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := mgr.Set(mobile, "u1", "layout", "compact"); err != nil {
+		t.Fatalf("Set on mobile failed: %v", err)
+	}
+	if err := mgr.Delete(mobile, "u1", "layout"); err != nil {
+		t.Fatalf("Delete on mobile failed: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(events))
+	}
+	if events[0].DeviceID != "" || events[1].DeviceID != "mobile" {
+		t.Errorf("Expected device IDs \"\" and \"mobile\", got %q and %q", events[0].DeviceID, events[1].DeviceID)
+	}
+	if events[2].Kind != ChangeDelete || events[2].DeviceID != "mobile" || events[2].Value != "spacious" {
+		t.Errorf("Expected a mobile ChangeDelete falling back to the user value, got %+v", events[2])
+	}
+
+	if err := mgr.Set(mobile, "u1", "layout", "compact"); err != nil {
+		t.Fatalf("Set on mobile failed: %v", err)
+	}
+	if err := mgr.Reset(mobile, "u1", "layout"); err != nil {
+		t.Fatalf("Reset on mobile failed: %v", err)
+	}
+	for _, c := range []context.Context{ctx, mobile} {
+		pref, err := mgr.Get(c, "u1", "layout")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if pref.Value != "comfortable" {
+			t.Errorf("Expected Reset on mobile to restore the default for the user and the device, got %v", pref.Value)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// Package userprefs provides right-to-erasure support for deleting every trace of a user.
+package userprefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErasureReceipt records what Manager.DeleteUser removed. It is designed to be kept as
+// evidence that an erasure request was fulfilled, and contains no preference values.
+type ErasureReceipt struct {
+	// TenantID is the tenant the user was erased from.
+	TenantID string `json:"tenant_id,omitempty"`
+	// UserID is the erased user.
+	UserID string `json:"user_id"`
+	// ErasedAt is when the erasure completed.
+	ErasedAt time.Time `json:"erased_at"`
+	// StorageKeys lists, in sorted order, the preference keys whose stored values were deleted.
+	StorageKeys []string `json:"storage_keys"`
+	// CacheKeys lists, in sorted order, the cache entries that were invalidated, whether or not
+	// they were present. It covers every stored and every defined key, since defaults may have
+	// been cached as well, and the cached results of DefaultFunc definitions.
+	CacheKeys []string `json:"cache_keys"`
+}
+
+// DeleteUser erases every preference of userID in the tenant in ctx (see WithTenant) from
+// storage and the cache, and returns a receipt listing what was removed. It deletes stored
+// rows with and without a definition, including values of deprecated keys.
+//
+// DeleteUser uses the storage backend's UserDeleter implementation when available, removing
+// all rows in one operation; otherwise the keys are read with GetAll and deleted one by one,
+// for the user's own values and for each device listed by the backend's DeviceLister.
+// ReadOnly and WritableBy restrictions do not apply, as erasure must remove
+// admin-managed values too. Registered ChangeListeners receive a ChangeDelete event for each
+// removed key that is defined. DeleteUser is idempotent: erasing an unknown user succeeds
+// with an empty StorageKeys list.
+//
+// Returns:
+//   - (*ErasureReceipt, nil): On success.
+//   - (nil, ErrInvalidInput): If userID is empty.
+//   - (nil, ErrNotSupported): If the storage backend keeps device overrides (see ScopedStorage)
+//     but implements neither UserDeleter nor DeviceLister. Nothing is deleted in that case.
+//   - (nil, wrapped storage error): If the storage deletion fails. Cache entries are not
+//     invalidated in that case, so the call can simply be retried.
+//   - (*ErasureReceipt, error): If some cache entries could not be invalidated. The receipt
+//     lists what was removed; the error wraps each cache failure. Retrying purges the rest.
+//
+// This method is thread-safe.
+func (m *Manager) DeleteUser(ctx context.Context, userID string) (_ *ErasureReceipt, err error) {
+	ctx, span := m.startOperationSpan(ctx, "DeleteUser")
+	defer func() { endSpan(span, err) }()
+	// UserDeleter implementations erase the overrides of every device as well.
+	ctx = withoutDevice(ctx)
+	if userID == "" {
+		return nil, ErrInvalidInput
+	}
+
+	removed, err := m.deleteUserRows(ctx, userID)
+	if err != nil {
+		m.config.logger.Error("Failed to erase user preferences from storage", "userID", userID, "error", err)
+		return nil, err
+	}
+
+	tenantID := TenantFromContext(ctx)
+	m.mu.RLock()
+	definitions := make(map[string]PreferenceDefinition)
+	for key, def := range m.definitionsFor(tenantID) {
+		definitions[key] = def
+	}
+	m.mu.RUnlock()
+
+	for _, key := range removed {
+		if def, exists := definitions[key]; exists {
+			m.notifyDefault(ctx, userID, def, ChangeDelete)
+		}
+	}
+
+	receipt := &ErasureReceipt{
+		TenantID:    tenantID,
+		UserID:      userID,
+		StorageKeys: removed,
+		CacheKeys:   []string{},
+	}
+
+	if m.config.cache != nil {
+		keys := make(map[string]bool, len(definitions)+len(removed))
+		for key := range definitions {
+			keys[key] = true
+		}
+		for _, key := range removed {
+			keys[key] = true
+		}
+		var cacheErrs []error
+		for key := range keys {
+			cacheKey := prefCacheKey(tenantID, userID, key)
+			if err := m.cacheDelete(ctx, userID, key); err != nil && !errors.Is(err, ErrNotFound) {
+				cacheErrs = append(cacheErrs, fmt.Errorf("cache key '%s': %w", cacheKey, err))
+				continue
+			}
+			receipt.CacheKeys = append(receipt.CacheKeys, cacheKey)
+		}
+		// Defaults computed by a DefaultFunc are derived from the user's data as well.
+		for key, def := range definitions {
+			if def.DefaultFunc == nil {
+				continue
+			}
+			cacheKey := defaultCacheKey(tenantID, userID, key)
+			if err := m.config.cache.Delete(ctx, cacheKey); err != nil && !errors.Is(err, ErrNotFound) {
+				cacheErrs = append(cacheErrs, fmt.Errorf("cache key '%s': %w", cacheKey, err))
+				continue
+			}
+			receipt.CacheKeys = append(receipt.CacheKeys, cacheKey)
+		}
+		sort.Strings(receipt.CacheKeys)
+		if len(cacheErrs) > 0 {
+			err := fmt.Errorf("failed to invalidate %d cache entries: %w", len(cacheErrs), errors.Join(cacheErrs...))
+			m.config.logger.Error("Failed to erase user preferences from cache", "userID", userID, "error", err)
+			return receipt, err
+		}
+	}
+
+	receipt.ErasedAt = time.Now()
+	m.config.logger.Info("Erased user preferences", "userID", userID, "storageKeys", len(receipt.StorageKeys), "cacheKeys", len(receipt.CacheKeys))
+	return receipt, nil
+}
+
+// deleteUserRows removes every stored preference of userID and returns the removed keys in sorted order.
+func (m *Manager) deleteUserRows(ctx context.Context, userID string) ([]string, error) {
+	if err := m.checkScope(ctx); err != nil {
+		return nil, err
+	}
+	if deleter, ok := m.config.storage.(UserDeleter); ok {
+		spanCtx, span := m.startSpan(ctx, "userprefs.storage.DeleteAll")
+		removed, err := deleter.DeleteAll(spanCtx, userID)
+		endSpan(span, err)
+		if err != nil {
+			return nil, fmt.Errorf("storage.DeleteAll failed for userID '%s': %w", userID, err)
+		}
+		sort.Strings(removed)
+		return removed, nil
+	}
+
+	var devices []string
+	if lister, ok := m.config.storage.(DeviceLister); ok {
+		spanCtx, span := m.startSpan(ctx, "userprefs.storage.ListDevices")
+		var err error
+		devices, err = lister.ListDevices(spanCtx, userID)
+		endSpan(span, err)
+		if err != nil {
+			return nil, fmt.Errorf("storage.ListDevices failed for userID '%s': %w", userID, err)
+		}
+	} else if m.scoped {
+		// Backends that do not scope by device cannot hold device overrides.
+		return nil, fmt.Errorf("%w: storage implements neither UserDeleter nor DeviceLister, so device overrides cannot be erased", ErrNotSupported)
+	}
+
+	seen := make(map[string]bool)
+	for _, scopeCtx := range append([]context.Context{ctx}, deviceContexts(ctx, devices)...) {
+		keys, err := m.deleteScopeRows(scopeCtx, userID)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			seen[key] = true
+		}
+	}
+	removed := make([]string, 0, len(seen))
+	for key := range seen {
+		removed = append(removed, key)
+	}
+	sort.Strings(removed)
+	return removed, nil
+}
+
+// deviceContexts returns a copy of ctx scoped to each of deviceIDs.
+func deviceContexts(ctx context.Context, deviceIDs []string) []context.Context {
+	scopes := make([]context.Context, len(deviceIDs))
+	for i, deviceID := range deviceIDs {
+		scopes[i] = WithDevice(ctx, deviceID)
+	}
+	return scopes
+}
+
+// deleteScopeRows removes the stored preferences of userID in the tenant and device of ctx,
+// enumerated with GetAll, and returns the removed keys in sorted order.
+func (m *Manager) deleteScopeRows(ctx context.Context, userID string) ([]string, error) {
+	stored, err := m.storageGetAll(ctx, userID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("storage.GetAll failed for userID '%s' on device '%s': %w", userID, DeviceFromContext(ctx), err)
+	}
+	removed := make([]string, 0, len(stored))
+	for key := range stored {
+		removed = append(removed, key)
+	}
+	sort.Strings(removed)
+
+	if err := m.deleteMany(ctx, userID, removed); err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
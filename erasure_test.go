@@ -0,0 +1,158 @@
+package userprefs
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestManager_DeleteUser(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMockStorage()
+	cache := NewMockCache()
+	recorder := &eventRecorder{}
+	mgr := newTestManager(t, []PreferenceDefinition{
+		{Key: "theme", Type: StringType, DefaultValue: "light"},
+		{Key: "plan", Type: StringType, DefaultValue: "free", ReadOnly: true},
+		{Key: "language", Type: StringType, DefaultValue: "en"},
+	}, WithStorage(storage), WithCache(cache), WithChangeListener(recorder.listen))
+
+	if err := mgr.SetMany(ctx, "u1", map[string]interface{}{"theme": "dark", "plan": "pro"}); err != nil {
+		t.Fatalf("SetMany failed: %v", err)
+	}
+	if err := mgr.Set(ctx, "u2", "theme", "dark"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	storeRaw(t, storage, "u1", "orphaned", "x")
+	// Cache a default so that erasure has to purge more than the stored keys.
+	if _, err := mgr.Get(ctx, "u1", "language"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	recorder.take()
+
+	userCtx := WithActor(ctx, Actor{ID: "u1", Role: ActorUser})
+	receipt, err := mgr.DeleteUser(userCtx, "u1")
+	if err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+
+	if want := []string{"orphaned", "plan", "theme"}; !reflect.DeepEqual(receipt.StorageKeys, want) {
+		t.Errorf("Expected storage keys %v, got %v", want, receipt.StorageKeys)
+	}
+	wantCache := []string{"pref:u1:language", "pref:u1:orphaned", "pref:u1:plan", "pref:u1:theme"}
+	if !reflect.DeepEqual(receipt.CacheKeys, wantCache) {
+		t.Errorf("Expected cache keys %v, got %v", wantCache, receipt.CacheKeys)
+	}
+	if receipt.UserID != "u1" || receipt.ErasedAt.IsZero() {
+		t.Errorf("Unexpected receipt: %+v", receipt)
+	}
+
+	if all, _ := storage.GetAll(ctx, "u1"); len(all) != 0 {
+		t.Errorf("Expected no stored preferences after erasure, got %v", all)
+	}
+	cache.mu.RLock()
+	for _, key := range wantCache {
+		if _, ok := cache.data[key]; ok {
+			t.Errorf("Expected cache entry %s to be purged", key)
+		}
+	}
+	cache.mu.RUnlock()
+	if pref, _ := mgr.Get(ctx, "u2", "theme"); pref.Value != "dark" {
+		t.Errorf("Expected other users to be unaffected, got %v", pref.Value)
+	}
+	if events := recorder.take(); len(events) != 2 || events[0].Kind != ChangeDelete {
+		t.Errorf("Expected 2 delete events for the defined keys, got %+v", events)
+	}
+
+	receipt, err = mgr.DeleteUser(ctx, "u1")
+	if err != nil || len(receipt.StorageKeys) != 0 {
+		t.Errorf("Expected erasing an erased user to succeed with nothing removed, got %+v (err %v)", receipt, err)
+	}
+	if _, err := mgr.DeleteUser(ctx, ""); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput, got %v", err)
+	}
+}
+
+// scopedStorageOnly exposes only the Storage and ScopedStorage methods of a backend, hiding
+// UserDeleter and DeviceLister.
+type scopedStorageOnly struct {
+	Storage
+}
+
+func (scopedStorageOnly) ScopesByContext() bool { return true }
+
+func TestManager_DeleteUser_DeviceOverrides(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMockStorage()
+	mgr := newTestManager(t, []PreferenceDefinition{
+		{Key: "theme", Type: StringType, DefaultValue: "light", PerDevice: true},
+		{Key: "language", Type: StringType, DefaultValue: "en"},
+	}, WithStorage(storage))
+
+	if err := mgr.SetMany(ctx, "u1", map[string]interface{}{"theme": "dark", "language": "de"}); err != nil {
+		t.Fatalf("SetMany failed: %v", err)
+	}
+	for _, deviceID := range []string{"phone", "tablet"} {
+		if err := mgr.Set(WithDevice(ctx, deviceID), "u1", "theme", "sepia"); err != nil {
+			t.Fatalf("Set on %s failed: %v", deviceID, err)
+		}
+	}
+	if err := mgr.Set(WithDevice(ctx, "phone"), "u2", "theme", "sepia"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	receipt, err := mgr.DeleteUser(WithDevice(ctx, "phone"), "u1")
+	if err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+	if want := []string{"language", "theme"}; !reflect.DeepEqual(receipt.StorageKeys, want) {
+		t.Errorf("Expected storage keys %v, got %v", want, receipt.StorageKeys)
+	}
+
+	for _, deviceID := range []string{"", "phone", "tablet"} {
+		if all, _ := storage.GetAll(WithDevice(ctx, deviceID), "u1"); len(all) != 0 {
+			t.Errorf("Expected no stored preferences on device %q after erasure, got %v", deviceID, all)
+		}
+	}
+	if devices, _ := storage.ListDevices(ctx, "u1"); len(devices) != 0 {
+		t.Errorf("Expected no devices after erasure, got %v", devices)
+	}
+	if pref, _ := mgr.Get(WithDevice(ctx, "phone"), "u2", "theme"); pref.Value != "sepia" {
+		t.Errorf("Expected other users' device overrides to be kept, got %v", pref.Value)
+	}
+}
+
+func TestManager_DeleteUser_DeviceOverridesNotSupported(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMockStorage()
+	mgr := newTestManager(t, []PreferenceDefinition{
+		{Key: "theme", Type: StringType, DefaultValue: "light", PerDevice: true},
+	}, WithStorage(scopedStorageOnly{storage}))
+
+	if err := mgr.Set(ctx, "u1", "theme", "dark"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := mgr.Set(WithDevice(ctx, "phone"), "u1", "theme", "sepia"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	if _, err := mgr.DeleteUser(ctx, "u1"); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("Expected ErrNotSupported, got %v", err)
+	}
+	if pref, _ := storage.Get(ctx, "u1", "theme"); pref == nil || pref.Value != "dark" {
+		t.Errorf("Expected nothing to be deleted, got %v", pref)
+	}
+
+	// Backends that do not scope by device hold no device overrides to miss.
+	unscoped := newTestManager(t, []PreferenceDefinition{
+		{Key: "theme", Type: StringType, DefaultValue: "light", PerDevice: true},
+	}, WithStorage(storageOnly{NewMockStorage()}))
+	if err := unscoped.Set(ctx, "u1", "theme", "dark"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	receipt, err := unscoped.DeleteUser(ctx, "u1")
+	if err != nil || !reflect.DeepEqual(receipt.StorageKeys, []string{"theme"}) {
+		t.Errorf("Expected the user's own values to be erased, got %+v (err %v)", receipt, err)
+	}
+}
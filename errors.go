@@ -47,3 +47,16 @@ var ErrEncryptionRequired = errors.New("encryption manager required for encrypte
 
 // ErrEncryptionFailed indicates that an encryption or decryption operation failed.
 var ErrEncryptionFailed = errors.New("encryption operation failed")
+
+// ErrMigrationFailed indicates that a stored value could not be upgraded to the current definition version.
+var ErrMigrationFailed = errors.New("preference migration failed")
+
+// ErrNotSupported indicates that the configured storage or cache backend does not support the requested operation.
+var ErrNotSupported = errors.New("operation not supported by backend")
+
+// ErrForbidden indicates that the actor in the context is not allowed to change the preference.
+var ErrForbidden = errors.New("operation forbidden for actor")
+
+// ErrCursorExpired indicates that a sync cursor is older than the tombstone retention, so
+// deletions since it may have been pruned. The client must resynchronize with an empty cursor.
+var ErrCursorExpired = errors.New("sync cursor expired, full resync required")
@@ -0,0 +1,365 @@
+// Package userprefs provides export and import of a user's preferences for data portability.
+package userprefs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ExportFormatVersion is the version of the ExportDocument format written by Manager.Export.
+// Manager.Import accepts documents of this version and older.
+const ExportFormatVersion = 1
+
+// ExportDocument is a portable snapshot of a user's stored preferences, produced by
+// Manager.Export and consumed by Manager.Import. It is designed to be serialized as JSON.
+type ExportDocument struct {
+	// FormatVersion is the ExportFormatVersion the document was written with.
+	FormatVersion int `json:"format_version"`
+	// TenantID is the tenant the preferences were exported from.
+	TenantID string `json:"tenant_id,omitempty"`
+	// UserID is the user the preferences belong to.
+	UserID string `json:"user_id"`
+	// ExportedAt is when the document was produced.
+	ExportedAt time.Time `json:"exported_at"`
+	// Preferences holds the user's stored values, ordered by key.
+	Preferences []ExportedPreference `json:"preferences"`
+}
+
+// ExportedPreference is a single stored value in an ExportDocument.
+type ExportedPreference struct {
+	Key      string `json:"key"`
+	Type     string `json:"type"`
+	Category string `json:"category,omitempty"`
+	// Value is the stored value in its JSON-compatible form (e.g. a duration as a string).
+	// If Encrypted is true, it is instead the ciphertext produced by ExportOptions.Rewrap.
+	Value interface{} `json:"value"`
+	// Encrypted reports whether Value was re-encrypted on export.
+	Encrypted bool `json:"encrypted,omitempty"`
+	// Version is the definition schema version the value conforms to.
+	Version int `json:"version"`
+	// UpdatedAt is when the value was last written.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ExportOptions configures Manager.Export.
+type ExportOptions struct {
+	// Rewrap, if set, re-encrypts the values of Encrypted preferences with this
+	// EncryptionManager (e.g. one holding the receiving system's key) instead of exporting
+	// them in plaintext. Values are JSON-encoded before encryption.
+	Rewrap EncryptionManager
+}
+
+// ConflictStrategy decides what Manager.Import does with a key the user already has a stored value for.
+type ConflictStrategy string
+
+const (
+	// ConflictOverwrite replaces existing values with the imported ones. It is the default.
+	ConflictOverwrite ConflictStrategy = "overwrite"
+	// ConflictKeepNewer replaces an existing value only if the imported one was updated later.
+	ConflictKeepNewer ConflictStrategy = "keep_newer"
+	// ConflictSkip keeps every existing value.
+	ConflictSkip ConflictStrategy = "skip"
+)
+
+// ImportOptions configures Manager.Import.
+type ImportOptions struct {
+	// Conflict decides what happens to keys the user already has a value for.
+	// Defaults to ConflictOverwrite.
+	Conflict ConflictStrategy
+	// DryRun validates the document and reports what would be imported without writing anything.
+	DryRun bool
+	// Decrypt, if set, decrypts values that were re-encrypted on export (see ExportOptions.Rewrap).
+	// It is required if the document contains encrypted values.
+	Decrypt EncryptionManager
+}
+
+// ImportResult reports the outcome of Manager.Import.
+type ImportResult struct {
+	// Imported lists the keys that were written (or, for a dry run, would be written).
+	// Values of deprecated keys are listed under the key they were redirected to.
+	Imported []string `json:"imported"`
+	// Skipped lists the keys left unchanged because of the ConflictStrategy.
+	Skipped []string `json:"skipped"`
+	// Unknown lists the keys in the document that have no definition and were ignored.
+	Unknown []string `json:"unknown"`
+	// DryRun reports whether nothing was written.
+	DryRun bool `json:"dry_run"`
+}
+
+// Export produces a portable document of every value the user has stored in the tenant in
+// ctx (see WithTenant). Defaults are not included, and stored rows without a definition are
+// skipped. Values are decrypted, upgraded to the definition's current Version, and converted
+// to their JSON-compatible form; values of Encrypted preferences are re-encrypted instead if
+// opts.Rewrap is set.
+//
+// Returns:
+//   - (*ExportDocument, nil): On success.
+//   - (nil, ErrInvalidInput): If userID is empty.
+//   - (nil, ErrEncryptionFailed): If a value cannot be decrypted or re-encrypted.
+//   - (nil, ErrMigrationFailed): If a value written with an older definition Version cannot be upgraded.
+//   - (nil, wrapped storage error): If the storage operation fails.
+//
+// This method is thread-safe.
+func (m *Manager) Export(ctx context.Context, userID string, opts ExportOptions) (_ *ExportDocument, err error) {
+	ctx, span := m.startOperationSpan(ctx, "Export")
+	defer func() { endSpan(span, err) }()
+	// Exports hold the user's own values; device overrides are not exported.
+	ctx = withoutDevice(ctx)
+	if userID == "" {
+		return nil, ErrInvalidInput
+	}
+
+	stored, err := m.storageGetAll(ctx, userID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		m.config.logger.Error("Storage GetAll failed", "userID", userID, "error", err)
+		return nil, fmt.Errorf("storage.GetAll failed for userID '%s': %w", userID, err)
+	}
+
+	keys := make([]string, 0, len(stored))
+	for key := range stored {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	doc := &ExportDocument{
+		FormatVersion: ExportFormatVersion,
+		TenantID:      TenantFromContext(ctx),
+		UserID:        userID,
+		ExportedAt:    time.Now(),
+		Preferences:   make([]ExportedPreference, 0, len(keys)),
+	}
+	for _, key := range keys {
+		def, exists := m.contextDefinition(ctx, key)
+		if !exists {
+			m.config.logger.Debug("Skipping stored preference without definition in export", "userID", userID, "key", key)
+			continue
+		}
+		exported, err := m.exportPreference(ctx, stored[key], def, opts)
+		if err != nil {
+			m.config.logger.Error("Failed to export preference", "userID", userID, "key", key, "error", err)
+			return nil, err
+		}
+		doc.Preferences = append(doc.Preferences, exported)
+	}
+	return doc, nil
+}
+
+// exportPreference converts a raw stored preference into its exported form.
+func (m *Manager) exportPreference(ctx context.Context, pref *Preference, def PreferenceDefinition, opts ExportOptions) (ExportedPreference, error) {
+	if err := m.decodeStored(ctx, pref, def); err != nil {
+		return ExportedPreference{}, err
+	}
+	value, err := encodeValue(pref.Value, def)
+	if err != nil {
+		return ExportedPreference{}, err
+	}
+
+	exported := ExportedPreference{
+		Key:       def.Key,
+		Type:      def.Type,
+		Category:  def.Category,
+		Value:     value,
+		Version:   pref.Version,
+		UpdatedAt: pref.UpdatedAt,
+	}
+	if def.Encrypted && opts.Rewrap != nil && value != nil {
+		plaintext, err := json.Marshal(value)
+		if err != nil {
+			return ExportedPreference{}, fmt.Errorf("%w: failed to marshal value for key '%s': %v", ErrEncryptionFailed, def.Key, err)
+		}
+		ciphertext, err := opts.Rewrap.Encrypt(string(plaintext))
+		if err != nil {
+			return ExportedPreference{}, fmt.Errorf("%w: re-encryption failed for key '%s': %v", ErrEncryptionFailed, def.Key, err)
+		}
+		exported.Value = ciphertext
+		exported.Encrypted = true
+	}
+	return exported, nil
+}
+
+// Import writes the values of an ExportDocument as the preferences of userID in the tenant
+// in ctx (see WithTenant). The document's own UserID and TenantID are informational, so a
+// document can be imported under a different account.
+//
+// Every value is validated against the current definitions as in SetMany: values written
+// with an older definition Version are upgraded through its Migrations, values of
+// deprecated keys are redirected to their replacements, and the dependency and cross-field
+// checks see all imported values at once. Nothing is written unless every value passes.
+// Keys without a definition are ignored and reported in ImportResult.Unknown. Existing
+// values are handled according to opts.Conflict. Imported values are stamped with the
+// time of the import.
+//
+// Returns:
+//   - (*ImportResult, nil): On success, including dry runs.
+//   - (nil, ErrInvalidInput): If userID is empty, doc is nil or has an unsupported FormatVersion,
+//     opts.Conflict is unknown, an entry has no key or a Version newer than its definition, or
+//     a key is imported more than once.
+//   - (nil, ErrEncryptionRequired): If the document has encrypted values and opts.Decrypt is nil.
+//   - (nil, ErrEncryptionFailed): If an encrypted value cannot be decrypted.
+//   - (nil, ErrMigrationFailed): If a value cannot be upgraded to the definition's Version.
+//   - (nil, ErrForbidden): If the actor in the context may not change one of the keys.
+//   - (nil, ValidationErrors matching ErrInvalidValue): If any value fails validation.
+//   - (result, wrapped storage error): If a storage operation fails; result lists the keys written so far.
+//
+// This method is thread-safe.
+func (m *Manager) Import(ctx context.Context, userID string, doc *ExportDocument, opts ImportOptions) (_ *ImportResult, err error) {
+	ctx, span := m.startOperationSpan(ctx, "Import")
+	defer func() { endSpan(span, err) }()
+	// Imported values become the user's own values, whatever the device in ctx.
+	ctx = withoutDevice(ctx)
+	if userID == "" || doc == nil {
+		return nil, ErrInvalidInput
+	}
+	if doc.FormatVersion < 1 || doc.FormatVersion > ExportFormatVersion {
+		return nil, fmt.Errorf("%w: unsupported export format version %d", ErrInvalidInput, doc.FormatVersion)
+	}
+	switch opts.Conflict {
+	case "":
+		opts.Conflict = ConflictOverwrite
+	case ConflictOverwrite, ConflictKeepNewer, ConflictSkip:
+	default:
+		return nil, fmt.Errorf("%w: unknown conflict strategy '%s'", ErrInvalidInput, opts.Conflict)
+	}
+
+	result := &ImportResult{Imported: []string{}, Skipped: []string{}, Unknown: []string{}, DryRun: opts.DryRun}
+	defs := make(map[string]PreferenceDefinition, len(doc.Preferences))
+	values := make(map[string]interface{}, len(doc.Preferences))
+	updated := make(map[string]time.Time, len(doc.Preferences))
+	var verrs ValidationErrors
+	for _, entry := range doc.Preferences {
+		if entry.Key == "" {
+			return nil, ErrInvalidInput
+		}
+		def, exists := m.contextDefinition(ctx, entry.Key)
+		if !exists {
+			result.Unknown = append(result.Unknown, entry.Key)
+			continue
+		}
+
+		def, value, err := m.importValue(ctx, entry, def, opts)
+		if err != nil {
+			keyErrs, ok := toValidationErrors(err, def.Key, "", "")
+			if !ok {
+				return nil, err
+			}
+			verrs = append(verrs, keyErrs...)
+			continue
+		}
+		if _, dup := defs[def.Key]; dup {
+			return nil, fmt.Errorf("%w: '%s' is imported more than once", ErrInvalidInput, def.Key)
+		}
+		defs[def.Key] = def
+		values[def.Key] = value
+		updated[def.Key] = entry.UpdatedAt
+	}
+	if len(verrs) > 0 {
+		return nil, verrs
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		keep, err := m.keepExisting(ctx, userID, key, updated[key], opts.Conflict)
+		if err != nil {
+			return nil, err
+		}
+		if keep {
+			result.Skipped = append(result.Skipped, key)
+			delete(values, key)
+			continue
+		}
+		result.Imported = append(result.Imported, key)
+	}
+
+	if len(values) > 0 {
+		if err := m.validateWrite(ctx, userID, values); err != nil {
+			return nil, err
+		}
+	}
+	if opts.DryRun {
+		return result, nil
+	}
+
+	for i, key := range result.Imported {
+		if err := m.write(ctx, userID, defs[key], values[key]); err != nil {
+			result.Imported = result.Imported[:i]
+			return result, err
+		}
+		m.notifyChange(ctx, userID, defs[key], ChangeSet, values[key])
+	}
+	m.config.logger.Info("Imported preferences", "userID", userID, "imported", len(result.Imported), "skipped", len(result.Skipped), "unknown", len(result.Unknown))
+	return result, nil
+}
+
+// importValue decrypts, upgrades, and validates a single exported value of def. It returns
+// the definition the value is to be written under, which differs from def for deprecated
+// keys with a replacement, and the normalized value.
+func (m *Manager) importValue(ctx context.Context, entry ExportedPreference, def PreferenceDefinition, opts ImportOptions) (PreferenceDefinition, interface{}, error) {
+	value := entry.Value
+	if entry.Encrypted && value != nil {
+		if opts.Decrypt == nil {
+			return def, nil, fmt.Errorf("%w: imported value of '%s' is encrypted", ErrEncryptionRequired, entry.Key)
+		}
+		ciphertext, ok := value.(string)
+		if !ok {
+			return def, nil, fmt.Errorf("%w: encrypted value of '%s' is not a string", ErrEncryptionFailed, entry.Key)
+		}
+		plaintext, err := opts.Decrypt.Decrypt(ciphertext)
+		if err != nil {
+			return def, nil, fmt.Errorf("%w: decryption failed for key '%s': %v", ErrEncryptionFailed, entry.Key, err)
+		}
+		if err := json.Unmarshal([]byte(plaintext), &value); err != nil {
+			return def, nil, fmt.Errorf("%w: failed to unmarshal decrypted value of '%s': %v", ErrEncryptionFailed, entry.Key, err)
+		}
+	}
+
+	if entry.Version > def.Version {
+		return def, nil, fmt.Errorf("%w: imported value of '%s' has version %d, newer than the definition's %d", ErrInvalidInput, entry.Key, entry.Version, def.Version)
+	}
+	pref := &Preference{Key: entry.Key, Value: value, Version: entry.Version}
+	if err := migrateValue(pref, def); err != nil {
+		return def, nil, err
+	}
+	value, err := decodeValue(pref.Value, def)
+	if err != nil {
+		return def, nil, err
+	}
+
+	def, value, err = m.redirectWrite(ctx, def, value)
+	if err != nil {
+		return def, nil, err
+	}
+	if err := checkWriteAccess(ctx, def); err != nil {
+		return def, nil, err
+	}
+	value, err = checkValue(value, def)
+	return def, value, err
+}
+
+// keepExisting reports whether the conflict strategy keeps the user's existing value for key
+// rather than replacing it with an imported value last updated at updatedAt.
+func (m *Manager) keepExisting(ctx context.Context, userID, key string, updatedAt time.Time, conflict ConflictStrategy) (bool, error) {
+	if conflict == ConflictOverwrite {
+		return false, nil
+	}
+	existing, err := m.storageGet(ctx, userID, key)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		m.config.logger.Error("Storage Get failed", "userID", userID, "key", key, "error", err)
+		return false, fmt.Errorf("storage.Get failed for key '%s': %w", key, err)
+	}
+	if conflict == ConflictSkip {
+		return true, nil
+	}
+	return !updatedAt.After(existing.UpdatedAt), nil
+}
@@ -0,0 +1,155 @@
+package userprefs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// exportDefinitions returns the preferences used by the export tests; api_token is
+// encrypted when the manager has an encryption manager.
+func exportDefinitions(encrypted bool) []PreferenceDefinition {
+	return []PreferenceDefinition{
+		{Key: "theme", Type: StringType, DefaultValue: "light", Category: "appearance"},
+		{Key: "font_size", Type: IntType, DefaultValue: 12, Category: "appearance"},
+		{Key: "timeout", Type: DurationType, DefaultValue: time.Minute},
+		{Key: "api_token", Type: StringType, Encrypted: encrypted},
+	}
+}
+
+func TestManager_ExportImport_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	sourceKey, err := NewEncryptionAdapterWithKey([]byte("this-is-a-32-byte-key-for-test!!"))
+	if err != nil {
+		t.Fatalf("NewEncryptionAdapterWithKey failed: %v", err)
+	}
+	transferKey, err := NewEncryptionAdapterWithKey([]byte("another-32-byte-key-for-testing!"))
+	if err != nil {
+		t.Fatalf("NewEncryptionAdapterWithKey failed: %v", err)
+	}
+
+	source := newTestManager(t, exportDefinitions(true), WithEncryption(sourceKey))
+	values := map[string]interface{}{"theme": "dark", "font_size": 16, "timeout": 90 * time.Second, "api_token": "secret"}
+	if err := source.SetMany(ctx, "u1", values); err != nil {
+		t.Fatalf("SetMany failed: %v", err)
+	}
+
+	doc, err := source.Export(ctx, "u1", ExportOptions{Rewrap: transferKey})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if doc.FormatVersion != ExportFormatVersion || doc.UserID != "u1" || len(doc.Preferences) != 4 {
+		t.Fatalf("Unexpected export document: %+v", doc)
+	}
+	for _, p := range doc.Preferences {
+		if p.Key == "api_token" && (!p.Encrypted || p.Value == "secret") {
+			t.Errorf("Expected api_token to be re-encrypted, got %+v", p)
+		}
+		if p.Key == "timeout" && p.Value != "1m30s" {
+			t.Errorf("Expected the duration in its portable form, got %v", p.Value)
+		}
+	}
+
+	// Round-trip through JSON as a real transfer would.
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var transferred ExportDocument
+	if err := json.Unmarshal(data, &transferred); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	target := newTestManager(t, exportDefinitions(false))
+	if _, err := target.Import(ctx, "u2", &transferred, ImportOptions{}); !errors.Is(err, ErrEncryptionRequired) {
+		t.Fatalf("Expected ErrEncryptionRequired without a decrypter, got %v", err)
+	}
+	result, err := target.Import(ctx, "u2", &transferred, ImportOptions{Decrypt: transferKey})
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if len(result.Imported) != 4 {
+		t.Errorf("Expected 4 imported keys, got %+v", result)
+	}
+
+	all, err := target.GetAll(ctx, "u2")
+	if err != nil {
+		t.Fatalf("GetAll failed: %v", err)
+	}
+	for key, want := range values {
+		if all[key].Value != want {
+			t.Errorf("%s: expected %v (%T), got %v (%T)", key, want, want, all[key].Value, all[key].Value)
+		}
+	}
+}
+
+func TestManager_Import_Conflicts(t *testing.T) {
+	ctx := context.Background()
+	mgr := newTestManager(t, exportDefinitions(false))
+	if err := mgr.Set(ctx, "u1", "theme", "dark"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	newDoc := func(updatedAt time.Time) *ExportDocument {
+		return &ExportDocument{FormatVersion: ExportFormatVersion, Preferences: []ExportedPreference{
+			{Key: "theme", Type: StringType, Value: "blue", UpdatedAt: updatedAt},
+			{Key: "font_size", Type: IntType, Value: float64(14), UpdatedAt: updatedAt},
+			{Key: "removed_key", Type: StringType, Value: "x", UpdatedAt: updatedAt},
+		}}
+	}
+
+	testCases := []struct {
+		name      string
+		doc       *ExportDocument
+		opts      ImportOptions
+		wantTheme string
+		imported  int
+	}{
+		// Cases run in order against the same user; font_size is stored by the first one.
+		{"skip", newDoc(future), ImportOptions{Conflict: ConflictSkip}, "dark", 1},
+		{"keep newer, older import", newDoc(past), ImportOptions{Conflict: ConflictKeepNewer}, "dark", 0},
+		{"dry run", newDoc(future), ImportOptions{DryRun: true}, "dark", 2},
+		{"keep newer, newer import", newDoc(future), ImportOptions{Conflict: ConflictKeepNewer}, "blue", 2},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := mgr.Import(ctx, "u1", tc.doc, tc.opts)
+			if err != nil {
+				t.Fatalf("Import failed: %v", err)
+			}
+			if len(result.Imported) != tc.imported || len(result.Unknown) != 1 || result.DryRun != tc.opts.DryRun {
+				t.Errorf("Unexpected result: %+v", result)
+			}
+			if pref, _ := mgr.Get(ctx, "u1", "theme"); pref.Value != tc.wantTheme {
+				t.Errorf("Expected theme %q, got %v", tc.wantTheme, pref.Value)
+			}
+		})
+	}
+}
+
+func TestManager_Import_Invalid(t *testing.T) {
+	ctx := context.Background()
+	mgr := newTestManager(t, exportDefinitions(false))
+
+	doc := &ExportDocument{FormatVersion: ExportFormatVersion, Preferences: []ExportedPreference{
+		{Key: "theme", Type: StringType, Value: "dark"},
+		{Key: "font_size", Type: IntType, Value: "big"},
+	}}
+	var verrs ValidationErrors
+	if _, err := mgr.Import(ctx, "u1", doc, ImportOptions{}); !errors.As(err, &verrs) || len(verrs.ForKey("font_size")) == 0 {
+		t.Fatalf("Expected a validation error for font_size, got %v", err)
+	}
+	if pref, _ := mgr.Get(ctx, "u1", "theme"); pref.Value != "light" {
+		t.Errorf("Expected nothing to be written when a value is invalid, got theme %v", pref.Value)
+	}
+
+	if _, err := mgr.Import(ctx, "u1", &ExportDocument{FormatVersion: ExportFormatVersion + 1}, ImportOptions{}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for an unsupported format version, got %v", err)
+	}
+	if _, err := mgr.Import(ctx, "u1", &ExportDocument{FormatVersion: ExportFormatVersion}, ImportOptions{Conflict: "merge"}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for an unknown conflict strategy, got %v", err)
+	}
+}
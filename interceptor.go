@@ -0,0 +1,134 @@
+// Package userprefs provides interceptors that wrap Manager operations.
+package userprefs
+
+import (
+	"context"
+	"fmt"
+)
+
+// OperationKind identifies the Manager operation described by an Operation.
+type OperationKind string
+
+const (
+	// OpGet is Manager.Get. Its result is a *Preference.
+	OpGet OperationKind = "Get"
+	// OpSet is Manager.Set. Its result is nil.
+	OpSet OperationKind = "Set"
+	// OpSetMany is Manager.SetMany. Its result is nil.
+	OpSetMany OperationKind = "SetMany"
+	// OpDelete is Manager.Delete. Its result is nil.
+	OpDelete OperationKind = "Delete"
+	// OpGetAll is Manager.GetAll. Its result is a map[string]*Preference.
+	OpGetAll OperationKind = "GetAll"
+	// OpGetByCategory is Manager.GetByCategory. Its result is a map[string]*Preference.
+	OpGetByCategory OperationKind = "GetByCategory"
+	// OpDefinePreference is Manager.DefinePreference. Its result is nil.
+	OpDefinePreference OperationKind = "DefinePreference"
+	// OpGetDefinition is Manager.GetDefinition. Its result is a *PreferenceDefinition, or nil
+	// if the key is not defined.
+	OpGetDefinition OperationKind = "GetDefinition"
+	// OpGetAllDefinitions is Manager.GetAllDefinitions. Its result is a []*PreferenceDefinition.
+	OpGetAllDefinitions OperationKind = "GetAllDefinitions"
+	// OpDeleteDefinition is Manager.DeleteDefinition. Its result is nil.
+	OpDeleteDefinition OperationKind = "DeleteDefinition"
+)
+
+// Operation describes a Manager call passing through the interceptor chain. Only the fields
+// relevant to Kind are set. Interceptors may modify the fields before calling the next
+// handler to change the call's arguments, e.g. to normalize a key or rewrite a value.
+type Operation struct {
+	// Kind identifies the operation.
+	Kind OperationKind
+	// TenantID is the tenant the operation runs in (see WithTenant). Changing it runs the
+	// operation in another tenant.
+	TenantID string
+	// UserID is the user whose preferences are read or written. Empty for definition calls.
+	UserID string
+	// Key is the preference key for OpGet, OpSet, OpDelete, OpGetDefinition, and OpDeleteDefinition.
+	Key string
+	// Category is the category for OpGetByCategory.
+	Category string
+	// Value is the value to write for OpSet.
+	Value interface{}
+	// Values are the values to write for OpSetMany, keyed by preference key.
+	Values map[string]interface{}
+	// Definition is the definition to register for OpDefinePreference.
+	Definition *PreferenceDefinition
+}
+
+// OperationHandler runs an Operation and returns its result, whose type depends on the
+// operation's Kind (see the OperationKind constants).
+type OperationHandler func(ctx context.Context, op *Operation) (interface{}, error)
+
+// Interceptor wraps a Manager operation, much like a gRPC unary server interceptor. It
+// receives the operation and the next handler in the chain, and must call next to proceed.
+// An interceptor can:
+//   - inspect op, e.g. for tracing, metrics, or authorization;
+//   - modify op or return next(ctx2, op) with a derived context, to change the call;
+//   - short-circuit the call by returning without calling next, e.g. with ErrForbidden
+//     or with a result of its own, which must have the type the operation returns;
+//   - inspect or replace the result and error returned by next.
+type Interceptor func(ctx context.Context, op *Operation, next OperationHandler) (interface{}, error)
+
+// WithInterceptors is a functional option that wraps Manager operations in interceptors.
+// The first interceptor is the outermost: it sees each call first and its result last.
+// The option may be supplied more than once; later interceptors are nested inside earlier ones.
+//
+// Interceptors wrap Get, Set, SetMany, Delete, GetAll, GetByCategory, DefinePreference,
+// GetDefinition, and GetAllDefinitions, including calls made through a TenantManager.
+// DefinePreference and GetDefinition take no context, so their interceptors receive a
+// background context scoped to the tenant. Calls the Manager makes internally, such as
+// reading a DependsOn parent, are not intercepted.
+// This option is optional.
+func WithInterceptors(interceptors ...Interceptor) Option {
+	return func(c *Config) {
+		c.interceptors = append(c.interceptors, interceptors...)
+	}
+}
+
+// intercept runs op through the configured interceptors, ending with call, within the
+// operation's span. The tenant of ctx is recorded in op.TenantID, and call runs in whichever
+// tenant op.TenantID names once the chain reaches it.
+func (m *Manager) intercept(ctx context.Context, op *Operation, call OperationHandler) (interface{}, error) {
+	op.TenantID = TenantFromContext(ctx)
+	ctx, span := m.startOperationSpan(ctx, string(op.Kind), operationAttributes(op)...)
+	result, err := m.chain(ctx, op, call)
+	endSpan(span, err)
+	return result, err
+}
+
+// chain runs op through the configured interceptors, ending with call.
+func (m *Manager) chain(ctx context.Context, op *Operation, call OperationHandler) (interface{}, error) {
+	if len(m.config.interceptors) == 0 {
+		return call(ctx, op)
+	}
+
+	handler := func(ctx context.Context, op *Operation) (interface{}, error) {
+		if op.TenantID != TenantFromContext(ctx) {
+			ctx = WithTenant(ctx, op.TenantID)
+		}
+		return call(ctx, op)
+	}
+	for i := len(m.config.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := m.config.interceptors[i], handler
+		handler = func(ctx context.Context, op *Operation) (interface{}, error) {
+			return interceptor(ctx, op, next)
+		}
+	}
+	return handler(ctx, op)
+}
+
+// interceptResult converts the result of an intercepted operation to the type the operation
+// returns. A nil result yields the zero value. A result of another type, which only a
+// misbehaving interceptor can produce, is reported as ErrInternal.
+func interceptResult[T any](op *Operation, result interface{}, err error) (T, error) {
+	var zero T
+	if result == nil {
+		return zero, err
+	}
+	typed, ok := result.(T)
+	if !ok {
+		return zero, fmt.Errorf("%w: interceptor returned %T for %s", ErrInternal, result, op.Kind)
+	}
+	return typed, err
+}
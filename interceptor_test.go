@@ -0,0 +1,110 @@
+package userprefs
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestManager_Interceptors(t *testing.T) {
+	ctx := context.Background()
+	var calls []string
+	trace := func(name string) Interceptor {
+		return func(ctx context.Context, op *Operation, next OperationHandler) (interface{}, error) {
+			calls = append(calls, name+">"+string(op.Kind))
+			result, err := next(ctx, op)
+			calls = append(calls, name+"<"+string(op.Kind))
+			return result, err
+		}
+	}
+	// normalize lowercases keys and trims string values before they reach the Manager.
+	normalize := func(ctx context.Context, op *Operation, next OperationHandler) (interface{}, error) {
+		op.Key = strings.ToLower(op.Key)
+		if s, ok := op.Value.(string); ok {
+			op.Value = strings.TrimSpace(s)
+		}
+		return next(ctx, op)
+	}
+	mgr := newTestManager(t, []PreferenceDefinition{{Key: "theme", Type: StringType, DefaultValue: "light"}},
+		WithInterceptors(trace("outer"), trace("inner")), WithInterceptors(normalize))
+	calls = nil
+
+	if err := mgr.Set(ctx, "u1", "THEME", "  dark "); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	want := []string{"outer>Set", "inner>Set", "inner<Set", "outer<Set"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("Expected call order %v, got %v", want, calls)
+	}
+
+	pref, err := mgr.Get(ctx, "u1", "Theme")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if pref.Value != "dark" {
+		t.Errorf("Expected the modified key and value to be used, got %v", pref.Value)
+	}
+	if _, ok := mgr.GetDefinition("THEME"); !ok {
+		t.Error("Expected GetDefinition to be intercepted")
+	}
+}
+
+func TestManager_Interceptors_ShortCircuit(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMockStorage()
+	readOnly := func(ctx context.Context, op *Operation, next OperationHandler) (interface{}, error) {
+		switch op.Kind {
+		case OpSet, OpSetMany, OpDelete:
+			return nil, ErrForbidden
+		case OpGet:
+			if op.Key == "motd" {
+				return &Preference{UserID: op.UserID, Key: op.Key, Value: "hello", Type: StringType}, nil
+			}
+		case OpGetAll:
+			return "not a map", nil
+		}
+		return next(ctx, op)
+	}
+	mgr := newTestManager(t, []PreferenceDefinition{{Key: "theme", Type: StringType, DefaultValue: "light"}}, WithStorage(storage), WithInterceptors(readOnly))
+
+	if err := mgr.Set(ctx, "u1", "theme", "dark"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected ErrForbidden from Set, got %v", err)
+	}
+	if all, _ := storage.GetAll(ctx, "u1"); len(all) != 0 {
+		t.Errorf("Expected a short-circuited Set not to write, got %v", all)
+	}
+	if pref, err := mgr.Get(ctx, "u1", "motd"); err != nil || pref.Value != "hello" {
+		t.Errorf("Expected the interceptor's result for an undefined key, got %+v (err %v)", pref, err)
+	}
+	if _, err := mgr.GetAll(ctx, "u1"); !errors.Is(err, ErrInternal) {
+		t.Errorf("Expected ErrInternal for a result of the wrong type, got %v", err)
+	}
+}
+
+func TestManager_Interceptors_TenantScoping(t *testing.T) {
+	ctx := context.Background()
+	// scope runs every call in the tenant named by a "tenant/" prefix of the user ID.
+	scope := func(ctx context.Context, op *Operation, next OperationHandler) (interface{}, error) {
+		if tenantID, _, ok := strings.Cut(op.UserID, "/"); ok {
+			op.TenantID = tenantID
+		}
+		return next(ctx, op)
+	}
+	mgr := newTestManager(t, nil, WithInterceptors(scope))
+	if err := mgr.Tenant("acme").DefinePreference(PreferenceDefinition{Key: "theme", Type: StringType, DefaultValue: "light"}); err != nil {
+		t.Fatalf("DefinePreference failed: %v", err)
+	}
+
+	if err := mgr.Set(ctx, "acme/u1", "theme", "dark"); err != nil {
+		t.Fatalf("Expected Set to run in the acme tenant, got %v", err)
+	}
+	pref, err := mgr.Tenant("acme").Get(ctx, "acme/u1", "theme")
+	if err != nil || pref.Value != "dark" {
+		t.Errorf("Expected the value to be stored in the acme tenant, got %+v (err %v)", pref, err)
+	}
+	if _, err := mgr.Get(ctx, "u1", "theme"); !errors.Is(err, ErrPreferenceNotDefined) {
+		t.Errorf("Expected the default tenant to be used without a prefix, got %v", err)
+	}
+}
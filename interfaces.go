@@ -9,6 +9,12 @@ import (
 // Storage defines the contract for persistent storage and retrieval of user preferences.
 // Implementations are responsible for interacting with the underlying data store (e.g., SQL database, NoSQL database, file system).
 // All methods that accept a context.Context should honor its cancellation and timeout signals.
+// Every operation must be scoped to the tenant returned by TenantFromContext(ctx): preferences of
+// different tenants are independent even when their user IDs and keys are equal. Within a tenant,
+// operations are further scoped to the device returned by DeviceFromContext(ctx): a device's
+// overrides are kept apart from the user's own values, which use the empty device ID.
+// Implementations declare that they do so by implementing ScopedStorage; the Manager refuses
+// operations in another tenant or on a device with backends that do not.
 // Implementations must be thread-safe, allowing for concurrent access from multiple goroutines.
 type Storage interface {
 	// Get retrieves a specific Preference for a given userID and key.
@@ -48,6 +54,175 @@ type Storage interface {
 	Close() error
 }
 
+// ScopedStorage is an optional extension of Storage for backends that scope every operation to
+// the tenant and device in its context, as Storage requires. The Manager checks for it in New:
+// with a backend that does not implement it, or whose ScopesByContext returns false, operations
+// in a tenant other than DefaultTenant (see WithTenant) or on a device (see WithDevice) fail with
+// ErrNotSupported instead of mixing the data of different tenants or devices.
+type ScopedStorage interface {
+	// ScopesByContext reports whether the backend honours TenantFromContext and
+	// DeviceFromContext in every operation.
+	ScopesByContext() bool
+}
+
+// KeyLister is an optional extension of Storage for backends that can enumerate the stored
+// values of a single preference key across all users. The Manager uses it for bulk
+// operations such as MigrateAll; backends that do not implement it cause those operations
+// to return ErrNotSupported.
+type KeyLister interface {
+	// ListByKey returns up to limit preferences of the tenant in ctx stored under key, ordered by UserID and
+	// starting strictly after afterUserID. An empty afterUserID starts from the first user.
+	// A result with fewer than limit entries indicates that the listing is complete.
+	ListByKey(ctx context.Context, key, afterUserID string, limit int) ([]*Preference, error)
+}
+
+// BatchDeleter is an optional extension of Storage for backends that can delete several of
+// a user's preferences in a single round-trip. The Manager uses it for Reset, ResetCategory,
+// and ResetAll; for backends that do not implement it, the keys are deleted one at a time.
+type BatchDeleter interface {
+	// DeleteMany removes the preferences of userID in the tenant in ctx stored under any of keys.
+	// Keys without a stored value are ignored; an error is only returned for underlying
+	// storage issues.
+	DeleteMany(ctx context.Context, userID string, keys []string) error
+}
+
+// UserDeleter is an optional extension of Storage for backends that can delete all of a
+// user's preferences at once. The Manager uses it for DeleteUser; for backends that do not
+// implement it, the user's keys are enumerated with GetAll and deleted individually, on each
+// device listed by DeviceLister.
+type UserDeleter interface {
+	// DeleteAll removes every preference of userID in the tenant in ctx and returns the keys
+	// that were removed. The overrides of every device are removed as well, whatever the device
+	// in ctx. A user without preferences yields an empty slice and a nil error.
+	DeleteAll(ctx context.Context, userID string) ([]string, error)
+}
+
+// DeviceLister is an optional extension of Storage for backends that can enumerate the devices
+// a user has overrides on (see WithDevice). The Manager uses it for DeleteUser on backends that
+// do not implement UserDeleter; ScopedStorage backends that implement neither cause DeleteUser
+// to return ErrNotSupported, as it could not erase the user's device overrides.
+type DeviceLister interface {
+	// ListDevices returns, in sorted order, the IDs of the devices with stored values of userID
+	// in the tenant in ctx, whatever the device in ctx. A user without device overrides yields
+	// an empty slice and a nil error.
+	ListDevices(ctx context.Context, userID string) ([]string, error)
+}
+
+// MultiUserGetter is an optional extension of Storage for backends that can read the same
+// keys of many users in a single query. The Manager uses it for GetForUsers and
+// GetManyForUsers; for backends that do not implement it, each value is read with Get.
+type MultiUserGetter interface {
+	// GetForUsers returns the preferences of the tenant in ctx stored under any of keys for any
+	// of userIDs, keyed by user ID and then by key. Users without any of the keys are absent
+	// from the result; an error is only returned for underlying storage issues.
+	GetForUsers(ctx context.Context, userIDs, keys []string) (map[string]map[string]*Preference, error)
+}
+
+// UserQuery selects users by the stored value of a preference. See UserFinder.
+type UserQuery struct {
+	// Key is the preference key whose stored values are tested.
+	Key string
+	// Predicate is the condition stored values must satisfy. Its operands are in the form
+	// values are stored in: an equality value is a string, bool, int, or float64, and range
+	// bounds are float64 or nil.
+	Predicate Predicate
+	// IncludeMissing also selects users who have no value stored for Key but have other
+	// preferences stored, because the default of Key satisfies Predicate.
+	IncludeMissing bool
+	// AfterUserID restricts the result to user IDs sorting strictly after it. Empty starts at the beginning.
+	AfterUserID string
+	// Limit is the maximum number of user IDs to return.
+	Limit int
+}
+
+// UserFinder is an optional extension of Storage for backends that can search stored values.
+// The Manager's FindUsers requires it.
+type UserFinder interface {
+	// FindUsers returns the IDs of the users of the tenant in ctx selected by query, in
+	// ascending order. Only the users' own values are searched; device overrides are not,
+	// whatever the device in ctx. An error is only returned for underlying storage issues.
+	FindUsers(ctx context.Context, query UserQuery) ([]string, error)
+}
+
+// StatsQuery describes the statistics a StatsProvider computes for a preference key.
+type StatsQuery struct {
+	// Key is the preference key whose stored values are aggregated.
+	Key string
+	// Buckets, when greater than zero, is the number of equal-width buckets numeric values are
+	// grouped into; values that are not numbers are then counted in Values. When zero, every
+	// value is counted in Values.
+	Buckets int
+	// MaxValues is the maximum number of distinct values to return in Values.
+	MaxValues int
+}
+
+// StatsProvider is an optional extension of Storage for backends that can aggregate the
+// stored values of a key. The Manager's Stats requires it.
+type StatsProvider interface {
+	// ValueStats aggregates the stored values of query.Key in the tenant of ctx. It fills in
+	// ExplicitCount, DefaultCount (users with other preferences stored but none for the key),
+	// Values (most frequent first), and Buckets; the Manager fills in the remaining fields.
+	// Only the users' own values are aggregated; device overrides are not.
+	// An error is only returned for underlying storage issues.
+	ValueStats(ctx context.Context, query StatsQuery) (*PreferenceStats, error)
+}
+
+// ChangeTracker is an optional extension of Storage for backends that record deletions and can
+// list what changed after a point in time. The Manager's ChangesSince and ApplyClientChanges
+// require it.
+//
+// Implementations must record a tombstone in Delete and DeleteMany for every value they remove,
+// and drop a user's tombstones in DeleteAll. Tombstones are compared with stored values'
+// UpdatedAt, so both must come from the same clock.
+type ChangeTracker interface {
+	// ChangesSince returns the preferences of userID in the tenant and device of ctx last written
+	// after since, and the tombstones recorded after since for keys that have no stored value.
+	// Tombstones carry the key and DeletedAt. An error is only returned for underlying
+	// storage issues.
+	ChangesSince(ctx context.Context, userID string, since time.Time) ([]*Preference, []Tombstone, error)
+}
+
+// TombstonePruner is an optional extension of ChangeTracker for backends that can remove old
+// tombstones. With a tombstone retention (see WithTombstoneRetention), the Manager prunes
+// tombstones older than the retention periodically and rejects older sync cursors.
+type TombstonePruner interface {
+	// PruneTombstones removes the tombstones of every tenant, device, and user recorded before
+	// before, and returns how many were removed. An error is only returned for underlying
+	// storage issues.
+	PruneTombstones(ctx context.Context, before time.Time) (int64, error)
+}
+
+// DefinitionStore persists preference definitions, so that Manager instances sharing it also
+// share their catalogue. The Manager loads it at startup and writes definitions through to it
+// (see WithDefinitionStore). The bundled Storage backends implement it.
+//
+// Definitions are stored without their function fields (ValidateFunc, NormalizeFunc,
+// Migrations, Transform, DefaultFunc, and MergeFunc), which are kept by the instances that
+// define them.
+// Implementations must be thread-safe.
+type DefinitionStore interface {
+	// SaveDefinition creates or replaces the definition of def.Key in tenantID's catalogue.
+	SaveDefinition(ctx context.Context, tenantID string, def PreferenceDefinition) error
+
+	// DeleteDefinition removes key from tenantID's catalogue. Deleting a key that is not
+	// stored is not an error.
+	DeleteDefinition(ctx context.Context, tenantID, key string) error
+
+	// LoadDefinitions returns the stored definitions of every tenant, keyed by tenant ID and
+	// then by key. DefaultTenant's definitions are stored under the empty tenant ID.
+	LoadDefinitions(ctx context.Context) (map[string]map[string]PreferenceDefinition, error)
+}
+
+// MultiGetter is an optional extension of Cache for backends that can read several entries
+// in a single round-trip. The Manager uses it for GetForUsers and GetManyForUsers; with
+// caches that do not implement it, those methods read from storage only.
+type MultiGetter interface {
+	// GetMany returns the cached items among keys, keyed by cache key. Keys that are not
+	// cached or have expired are absent from the result; an error is only returned for
+	// underlying cache issues.
+	GetMany(ctx context.Context, keys []string) (map[string][]byte, error)
+}
+
 // Cache defines the contract for a caching layer.
 // It is used by the Manager to temporarily store marshalled user preferences
 // for faster retrieval and to reduce load on the primary Storage backend.
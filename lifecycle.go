@@ -0,0 +1,253 @@
+// Package userprefs provides the Manager's lifecycle: background cache warming and Close.
+package userprefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	// defaultWarmWorkers is the number of goroutines that warm the cache unless WithCacheWarming says otherwise.
+	defaultWarmWorkers = 4
+	// defaultWarmQueueSize is the number of preferences that may wait to be cached unless WithCacheWarming says otherwise.
+	defaultWarmQueueSize = 1024
+)
+
+// WithCacheWarming is a functional option that sizes the worker pool GetAll uses to copy the
+// preferences it returns into the cache. At most queueSize preferences wait to be cached;
+// when the queue is full, further preferences are not cached rather than slowing GetAll
+// down, and later reads populate the cache instead. A preference already waiting is not
+// queued twice; the newest value read replaces the queued one.
+// A workers value of 0 or less disables cache warming. Without this option, 4 workers and a
+// queue of 1024 preferences are used. The workers start with the first GetAll and stop on Close.
+// This option is optional.
+func WithCacheWarming(workers, queueSize int) Option {
+	return func(c *Config) {
+		c.warmWorkers = workers
+		c.warmQueueSize = queueSize
+	}
+}
+
+// warmTask is a preference waiting to be written to the cache.
+type warmTask struct {
+	ctx  context.Context
+	pref *Preference
+}
+
+// warmFlight tracks the workers writing one cache key.
+type warmFlight struct {
+	workers int
+	stale   bool // Set by forget: the key was written or deleted while the workers were running.
+}
+
+// cacheWarmer writes preferences to the cache from a bounded pool of workers. Tasks are
+// deduplicated by cache key: the queue holds cache keys, and pending holds the newest
+// task for each of them. inflight holds the keys that workers are writing, so that a
+// write or delete racing with a worker can have the worker's possibly stale value removed.
+type cacheWarmer struct {
+	m         *Manager
+	workers   int
+	queueSize int
+
+	startOnce sync.Once
+	queue     chan string
+	ctx       context.Context // Cancelled when Close gives up waiting for the queue to drain.
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+
+	mu       sync.Mutex
+	pending  map[string]warmTask
+	inflight map[string]*warmFlight
+	closed   bool
+}
+
+// newCacheWarmer creates the cache warmer of m. Its workers are started on first use.
+func newCacheWarmer(m *Manager, workers, queueSize int) *cacheWarmer {
+	if queueSize <= 0 {
+		queueSize = defaultWarmQueueSize
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &cacheWarmer{
+		m:         m,
+		workers:   workers,
+		queueSize: queueSize,
+		queue:     make(chan string, queueSize),
+		ctx:       ctx,
+		cancel:    cancel,
+		pending:   make(map[string]warmTask),
+		inflight:  make(map[string]*warmFlight),
+	}
+}
+
+// enqueue schedules prefs to be written to the cache in the tenant of ctx, and returns how
+// many were dropped because the queue was full. The request's cancellation is dropped, as
+// it usually ends before the cache is written, but its values, such as the tenant and the
+// trace span, are kept.
+func (w *cacheWarmer) enqueue(ctx context.Context, prefs []*Preference) (dropped int) {
+	if w.workers <= 0 {
+		return 0
+	}
+	w.startOnce.Do(w.start)
+	ctx = context.WithoutCancel(ctx)
+	tenantID := TenantFromContext(ctx)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0
+	}
+	for _, pref := range prefs {
+		cacheKey := prefCacheKey(tenantID, pref.UserID, pref.Key)
+		if _, queued := w.pending[cacheKey]; queued {
+			w.pending[cacheKey] = warmTask{ctx: ctx, pref: pref}
+			continue
+		}
+		select {
+		case w.queue <- cacheKey:
+			w.pending[cacheKey] = warmTask{ctx: ctx, pref: pref}
+		default:
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// forget drops a queued task for cacheKey, so that a value read before a write or delete
+// does not overwrite the cache afterwards. A worker already writing cacheKey cannot be
+// stopped, so it is told to remove the entry again once its write has finished.
+func (w *cacheWarmer) forget(cacheKey string) {
+	w.mu.Lock()
+	delete(w.pending, cacheKey)
+	if flight, ok := w.inflight[cacheKey]; ok {
+		flight.stale = true
+	}
+	w.mu.Unlock()
+}
+
+// start launches the workers.
+func (w *cacheWarmer) start() {
+	w.wg.Add(w.workers)
+	for i := 0; i < w.workers; i++ {
+		go w.work()
+	}
+}
+
+// work writes queued preferences to the cache until the queue is closed and drained.
+func (w *cacheWarmer) work() {
+	defer w.wg.Done()
+	for cacheKey := range w.queue {
+		w.mu.Lock()
+		task, ok := w.pending[cacheKey]
+		delete(w.pending, cacheKey)
+		w.mu.Unlock()
+		if !ok || w.ctx.Err() != nil {
+			continue
+		}
+		w.write(cacheKey, task)
+	}
+}
+
+// write caches task's preference under cacheKey. If the preference is written or deleted
+// while the cache write is in flight, the cached entry is removed again afterwards, as it
+// may hold the value read before the change; the next read repopulates it.
+func (w *cacheWarmer) write(cacheKey string, task warmTask) {
+	w.mu.Lock()
+	flight, ok := w.inflight[cacheKey]
+	if !ok {
+		flight = &warmFlight{}
+		w.inflight[cacheKey] = flight
+	}
+	flight.workers++
+	w.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(task.ctx)
+	defer cancel()
+	stop := context.AfterFunc(w.ctx, cancel)
+	defer stop()
+	w.m.setToCache(ctx, task.pref) // setToCache handles logging errors internally
+
+	w.mu.Lock()
+	flight.workers--
+	stale := flight.stale
+	if flight.workers == 0 {
+		delete(w.inflight, cacheKey)
+	}
+	w.mu.Unlock()
+	if stale {
+		w.m.deleteFromCache(ctx, task.pref.UserID, task.pref.Key)
+	}
+}
+
+// close stops accepting tasks and waits for the queued ones to be written. If ctx ends
+// first, the remaining tasks are abandoned, in-flight cache writes are cancelled, and
+// ctx's error is returned once the workers have exited.
+func (w *cacheWarmer) close(ctx context.Context) error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.queue)
+	w.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		w.cancel()
+		return nil
+	case <-ctx.Done():
+		w.cancel()
+		<-done
+		return fmt.Errorf("cache warming did not finish: %w", ctx.Err())
+	}
+}
+
+// Close shuts the Manager down. It stops cache warming, DefinitionStore polling, and tombstone
+// pruning, waits for the preferences already queued to be cached, and then closes the storage
+// backend and the cache. If ctx ends before the queue has drained, the remaining preferences
+// are not cached and Close proceeds to close the backends. The Manager must not be used after Close; calling Close again has no effect.
+//
+// Returns:
+//   - nil: On success.
+//   - An error wrapping ctx.Err(): If ctx ended before cache warming finished.
+//   - A wrapped storage or cache error: If closing a backend fails.
+//
+// Several failures are reported together (see errors.Join).
+//
+// This method is thread-safe.
+func (m *Manager) Close(ctx context.Context) error {
+	var errs []error
+	m.closeOnce.Do(func() {
+		if err := m.warmer.close(ctx); err != nil {
+			errs = append(errs, err)
+		}
+		if m.stopPoll != nil {
+			close(m.stopPoll)
+			<-m.pollDone
+		}
+		if m.stopPrune != nil {
+			close(m.stopPrune)
+			<-m.pruneDone
+		}
+		if m.config.storage != nil {
+			if err := m.config.storage.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("storage.Close failed: %w", err))
+			}
+		}
+		if m.config.cache != nil {
+			if err := m.config.cache.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("cache.Close failed: %w", err))
+			}
+		}
+		m.config.logger.Info("Manager closed")
+	})
+	return errors.Join(errs...)
+}
@@ -0,0 +1,167 @@
+package userprefs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingCache is a MockCache whose Set blocks until its context ends or release is closed.
+type blockingCache struct {
+	*MockCache
+	release chan struct{}
+}
+
+func (c *blockingCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	select {
+	case <-c.release:
+		return c.MockCache.Set(ctx, key, value, ttl)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// gatedCache is a MockCache whose first Set signals entered and then waits for release.
+type gatedCache struct {
+	*MockCache
+	gated   atomic.Bool
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (c *gatedCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if c.gated.CompareAndSwap(false, true) {
+		close(c.entered)
+		<-c.release
+	}
+	return c.MockCache.Set(ctx, key, value, ttl)
+}
+
+// lifecycleDefinitions returns three string preferences, so that GetAll queues three cache fills.
+func lifecycleDefinitions() []PreferenceDefinition {
+	return []PreferenceDefinition{
+		{Key: "theme", Type: StringType, DefaultValue: "default"},
+		{Key: "language", Type: StringType, DefaultValue: "default"},
+		{Key: "timezone", Type: StringType, DefaultValue: "default"},
+	}
+}
+
+func TestManager_Close(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMockCache()
+	storage := NewMockStorage()
+	mgr := newTestManager(t, lifecycleDefinitions(), WithStorage(storage), WithCache(cache))
+
+	if _, err := mgr.GetAll(ctx, "u1"); err != nil {
+		t.Fatalf("GetAll failed: %v", err)
+	}
+	if err := mgr.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	cache.mu.RLock()
+	cached, cacheClosed := len(cache.data), cache.closed
+	cache.mu.RUnlock()
+	if cached != 3 {
+		t.Errorf("Expected Close to wait for the 3 queued preferences to be cached, got %d", cached)
+	}
+	storage.mu.RLock()
+	storageClosed := storage.closed
+	storage.mu.RUnlock()
+	if !cacheClosed || !storageClosed {
+		t.Errorf("Expected the storage and cache to be closed, got storage=%v cache=%v", storageClosed, cacheClosed)
+	}
+
+	if err := mgr.Close(ctx); err != nil {
+		t.Errorf("Expected a second Close to be a no-op, got %v", err)
+	}
+	if dropped := mgr.warmer.enqueue(ctx, []*Preference{{UserID: "u1", Key: "theme"}}); dropped != 0 || len(mgr.warmer.pending) != 0 {
+		t.Errorf("Expected warming to be disabled after Close, got %d dropped and %d pending", dropped, len(mgr.warmer.pending))
+	}
+}
+
+func TestManager_Close_Timeout(t *testing.T) {
+	cache := &blockingCache{MockCache: NewMockCache(), release: make(chan struct{})}
+	mgr := newTestManager(t, lifecycleDefinitions(), WithCache(cache))
+
+	if _, err := mgr.GetAll(context.Background(), "u1"); err != nil {
+		t.Fatalf("GetAll failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := mgr.Close(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected Close to report the deadline, got %v", err)
+	}
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	if len(cache.data) != 0 || !cache.closed {
+		t.Errorf("Expected abandoned warming to write nothing and the cache to be closed, got %d entries (closed=%v)", len(cache.data), cache.closed)
+	}
+}
+
+func TestCacheWarmer_DedupAndBackpressure(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMockCache()
+	mgr := newTestManager(t, lifecycleDefinitions(), WithCache(cache), WithCacheWarming(1, 2))
+	warmer := mgr.warmer
+	warmer.startOnce.Do(func() {}) // Hold the workers back so that the queue fills up.
+
+	prefs := []*Preference{
+		{UserID: "u1", Key: "theme", Value: "old"},
+		{UserID: "u1", Key: "language", Value: "en"},
+		{UserID: "u1", Key: "timezone", Value: "UTC"},
+	}
+	if dropped := warmer.enqueue(ctx, prefs); dropped != 1 {
+		t.Errorf("Expected 1 preference to be dropped by a full queue, got %d", dropped)
+	}
+	newer := []*Preference{{UserID: "u1", Key: "theme", Value: "new"}}
+	if dropped := warmer.enqueue(ctx, newer); dropped != 0 || len(warmer.queue) != 2 {
+		t.Errorf("Expected a queued key to be replaced in place, got %d dropped and %d queued", dropped, len(warmer.queue))
+	}
+	warmer.forget(prefCacheKey(DefaultTenant, "u1", "language"))
+
+	warmer.start()
+	if err := mgr.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	if len(cache.data) != 1 {
+		t.Fatalf("Expected only theme to be cached, got %d entries", len(cache.data))
+	}
+	var pref Preference
+	if err := json.Unmarshal(cache.data["pref:u1:theme"].value, &pref); err != nil || pref.Value != "new" {
+		t.Errorf("Expected the newest queued value to be cached, got %v (err %v)", pref.Value, err)
+	}
+}
+
+func TestCacheWarmer_WriteDuringWarm(t *testing.T) {
+	ctx := context.Background()
+	cache := &gatedCache{MockCache: NewMockCache(), entered: make(chan struct{}), release: make(chan struct{})}
+	mgr := newTestManager(t, lifecycleDefinitions(), WithCache(cache), WithCacheWarming(1, 4))
+
+	// A worker starts caching the value GetAll read, and a Set lands while its cache write is in flight.
+	mgr.warmer.enqueue(ctx, []*Preference{{UserID: "u1", Key: "theme", Value: "old"}})
+	<-cache.entered
+	if err := mgr.Set(ctx, "u1", "theme", "new"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	close(cache.release)
+	if err := mgr.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	cache.mu.RLock()
+	defer cache.mu.RUnlock()
+	if entry, ok := cache.data["pref:u1:theme"]; ok {
+		var pref Preference
+		if err := json.Unmarshal(entry.value, &pref); err != nil || pref.Value != "new" {
+			t.Errorf("Expected the stale warmed value to be dropped, got %v (err %v)", pref.Value, err)
+		}
+	}
+}
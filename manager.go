@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 )
@@ -16,12 +17,20 @@ import (
 // A Manager requires a userprefs.Storage implementation for persistence and can optionally
 // be configured with a userprefs.Cache implementation to improve performance for frequently
 // accessed preferences. All public methods of the Manager are thread-safe and can be
-// called concurrently from multiple goroutines.
+// called concurrently from multiple goroutines. Cross-cutting concerns such as authorization,
+// tracing, and metrics can be attached to its operations with WithInterceptors.
 //
 // Instances of Manager are typically created using the New() function, configured via Options.
 type Manager struct {
-	mu     sync.RWMutex // Protects access to the config, especially definitions map.
-	config *Config      // Holds storage, cache, logger, and preference definitions.
+	mu        sync.RWMutex  // Protects access to the config, especially definitions map.
+	config    *Config       // Holds storage, cache, logger, and preference definitions.
+	warmer    *cacheWarmer  // Writes GetAll results to the cache in the background.
+	closeOnce sync.Once     // Makes Close idempotent.
+	stopPoll  chan struct{} // Closed by Close to stop polling the DefinitionStore; nil without polling.
+	pollDone  chan struct{} // Closed when the DefinitionStore poller has stopped.
+	stopPrune chan struct{} // Closed by Close to stop pruning tombstones; nil without pruning.
+	pruneDone chan struct{} // Closed when the tombstone pruner has stopped.
+	scoped    bool          // Whether the storage backend implements ScopedStorage.
 }
 
 // New creates and initializes a new Manager instance using functional options.
@@ -40,20 +49,45 @@ type Manager struct {
 //	    userprefs.WithLogger(logger),
 //	)
 //
-// The returned Manager is ready for use.
+// The returned Manager is ready for use. With WithDefinitionStore, it has loaded the stored
+// definitions. Call Close when it is no longer needed, to stop its background cache warming
+// and definition polling, stop pruning tombstones, and close the storage and cache.
 func New(opts ...Option) *Manager {
 	cfg := &Config{
-		logger:      NewDefaultLogger(), // Use exported version
-		definitions: make(map[string]PreferenceDefinition),
+		logger:             NewDefaultLogger(), // Use exported version
+		definitions:        make(map[string]PreferenceDefinition),
+		warmWorkers:        defaultWarmWorkers,
+		warmQueueSize:      defaultWarmQueueSize,
+		defaultFuncTTL:     defaultFuncCacheTTL,
+		tombstoneRetention: defaultTombstoneRetention,
 	}
 
 	for _, opt := range opts {
 		opt(cfg)
 	}
 
-	return &Manager{
+	m := &Manager{
 		config: cfg,
 	}
+	if scoped, ok := cfg.storage.(ScopedStorage); ok {
+		m.scoped = scoped.ScopesByContext()
+	}
+	m.warmer = newCacheWarmer(m, cfg.warmWorkers, cfg.warmQueueSize)
+	if cfg.definitionStore != nil {
+		// A failure is logged; the Manager starts with an empty catalogue and polling retries.
+		_ = m.ReloadDefinitions(context.Background())
+		if cfg.definitionPollInterval > 0 {
+			m.stopPoll = make(chan struct{})
+			m.pollDone = make(chan struct{})
+			go m.pollDefinitions(cfg.definitionPollInterval, m.stopPoll, m.pollDone)
+		}
+	}
+	if pruner, ok := cfg.storage.(TombstonePruner); ok && cfg.tombstoneRetention > 0 {
+		m.stopPrune = make(chan struct{})
+		m.pruneDone = make(chan struct{})
+		go m.pruneTombstones(pruner, cfg.tombstoneRetention, m.stopPrune, m.pruneDone)
+	}
+	return m
 }
 
 // DefinePreference registers a new preference definition with the Manager.
@@ -67,32 +101,207 @@ func New(opts ...Option) *Manager {
 //   - Category: An optional string for grouping preferences.
 //   - Encrypted: Whether the preference value should be encrypted at rest.
 //   - ValidateFunc: An optional function for custom value validation during Set operations.
+//   - NormalizeFunc and CoerceStrings: Optional input normalization applied before validation.
+//     AllowedValues are normalized the same way when the definition is registered.
+//   - Version and Migrations: The current value schema version and the steps that upgrade older stored values.
+//   - DefaultRules: Optional percentage rollout and attribute targeting rules for choosing defaults.
+//   - DefaultFunc: An optional function computing the default of each user, e.g. from a profile service.
+//   - PerDevice: Whether each of a user's devices may override the user's value (see WithDevice).
+//   - DependsOn: An optional parent preference that must be on for this preference to be writable.
+//   - ReadOnly, WritableBy, and Hidden: Optional restrictions on which actors may change or see the preference.
+//   - Label, Description, DisplayOrder, Widget, ValueLabels, and Translations: Optional metadata for
+//     rendering the preference on a settings screen.
 //
 // Returns:
 //   - ErrInvalidKey: if def.Key is empty.
-//   - ErrInvalidType: if def.Type is not a supported PreferenceType (see IsValidType).
+//   - ErrInvalidType: if def.Type is neither a built-in type nor registered with RegisterType.
 //   - ErrEncryptionRequired: if def.Encrypted is true but no encryption manager is configured.
+//   - ErrInvalidInput: if def.Version is negative, the Migrations chain is inconsistent with it,
+//     DefaultRules are malformed, or DependsOn refers to the preference itself, uses an unknown
+//     mode, or closes a dependency cycle, an EnumType definition has no AllowedValues, or
+//     the declarative constraints (Min/Max, MinLength/MaxLength, Pattern, MaxItems, Schema) are malformed,
+//     or an AllowedValues entry cannot be normalized, or WritableBy lists an unknown role, or the
+//     presentation metadata uses an unknown Widget, an empty or malformed translation language tag,
+//     or labels a value that is not in AllowedValues.
+//   - A wrapped store error: if a DefinitionStore is configured (see WithDefinitionStore) and
+//     saving the definition to it fails. The definition is then not registered.
 //   - nil: on successful registration of the preference definition.
 //
+// Definitions registered here belong to the DefaultTenant; use Manager.Tenant to register
+// definitions for another tenant.
+//
 // This method is thread-safe.
 func (m *Manager) DefinePreference(def PreferenceDefinition) error {
+	return m.definePreference(DefaultTenant, def)
+}
+
+// definePreference runs def through the interceptors and registers it in tenantID's catalogue.
+func (m *Manager) definePreference(tenantID string, def PreferenceDefinition) error {
+	op := &Operation{Kind: OpDefinePreference, Definition: &def}
+	_, err := m.intercept(WithTenant(context.Background(), tenantID), op, func(ctx context.Context, op *Operation) (interface{}, error) {
+		if op.Definition == nil {
+			return nil, ErrInvalidInput
+		}
+		return nil, m.registerDefinition(ctx, op.TenantID, *op.Definition)
+	})
+	return err
+}
+
+// registerDefinition validates def and registers it in tenantID's catalogue, saving it to
+// the DefinitionStore first if one is configured.
+func (m *Manager) registerDefinition(ctx context.Context, tenantID string, def PreferenceDefinition) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	def, err := m.prepareDefinition(def)
+	if err == nil {
+		err = validateDependency(m.definitionsFor(tenantID), def)
+	}
+	if err != nil {
+		return err
+	}
+	if err := m.saveDefinition(ctx, tenantID, def); err != nil {
+		return err
+	}
+	m.putDefinition(tenantID, def)
+	return nil
+}
+
+// prepareDefinition validates def on its own and returns it with its AllowedValues and
+// DefaultRules values normalized. Dependency cycles depend on the rest of the catalogue and
+// are checked separately by validateDependency. m.mu must be held.
+func (m *Manager) prepareDefinition(def PreferenceDefinition) (PreferenceDefinition, error) {
 	if def.Key == "" {
-		return ErrInvalidKey
+		return def, ErrInvalidKey
 	}
 
 	if !isValidType(def.Type) {
-		return ErrInvalidType
+		return def, ErrInvalidType
+	}
+
+	if def.Type == EnumType && len(def.AllowedValues) == 0 {
+		return def, fmt.Errorf("%w: enum preference '%s' requires AllowedValues", ErrInvalidInput, def.Key)
 	}
 
 	// Validate encryption requirements
 	if def.Encrypted && m.config.encryptionManager == nil {
-		return fmt.Errorf("%w: preference '%s' is marked as encrypted but no encryption manager is configured", ErrEncryptionRequired, def.Key)
+		return def, fmt.Errorf("%w: preference '%s' is marked as encrypted but no encryption manager is configured", ErrEncryptionRequired, def.Key)
+	}
+
+	if err := validateMigrations(def); err != nil {
+		return def, err
+	}
+
+	if err := validateDefaultRules(def); err != nil {
+		return def, err
+	}
+
+	if err := validateDependencyKey(def); err != nil {
+		return def, err
+	}
+
+	if err := validateConstraints(def); err != nil {
+		return def, err
+	}
+
+	if err := validateAccess(def); err != nil {
+		return def, err
+	}
+
+	if err := validateDeprecation(def); err != nil {
+		return def, err
+	}
+
+	if err := validateMerge(def); err != nil {
+		return def, err
+	}
+
+	allowed, err := normalizeAllowedValues(def)
+	if err != nil {
+		return def, err
+	}
+	def.AllowedValues = allowed
+
+	rules, err := normalizeDefaultRules(def)
+	if err != nil {
+		return def, err
+	}
+	def.DefaultRules = rules
+
+	if err := validatePresentation(def); err != nil {
+		return def, err
+	}
+	return def, nil
+}
+
+// putDefinition adds def to tenantID's catalogue. m.mu must be held.
+func (m *Manager) putDefinition(tenantID string, def PreferenceDefinition) {
+	if tenantID == DefaultTenant {
+		m.config.definitions[def.Key] = def
+		return
+	}
+	if m.config.tenantDefinitions == nil {
+		m.config.tenantDefinitions = make(map[string]map[string]PreferenceDefinition)
+	}
+	if m.config.tenantDefinitions[tenantID] == nil {
+		m.config.tenantDefinitions[tenantID] = make(map[string]PreferenceDefinition)
+	}
+	m.config.tenantDefinitions[tenantID][def.Key] = def
+}
+
+// DeleteDefinition removes the definition of key from the DefaultTenant's catalogue, and from
+// the DefinitionStore if one is configured. Use Manager.Tenant(tenantID).DeleteDefinition for
+// other tenants. Stored values of the key are kept, and become visible again if the key is
+// defined anew; use RenameKey or DeleteUser to remove them.
+//
+// Returns:
+//   - nil: On success.
+//   - ErrInvalidKey: If key is empty.
+//   - ErrPreferenceNotDefined: If the key is not defined.
+//   - ErrInvalidInput: If another definition depends on the key through DependsOn or ReplacedBy.
+//   - A wrapped store error: If deleting the definition from the DefinitionStore fails. The
+//     definition is then kept.
+//
+// This method is thread-safe.
+func (m *Manager) DeleteDefinition(key string) error {
+	return m.deleteDefinition(DefaultTenant, key)
+}
+
+// deleteDefinition runs the removal of key from tenantID's catalogue through the interceptors.
+func (m *Manager) deleteDefinition(tenantID, key string) error {
+	op := &Operation{Kind: OpDeleteDefinition, Key: key}
+	_, err := m.intercept(WithTenant(context.Background(), tenantID), op, func(ctx context.Context, op *Operation) (interface{}, error) {
+		return nil, m.unregisterDefinition(ctx, op.TenantID, op.Key)
+	})
+	return err
+}
+
+// unregisterDefinition removes key from tenantID's catalogue and the DefinitionStore.
+func (m *Manager) unregisterDefinition(ctx context.Context, tenantID, key string) error {
+	if key == "" {
+		return ErrInvalidKey
 	}
 
-	m.config.definitions[def.Key] = def
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	definitions := m.definitionsFor(tenantID)
+	if _, exists := definitions[key]; !exists {
+		return fmt.Errorf("%w: '%s'", ErrPreferenceNotDefined, key)
+	}
+	for _, def := range definitions {
+		if (def.DependsOn != nil && def.DependsOn.Key == key) || def.ReplacedBy == key {
+			return fmt.Errorf("%w: preference '%s' is still referenced by '%s'", ErrInvalidInput, key, def.Key)
+		}
+	}
+
+	if store := m.config.definitionStore; store != nil {
+		if err := store.DeleteDefinition(ctx, tenantID, key); err != nil {
+			m.config.logger.Error("DefinitionStore DeleteDefinition failed", "tenantID", tenantID, "key", key, "error", err)
+			return fmt.Errorf("definitionStore.DeleteDefinition failed for key '%s': %w", key, err)
+		}
+	}
+	delete(definitions, key)
 	return nil
 }
 
@@ -112,36 +321,103 @@ func (m *Manager) DefinePreference(def PreferenceDefinition) error {
 //     d. On cache miss (or 'not found' error), proceeds to storage lookup.
 //  4. Storage Lookup (if no cache, or cache miss):
 //     a. Fetches the preference from the storage backend.
-//     b. If found in storage: The retrieved preference value is decrypted if needed and returned.
+//     b. If found in storage: The retrieved preference value is decrypted if needed, upgraded through the
+//     definition's Migrations if it was written with an older Version, and returned.
 //     If a cache is configured, the preference is asynchronously stored in the cache for future requests.
-//     c. If storage returns ErrNotFound: A Preference struct populated with the *defined default value*
-//     is returned with a nil error (indicating successful application of default).
+//     c. If storage returns ErrNotFound: If a deprecated definition names this key in ReplacedBy and the
+//     user has a value stored under the deprecated key, that value (converted by its Transform) is
+//     returned, and moved to this key if the deprecated definition sets MigrateOnRead. Otherwise, a
+//     Preference struct populated with the *defined default value* is returned with a nil error (indicating successful application of default). When the definition
+//     has DefaultRules, the first applicable rule chooses the default and is reported in AppliedRule;
+//     otherwise a DefaultFunc, if set, computes it.
 //     d. If storage returns any other error: That error is wrapped and returned.
+//  5. Dependencies: If the definition has DependsOn and its parent is off, the returned Preference
+//     is marked Disabled (regardless of the dependency Mode).
+//
+// If the definition is PerDevice and ctx carries a device (see WithDevice), the device's override
+// is read from storage first and returned with its DeviceID set; the steps above only run for
+// devices without an override.
 //
 // Returns:
 //   - (*Preference, nil): On successful retrieval (from cache or storage) or when a defined default value is applied.
 //   - (nil, ErrInvalidInput): If userID or key is empty.
 //   - (nil, ErrPreferenceNotDefined): If the preference key has not been defined.
 //   - (nil, ErrEncryptionFailed): If decryption is required but fails.
+//   - (nil, ErrMigrationFailed): If a value written with an older definition Version cannot be upgraded.
 //   - (*Preference with default, wrapped cache error): If cache fails and a default is applied.
 //   - (nil, wrapped storage error): If storage fails and a default cannot be applied or is not applicable.
 //
 // This method is thread-safe.
 func (m *Manager) Get(ctx context.Context, userID, key string) (*Preference, error) {
+	op := &Operation{Kind: OpGet, UserID: userID, Key: key}
+	result, err := m.intercept(ctx, op, func(ctx context.Context, op *Operation) (interface{}, error) {
+		return m.getPreference(ctx, op.UserID, op.Key)
+	})
+	return interceptResult[*Preference](op, result, err)
+}
+
+// getPreference implements Get without interceptors.
+func (m *Manager) getPreference(ctx context.Context, userID, key string) (*Preference, error) {
+	pref, err := m.get(ctx, userID, key)
+	if pref == nil {
+		return nil, err
+	}
+
+	def, _ := m.contextDefinition(ctx, key)
+	if def.DependsOn != nil {
+		active, depErr := m.dependencyActive(ctx, userID, def, nil, make(map[string]bool))
+		if depErr != nil {
+			return nil, depErr
+		}
+		if !active {
+			// Copy so that a Preference shared with the cache path is never mutated.
+			disabled := *pref
+			disabled.Disabled = true
+			pref = &disabled
+		}
+	}
+	return pref, err
+}
+
+// get implements Get without applying DependsOn to the result.
+func (m *Manager) get(ctx context.Context, userID, key string) (*Preference, error) {
 	if userID == "" || key == "" {
 		return nil, ErrInvalidInput
 	}
 
-	def, exists := m.GetDefinition(key)
+	def, exists := m.contextDefinition(ctx, key)
 	if !exists {
 		return nil, ErrPreferenceNotDefined
 	}
 
+	if deviceID := DeviceFromContext(ctx); deviceID != "" {
+		if def.PerDevice {
+			override, err := m.getDeviceOverride(ctx, userID, deviceID, def)
+			if override != nil || err != nil {
+				return override, err
+			}
+		}
+		// Without an override, the user's own value applies.
+		ctx = withoutDevice(ctx)
+	}
+
 	if m.config.cache != nil {
 		prefFromCache, cacheErr := m.getFromCache(ctx, userID, key)
 		if cacheErr == nil { // Cache hit, no error
 			// Cached values are already decrypted for performance, so return directly
 			m.config.logger.Debug("Cache hit", "userID", userID, "key", key)
+			// Entries cached before the definition's version was bumped still need upgrading.
+			if err := migrateValue(prefFromCache, def); err != nil {
+				m.config.logger.Error("Failed to migrate cached value", "userID", userID, "key", key, "error", err)
+				return nil, err
+			}
+			// Rich types lose their Go representation in the cache's JSON encoding.
+			decoded, err := decodeValue(prefFromCache.Value, def)
+			if err != nil {
+				m.config.logger.Error("Failed to decode cached value", "userID", userID, "key", key, "error", err)
+				return nil, err
+			}
+			prefFromCache.Value = decoded
 			return prefFromCache, nil
 		}
 
@@ -153,15 +429,7 @@ func (m *Manager) Get(ctx context.Context, userID, key string) (*Preference, err
 		// In this case, return the default value along with this cache error.
 		if !errors.Is(cacheErr, ErrNotFound) {
 			m.config.logger.Error("Cache error is not ErrNotFound, returning default and propagating cache error", "userID", userID, "key", key, "originalError", cacheErr)
-			return &Preference{
-				UserID:       userID,
-				Key:          key,
-				Value:        def.DefaultValue,
-				DefaultValue: def.DefaultValue,
-				Type:         def.Type,
-				Category:     def.Category,
-				UpdatedAt:    time.Now(), // Consider if a zero time or definition's timestamp is more appropriate
-			}, cacheErr // Propagate the actual cache error
+			return m.defaultPreference(ctx, userID, def), cacheErr // Propagate the actual cache error
 		}
 		// If errors.Is(cacheErr, ErrNotFound), it was a clean cache miss. Proceed to storage.
 		m.config.logger.Debug("Cache miss (ErrNotFound from cache layer), proceeding to storage", "userID", userID, "key", key)
@@ -169,32 +437,42 @@ func (m *Manager) Get(ctx context.Context, userID, key string) (*Preference, err
 
 	// Fallback to storage if cache is nil or if getFromCache resulted in ErrNotFound.
 	m.config.logger.Debug("Fetching from storage", "userID", userID, "key", key)
-	pref, err := m.config.storage.Get(ctx, userID, key)
+	pref, err := m.storageGet(ctx, userID, key)
 	if err != nil {
 		if errors.Is(err, ErrNotFound) { // Use errors.Is for checking predefined errors
+			// Fall back to a value stored under a key this one replaces
+			replaced, err := m.readReplaced(ctx, userID, def)
+			if replaced != nil || err != nil {
+				return replaced, err
+			}
 			// If not found in storage, return the preference with its default value
-			return &Preference{
-				UserID:       userID,
-				Key:          key,
-				Value:        def.DefaultValue,
-				DefaultValue: def.DefaultValue, // Ensure DefaultValue is also populated here
-				Type:         def.Type,
-				Category:     def.Category,
-				UpdatedAt:    time.Now(), // Or perhaps a zero time if it's purely a default?
-			}, nil
+			return m.defaultPreference(ctx, userID, def), nil
 		}
 		m.config.logger.Error("Storage Get failed", "userID", userID, "key", key, "error", err)
 		return nil, fmt.Errorf("storage.Get failed for key '%s': %w", key, err)
 	}
 
 	// Decrypt value if needed
-	decryptedValue, err := m.decryptValue(pref.Value, def)
+	decryptedValue, err := m.decryptValue(ctx, pref.Value, def)
 	if err != nil {
 		m.config.logger.Error("Failed to decrypt stored value", "userID", userID, "key", key, "error", err)
 		return nil, err
 	}
 	pref.Value = decryptedValue
 
+	// Upgrade values written with an older definition version
+	if err := migrateValue(pref, def); err != nil {
+		m.config.logger.Error("Failed to migrate stored value", "userID", userID, "key", key, "error", err)
+		return nil, err
+	}
+
+	decodedValue, err := decodeValue(pref.Value, def)
+	if err != nil {
+		m.config.logger.Error("Failed to decode stored value", "userID", userID, "key", key, "error", err)
+		return nil, err
+	}
+	pref.Value = decodedValue
+
 	if m.config.cache != nil {
 		m.setToCache(ctx, pref)
 	}
@@ -208,49 +486,223 @@ func (m *Manager) Get(ctx context.Context, userID, key string) (*Preference, err
 // Operational Flow:
 //  1. Input Validation: Checks userID and key. Returns ErrInvalidInput if empty.
 //  2. Definition Check: Verifies key is defined. Returns ErrPreferenceNotDefined if not.
-//  3. Type Validation: Ensures the provided `value` matches the `Type` in the PreferenceDefinition.
+//     Deprecation: Writes to a key whose definition has ReplacedBy are redirected to the replacement
+//     key, with the value converted by the definition's Transform; writes to other Deprecated keys
+//     log a warning. The remaining steps apply to the key actually written.
+//     Access Check: Returns ErrForbidden if the actor in the context (see WithActor) may not change
+//     the preference because of its ReadOnly or WritableBy settings.
+//  3. Normalization: Converts the value losslessly to the canonical Go type of the preference
+//     (int for IntType, float64 for FloatType, e.g. a JSON-decoded 5.0 becomes 5), parses string forms
+//     if CoerceStrings is set, and applies NormalizeFunc.
+//     Type Validation: Ensures the normalized value matches the `Type` in the PreferenceDefinition.
 //     Returns ErrInvalidValue if type mismatch (e.g., providing a string for an Int preference).
+//     Declarative constraints are then checked; violations are reported as a *ValidationError.
 //  4. Custom Validation: If `ValidateFunc` is set in PreferenceDefinition, it's called. Returns ErrInvalidValue
 //     if this custom validation fails.
-//  5. Encryption: If the preference is marked as encrypted, the value is encrypted before storage.
-//  6. Storage Operation: Saves the preference (UserID, Key, Value, Type, Category, DefaultValue from definition,
+//  5. Cross-key Validation: If the key or a preference depending on it has DependsOn, or any
+//     CrossFieldValidator is registered, the write is checked against the user's current preferences.
+//     Returns ErrInvalidValue if the key's parent is off, a Required dependent would be left empty,
+//     or a CrossFieldValidator rejects the change.
+//  6. Encryption: If the preference is marked as encrypted, the value is encrypted before storage.
+//  7. Storage Operation: Saves the preference (UserID, Key, Value, Type, Category, DefaultValue from definition,
 //     and current UpdatedAt) to the storage backend.
-//  7. Cache Invalidation (if cache is configured): Deletes the corresponding entry from the cache
+//  8. Cache Invalidation (if cache is configured): Deletes the corresponding entry from the cache
 //     to maintain consistency. Subsequent Get calls will fetch from storage and repopulate cache.
+//  9. Change Notification: Registered ChangeListeners (see WithChangeListener) receive a ChangeSet event.
+//
+// If the definition is PerDevice and ctx carries a device (see WithDevice), the value is stored
+// as the device's override and the user's own value is left unchanged.
 //
 // Returns:
 //   - nil: On successful creation or update.
 //   - ErrInvalidInput: If userID or key is empty.
 //   - ErrPreferenceNotDefined: If the preference key has not been defined.
-//   - ErrInvalidValue: If the provided value fails type validation or custom validation.
+//   - ErrForbidden: If the actor in the context may not change the preference.
+//   - ErrInvalidValue: If the provided value fails type, custom, dependency, or cross-field validation.
+//     The error is a *ValidationError, or ValidationErrors when several rules failed; use errors.As
+//     to find which rule failed.
 //   - ErrEncryptionFailed: If encryption is required but fails.
 //   - A wrapped storage error: If the storage operation fails.
 //
 // This method is thread-safe.
 func (m *Manager) Set(ctx context.Context, userID, key string, value interface{}) error {
+	op := &Operation{Kind: OpSet, UserID: userID, Key: key, Value: value}
+	_, err := m.intercept(ctx, op, func(ctx context.Context, op *Operation) (interface{}, error) {
+		return nil, m.set(ctx, op.UserID, op.Key, op.Value)
+	})
+	return err
+}
+
+// set implements Set without interceptors.
+func (m *Manager) set(ctx context.Context, userID, key string, value interface{}) error {
 	if userID == "" || key == "" {
 		return ErrInvalidInput
 	}
 
-	def, exists := m.GetDefinition(key)
+	def, exists := m.contextDefinition(ctx, key)
 	if !exists {
 		return ErrPreferenceNotDefined
 	}
 
+	def, value, err := m.redirectWrite(ctx, def, value)
+	if err != nil {
+		return err
+	}
+
+	if err := checkWriteAccess(ctx, def); err != nil {
+		return err
+	}
+
+	value, err = checkValue(value, def)
+	if err != nil {
+		return err
+	}
+
+	if err := m.validateWrite(ctx, userID, map[string]interface{}{def.Key: value}); err != nil {
+		if verrs, ok := err.(ValidationErrors); ok {
+			return verrs.asError()
+		}
+		return err
+	}
+
+	if err := m.write(ctx, userID, def, value); err != nil {
+		return err
+	}
+	m.notifyChange(ctx, userID, def, ChangeSet, value)
+	return nil
+}
+
+// SetMany creates or updates several of a user's preferences as one logical write.
+// Every value is validated as in Set, and dependency and cross-field validation see all
+// of the changes at once, so related keys (e.g. a toggle and the setting it enables) can
+// be changed together. Nothing is written unless every check passes, and every failure
+// across all keys is reported together as ValidationErrors. Values are then
+// written one key at a time in key order; a storage failure part-way through leaves the
+// earlier keys written.
+//
+// Returns:
+//   - nil: On success.
+//   - ErrInvalidInput: If userID is empty or values is empty, or if a key is given both directly and
+//     through a deprecated key redirected to it.
+//   - ErrPreferenceNotDefined: If any key has not been defined.
+//   - ErrForbidden: If the actor in the context may not change any one of the keys.
+//   - ValidationErrors (matching ErrInvalidValue): If any value fails type, custom, dependency,
+//     or cross-field validation.
+//   - ErrEncryptionFailed: If encryption is required but fails.
+//   - A wrapped storage error: If a storage operation fails.
+//
+// This method is thread-safe.
+func (m *Manager) SetMany(ctx context.Context, userID string, values map[string]interface{}) error {
+	op := &Operation{Kind: OpSetMany, UserID: userID, Values: values}
+	_, err := m.intercept(ctx, op, func(ctx context.Context, op *Operation) (interface{}, error) {
+		return nil, m.setMany(ctx, op.UserID, op.Values)
+	})
+	return err
+}
+
+// setMany implements SetMany without interceptors.
+func (m *Manager) setMany(ctx context.Context, userID string, values map[string]interface{}) error {
+	if userID == "" || len(values) == 0 {
+		return ErrInvalidInput
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	defs := make(map[string]PreferenceDefinition, len(keys))
+	normalized := make(map[string]interface{}, len(keys))
+	var verrs ValidationErrors
+	for _, key := range keys {
+		if key == "" {
+			return ErrInvalidInput
+		}
+		def, exists := m.contextDefinition(ctx, key)
+		if !exists {
+			return fmt.Errorf("%w: '%s'", ErrPreferenceNotDefined, key)
+		}
+		def, value, err := m.redirectWrite(ctx, def, values[key])
+		if err == nil {
+			_, seen := defs[def.Key]
+			if _, direct := values[def.Key]; seen || (direct && def.Key != key) {
+				return fmt.Errorf("%w: '%s' is set more than once through a deprecated key", ErrInvalidInput, def.Key)
+			}
+			if err := checkWriteAccess(ctx, def); err != nil {
+				return err
+			}
+			value, err = checkValue(value, def)
+		}
+		if err != nil {
+			keyErrs, ok := toValidationErrors(err, def.Key, "", "")
+			if !ok {
+				return err
+			}
+			verrs = append(verrs, keyErrs...)
+		}
+		defs[def.Key] = def
+		normalized[def.Key] = value
+	}
+	if len(verrs) > 0 {
+		return verrs
+	}
+
+	if err := m.validateWrite(ctx, userID, normalized); err != nil {
+		return err
+	}
+
+	// Writes to deprecated keys may have been redirected, so order by the keys actually written.
+	keys = keys[:0]
+	for key := range defs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := m.write(ctx, userID, defs[key], normalized[key]); err != nil {
+			return err
+		}
+		m.notifyChange(ctx, userID, defs[key], ChangeSet, normalized[key])
+	}
+	return nil
+}
+
+// checkValue normalizes value for def and runs the per-value type, constraint, AllowedValues,
+// and ValidateFunc checks. It returns the normalized value to be stored.
+func checkValue(value interface{}, def PreferenceDefinition) (interface{}, error) {
+	value, err := normalizeValue(value, def)
+	if err != nil {
+		return nil, err
+	}
+
 	if err := validateValue(value, def); err != nil {
-		return err // This already returns ErrInvalidValue if types mismatch or value not in AllowedValues
+		return nil, err // This already returns a *ValidationError or ValidationErrors matching ErrInvalidValue
 	}
 
 	// Custom validation function, if defined
 	if def.ValidateFunc != nil {
 		if err := def.ValidateFunc(value); err != nil {
-			// Wrap the error from ValidateFunc to indicate it's a validation failure
-			return fmt.Errorf("%w: custom validation failed: %v", ErrInvalidValue, err)
+			// Keep structured errors from the function; wrap anything else as a custom rule failure.
+			verrs, _ := toValidationErrors(err, def.Key, RuleCustom, actualType(value))
+			return nil, verrs.asError()
 		}
 	}
+	return value, nil
+}
+
+// write encrypts and persists an already validated value and refreshes the cache.
+func (m *Manager) write(ctx context.Context, userID string, def PreferenceDefinition, value interface{}) error {
+	key := def.Key
+	ctx, deviceID := deviceScope(ctx, def)
+
+	// Store rich types in their canonical form
+	value, err := encodeValue(value, def)
+	if err != nil {
+		return err
+	}
 
 	// Encrypt value if required
-	storageValue, err := m.encryptValue(value, def)
+	storageValue, err := m.encryptValue(ctx, value, def)
 	if err != nil {
 		return err
 	}
@@ -263,14 +715,17 @@ func (m *Manager) Set(ctx context.Context, userID, key string, value interface{}
 		Type:         def.Type,
 		Category:     def.Category,
 		UpdatedAt:    time.Now(),
+		Version:      def.Version,
+		DeviceID:     deviceID,
 	}
 
-	if err := m.config.storage.Set(ctx, pref); err != nil {
+	if err := m.storageSet(ctx, pref); err != nil {
 		m.config.logger.Error("Storage Set failed", "userID", userID, "key", key, "error", err)
 		return fmt.Errorf("storage.Set failed for key '%s': %w", key, err)
 	}
 
-	if m.config.cache != nil {
+	// Device overrides are not cached (see getDeviceOverride).
+	if m.config.cache != nil && deviceID == "" {
 		// Cache the preference with the original (decrypted) value for better performance
 		cachedPref := &Preference{
 			UserID:       userID,
@@ -280,7 +735,9 @@ func (m *Manager) Set(ctx context.Context, userID, key string, value interface{}
 			Type:         def.Type,
 			Category:     def.Category,
 			UpdatedAt:    pref.UpdatedAt,
+			Version:      pref.Version,
 		}
+		m.warmer.forget(prefCacheKey(TenantFromContext(ctx), userID, key))
 		m.setToCache(ctx, cachedPref)
 	}
 
@@ -295,7 +752,13 @@ func (m *Manager) Set(ctx context.Context, userID, key string, value interface{}
 //   - Fetches preferences directly from the storage backend. This method *does not* currently
 //     utilize or interact with the cache.
 //   - For each preference retrieved from storage, it ensures the DefaultValue from its
-//     definition is populated in the returned Preference struct and decrypts values if needed.
+//     definition is populated in the returned Preference struct, decrypts values if needed, and
+//     migrates values written with an older definition Version.
+//   - Preferences whose DependsOn parent is off are omitted when the dependency Mode is
+//     DependencyHide, and marked Disabled otherwise.
+//   - Hidden preferences are omitted for ActorUser actors if the Manager was created WithHiddenFiltering.
+//   - With a device in ctx (see WithDevice), the device's overrides of PerDevice preferences in the
+//     category replace or complement the user's own values.
 //
 // Returns:
 //   - (map[string]*Preference, nil): A map of preference keys to Preference structs on success.
@@ -306,11 +769,21 @@ func (m *Manager) Set(ctx context.Context, userID, key string, value interface{}
 //
 // This method is thread-safe.
 func (m *Manager) GetByCategory(ctx context.Context, userID, category string) (map[string]*Preference, error) {
+	op := &Operation{Kind: OpGetByCategory, UserID: userID, Category: category}
+	result, err := m.intercept(ctx, op, func(ctx context.Context, op *Operation) (interface{}, error) {
+		return m.getByCategory(ctx, op.UserID, op.Category)
+	})
+	return interceptResult[map[string]*Preference](op, result, err)
+}
+
+// getByCategory implements GetByCategory without interceptors.
+func (m *Manager) getByCategory(ctx context.Context, userID, category string) (map[string]*Preference, error) {
 	if userID == "" || category == "" {
 		return nil, ErrInvalidInput
 	}
 
-	prefs, err := m.config.storage.GetByCategory(ctx, userID, category)
+	deviceCtx, ctx := ctx, withoutDevice(ctx)
+	prefs, err := m.storageGetByCategory(ctx, userID, category)
 	if err != nil {
 		m.config.logger.Error("Storage GetByCategory failed", "userID", userID, "category", category, "error", err)
 		return nil, fmt.Errorf("storage.GetByCategory failed for category '%s': %w", category, err)
@@ -318,26 +791,50 @@ func (m *Manager) GetByCategory(ctx context.Context, userID, category string) (m
 
 	// Decrypt values for preferences that are marked as encrypted
 	for key, pref := range prefs {
-		def, exists := m.GetDefinition(key)
+		def, exists := m.contextDefinition(ctx, key)
 		if !exists {
 			m.config.logger.Warn("Found preference without definition", "userID", userID, "key", key)
 			continue
 		}
 
 		// Decrypt value if needed
-		decryptedValue, err := m.decryptValue(pref.Value, def)
+		decryptedValue, err := m.decryptValue(ctx, pref.Value, def)
 		if err != nil {
 			m.config.logger.Error("Failed to decrypt preference value", "userID", userID, "key", key, "error", err)
 			return nil, err
 		}
 		pref.Value = decryptedValue
 
+		if err := migrateValue(pref, def); err != nil {
+			m.config.logger.Error("Failed to migrate preference value", "userID", userID, "key", key, "error", err)
+			return nil, err
+		}
+
+		decodedValue, err := decodeValue(pref.Value, def)
+		if err != nil {
+			m.config.logger.Error("Failed to decode preference value", "userID", userID, "key", key, "error", err)
+			return nil, err
+		}
+		pref.Value = decodedValue
+
 		// Ensure definition data is reflected
 		pref.DefaultValue = def.DefaultValue
 		pref.Type = def.Type
 		pref.Category = def.Category
 	}
 
+	err = m.applyDeviceOverrides(deviceCtx, userID, prefs, func(ctx context.Context) (map[string]*Preference, error) {
+		return m.storageGetByCategory(ctx, userID, category)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.applyDependencies(deviceCtx, userID, prefs); err != nil {
+		return nil, err
+	}
+	m.omitHidden(ctx, prefs)
+
 	return prefs, nil
 }
 
@@ -351,28 +848,61 @@ func (m *Manager) GetByCategory(ctx context.Context, userID, category string) (m
 //  4. For each defined preference:
 //     a. If a corresponding preference is found in the storage results, that preference is used.
 //     Its DefaultValue, Type, and Category are updated from the definition to ensure consistency.
-//     The value is decrypted if the preference is marked as encrypted.
-//     b. If not found in storage, a new Preference struct is created using the DefaultValue from its definition.
-//     The Value field is set to this DefaultValue.
+//     The value is decrypted if the preference is marked as encrypted and migrated to the current definition Version.
+//     b. If not found in storage, the value stored under a deprecated key it replaces (see ReplacedBy)
+//     is used, as in Get. Otherwise a new Preference struct is created using the default from its definition,
+//     as chosen by its DefaultRules if any apply. The Value field is set to this default.
 //     c. The processed preference is added to the result map.
-//  5. If a cache is configured, all retrieved/defaulted preferences are asynchronously added to the cache.
+//  5. If a cache is configured, all retrieved/defaulted preferences are queued to be added to the cache
+//     by a background worker pool (see WithCacheWarming).
+//  6. Preferences whose DependsOn parent is off are omitted when the dependency Mode is
+//     DependencyHide, and marked Disabled otherwise.
+//  7. If the Manager was created WithHiddenFiltering and the actor in the context is an
+//     ActorUser, Hidden preferences are omitted.
+//  8. With a device in ctx (see WithDevice), the device's overrides of PerDevice preferences
+//     replace the values above. Overrides are not cached.
 //
 // Returns:
 //   - (map[string]*Preference, nil): A map of preference keys to Preference structs on success.
 //     The map will be empty if the user has no (defined) preferences or if no preferences are defined.
 //   - (nil, ErrInvalidInput): If userID is empty.
 //   - (nil, ErrEncryptionFailed): If decryption is required but fails.
+//   - (nil, ErrMigrationFailed): If a value written with an older definition Version cannot be upgraded.
 //   - (nil, wrapped storage error): If the storage.GetAll operation fails.
 //
 // This method is thread-safe.
 func (m *Manager) GetAll(ctx context.Context, userID string) (map[string]*Preference, error) {
+	op := &Operation{Kind: OpGetAll, UserID: userID}
+	result, err := m.intercept(ctx, op, func(ctx context.Context, op *Operation) (interface{}, error) {
+		return m.getAllPreferences(ctx, op.UserID)
+	})
+	return interceptResult[map[string]*Preference](op, result, err)
+}
+
+// getAllPreferences implements GetAll without interceptors.
+func (m *Manager) getAllPreferences(ctx context.Context, userID string) (map[string]*Preference, error) {
+	prefs, err := m.getAll(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.applyDependencies(ctx, userID, prefs); err != nil {
+		return nil, err
+	}
+	m.omitHidden(ctx, prefs)
+	return prefs, nil
+}
+
+// getAll implements GetAll without applying DependsOn to the result.
+func (m *Manager) getAll(ctx context.Context, userID string) (map[string]*Preference, error) {
 	if userID == "" {
 		return nil, ErrInvalidInput
 	}
+	deviceCtx, ctx := ctx, withoutDevice(ctx)
 
 	m.mu.RLock()
-	definitions := make(map[string]PreferenceDefinition, len(m.config.definitions))
-	for k, v := range m.config.definitions {
+	tenantDefinitions := m.definitionsFor(TenantFromContext(ctx))
+	definitions := make(map[string]PreferenceDefinition, len(tenantDefinitions))
+	for k, v := range tenantDefinitions {
 		definitions[k] = v
 	}
 	m.mu.RUnlock()
@@ -382,7 +912,7 @@ func (m *Manager) GetAll(ctx context.Context, userID string) (map[string]*Prefer
 	}
 
 	// Fetch all preferences from storage for this user in one go.
-	storedPrefs, err := m.config.storage.GetAll(ctx, userID)
+	storedPrefs, err := m.storageGetAll(ctx, userID)
 	if err != nil {
 		// Do not return ErrNotFound from storage as an error here; an empty map from storage is valid.
 		// Only propagate other storage errors.
@@ -394,6 +924,14 @@ func (m *Manager) GetAll(ctx context.Context, userID string) (map[string]*Prefer
 		storedPrefs = make(map[string]*Preference) // Ensure it's an empty map, not nil
 	}
 
+	// Values of replaced keys are read before the loop below decrypts them in place.
+	replacedStored := make(map[string]Preference)
+	for key, def := range definitions {
+		if storedPref, ok := storedPrefs[key]; ok && def.ReplacedBy != "" {
+			replacedStored[key] = *storedPref
+		}
+	}
+
 	userPreferences := make(map[string]*Preference, len(definitions))
 	prefsToCache := make([]*Preference, 0, len(definitions))
 
@@ -409,47 +947,60 @@ func (m *Manager) GetAll(ctx context.Context, userID string) (map[string]*Prefer
 			finalPref.Category = def.Category
 
 			// Decrypt value if needed
-			decryptedValue, err := m.decryptValue(finalPref.Value, def)
+			decryptedValue, err := m.decryptValue(ctx, finalPref.Value, def)
 			if err != nil {
 				m.config.logger.Error("Failed to decrypt preference value in GetAll", "userID", userID, "key", key, "error", err)
 				return nil, err
 			}
 			finalPref.Value = decryptedValue
 			// UserID and Key should match, UpdatedAt comes from storage.
+
+			if err := migrateValue(finalPref, def); err != nil {
+				m.config.logger.Error("Failed to migrate preference value in GetAll", "userID", userID, "key", key, "error", err)
+				return nil, err
+			}
+
+			decodedValue, err := decodeValue(finalPref.Value, def)
+			if err != nil {
+				m.config.logger.Error("Failed to decode preference value in GetAll", "userID", userID, "key", key, "error", err)
+				return nil, err
+			}
+			finalPref.Value = decodedValue
 		} else {
-			// Not found in storage, use default from definition
-			finalPref = &Preference{
-				UserID:       userID,
-				Key:          key,
-				Value:        def.DefaultValue,
-				DefaultValue: def.DefaultValue,
-				Type:         def.Type,
-				Category:     def.Category,
-				// The original m.Get sets UpdatedAt to time.Now() for defaults.
-				// Let's stick to that for now for consistency.
-				UpdatedAt: time.Now(),
+			// Fall back to a value stored under a key this one replaces
+			replaced, err := m.adoptReplacedStored(ctx, userID, def, definitions, replacedStored)
+			if err != nil {
+				return nil, err
+			}
+			if replaced != nil {
+				// Like Get, a value read through a replaced key is not cached under the new key.
+				userPreferences[key] = replaced
+				continue
 			}
+			// Not found in storage, use default from definition
+			finalPref = m.defaultPreference(ctx, userID, def)
 		}
 		userPreferences[key] = finalPref
-		if m.config.cache != nil {
-			prefsToCache = append(prefsToCache, finalPref)
+		// Rule-based defaults depend on the caller's attributes, so they are not cached per user;
+		// computed defaults are cached separately, for the shorter DefaultFunc TTL.
+		if m.config.cache != nil && (foundInStorage || cacheableDefault(def)) {
+			// Copied, as the caller owns the returned Preference and GetAll marks it Disabled in place.
+			cached := *finalPref
+			prefsToCache = append(prefsToCache, &cached)
 		}
 	}
 
 	// Asynchronously warm the cache with all preferences (stored or defaulted)
 	if m.config.cache != nil && len(prefsToCache) > 0 {
-		go func() {
-			// Create a new context for this background task, or use a non-cancellable one if appropriate.
-			// Using context.Background() for simplicity here, but consider if parent context's lifetime is relevant.
-			// If the parent ctx for GetAll might be very short-lived, Background is safer for cache warming.
-			cacheCtx := context.Background() // Or context.TODO() if a specific context strategy is TBD
-			for _, p := range prefsToCache {
-				// Use a new pointer for each cache set if pref structs are reused or modified in loop
-				// In this case, finalPref is new in each loop or points to storedPref, so it should be fine.
-				m.setToCache(cacheCtx, p) // setToCache handles logging errors internally
-			}
-			m.config.logger.Debug("Cache warming initiated for GetAll results", "userID", userID, "count", len(prefsToCache))
-		}()
+		dropped := m.warmer.enqueue(ctx, prefsToCache)
+		m.config.logger.Debug("Cache warming queued for GetAll results", "userID", userID, "count", len(prefsToCache), "dropped", dropped)
+	}
+
+	err = m.applyDeviceOverrides(deviceCtx, userID, userPreferences, func(ctx context.Context) (map[string]*Preference, error) {
+		return m.storageGetAll(ctx, userID)
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return userPreferences, nil
@@ -463,30 +1014,51 @@ func (m *Manager) GetAll(ctx context.Context, userID string) (map[string]*Prefer
 //  2. Definition Check: Verifies key is defined. Returns ErrPreferenceNotDefined if not.
 //     (Note: This check ensures operations are only on known preference types, though the preference
 //     might not exist for this specific user in storage).
+//     Returns ErrForbidden if the actor in the context may not change the preference.
 //  3. Storage Operation: Deletes the preference from the storage backend.
 //     - If storage returns ErrNotFound, this is considered a successful deletion (idempotency),
 //     as the desired state (preference not present) is achieved. Returns nil error.
 //  4. Cache Invalidation (if cache is configured): Deletes the corresponding entry from the cache,
 //     regardless of whether the item was found in storage.
+//  5. Change Notification: Registered ChangeListeners receive a ChangeDelete event carrying the default.
+//
+// If the definition is PerDevice and ctx carries a device (see WithDevice), only the device's
+// override is removed, and the device falls back to the user's own value, which the
+// ChangeDelete event then carries.
 //
 // Returns:
 //   - nil: On successful deletion or if the preference was not found in storage (idempotent).
 //   - ErrInvalidInput: If userID or key is empty.
 //   - ErrPreferenceNotDefined: If the preference key has not been defined.
+//   - ErrForbidden: If the actor in the context may not change the preference.
 //   - A wrapped storage error: If the storage deletion fails for reasons other than ErrNotFound.
 //
 // This method is thread-safe.
 func (m *Manager) Delete(ctx context.Context, userID, key string) error {
+	op := &Operation{Kind: OpDelete, UserID: userID, Key: key}
+	_, err := m.intercept(ctx, op, func(ctx context.Context, op *Operation) (interface{}, error) {
+		return nil, m.deletePreference(ctx, op.UserID, op.Key)
+	})
+	return err
+}
+
+// deletePreference implements Delete without interceptors.
+func (m *Manager) deletePreference(ctx context.Context, userID, key string) error {
 	if userID == "" || key == "" {
 		return ErrInvalidInput
 	}
 
-	_, exists := m.GetDefinition(key)
+	def, exists := m.contextDefinition(ctx, key)
 	if !exists {
 		return ErrPreferenceNotDefined
 	}
 
-	if err := m.config.storage.Delete(ctx, userID, key); err != nil {
+	if err := checkWriteAccess(ctx, def); err != nil {
+		return err
+	}
+
+	storeCtx, deviceID := deviceScope(ctx, def)
+	if err := m.storageDelete(storeCtx, userID, key); err != nil {
 		// If storage.Delete returns ErrNotFound, it means the item was already gone
 		// or never set for this user, which is fine after definition check.
 		if !errors.Is(err, ErrNotFound) {
@@ -496,39 +1068,100 @@ func (m *Manager) Delete(ctx context.Context, userID, key string) error {
 		// If ErrNotFound, it's okay, the item wasn't there to delete or already deleted.
 	}
 
-	if m.config.cache != nil {
+	if m.config.cache != nil && deviceID == "" {
 		m.deleteFromCache(ctx, userID, key)
 	}
 
+	m.notifyDefault(ctx, userID, def, ChangeDelete)
 	return nil
 }
 
-// GetDefinition retrieves the preference definition for a given key.
-// It is the exported version of the internal getDefinition logic.
+// GetDefinition retrieves the preference definition for a given key in the DefaultTenant's
+// catalogue. Use Manager.Tenant(tenantID).GetDefinition for other tenants.
+// It reports false if the key is not defined or an interceptor rejects the lookup.
 func (m *Manager) GetDefinition(key string) (PreferenceDefinition, bool) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	def, exists := m.config.definitions[key]
-	return def, exists
+	return m.getDefinition(DefaultTenant, key)
 }
 
-// GetAllDefinitions retrieves all preference definitions.
-func (m *Manager) GetAllDefinitions(_ context.Context) ([]*PreferenceDefinition, error) {
+// getDefinition runs a lookup of key in tenantID's catalogue through the interceptors.
+func (m *Manager) getDefinition(tenantID, key string) (PreferenceDefinition, bool) {
+	op := &Operation{Kind: OpGetDefinition, Key: key}
+	result, err := m.intercept(WithTenant(context.Background(), tenantID), op, func(ctx context.Context, op *Operation) (interface{}, error) {
+		def, exists := m.definition(op.TenantID, op.Key)
+		if !exists {
+			return nil, nil
+		}
+		return &def, nil
+	})
+	def, err := interceptResult[*PreferenceDefinition](op, result, err)
+	if err != nil || def == nil {
+		return PreferenceDefinition{}, false
+	}
+	return *def, true
+}
+
+// GetAllDefinitions retrieves all preference definitions of the tenant in ctx (see WithTenant),
+// sorted by DisplayOrder and then by Key.
+func (m *Manager) GetAllDefinitions(ctx context.Context) ([]*PreferenceDefinition, error) {
+	op := &Operation{Kind: OpGetAllDefinitions}
+	result, err := m.intercept(ctx, op, func(ctx context.Context, op *Operation) (interface{}, error) {
+		return m.allDefinitions(ctx), nil
+	})
+	return interceptResult[[]*PreferenceDefinition](op, result, err)
+}
+
+// allDefinitions implements GetAllDefinitions without interceptors.
+func (m *Manager) allDefinitions(ctx context.Context) []*PreferenceDefinition {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	defs := make([]*PreferenceDefinition, 0, len(m.config.definitions))
-	for i := range m.config.definitions {
-		def := m.config.definitions[i] // Create a new variable to take its address
+	definitions := m.definitionsFor(TenantFromContext(ctx))
+	defs := make([]*PreferenceDefinition, 0, len(definitions))
+	for i := range definitions {
+		def := definitions[i] // Create a new variable to take its address
 		defs = append(defs, &def)
 	}
-	return defs, nil
+	// Settings screens render definitions in this order.
+	sort.Slice(defs, func(i, j int) bool {
+		if defs[i].DisplayOrder != defs[j].DisplayOrder {
+			return defs[i].DisplayOrder < defs[j].DisplayOrder
+		}
+		return defs[i].Key < defs[j].Key
+	})
+	return defs
+}
+
+// defaultPreference builds the Preference returned for a user without a stored value.
+// The default is chosen by the definition's DefaultRules, then by its DefaultFunc, falling
+// back to its DefaultValue.
+func (m *Manager) defaultPreference(ctx context.Context, userID string, def PreferenceDefinition) *Preference {
+	value, rule := resolveDefault(ctx, userID, def)
+	if rule == "" && def.DefaultFunc != nil {
+		if computed, ok := m.computedDefault(ctx, userID, def); ok {
+			value = computed
+		}
+	}
+	return &Preference{
+		UserID:       userID,
+		Key:          def.Key,
+		Value:        value,
+		DefaultValue: value,
+		Type:         def.Type,
+		Category:     def.Category,
+		// Defaults have no stored timestamp; the time of resolution is reported instead.
+		UpdatedAt:   time.Now(),
+		Version:     def.Version,
+		AppliedRule: rule,
+	}
 }
 
 // getFromCache retrieves a preference from the cache.
 func (m *Manager) getFromCache(ctx context.Context, userID, key string) (*Preference, error) {
-	cacheKey := fmt.Sprintf("pref:%s:%s", userID, key)
-	data, err := m.config.cache.Get(ctx, cacheKey)
+	cacheKey := prefCacheKey(TenantFromContext(ctx), userID, key)
+	spanCtx, span := m.startSpan(ctx, "userprefs.cache.Get", AttrKey.String(key))
+	data, err := m.config.cache.Get(spanCtx, cacheKey)
+	span.SetAttributes(AttrCacheHit.Bool(err == nil))
+	endSpan(span, err)
 	if err != nil {
 		// Don't log simple cache misses if cache returns a specific 'not found' error.
 		// Assuming any other error is unexpected for getFromCache.
@@ -551,22 +1184,25 @@ func (m *Manager) getFromCache(ctx context.Context, userID, key string) (*Prefer
 
 // setToCache stores a preference in the cache.
 func (m *Manager) setToCache(ctx context.Context, pref *Preference) {
-	cacheKey := fmt.Sprintf("pref:%s:%s", pref.UserID, pref.Key)
+	cacheKey := prefCacheKey(TenantFromContext(ctx), pref.UserID, pref.Key)
 	data, err := json.Marshal(pref)
 	if err != nil {
 		m.config.logger.Error("Failed to marshal preference for cache", "userID", pref.UserID, "key", pref.Key, "type", ErrSerialization, "error", err)
 		return
 	}
 
-	if err := m.config.cache.Set(ctx, cacheKey, data, 24*time.Hour); err != nil {
+	ctx, span := m.startSpan(ctx, "userprefs.cache.Set", AttrKey.String(pref.Key))
+	err = m.config.cache.Set(ctx, cacheKey, data, 24*time.Hour)
+	endSpan(span, err)
+	if err != nil {
 		m.config.logger.Error("Failed to cache preference", "error", err)
 	}
 }
 
 // deleteFromCache removes a preference from the cache.
 func (m *Manager) deleteFromCache(ctx context.Context, userID, key string) {
-	cacheKey := fmt.Sprintf("pref:%s:%s", userID, key)
-	if err := m.config.cache.Delete(ctx, cacheKey); err != nil {
+	if err := m.cacheDelete(ctx, userID, key); err != nil {
+		cacheKey := prefCacheKey(TenantFromContext(ctx), userID, key)
 		// Similarly, don't spam logs for misses if cache.Delete returns a specific 'not found' error.
 		m.config.logger.Warn("Failed to delete preference from cache", "cacheKey", cacheKey, "error", err)
 	}
@@ -574,15 +1210,25 @@ func (m *Manager) deleteFromCache(ctx context.Context, userID, key string) {
 
 // encryptValue encrypts a preference value if encryption is required.
 // It converts the value to a string representation before encryption.
-func (m *Manager) encryptValue(value interface{}, def PreferenceDefinition) (interface{}, error) {
+func (m *Manager) encryptValue(ctx context.Context, value interface{}, def PreferenceDefinition) (interface{}, error) {
 	if !def.Encrypted || m.config.encryptionManager == nil {
 		return value, nil
 	}
 
-	// Convert value to string for encryption
+	// Convert value to string for encryption. Rich types are always JSON-encoded so that
+	// decryptValue can tell a string-encoded value from its JSON form.
+	_, richType := lookupType(def.Type)
 	var plaintext string
 	switch v := value.(type) {
 	case string:
+		if richType {
+			jsonBytes, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("%w: failed to marshal value for encryption: %v", ErrEncryptionFailed, err)
+			}
+			plaintext = string(jsonBytes)
+			break
+		}
 		plaintext = v
 	case nil:
 		return nil, nil
@@ -595,7 +1241,9 @@ func (m *Manager) encryptValue(value interface{}, def PreferenceDefinition) (int
 		plaintext = string(jsonBytes)
 	}
 
+	_, span := m.startSpan(ctx, "userprefs.encrypt", AttrKey.String(def.Key))
 	encrypted, err := m.config.encryptionManager.Encrypt(plaintext)
+	endSpan(span, err)
 	if err != nil {
 		return nil, fmt.Errorf("%w: encryption failed for key '%s': %v", ErrEncryptionFailed, def.Key, err)
 	}
@@ -605,7 +1253,7 @@ func (m *Manager) encryptValue(value interface{}, def PreferenceDefinition) (int
 
 // decryptValue decrypts a preference value if it was encrypted.
 // It converts the decrypted string back to the appropriate type.
-func (m *Manager) decryptValue(encryptedValue interface{}, def PreferenceDefinition) (interface{}, error) {
+func (m *Manager) decryptValue(ctx context.Context, encryptedValue interface{}, def PreferenceDefinition) (interface{}, error) {
 	if !def.Encrypted || m.config.encryptionManager == nil {
 		return encryptedValue, nil
 	}
@@ -622,7 +1270,9 @@ func (m *Manager) decryptValue(encryptedValue interface{}, def PreferenceDefinit
 		return encryptedValue, nil
 	}
 
+	_, span := m.startSpan(ctx, "userprefs.decrypt", AttrKey.String(def.Key))
 	plaintext, err := m.config.encryptionManager.Decrypt(encryptedStr)
+	endSpan(span, err)
 	if err != nil {
 		return nil, fmt.Errorf("%w: decryption failed for key '%s': %v", ErrEncryptionFailed, def.Key, err)
 	}
@@ -656,7 +1306,20 @@ func (m *Manager) decryptValue(encryptedValue interface{}, def PreferenceDefinit
 		}
 		return result, nil
 	default:
-		// Unknown type, return as string
-		return plaintext, nil
+		if _, ok := lookupType(def.Type); !ok {
+			// Unknown type, return as string
+			return plaintext, nil
+		}
+		// Rich types are JSON-encoded before encryption; fall back to the raw plaintext for
+		// values encrypted before the type was registered.
+		var stored interface{}
+		if err := json.Unmarshal([]byte(plaintext), &stored); err != nil {
+			stored = plaintext
+		}
+		result, err := decodeValue(stored, def)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to decode decrypted %s: %v", ErrEncryptionFailed, def.Type, err)
+		}
+		return result, nil
 	}
 }
@@ -0,0 +1,178 @@
+// Package userprefs provides versioned migrations for stored preference values.
+package userprefs
+
+import (
+	"context"
+	"fmt"
+)
+
+// migrateAllPageSize is the number of rows MigrateAll reads from storage per round-trip.
+const migrateAllPageSize = 500
+
+// Migration upgrades a stored preference value from one definition version to the next.
+// A chain of Migrations is attached to a PreferenceDefinition via its Migrations field.
+type Migration struct {
+	// FromVersion is the version of the values this step accepts.
+	// The step produces values for version FromVersion+1.
+	FromVersion int
+	// Migrate converts a value written with FromVersion into the shape expected by FromVersion+1.
+	// It receives the decrypted value as read from storage and returns the upgraded value,
+	// or an error if the value cannot be converted.
+	Migrate func(value interface{}) (interface{}, error)
+}
+
+// validateMigrations checks that a definition's Version and Migrations chain are consistent.
+// Each step must target a version below def.Version, and no version may be migrated twice.
+func validateMigrations(def PreferenceDefinition) error {
+	if def.Version < 0 {
+		return fmt.Errorf("%w: preference '%s' has negative version %d", ErrInvalidInput, def.Key, def.Version)
+	}
+
+	seen := make(map[int]bool, len(def.Migrations))
+	for _, mig := range def.Migrations {
+		if mig.Migrate == nil {
+			return fmt.Errorf("%w: preference '%s' has a nil migration from version %d", ErrInvalidInput, def.Key, mig.FromVersion)
+		}
+		if mig.FromVersion < 0 || mig.FromVersion >= def.Version {
+			return fmt.Errorf("%w: preference '%s' has a migration from version %d outside [0, %d)", ErrInvalidInput, def.Key, mig.FromVersion, def.Version)
+		}
+		if seen[mig.FromVersion] {
+			return fmt.Errorf("%w: preference '%s' has more than one migration from version %d", ErrInvalidInput, def.Key, mig.FromVersion)
+		}
+		seen[mig.FromVersion] = true
+	}
+	return nil
+}
+
+// migrateValue upgrades pref.Value in place to def.Version by running each applicable
+// Migration in order. Versions without a registered step are passed through unchanged.
+// Preferences already at (or, from a newer writer, beyond) def.Version are left untouched.
+func migrateValue(pref *Preference, def PreferenceDefinition) error {
+	if pref.Version >= def.Version {
+		return nil
+	}
+
+	steps := make(map[int]Migration, len(def.Migrations))
+	for _, mig := range def.Migrations {
+		steps[mig.FromVersion] = mig
+	}
+
+	value := pref.Value
+	for v := pref.Version; v < def.Version; v++ {
+		step, ok := steps[v]
+		if !ok {
+			continue
+		}
+		migrated, err := step.Migrate(value)
+		if err != nil {
+			return fmt.Errorf("%w: key '%s' from version %d to %d: %v", ErrMigrationFailed, def.Key, v, v+1, err)
+		}
+		value = migrated
+	}
+
+	pref.Value = value
+	pref.Version = def.Version
+	return nil
+}
+
+// MigrateAll eagerly upgrades every stored value of the given preference key to the
+// definition's current Version, rewriting the rows in storage.
+// Values are decrypted before and re-encrypted after migration when the preference is
+// marked as encrypted. Cached entries for rewritten rows are invalidated.
+//
+// Only rows of the tenant in ctx (see WithTenant) are migrated, using that tenant's definition of key.
+// MigrateAll requires the configured Storage to implement KeyLister. Rows are read in
+// pages, so the operation is safe to run against large tables; it is not atomic, and a
+// failure part-way leaves earlier pages migrated. Re-running it is safe.
+//
+// Returns:
+//   - (int, nil): The number of rows rewritten.
+//   - (0, ErrInvalidInput): If key is empty.
+//   - (0, ErrPreferenceNotDefined): If the key has not been defined.
+//   - (0, ErrNotSupported): If the storage backend does not implement KeyLister.
+//   - (n, ErrMigrationFailed or a wrapped storage error): If a row could not be migrated or written.
+//
+// This method is thread-safe.
+func (m *Manager) MigrateAll(ctx context.Context, key string) (_ int, err error) {
+	ctx, span := m.startOperationSpan(ctx, "MigrateAll", AttrKey.String(key))
+	defer func() { endSpan(span, err) }()
+	// Device overrides are upgraded when read instead.
+	ctx = withoutDevice(ctx)
+	if key == "" {
+		return 0, ErrInvalidInput
+	}
+
+	def, exists := m.contextDefinition(ctx, key)
+	if !exists {
+		return 0, ErrPreferenceNotDefined
+	}
+
+	lister, ok := m.config.storage.(KeyLister)
+	if !ok {
+		return 0, fmt.Errorf("%w: storage does not implement KeyLister", ErrNotSupported)
+	}
+
+	migrated := 0
+	afterUserID := ""
+	for {
+		page, err := m.storageListByKey(ctx, lister, key, afterUserID, migrateAllPageSize)
+		if err != nil {
+			m.config.logger.Error("Storage ListByKey failed", "key", key, "afterUserID", afterUserID, "error", err)
+			return migrated, fmt.Errorf("storage.ListByKey failed for key '%s': %w", key, err)
+		}
+
+		for _, pref := range page {
+			afterUserID = pref.UserID
+			if pref.Version >= def.Version {
+				continue
+			}
+			if err := m.rewriteMigrated(ctx, pref, def); err != nil {
+				return migrated, err
+			}
+			migrated++
+		}
+
+		if len(page) < migrateAllPageSize {
+			break
+		}
+	}
+
+	m.config.logger.Info("Migrated stored preference values", "key", key, "version", def.Version, "count", migrated)
+	return migrated, nil
+}
+
+// rewriteMigrated migrates a single stored row and writes it back to storage.
+func (m *Manager) rewriteMigrated(ctx context.Context, pref *Preference, def PreferenceDefinition) error {
+	decrypted, err := m.decryptValue(ctx, pref.Value, def)
+	if err != nil {
+		return err
+	}
+	pref.Value = decrypted
+
+	if err := migrateValue(pref, def); err != nil {
+		m.config.logger.Error("Failed to migrate stored value", "userID", pref.UserID, "key", def.Key, "error", err)
+		return err
+	}
+
+	encodedValue, err := encodeValue(pref.Value, def)
+	if err != nil {
+		return err
+	}
+
+	storageValue, err := m.encryptValue(ctx, encodedValue, def)
+	if err != nil {
+		return err
+	}
+	pref.Value = storageValue
+	pref.DefaultValue = def.DefaultValue
+
+	if err := m.storageSet(ctx, pref); err != nil {
+		m.config.logger.Error("Storage Set failed during migration", "userID", pref.UserID, "key", def.Key, "error", err)
+		return fmt.Errorf("storage.Set failed for key '%s': %w", def.Key, err)
+	}
+
+	if m.config.cache != nil {
+		m.deleteFromCache(ctx, pref.UserID, def.Key)
+	}
+	return nil
+}
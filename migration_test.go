@@ -0,0 +1,179 @@
+package userprefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// layoutDefinition returns a definition whose value moved from a plain string (version 0)
+// to a JSON object (version 1).
+func layoutDefinition() PreferenceDefinition {
+	return PreferenceDefinition{
+		Key:          "layout",
+		Type:         JSONType,
+		Category:     "appearance",
+		DefaultValue: map[string]interface{}{"mode": "comfortable"},
+		Version:      1,
+		Migrations: []Migration{
+			{
+				FromVersion: 0,
+				Migrate: func(value interface{}) (interface{}, error) {
+					mode, ok := value.(string)
+					if !ok {
+						return nil, fmt.Errorf("expected string, got %T", value)
+					}
+					return map[string]interface{}{"mode": mode}, nil
+				},
+			},
+		},
+	}
+}
+
+func TestManager_DefinePreference_Migrations(t *testing.T) {
+	mgr := newTestManager(t, []PreferenceDefinition{layoutDefinition()})
+
+	noop := func(value interface{}) (interface{}, error) { return value, nil }
+	invalid := []PreferenceDefinition{
+		{Key: "negative", Type: StringType, Version: -1},
+		{Key: "future_step", Type: StringType, Version: 1, Migrations: []Migration{{FromVersion: 1, Migrate: noop}}},
+		{Key: "duplicate_step", Type: StringType, Version: 2, Migrations: []Migration{{FromVersion: 0, Migrate: noop}, {FromVersion: 0, Migrate: noop}}},
+		{Key: "nil_step", Type: StringType, Version: 1, Migrations: []Migration{{FromVersion: 0}}},
+	}
+	for _, def := range invalid {
+		if err := mgr.DefinePreference(def); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("DefinePreference(%s): expected ErrInvalidInput, got %v", def.Key, err)
+		}
+	}
+}
+
+func TestManager_Get_MigratesLegacyValue(t *testing.T) {
+	ctx := context.Background()
+	store := NewMockStorage()
+	cache := NewMockCache()
+	mgr := newTestManager(t, []PreferenceDefinition{layoutDefinition()}, WithStorage(store), WithCache(cache))
+
+	// A row written before the definition was versioned.
+	_ = store.Set(ctx, &Preference{UserID: "u1", Key: "layout", Value: "compact", Type: JSONType, UpdatedAt: time.Now()})
+
+	pref, err := mgr.Get(ctx, "u1", "layout")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	obj, ok := pref.Value.(map[string]interface{})
+	if !ok || obj["mode"] != "compact" {
+		t.Fatalf("Expected migrated value {mode: compact}, got %v (type %T)", pref.Value, pref.Value)
+	}
+	if pref.Version != 1 {
+		t.Errorf("Expected version 1 after migration, got %d", pref.Version)
+	}
+
+	// The stored row is only upgraded lazily; storage still holds the legacy value.
+	stored, _ := store.Get(ctx, "u1", "layout")
+	if stored.Version != 0 {
+		t.Errorf("Expected stored row to remain at version 0, got %d", stored.Version)
+	}
+
+	// Subsequent reads served from the cache see the migrated value.
+	pref, err = mgr.Get(ctx, "u1", "layout")
+	if err != nil {
+		t.Fatalf("Get from cache failed: %v", err)
+	}
+	if obj, ok := pref.Value.(map[string]interface{}); !ok || obj["mode"] != "compact" {
+		t.Errorf("Expected cached migrated value, got %v", pref.Value)
+	}
+
+	all, err := mgr.GetAll(ctx, "u1")
+	if err != nil {
+		t.Fatalf("GetAll failed: %v", err)
+	}
+	if obj, ok := all["layout"].Value.(map[string]interface{}); !ok || obj["mode"] != "compact" {
+		t.Errorf("Expected GetAll to return migrated value, got %v", all["layout"].Value)
+	}
+}
+
+func TestManager_Get_MigrationFailure(t *testing.T) {
+	ctx := context.Background()
+	store := NewMockStorage()
+	mgr := newTestManager(t, []PreferenceDefinition{layoutDefinition()}, WithStorage(store))
+	_ = store.Set(ctx, &Preference{UserID: "u1", Key: "layout", Value: 42.0, Type: JSONType})
+
+	if _, err := mgr.Get(ctx, "u1", "layout"); !errors.Is(err, ErrMigrationFailed) {
+		t.Errorf("Expected ErrMigrationFailed, got %v", err)
+	}
+}
+
+func TestManager_Set_RecordsVersion(t *testing.T) {
+	ctx := context.Background()
+	store := NewMockStorage()
+	mgr := newTestManager(t, []PreferenceDefinition{layoutDefinition()}, WithStorage(store))
+	if err := mgr.Set(ctx, "u1", "layout", map[string]interface{}{"mode": "compact"}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	stored, err := store.Get(ctx, "u1", "layout")
+	if err != nil {
+		t.Fatalf("store.Get failed: %v", err)
+	}
+	if stored.Version != 1 {
+		t.Errorf("Expected stored version 1, got %d", stored.Version)
+	}
+}
+
+func TestManager_MigrateAll(t *testing.T) {
+	ctx := context.Background()
+	store := NewMockStorage()
+	cache := NewMockCache()
+	mgr := newTestManager(t, []PreferenceDefinition{layoutDefinition()}, WithStorage(store), WithCache(cache))
+
+	for i := 0; i < 3; i++ {
+		_ = store.Set(ctx, &Preference{UserID: fmt.Sprintf("legacy%d", i), Key: "layout", Value: "compact", Type: JSONType})
+	}
+	if err := mgr.Set(ctx, "current", "layout", map[string]interface{}{"mode": "cozy"}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	count, err := mgr.MigrateAll(ctx, "layout")
+	if err != nil {
+		t.Fatalf("MigrateAll failed: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 rows migrated, got %d", count)
+	}
+
+	for i := 0; i < 3; i++ {
+		stored, err := store.Get(ctx, fmt.Sprintf("legacy%d", i), "layout")
+		if err != nil {
+			t.Fatalf("store.Get failed: %v", err)
+		}
+		obj, ok := stored.Value.(map[string]interface{})
+		if stored.Version != 1 || !ok || obj["mode"] != "compact" {
+			t.Errorf("Expected migrated row at version 1, got version %d value %v", stored.Version, stored.Value)
+		}
+	}
+
+	// A second run has nothing left to do.
+	count, err = mgr.MigrateAll(ctx, "layout")
+	if err != nil || count != 0 {
+		t.Errorf("Expected idempotent second run, got count %d err %v", count, err)
+	}
+}
+
+func TestManager_MigrateAll_Errors(t *testing.T) {
+	ctx := context.Background()
+
+	// Embedding only the Storage interface hides MockStorage's ListByKey method.
+	mgr := newTestManager(t, []PreferenceDefinition{layoutDefinition()}, WithStorage(struct{ Storage }{NewMockStorage()}))
+
+	if _, err := mgr.MigrateAll(ctx, "layout"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported, got %v", err)
+	}
+	if _, err := mgr.MigrateAll(ctx, ""); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput, got %v", err)
+	}
+	if _, err := mgr.MigrateAll(ctx, "unknown"); !errors.Is(err, ErrPreferenceNotDefined) {
+		t.Errorf("Expected ErrPreferenceNotDefined, got %v", err)
+	}
+}
@@ -4,24 +4,89 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"testing"
 	"time"
 )
 
-// MockStorage implements the Storage interface for testing
+// MockStorage implements the Storage interface for testing.
+// data holds the default tenant's preferences; other tenants, and device overrides (see
+// WithDevice), are kept in tenants.
 type MockStorage struct {
 	mu               sync.RWMutex
 	data             map[string]map[string]*Preference
+	tenants          map[string]map[string]map[string]*Preference
 	closed           bool
 	forceGetByCatErr error // For forcing errors in GetByCategory for testing
 }
 
+// newTestManager returns a Manager on a MockStorage and MockCache with defs defined. opts are
+// applied after those defaults, so they can replace the storage or cache.
+func newTestManager(t *testing.T, defs []PreferenceDefinition, opts ...Option) *Manager {
+	t.Helper()
+	mgr := New(append([]Option{WithStorage(NewMockStorage()), WithCache(NewMockCache()), WithLogger(&MockLogger{})}, opts...)...)
+	definePreferences(t, mgr, defs)
+	return mgr
+}
+
+// definePreferences defines defs through d, a Manager or TenantManager, failing the test on error.
+func definePreferences(t *testing.T, d interface {
+	DefinePreference(PreferenceDefinition) error
+}, defs []PreferenceDefinition) {
+	t.Helper()
+	for _, def := range defs {
+		if err := d.DefinePreference(def); err != nil {
+			t.Fatalf("DefinePreference(%s) failed: %v", def.Key, err)
+		}
+	}
+}
+
 func NewMockStorage() *MockStorage {
 	return &MockStorage{
 		data: make(map[string]map[string]*Preference),
 	}
 }
 
+// users returns the preferences of the tenant and device in ctx, creating the scope's map if
+// create is set.
+func (m *MockStorage) users(ctx context.Context, create bool) map[string]map[string]*Preference {
+	tenantID := TenantFromContext(ctx)
+	if deviceID := DeviceFromContext(ctx); deviceID != "" {
+		tenantID += "\x00device:" + deviceID
+	} else if tenantID == DefaultTenant {
+		return m.data
+	}
+	if m.tenants[tenantID] == nil && create {
+		if m.tenants == nil {
+			m.tenants = make(map[string]map[string]map[string]*Preference)
+		}
+		m.tenants[tenantID] = make(map[string]map[string]*Preference)
+	}
+	return m.tenants[tenantID]
+}
+
+// ScopesByContext implements ScopedStorage; users keeps tenants and devices apart.
+func (m *MockStorage) ScopesByContext() bool {
+	return true
+}
+
+// ListDevices implements DeviceLister.
+func (m *MockStorage) ListDevices(ctx context.Context, userID string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	prefix := TenantFromContext(ctx) + "\x00device:"
+	devices := []string{}
+	for scope, users := range m.tenants {
+		if deviceID, ok := strings.CutPrefix(scope, prefix); ok && len(users[userID]) > 0 {
+			devices = append(devices, deviceID)
+		}
+	}
+	sort.Strings(devices)
+	return devices, nil
+}
+
 func (m *MockStorage) Get(ctx context.Context, userID, key string) (*Preference, error) {
 	_, _ = ctx.Deadline()
 	m.mu.RLock()
@@ -31,7 +96,7 @@ func (m *MockStorage) Get(ctx context.Context, userID, key string) (*Preference,
 		return nil, ErrStorageUnavailable
 	}
 
-	if userPrefs, exists := m.data[userID]; exists {
+	if userPrefs, exists := m.users(ctx, false)[userID]; exists {
 		if pref, exists := userPrefs[key]; exists {
 			// Return a deep copy to prevent modifications from affecting stored data
 			copiedPref, err := deepCopyPreference(pref)
@@ -53,10 +118,11 @@ func (m *MockStorage) Set(ctx context.Context, pref *Preference) error {
 		return ErrStorageUnavailable
 	}
 
-	if _, exists := m.data[pref.UserID]; !exists {
-		m.data[pref.UserID] = make(map[string]*Preference)
+	users := m.users(ctx, true)
+	if _, exists := users[pref.UserID]; !exists {
+		users[pref.UserID] = make(map[string]*Preference)
 	}
-	m.data[pref.UserID][pref.Key] = pref
+	users[pref.UserID][pref.Key] = pref
 	return nil
 }
 
@@ -69,7 +135,7 @@ func (m *MockStorage) Delete(ctx context.Context, userID, key string) error {
 		return ErrStorageUnavailable
 	}
 
-	if userPrefs, exists := m.data[userID]; exists {
+	if userPrefs, exists := m.users(ctx, false)[userID]; exists {
 		if _, exists := userPrefs[key]; exists {
 			delete(userPrefs, key)
 			return nil
@@ -87,7 +153,7 @@ func (m *MockStorage) GetAll(ctx context.Context, userID string) (map[string]*Pr
 		return nil, ErrStorageUnavailable
 	}
 
-	if userPrefs, exists := m.data[userID]; exists && len(userPrefs) > 0 {
+	if userPrefs, exists := m.users(ctx, false)[userID]; exists && len(userPrefs) > 0 {
 		copiedPrefs := make(map[string]*Preference, len(userPrefs))
 		for key, p := range userPrefs {
 			copiedP, err := deepCopyPreference(p) // Use the actual Preference type from the userprefs package
@@ -124,7 +190,7 @@ func (m *MockStorage) GetByCategory(ctx context.Context, userID, category string
 	}
 
 	result := make(map[string]*Preference)
-	if userPrefs, exists := m.data[userID]; exists {
+	if userPrefs, exists := m.users(ctx, false)[userID]; exists {
 		for key, pref := range userPrefs {
 			if pref.Category == category {
 				copiedP, err := deepCopyPreference(pref) // Use the actual Preference type
@@ -140,6 +206,125 @@ func (m *MockStorage) GetByCategory(ctx context.Context, userID, category string
 	return result, nil
 }
 
+// ListByKey implements KeyLister, returning preferences for key ordered by user ID.
+func (m *MockStorage) ListByKey(ctx context.Context, key, afterUserID string, limit int) ([]*Preference, error) {
+	_, _ = ctx.Deadline()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return nil, ErrStorageUnavailable
+	}
+
+	users := m.users(ctx, false)
+	userIDs := make([]string, 0, len(users))
+	for userID, userPrefs := range users {
+		if _, exists := userPrefs[key]; exists && userID > afterUserID {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	sort.Strings(userIDs)
+	if len(userIDs) > limit {
+		userIDs = userIDs[:limit]
+	}
+
+	result := make([]*Preference, 0, len(userIDs))
+	for _, userID := range userIDs {
+		copiedP, err := deepCopyPreference(users[userID][key])
+		if err != nil {
+			return nil, fmt.Errorf("mockstorage: error deep copying preference %s for user %s: %w", key, userID, err)
+		}
+		result = append(result, copiedP)
+	}
+	return result, nil
+}
+
+// FindUsers implements UserFinder with a scan of the tenant's preferences.
+func (m *MockStorage) FindUsers(ctx context.Context, query UserQuery) ([]string, error) {
+	_, _ = ctx.Deadline()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return nil, ErrStorageUnavailable
+	}
+
+	var userIDs []string
+	for userID, userPrefs := range m.users(ctx, false) {
+		if userID <= query.AfterUserID {
+			continue
+		}
+		pref, exists := userPrefs[query.Key]
+		if (exists && query.Predicate.Matches(pref.Value)) || (!exists && query.IncludeMissing) {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	sort.Strings(userIDs)
+	if len(userIDs) > query.Limit {
+		userIDs = userIDs[:query.Limit]
+	}
+	return userIDs, nil
+}
+
+// ValueStats implements StatsProvider with a scan of the tenant's preferences.
+func (m *MockStorage) ValueStats(ctx context.Context, query StatsQuery) (*PreferenceStats, error) {
+	_, _ = ctx.Deadline()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return nil, ErrStorageUnavailable
+	}
+
+	stats := &PreferenceStats{}
+	counts := make(map[string]*ValueCount)
+	var numbers []float64
+	for _, userPrefs := range m.users(ctx, false) {
+		pref, exists := userPrefs[query.Key]
+		if !exists {
+			stats.DefaultCount++
+			continue
+		}
+		stats.ExplicitCount++
+		if n, ok := numericValue(pref.Value); ok && query.Buckets > 0 {
+			numbers = append(numbers, n)
+			continue
+		}
+		id := fmt.Sprint(pref.Value)
+		if counts[id] == nil {
+			counts[id] = &ValueCount{Value: pref.Value}
+		}
+		counts[id].Count++
+	}
+
+	for _, count := range counts {
+		stats.Values = append(stats.Values, *count)
+	}
+	sort.Slice(stats.Values, func(i, j int) bool { return stats.Values[i].Count > stats.Values[j].Count })
+	if len(stats.Values) > query.MaxValues {
+		stats.Values = stats.Values[:query.MaxValues]
+	}
+
+	if len(numbers) > 0 {
+		sort.Float64s(numbers)
+		min, max := numbers[0], numbers[len(numbers)-1]
+		width := (max - min) / float64(query.Buckets)
+		for i := 0; i < query.Buckets; i++ {
+			bucket := NumericBucket{Min: min + float64(i)*width, Max: min + float64(i+1)*width}
+			for _, n := range numbers {
+				if (n >= bucket.Min && n < bucket.Max) || (i == query.Buckets-1 && n == max) {
+					bucket.Count++
+				}
+			}
+			stats.Buckets = append(stats.Buckets, bucket)
+			if width == 0 {
+				break
+			}
+		}
+	}
+	return stats, nil
+}
+
 func (m *MockStorage) Close() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -192,6 +377,7 @@ func deepCopyPreference(original *Preference) (*Preference, error) {
 		Type:         original.Type,
 		Category:     original.Category,
 		UpdatedAt:    original.UpdatedAt, // time.Time is a struct, direct assignment copies its value.
+		Version:      original.Version,
 	}, nil
 }
 
@@ -0,0 +1,146 @@
+// Package userprefs provides input normalization and lossless numeric coercion for preference values.
+package userprefs
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// maxExactFloatInt is the largest integer magnitude a float64 represents exactly (2^53).
+const maxExactFloatInt = 1 << 53
+
+// normalizeValue converts value into the canonical Go type of def.Type and then applies
+// def.NormalizeFunc. Conversions never lose information: a value that cannot be converted
+// exactly (e.g. 3.5 for an int preference) is returned unchanged so that validation reports it.
+// Only errors returned by NormalizeFunc are reported, as a *ValidationError with RuleNormalize.
+func normalizeValue(value interface{}, def PreferenceDefinition) (interface{}, error) {
+	value = canonicalValue(value, def, true)
+
+	if def.NormalizeFunc != nil {
+		normalized, err := def.NormalizeFunc(value)
+		if err != nil {
+			verrs, _ := toValidationErrors(err, def.Key, RuleNormalize, actualType(value))
+			return nil, verrs.asError()
+		}
+		value = normalized
+	}
+	return value, nil
+}
+
+// normalizeAllowedValues returns def.AllowedValues normalized like values passed to Set,
+// so that membership checks compare canonical values.
+func normalizeAllowedValues(def PreferenceDefinition) ([]interface{}, error) {
+	if len(def.AllowedValues) == 0 {
+		return def.AllowedValues, nil
+	}
+	allowed := make([]interface{}, len(def.AllowedValues))
+	for i, v := range def.AllowedValues {
+		normalized, err := normalizeValue(v, def)
+		if err != nil {
+			return nil, fmt.Errorf("%w: preference '%s' has an allowed value that cannot be normalized: %v", ErrInvalidInput, def.Key, err)
+		}
+		allowed[i] = normalized
+	}
+	return allowed, nil
+}
+
+// canonicalValue converts value to the canonical Go type of def.Type when that is possible
+// without loss: int for IntType, float64 for FloatType, and the Decode output for rich types.
+// String forms ("42", "true") are parsed only when input is true and def.CoerceStrings is set.
+// Values that cannot be converted are returned unchanged.
+func canonicalValue(value interface{}, def PreferenceDefinition, input bool) interface{} {
+	if value == nil {
+		return nil
+	}
+	coerceStrings := input && def.CoerceStrings
+
+	switch def.Type {
+	case IntType:
+		if i, ok := exactInt(value, coerceStrings); ok {
+			return i
+		}
+	case FloatType:
+		if f, ok := exactFloat(value, coerceStrings); ok {
+			return f
+		}
+	case BoolType:
+		if s, ok := value.(string); ok && coerceStrings {
+			if b, err := strconv.ParseBool(strings.TrimSpace(s)); err == nil {
+				return b
+			}
+		}
+	case StringType, JSONType:
+	default:
+		if handler, ok := lookupType(def.Type); ok && handler.Decode != nil {
+			if decoded, err := handler.Decode(value); err == nil {
+				return decoded
+			}
+		}
+	}
+	return value
+}
+
+// exactInt converts integers, integral floats, json.Number, and optionally numeric strings to int.
+func exactInt(value interface{}, coerceStrings bool) (int, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		i, err := v.Int64()
+		return int(i), err == nil
+	case string:
+		if !coerceStrings {
+			return 0, false
+		}
+		i, err := strconv.ParseInt(strings.TrimSpace(v), 10, 0)
+		return int(i), err == nil
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := rv.Uint()
+		return int(u), u <= math.MaxInt
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, false
+		}
+		return int(f), true
+	default:
+		return 0, false
+	}
+}
+
+// exactFloat converts floats, integers within ±2^53, json.Number, and optionally numeric strings to float64.
+func exactFloat(value interface{}, coerceStrings bool) (float64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		if !coerceStrings {
+			return 0, false
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := rv.Int()
+		return float64(i), i >= -maxExactFloatInt && i <= maxExactFloatInt
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := rv.Uint()
+		return float64(u), u <= maxExactFloatInt
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,115 @@
+package userprefs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeValue_Numeric(t *testing.T) {
+	testCases := []struct {
+		name     string
+		def      PreferenceDefinition
+		value    interface{}
+		expected interface{}
+	}{
+		{"float64 integral to int", PreferenceDefinition{Type: IntType}, float64(5), 5},
+		{"int64 to int", PreferenceDefinition{Type: IntType}, int64(7), 7},
+		{"uint8 to int", PreferenceDefinition{Type: IntType}, uint8(3), 3},
+		{"json.Number to int", PreferenceDefinition{Type: IntType}, json.Number("42"), 42},
+		{"fractional float kept", PreferenceDefinition{Type: IntType}, 3.5, 3.5},
+		{"string kept without coercion", PreferenceDefinition{Type: IntType}, "42", "42"},
+		{"string coerced to int", PreferenceDefinition{Type: IntType, CoerceStrings: true}, " 42 ", 42},
+		{"int to float64", PreferenceDefinition{Type: FloatType}, 2, float64(2)},
+		{"float32 to float64", PreferenceDefinition{Type: FloatType}, float32(0.5), float64(0.5)},
+		{"large int kept for float", PreferenceDefinition{Type: FloatType}, int64(1<<53 + 1), int64(1<<53 + 1)},
+		{"string coerced to float", PreferenceDefinition{Type: FloatType, CoerceStrings: true}, "2.5", 2.5},
+		{"string coerced to bool", PreferenceDefinition{Type: BoolType, CoerceStrings: true}, "true", true},
+		{"invalid bool string kept", PreferenceDefinition{Type: BoolType, CoerceStrings: true}, "maybe", "maybe"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := normalizeValue(tc.value, tc.def)
+			if err != nil {
+				t.Fatalf("normalizeValue failed: %v", err)
+			}
+			if !valuesEqual(got, tc.expected) {
+				t.Errorf("Expected %#v, got %#v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestManager_Set_Normalization(t *testing.T) {
+	ctx := context.Background()
+	defs := []PreferenceDefinition{
+		{Key: "page_size", Type: IntType, AllowedValues: []interface{}{int64(10), int32(25), float64(50)}},
+		{Key: "ratio", Type: FloatType, CoerceStrings: true},
+		{
+			Key:  "nickname",
+			Type: StringType,
+			NormalizeFunc: func(value interface{}) (interface{}, error) {
+				s, ok := value.(string)
+				if !ok {
+					return value, nil
+				}
+				if strings.Contains(s, "@") {
+					return nil, fmt.Errorf("nicknames cannot contain '@'")
+				}
+				return strings.ToLower(strings.TrimSpace(s)), nil
+			},
+			AllowedValues: []interface{}{" Alice ", "bob"},
+		},
+	}
+	mgr := newTestManager(t, defs)
+
+	if def, _ := mgr.GetDefinition("page_size"); !valuesEqual(def.AllowedValues, []interface{}{10, 25, 50}) {
+		t.Errorf("Expected canonical AllowedValues, got %#v", def.AllowedValues)
+	}
+
+	// A JSON-decoded 25 arrives as float64 and must match the int32 allowed value.
+	if err := mgr.Set(ctx, "u1", "page_size", float64(25)); err != nil {
+		t.Fatalf("Set page_size failed: %v", err)
+	}
+	if err := mgr.Set(ctx, "u1", "page_size", 25.5); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("Expected ErrInvalidValue for a fractional int, got %v", err)
+	}
+	if err := mgr.Set(ctx, "u1", "ratio", "0.75"); err != nil {
+		t.Fatalf("Set ratio failed: %v", err)
+	}
+	if err := mgr.Set(ctx, "u1", "nickname", "  ALICE"); err != nil {
+		t.Fatalf("Set nickname failed: %v", err)
+	}
+
+	err := mgr.Set(ctx, "u1", "nickname", "a@b")
+	var verr *ValidationError
+	if !errors.As(err, &verr) || verr.Rule != RuleNormalize || verr.Key != "nickname" {
+		t.Errorf("Expected a RuleNormalize ValidationError, got %v", err)
+	}
+
+	// Read back from storage, which round-trips values through JSON.
+	if err := mgr.config.cache.Delete(ctx, fmt.Sprintf("pref:%s:%s", "u1", "page_size")); err != nil {
+		t.Fatalf("cache Delete failed: %v", err)
+	}
+	expected := map[string]interface{}{"page_size": 25, "ratio": 0.75, "nickname": "alice"}
+	for key, want := range expected {
+		pref, err := mgr.Get(ctx, "u1", key)
+		if err != nil {
+			t.Fatalf("Get(%s) failed: %v", key, err)
+		}
+		if pref.Value != want {
+			t.Errorf("Get(%s): expected %#v, got %#v", key, want, pref.Value)
+		}
+	}
+
+	invalid := PreferenceDefinition{Key: "level", Type: IntType, AllowedValues: []interface{}{1}, NormalizeFunc: func(interface{}) (interface{}, error) {
+		return nil, errors.New("boom")
+	}}
+	if err := mgr.DefinePreference(invalid); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for an allowed value that fails normalization, got %v", err)
+	}
+}
@@ -22,3 +22,30 @@ const (
 	// The actual Go type would typically be map[string]interface{} or []interface{}.
 	JSONType string = "json"
 )
+
+// Constants for the built-in rich preference types. Each has a canonical storage encoding
+// and is decoded back into the Go representation noted below when read.
+const (
+	// EnumType represents a string restricted to the definition's AllowedValues, which must be set.
+	EnumType string = "enum"
+	// DurationType represents a time.Duration. Strings such as "90m" are accepted on Set;
+	// values are stored in time.Duration.String form.
+	DurationType string = "duration"
+	// TimestampType represents a time.Time. RFC 3339 strings are accepted on Set;
+	// values are stored as RFC 3339 strings in UTC.
+	TimestampType string = "timestamp"
+	// TimezoneType represents an IANA time zone name string, such as "Europe/Berlin".
+	TimezoneType string = "timezone"
+	// LocaleType represents a BCP 47 language tag string, such as "pt-BR", stored in canonical case.
+	LocaleType string = "locale"
+	// ColorType represents a hex color string (#rgb, #rrggbb, or #rrggbbaa), stored as lowercase #rrggbb[aa].
+	ColorType string = "color"
+	// EmailType represents a bare email address string, stored with a lowercase domain.
+	EmailType string = "email"
+	// URLType represents an absolute URL string with a scheme and host.
+	URLType string = "url"
+	// StringListType represents a []string.
+	StringListType string = "string_list"
+	// IntListType represents a []int.
+	IntListType string = "int_list"
+)
@@ -0,0 +1,159 @@
+// Package userprefs provides presentation metadata and localization for rendering settings screens.
+package userprefs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Widget identifies the input control a settings screen should render for a preference.
+type Widget string
+
+// Supported widgets. A preference without a Widget is rendered as its Type suggests.
+const (
+	// WidgetToggle is an on/off switch, typically for bool preferences.
+	WidgetToggle Widget = "toggle"
+	// WidgetSelect is a drop-down list of the AllowedValues.
+	WidgetSelect Widget = "select"
+	// WidgetRadio is a group of radio buttons, one per AllowedValues entry.
+	WidgetRadio Widget = "radio"
+	// WidgetText is a single-line text field.
+	WidgetText Widget = "text"
+	// WidgetTextarea is a multi-line text field.
+	WidgetTextarea Widget = "textarea"
+	// WidgetNumber is a numeric input field.
+	WidgetNumber Widget = "number"
+	// WidgetSlider is a slider between the definition's Min and Max.
+	WidgetSlider Widget = "slider"
+	// WidgetColor is a color picker.
+	WidgetColor Widget = "color"
+)
+
+// Translation holds the presentation metadata of a preference in one language. Empty fields,
+// and values missing from ValueLabels, fall back to those of the definition.
+type Translation struct {
+	Label       string            `json:"label,omitempty"`
+	Description string            `json:"description,omitempty"`
+	ValueLabels map[string]string `json:"value_labels,omitempty"`
+}
+
+// validatePresentation checks that a definition's presentation metadata is well-formed. It is
+// called after AllowedValues are normalized, so that ValueLabels are matched against the
+// values as stored.
+func validatePresentation(def PreferenceDefinition) error {
+	switch def.Widget {
+	case "", WidgetToggle, WidgetSelect, WidgetRadio, WidgetText, WidgetTextarea, WidgetNumber, WidgetSlider, WidgetColor:
+	default:
+		return fmt.Errorf("%w: preference '%s' has unknown widget '%s'", ErrInvalidInput, def.Key, def.Widget)
+	}
+
+	if err := validateValueLabels(def, def.ValueLabels); err != nil {
+		return err
+	}
+	for tag, translation := range def.Translations {
+		if !validLanguageTag(tag) {
+			return fmt.Errorf("%w: preference '%s' has a translation with invalid language tag '%s'", ErrInvalidInput, def.Key, tag)
+		}
+		if err := validateValueLabels(def, translation.ValueLabels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateValueLabels checks that every key of labels names one of def's AllowedValues.
+// Definitions without AllowedValues may label any value.
+func validateValueLabels(def PreferenceDefinition, labels map[string]string) error {
+	if len(def.AllowedValues) == 0 {
+		return nil
+	}
+	allowed := make(map[string]bool, len(def.AllowedValues))
+	for _, value := range def.AllowedValues {
+		allowed[fmt.Sprint(value)] = true
+	}
+	for value := range labels {
+		if !allowed[value] {
+			return fmt.Errorf("%w: preference '%s' labels value '%s', which is not in AllowedValues", ErrInvalidInput, def.Key, value)
+		}
+	}
+	return nil
+}
+
+// validLanguageTag reports whether tag is shaped like a BCP 47 language tag: a language subtag
+// of 2 to 8 letters followed by subtags of 1 to 8 letters or digits, separated by hyphens.
+func validLanguageTag(tag string) bool {
+	for i, subtag := range strings.Split(tag, "-") {
+		if len(subtag) < 1 || len(subtag) > 8 || (i == 0 && len(subtag) < 2) {
+			return false
+		}
+		for _, r := range subtag {
+			isLetter := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+			if !isLetter && (i == 0 || r < '0' || r > '9') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Localize returns a copy of def presented in the first of locales it has a translation for,
+// along with the tag of that translation. Locales are BCP 47 language tags in order of
+// preference, e.g. from an Accept-Language header. Tags are matched case-insensitively, and
+// a tag without a translation of its own falls back to its prefixes, so "de-CH" uses the "de"
+// translation if there is no "de-CH" one.
+//
+// The copy has the translated Label, Description, and ValueLabels, and no Translations. If
+// none of locales has a translation, the copy keeps the untranslated metadata and the
+// returned tag is empty.
+func (def PreferenceDefinition) Localize(locales ...string) (PreferenceDefinition, string) {
+	localized := def
+	localized.Translations = nil
+
+	tag, translation, ok := findTranslation(def.Translations, locales)
+	if !ok {
+		return localized, ""
+	}
+	if translation.Label != "" {
+		localized.Label = translation.Label
+	}
+	if translation.Description != "" {
+		localized.Description = translation.Description
+	}
+	if len(translation.ValueLabels) > 0 {
+		labels := make(map[string]string, len(def.ValueLabels)+len(translation.ValueLabels))
+		for value, label := range def.ValueLabels {
+			labels[value] = label
+		}
+		for value, label := range translation.ValueLabels {
+			labels[value] = label
+		}
+		localized.ValueLabels = labels
+	}
+	return localized, tag
+}
+
+// findTranslation returns the translation best matching locales, in the lookup order
+// described by Localize.
+func findTranslation(translations map[string]Translation, locales []string) (string, Translation, bool) {
+	if len(translations) == 0 {
+		return "", Translation{}, false
+	}
+	byTag := make(map[string]string, len(translations))
+	for tag := range translations {
+		byTag[strings.ToLower(tag)] = tag
+	}
+	for _, locale := range locales {
+		candidate := strings.ToLower(strings.TrimSpace(locale))
+		for candidate != "" {
+			if tag, ok := byTag[candidate]; ok {
+				return tag, translations[tag], true
+			}
+			cut := strings.LastIndexByte(candidate, '-')
+			if cut < 0 {
+				break
+			}
+			candidate = candidate[:cut]
+		}
+	}
+	return "", Translation{}, false
+}
@@ -0,0 +1,146 @@
+package userprefs
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestManager_DefinePreference_Presentation(t *testing.T) {
+	mgr := newTestManager(t, nil)
+
+	testCases := []struct {
+		name    string
+		def     PreferenceDefinition
+		wantErr bool
+	}{
+		{
+			name: "valid metadata",
+			def: PreferenceDefinition{
+				Key: "theme", Type: EnumType, AllowedValues: []interface{}{"light", "dark"},
+				Label: "Theme", Widget: WidgetRadio, ValueLabels: map[string]string{"dark": "Dark"},
+				Translations: map[string]Translation{"de": {Label: "Farbschema"}, "pt-BR": {ValueLabels: map[string]string{"light": "Claro"}}},
+			},
+		},
+		{
+			name: "numeric value labels",
+			def: PreferenceDefinition{
+				Key: "font_size", Type: IntType, AllowedValues: []interface{}{12, 14.0},
+				ValueLabels: map[string]string{"12": "Small", "14": "Medium"},
+			},
+		},
+		{
+			name: "labels without AllowedValues",
+			def:  PreferenceDefinition{Key: "nickname", Type: StringType, ValueLabels: map[string]string{"": "None"}},
+		},
+		{
+			name:    "unknown widget",
+			def:     PreferenceDefinition{Key: "a", Type: BoolType, Widget: "checkbox-ish"},
+			wantErr: true,
+		},
+		{
+			name:    "label for a value not allowed",
+			def:     PreferenceDefinition{Key: "b", Type: EnumType, AllowedValues: []interface{}{"light"}, ValueLabels: map[string]string{"dark": "Dark"}},
+			wantErr: true,
+		},
+		{
+			name: "translated label for a value not allowed",
+			def: PreferenceDefinition{Key: "c", Type: EnumType, AllowedValues: []interface{}{"light"},
+				Translations: map[string]Translation{"de": {ValueLabels: map[string]string{"dark": "Dunkel"}}}},
+			wantErr: true,
+		},
+		{
+			name:    "malformed language tag",
+			def:     PreferenceDefinition{Key: "d", Type: BoolType, Translations: map[string]Translation{"de_DE": {Label: "x"}}},
+			wantErr: true,
+		},
+		{
+			name:    "empty language tag",
+			def:     PreferenceDefinition{Key: "e", Type: BoolType, Translations: map[string]Translation{"": {Label: "x"}}},
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := mgr.DefinePreference(tc.def)
+			if tc.wantErr && !errors.Is(err, ErrInvalidInput) {
+				t.Errorf("Expected ErrInvalidInput, got %v", err)
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("DefinePreference failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestPreferenceDefinition_Localize(t *testing.T) {
+	def := PreferenceDefinition{
+		Key:         "theme",
+		Label:       "Theme",
+		Description: "How the app looks",
+		ValueLabels: map[string]string{"light": "Light", "dark": "Dark"},
+		Translations: map[string]Translation{
+			"de":    {Label: "Farbschema", ValueLabels: map[string]string{"dark": "Dunkel"}},
+			"pt-BR": {Label: "Tema", Description: "Aparência do app"},
+		},
+	}
+
+	testCases := []struct {
+		name       string
+		locales    []string
+		wantTag    string
+		wantLabel  string
+		wantDesc   string
+		wantLabels map[string]string
+	}{
+		{"no locales", nil, "", "Theme", "How the app looks", def.ValueLabels},
+		{"untranslated locale", []string{"fr"}, "", "Theme", "How the app looks", def.ValueLabels},
+		{"region falls back to language", []string{"de-CH"}, "de", "Farbschema", "How the app looks", map[string]string{"light": "Light", "dark": "Dunkel"}},
+		{"case-insensitive", []string{"PT-br"}, "pt-BR", "Tema", "Aparência do app", def.ValueLabels},
+		{"first match wins", []string{"fr", "pt-BR", "de"}, "pt-BR", "Tema", "Aparência do app", def.ValueLabels},
+		{"language does not match a region", []string{"pt"}, "", "Theme", "How the app looks", def.ValueLabels},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			localized, tag := def.Localize(tc.locales...)
+			if tag != tc.wantTag {
+				t.Errorf("Expected tag %q, got %q", tc.wantTag, tag)
+			}
+			if localized.Label != tc.wantLabel || localized.Description != tc.wantDesc {
+				t.Errorf("Expected %q / %q, got %q / %q", tc.wantLabel, tc.wantDesc, localized.Label, localized.Description)
+			}
+			if !reflect.DeepEqual(localized.ValueLabels, tc.wantLabels) {
+				t.Errorf("Expected value labels %v, got %v", tc.wantLabels, localized.ValueLabels)
+			}
+			if localized.Translations != nil {
+				t.Error("Expected the localized copy to have no translations")
+			}
+		})
+	}
+	if def.ValueLabels["dark"] != "Dark" || len(def.Translations) != 2 {
+		t.Error("Localize must not modify the definition")
+	}
+}
+
+func TestManager_GetAllDefinitions_DisplayOrder(t *testing.T) {
+	mgr := newTestManager(t, []PreferenceDefinition{
+		{Key: "language", Type: StringType, DisplayOrder: 2},
+		{Key: "theme", Type: StringType, DisplayOrder: 1},
+		{Key: "beta", Type: BoolType, DisplayOrder: 2},
+		{Key: "advanced", Type: BoolType},
+	})
+
+	defs, err := mgr.GetAllDefinitions(context.Background())
+	if err != nil {
+		t.Fatalf("GetAllDefinitions failed: %v", err)
+	}
+	var keys []string
+	for _, def := range defs {
+		keys = append(keys, def.Key)
+	}
+	want := []string{"advanced", "theme", "beta", "language"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("Expected order %v, got %v", want, keys)
+	}
+}
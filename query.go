@@ -0,0 +1,235 @@
+// Package userprefs provides queries for the users whose preference values match a predicate.
+package userprefs
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+)
+
+// findUsersPageSize is the maximum number of user IDs FindUsers returns per page.
+const findUsersPageSize = 500
+
+// PredicateOp is the comparison applied by a Predicate.
+type PredicateOp string
+
+const (
+	// PredicateEqual matches values equal to Predicate.Value.
+	PredicateEqual PredicateOp = "eq"
+	// PredicateRange matches numeric values between Predicate.Min and Predicate.Max, inclusive.
+	PredicateRange PredicateOp = "range"
+)
+
+// Predicate is a condition on preference values, used by Manager.FindUsers. Create one with
+// Equals or InRange.
+type Predicate struct {
+	// Op is the comparison to apply.
+	Op PredicateOp `json:"op"`
+	// Value is the value PredicateEqual compares against.
+	Value interface{} `json:"value"`
+	// Min is the inclusive lower bound of PredicateRange; nil leaves the range open below.
+	Min interface{} `json:"min"`
+	// Max is the inclusive upper bound of PredicateRange; nil leaves the range open above.
+	Max interface{} `json:"max"`
+}
+
+// Equals returns a Predicate matching values equal to value.
+func Equals(value interface{}) Predicate {
+	return Predicate{Op: PredicateEqual, Value: value}
+}
+
+// InRange returns a Predicate matching numeric values v with min <= v <= max. Either bound
+// may be nil to leave the range open on that side.
+func InRange(min, max interface{}) Predicate {
+	return Predicate{Op: PredicateRange, Min: min, Max: max}
+}
+
+// Matches reports whether value satisfies p. Numbers are compared by value regardless of
+// their Go type, so that values read back from JSON match. Storage backends that evaluate
+// predicates in memory use it on stored values.
+func (p Predicate) Matches(value interface{}) bool {
+	switch p.Op {
+	case PredicateEqual:
+		if a, ok := numericValue(value); ok {
+			b, ok := numericValue(p.Value)
+			return ok && a == b
+		}
+		return valuesEqual(value, p.Value)
+	case PredicateRange:
+		n, ok := numericValue(value)
+		if !ok {
+			return false
+		}
+		if min, ok := numericValue(p.Min); ok && n < min {
+			return false
+		}
+		if max, ok := numericValue(p.Max); ok && n > max {
+			return false
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// UserPage is a page of user IDs returned by Manager.FindUsers.
+type UserPage struct {
+	// UserIDs are the matching users, in ascending order.
+	UserIDs []string `json:"user_ids"`
+	// NextCursor is passed to FindUsers to fetch the next page. It is empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// FindUsers returns the users of the tenant in ctx (see WithTenant) whose value for key
+// matches pred, e.g. FindUsers(ctx, "newsletter.opt_in", Equals(true), ""). Results are
+// paginated: pass an empty cursor for the first page and the returned NextCursor for the next.
+//
+// The predicate is evaluated by the storage backend, which must implement UserFinder. Its
+// value is normalized like a value passed to Set, so Equals(5.0) finds the users of an int
+// preference set to 5. When the definition's DefaultValue matches, users without a stored value
+// for key are included, provided they have some other preference stored; users unknown to
+// storage cannot be listed. DefaultRules and DefaultFunc are not taken into account. Values
+// are compared as stored: values awaiting a Version migration, or stored under a key this one
+// replaces, are not converted first (see MigrateAll and RenameKey).
+//
+// Returns:
+//   - (*UserPage, nil): Up to 500 matching user IDs, and the cursor of the next page.
+//   - (nil, ErrInvalidInput): If key is empty, the cursor is malformed, or pred is not an
+//     Equals predicate with a value or an InRange predicate with a numeric bound on an int or
+//     float preference.
+//   - (nil, ErrPreferenceNotDefined): If the key has not been defined.
+//   - (nil, ErrInvalidValue): If the Equals value is not a valid value of the preference.
+//   - (nil, ErrNotSupported): If the preference is encrypted or holds lists or objects, or the
+//     storage backend does not implement UserFinder.
+//   - (nil, wrapped storage error): If the storage query fails.
+//
+// This method is thread-safe.
+func (m *Manager) FindUsers(ctx context.Context, key string, pred Predicate, cursor string) (_ *UserPage, err error) {
+	ctx, span := m.startOperationSpan(ctx, "FindUsers", AttrKey.String(key))
+	defer func() { endSpan(span, err) }()
+	// Users are matched on their own values, not on device overrides.
+	ctx = withoutDevice(ctx)
+	if key == "" {
+		return nil, ErrInvalidInput
+	}
+
+	def, exists := m.contextDefinition(ctx, key)
+	if !exists {
+		return nil, fmt.Errorf("%w: '%s'", ErrPreferenceNotDefined, key)
+	}
+	if def.Encrypted {
+		return nil, fmt.Errorf("%w: preference '%s' is encrypted and cannot be queried", ErrNotSupported, key)
+	}
+	pred, err = storedPredicate(pred, def)
+	if err != nil {
+		return nil, err
+	}
+	afterUserID, err := decodeUserCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	finder, ok := m.config.storage.(UserFinder)
+	if !ok {
+		return nil, fmt.Errorf("%w: storage does not implement UserFinder", ErrNotSupported)
+	}
+	if err = m.checkScope(ctx); err != nil {
+		return nil, err
+	}
+
+	query := UserQuery{
+		Key:            key,
+		Predicate:      pred,
+		IncludeMissing: defaultMatches(pred, def),
+		AfterUserID:    afterUserID,
+		Limit:          findUsersPageSize + 1, // One more than a page tells whether another follows.
+	}
+	spanCtx, storageSpan := m.startSpan(ctx, "userprefs.storage.FindUsers", AttrKey.String(key))
+	userIDs, err := finder.FindUsers(spanCtx, query)
+	endSpan(storageSpan, err)
+	if err != nil {
+		m.config.logger.Error("Storage FindUsers failed", "key", key, "error", err)
+		return nil, fmt.Errorf("storage.FindUsers failed for key '%s': %w", key, err)
+	}
+
+	page := &UserPage{UserIDs: userIDs}
+	if len(userIDs) > findUsersPageSize {
+		page.UserIDs = userIDs[:findUsersPageSize]
+		page.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(page.UserIDs[findUsersPageSize-1]))
+	}
+	if page.UserIDs == nil {
+		page.UserIDs = []string{}
+	}
+	return page, nil
+}
+
+// storedPredicate validates pred for def and converts its operands to the form values of
+// def are stored in, so that storage backends can compare them with stored values directly.
+func storedPredicate(pred Predicate, def PreferenceDefinition) (Predicate, error) {
+	switch pred.Op {
+	case PredicateEqual:
+		if pred.Value == nil {
+			return pred, fmt.Errorf("%w: equality predicate on '%s' has no value", ErrInvalidInput, def.Key)
+		}
+		value, err := checkValue(pred.Value, def)
+		if err != nil {
+			return pred, err
+		}
+		if value, err = encodeValue(value, def); err != nil {
+			return pred, err
+		}
+		switch value.(type) {
+		case string, bool, int, float64:
+		default:
+			return pred, fmt.Errorf("%w: preference '%s' of type '%s' cannot be compared for equality", ErrNotSupported, def.Key, def.Type)
+		}
+		return Equals(value), nil
+
+	case PredicateRange:
+		if def.Type != IntType && def.Type != FloatType {
+			return pred, fmt.Errorf("%w: range predicate on non-numeric preference '%s'", ErrInvalidInput, def.Key)
+		}
+		var bounds [2]interface{}
+		for i, bound := range []interface{}{pred.Min, pred.Max} {
+			if bound == nil {
+				continue
+			}
+			n, ok := numericValue(bound)
+			if !ok {
+				return pred, fmt.Errorf("%w: range predicate on '%s' has a non-numeric bound %v", ErrInvalidInput, def.Key, bound)
+			}
+			bounds[i] = n
+		}
+		if bounds[0] == nil && bounds[1] == nil {
+			return pred, fmt.Errorf("%w: range predicate on '%s' has no bounds", ErrInvalidInput, def.Key)
+		}
+		if bounds[0] != nil && bounds[1] != nil && bounds[0].(float64) > bounds[1].(float64) {
+			return pred, fmt.Errorf("%w: range predicate on '%s' has min greater than max", ErrInvalidInput, def.Key)
+		}
+		return InRange(bounds[0], bounds[1]), nil
+
+	default:
+		return pred, fmt.Errorf("%w: unsupported predicate operator '%s'", ErrInvalidInput, pred.Op)
+	}
+}
+
+// defaultMatches reports whether the DefaultValue of def, in its stored form, satisfies pred.
+func defaultMatches(pred Predicate, def PreferenceDefinition) bool {
+	if def.DefaultValue == nil {
+		return false
+	}
+	value, err := encodeValue(canonicalValue(def.DefaultValue, def, false), def)
+	return err == nil && pred.Matches(value)
+}
+
+// decodeUserCursor returns the user ID a FindUsers cursor resumes after.
+func decodeUserCursor(cursor string) (string, error) {
+	if cursor == "" {
+		return "", nil
+	}
+	userID, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(userID) == 0 {
+		return "", fmt.Errorf("%w: malformed cursor", ErrInvalidInput)
+	}
+	return string(userID), nil
+}
@@ -0,0 +1,168 @@
+package userprefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// storageOnly hides the optional interfaces of a Storage.
+type storageOnly struct {
+	Storage
+}
+
+func TestPredicate_Matches(t *testing.T) {
+	testCases := []struct {
+		name  string
+		pred  Predicate
+		value interface{}
+		want  bool
+	}{
+		{"equal bool", Equals(true), true, true},
+		{"unequal bool", Equals(true), false, false},
+		{"equal string", Equals("dark"), "dark", true},
+		{"int equals float", Equals(5), 5.0, true},
+		{"number never equals string", Equals(5), "5", false},
+		{"within range", InRange(1, 10), 10, true},
+		{"below range", InRange(1.5, nil), 1, false},
+		{"open below", InRange(nil, 3), -100.0, true},
+		{"range rejects strings", InRange(nil, 3), "1", false},
+		{"unknown operator", Predicate{Op: "like"}, "x", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.pred.Matches(tc.value); got != tc.want {
+				t.Errorf("Matches(%v) = %v, want %v", tc.value, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestManager_FindUsers(t *testing.T) {
+	ctx := context.Background()
+	mgr := newTestManager(t, []PreferenceDefinition{
+		{Key: "newsletter.opt_in", Type: BoolType, DefaultValue: false},
+		{Key: "digest", Type: BoolType, DefaultValue: true},
+		{Key: "age", Type: IntType, DefaultValue: 0},
+		{Key: "nickname", Type: StringType, DefaultValue: ""},
+	})
+	set := func(userID, key string, value interface{}) {
+		t.Helper()
+		if err := mgr.Set(ctx, userID, key, value); err != nil {
+			t.Fatalf("Set(%s, %s) failed: %v", userID, key, err)
+		}
+	}
+	set("u1", "newsletter.opt_in", true)
+	set("u2", "newsletter.opt_in", false)
+	set("u3", "newsletter.opt_in", true)
+	set("u4", "nickname", "dee")
+	set("u1", "digest", false)
+	set("u1", "age", 42)
+	set("u2", "age", 17)
+
+	testCases := []struct {
+		name string
+		key  string
+		pred Predicate
+		want []string
+	}{
+		{"explicit values only", "newsletter.opt_in", Equals(true), []string{"u1", "u3"}},
+		{"default matches", "newsletter.opt_in", Equals(false), []string{"u2", "u4"}},
+		{"default matches for users without the key", "digest", Equals(true), []string{"u2", "u3", "u4"}},
+		{"normalized value", "age", Equals(42.0), []string{"u1"}},
+		{"range", "age", InRange(18, nil), []string{"u1"}},
+		{"range including default", "age", InRange(nil, 17), []string{"u2", "u3", "u4"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			page, err := mgr.FindUsers(ctx, tc.key, tc.pred, "")
+			if err != nil {
+				t.Fatalf("FindUsers failed: %v", err)
+			}
+			if !reflect.DeepEqual(page.UserIDs, tc.want) || page.NextCursor != "" {
+				t.Errorf("Expected %v without a next page, got %v (cursor %q)", tc.want, page.UserIDs, page.NextCursor)
+			}
+		})
+	}
+
+	errorCases := []struct {
+		name   string
+		key    string
+		pred   Predicate
+		cursor string
+		want   error
+	}{
+		{"empty key", "", Equals(true), "", ErrInvalidInput},
+		{"undefined key", "missing", Equals(true), "", ErrPreferenceNotDefined},
+		{"invalid value", "age", Equals("old"), "", ErrInvalidValue},
+		{"nil value", "age", Equals(nil), "", ErrInvalidInput},
+		{"range on string", "nickname", InRange("a", "b"), "", ErrInvalidInput},
+		{"unbounded range", "age", InRange(nil, nil), "", ErrInvalidInput},
+		{"inverted range", "age", InRange(10, 1), "", ErrInvalidInput},
+		{"unknown operator", "age", Predicate{Op: "like"}, "", ErrInvalidInput},
+		{"malformed cursor", "age", Equals(1), "!!", ErrInvalidInput},
+	}
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := mgr.FindUsers(ctx, tc.key, tc.pred, tc.cursor); !errors.Is(err, tc.want) {
+				t.Errorf("Expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestManager_FindUsers_Pagination(t *testing.T) {
+	ctx := context.Background()
+	mgr := newTestManager(t, []PreferenceDefinition{{Key: "opt_in", Type: BoolType, DefaultValue: false}})
+	total := findUsersPageSize + 3
+	for i := 0; i < total; i++ {
+		if err := mgr.Set(ctx, fmt.Sprintf("user%04d", i), "opt_in", true); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+
+	var found []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 2 {
+			t.Fatal("Pagination did not terminate")
+		}
+		page, err := mgr.FindUsers(ctx, "opt_in", Equals(true), cursor)
+		if err != nil {
+			t.Fatalf("FindUsers failed: %v", err)
+		}
+		found = append(found, page.UserIDs...)
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	if len(found) != total || found[0] != "user0000" || found[total-1] != fmt.Sprintf("user%04d", total-1) {
+		t.Errorf("Expected %d users in order, got %d", total, len(found))
+	}
+}
+
+func TestManager_FindUsers_NotSupported(t *testing.T) {
+	ctx := context.Background()
+	adapter, err := NewEncryptionAdapterWithKey([]byte("this-is-a-32-byte-key-for-test!!"))
+	if err != nil {
+		t.Fatalf("NewEncryptionAdapterWithKey failed: %v", err)
+	}
+	encrypted := newTestManager(t, []PreferenceDefinition{{Key: "token", Type: StringType, Encrypted: true}}, WithEncryption(adapter))
+	if _, err := encrypted.FindUsers(ctx, "token", Equals("secret"), ""); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported for an encrypted key, got %v", err)
+	}
+
+	plain := newTestManager(t, []PreferenceDefinition{
+		{Key: "opt_in", Type: BoolType, DefaultValue: false},
+		{Key: "tags", Type: StringListType},
+	}, WithStorage(storageOnly{NewMockStorage()}))
+	if _, err := plain.FindUsers(ctx, "opt_in", Equals(true), ""); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported without UserFinder, got %v", err)
+	}
+	if _, err := plain.FindUsers(ctx, "tags", Equals([]string{"a"}), ""); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported for a list preference, got %v", err)
+	}
+}
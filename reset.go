@@ -0,0 +1,201 @@
+// Package userprefs provides operations that restore a user's preferences to their defaults.
+package userprefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Reset restores the given preferences of a user to their defaults by removing the stored
+// values. All values are removed in one storage round-trip when the backend implements
+// BatchDeleter. The affected cache entries are invalidated, and registered ChangeListeners
+// receive a ChangeReset event per key carrying the default the user now falls back to.
+// Values stored under deprecated keys that the given keys replace (see ReplacedBy) are
+// removed as well, so that reads do not fall back to them.
+// With a device in ctx (see WithDevice), the device's overrides of PerDevice keys are removed
+// too; other devices keep theirs.
+//
+// Defaults are not re-validated against dependencies or CrossFieldValidators.
+//
+// Returns:
+//   - nil: On success, including for keys that had no stored value.
+//   - ErrInvalidInput: If userID is empty or no keys are given.
+//   - ErrPreferenceNotDefined: If any key has not been defined.
+//   - ErrForbidden: If the actor in the context may not change any one of the keys.
+//   - A wrapped storage error: If the storage deletion fails.
+//
+// This method is thread-safe.
+func (m *Manager) Reset(ctx context.Context, userID string, keys ...string) (err error) {
+	ctx, span := m.startOperationSpan(ctx, "Reset", AttrKeyCount.Int(len(keys)))
+	defer func() { endSpan(span, err) }()
+	if userID == "" || len(keys) == 0 {
+		return ErrInvalidInput
+	}
+
+	defs := make([]PreferenceDefinition, 0, len(keys))
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if key == "" {
+			return ErrInvalidInput
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		def, exists := m.contextDefinition(ctx, key)
+		if !exists {
+			return fmt.Errorf("%w: '%s'", ErrPreferenceNotDefined, key)
+		}
+		if err := checkWriteAccess(ctx, def); err != nil {
+			return err
+		}
+		defs = append(defs, def)
+	}
+
+	return m.reset(ctx, userID, defs)
+}
+
+// ResetCategory restores every preference in category to its default for a user, as Reset does.
+// Preferences the actor in the context may not change (see ReadOnly and WritableBy) are
+// skipped rather than rejected, so that an end user's "restore defaults" leaves
+// admin-managed settings untouched. An unknown or empty category resets nothing.
+//
+// Returns:
+//   - nil: On success.
+//   - ErrInvalidInput: If userID is empty.
+//   - A wrapped storage error: If the storage deletion fails.
+//
+// This method is thread-safe.
+func (m *Manager) ResetCategory(ctx context.Context, userID, category string) (err error) {
+	ctx, span := m.startOperationSpan(ctx, "ResetCategory", AttrCategory.String(category))
+	defer func() { endSpan(span, err) }()
+	if userID == "" {
+		return ErrInvalidInput
+	}
+	return m.reset(ctx, userID, m.resettableDefinitions(ctx, func(def PreferenceDefinition) bool {
+		return def.Category == category
+	}))
+}
+
+// ResetAll restores every defined preference of the tenant in ctx to its default for a user,
+// as Reset does. Like ResetCategory, preferences the actor may not change are skipped.
+//
+// Returns:
+//   - nil: On success.
+//   - ErrInvalidInput: If userID is empty.
+//   - A wrapped storage error: If the storage deletion fails.
+//
+// This method is thread-safe.
+func (m *Manager) ResetAll(ctx context.Context, userID string) (err error) {
+	ctx, span := m.startOperationSpan(ctx, "ResetAll")
+	defer func() { endSpan(span, err) }()
+	if userID == "" {
+		return ErrInvalidInput
+	}
+	return m.reset(ctx, userID, m.resettableDefinitions(ctx, func(PreferenceDefinition) bool {
+		return true
+	}))
+}
+
+// resettableDefinitions returns the definitions of the tenant in ctx that match and that
+// the actor in ctx may change.
+func (m *Manager) resettableDefinitions(ctx context.Context, match func(PreferenceDefinition) bool) []PreferenceDefinition {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var defs []PreferenceDefinition
+	for _, def := range m.definitionsFor(TenantFromContext(ctx)) {
+		if match(def) && checkWriteAccess(ctx, def) == nil {
+			defs = append(defs, def)
+		}
+	}
+	return defs
+}
+
+// reset removes the stored values of defs, and of the deprecated keys they replace, for a
+// user, along with the overrides of the device in ctx, invalidates their cache entries, and
+// notifies ChangeListeners.
+func (m *Manager) reset(ctx context.Context, userID string, defs []PreferenceDefinition) error {
+	if len(defs) == 0 {
+		return nil
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Key < defs[j].Key })
+
+	seen := make(map[string]bool, len(defs))
+	keys := make([]string, 0, len(defs))
+	for _, def := range defs {
+		for _, key := range append([]string{def.Key}, definitionKeys(m.replacedDefinitions(ctx, def.Key))...) {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	if err := m.deleteMany(withoutDevice(ctx), userID, keys); err != nil {
+		return err
+	}
+	if DeviceFromContext(ctx) != "" {
+		var overrides []string
+		for _, def := range defs {
+			if def.PerDevice {
+				overrides = append(overrides, def.Key)
+			}
+		}
+		if len(overrides) > 0 {
+			if err := m.deleteMany(ctx, userID, overrides); err != nil {
+				return err
+			}
+		}
+	}
+
+	if m.config.cache != nil {
+		for _, key := range keys {
+			m.deleteFromCache(ctx, userID, key)
+		}
+	}
+
+	m.config.logger.Debug("Reset preferences to defaults", "userID", userID, "count", len(defs))
+	for _, def := range defs {
+		m.notifyDefault(ctx, userID, def, ChangeReset)
+	}
+	return nil
+}
+
+// deleteMany removes a user's stored values for keys, in one round-trip if the storage
+// backend implements BatchDeleter and one key at a time otherwise.
+func (m *Manager) deleteMany(ctx context.Context, userID string, keys []string) error {
+	if err := m.checkScope(ctx); err != nil {
+		return err
+	}
+	if deleter, ok := m.config.storage.(BatchDeleter); ok {
+		spanCtx, span := m.startSpan(ctx, "userprefs.storage.DeleteMany", AttrKeyCount.Int(len(keys)))
+		err := deleter.DeleteMany(spanCtx, userID, keys)
+		endSpan(span, err)
+		if err != nil {
+			m.config.logger.Error("Storage DeleteMany failed", "userID", userID, "keys", keys, "error", err)
+			return fmt.Errorf("storage.DeleteMany failed for userID '%s': %w", userID, err)
+		}
+		return nil
+	}
+
+	for _, key := range keys {
+		if err := m.storageDelete(ctx, userID, key); err != nil && !errors.Is(err, ErrNotFound) {
+			m.config.logger.Error("Storage Delete failed", "userID", userID, "key", key, "error", err)
+			return fmt.Errorf("storage.Delete failed for key '%s': %w", key, err)
+		}
+	}
+	return nil
+}
+
+// definitionKeys returns the keys of defs.
+func definitionKeys(defs []PreferenceDefinition) []string {
+	keys := make([]string, len(defs))
+	for i, def := range defs {
+		keys[i] = def.Key
+	}
+	return keys
+}
@@ -0,0 +1,152 @@
+package userprefs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// eventRecorder collects ChangeEvents delivered to a ChangeListener.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []ChangeEvent
+}
+
+func (r *eventRecorder) listen(_ context.Context, event ChangeEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *eventRecorder) take() []ChangeEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
+
+// resetDefinitions returns two appearance preferences and two general ones, one of them read-only.
+func resetDefinitions() []PreferenceDefinition {
+	return []PreferenceDefinition{
+		{Key: "theme", Type: StringType, DefaultValue: "light", Category: "appearance"},
+		{Key: "font_size", Type: IntType, DefaultValue: 12, Category: "appearance"},
+		{Key: "language", Type: StringType, DefaultValue: "en", Category: "general"},
+		{Key: "plan", Type: StringType, DefaultValue: "free", Category: "general", ReadOnly: true},
+	}
+}
+
+// seedResetValues stores a non-default value for every reset definition and drains the
+// resulting events from recorder.
+func seedResetValues(t *testing.T, mgr *Manager, recorder *eventRecorder) {
+	t.Helper()
+	values := map[string]interface{}{"theme": "dark", "font_size": 16, "language": "de", "plan": "pro"}
+	if err := mgr.SetMany(context.Background(), "u1", values); err != nil {
+		t.Fatalf("SetMany failed: %v", err)
+	}
+	if events := recorder.take(); len(events) != len(values) || events[0].Kind != ChangeSet {
+		t.Fatalf("Expected %d set events, got %+v", len(values), events)
+	}
+}
+
+func TestManager_Reset(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMockCache()
+	recorder := &eventRecorder{}
+	mgr := newTestManager(t, resetDefinitions(), WithCache(cache), WithChangeListener(recorder.listen))
+	seedResetValues(t, mgr, recorder)
+
+	if err := mgr.Reset(ctx, "u1", "theme", "font_size", "theme"); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+
+	all, err := mgr.GetAll(ctx, "u1")
+	if err != nil {
+		t.Fatalf("GetAll failed: %v", err)
+	}
+	if all["theme"].Value != "light" || all["font_size"].Value != 12 || all["language"].Value != "de" {
+		t.Errorf("Expected only theme and font_size to be reset, got theme=%v font_size=%v language=%v",
+			all["theme"].Value, all["font_size"].Value, all["language"].Value)
+	}
+
+	cache.mu.RLock()
+	_, cached := cache.data["pref:u1:theme"]
+	cache.mu.RUnlock()
+	if cached {
+		t.Error("Expected the cache entry of a reset key to be invalidated")
+	}
+
+	events := recorder.take()
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 reset events, got %+v", events)
+	}
+	if events[0].Key != "font_size" || events[0].Kind != ChangeReset || events[0].Value != 12 || events[0].UserID != "u1" {
+		t.Errorf("Unexpected reset event: %+v", events[0])
+	}
+
+	if err := mgr.Reset(ctx, "u1"); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput without keys, got %v", err)
+	}
+	if err := mgr.Reset(ctx, "u1", "unknown"); !errors.Is(err, ErrPreferenceNotDefined) {
+		t.Errorf("Expected ErrPreferenceNotDefined, got %v", err)
+	}
+	userCtx := WithActor(ctx, Actor{ID: "u1", Role: ActorUser})
+	if err := mgr.Reset(userCtx, "u1", "plan"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected ErrForbidden resetting a read-only key as a user, got %v", err)
+	}
+}
+
+func TestManager_ResetCategory_ResetAll(t *testing.T) {
+	ctx := context.Background()
+	recorder := &eventRecorder{}
+	mgr := newTestManager(t, resetDefinitions(), WithChangeListener(recorder.listen))
+	seedResetValues(t, mgr, recorder)
+	userCtx := WithActor(ctx, Actor{ID: "u1", Role: ActorUser})
+
+	if err := mgr.ResetCategory(userCtx, "u1", "general"); err != nil {
+		t.Fatalf("ResetCategory failed: %v", err)
+	}
+	all, _ := mgr.GetAll(ctx, "u1")
+	if all["language"].Value != "en" || all["plan"].Value != "pro" || all["theme"].Value != "dark" {
+		t.Errorf("Expected only language to be reset, got language=%v plan=%v theme=%v",
+			all["language"].Value, all["plan"].Value, all["theme"].Value)
+	}
+	if events := recorder.take(); len(events) != 1 || events[0].Key != "language" {
+		t.Errorf("Expected a single reset event for language, got %+v", events)
+	}
+
+	if err := mgr.ResetAll(ctx, "u1"); err != nil {
+		t.Fatalf("ResetAll failed: %v", err)
+	}
+	all, _ = mgr.GetAll(ctx, "u1")
+	for key, pref := range all {
+		if pref.Value != pref.DefaultValue {
+			t.Errorf("Expected %s to be reset to %v, got %v", key, pref.DefaultValue, pref.Value)
+		}
+	}
+	if events := recorder.take(); len(events) != 4 {
+		t.Errorf("Expected 4 reset events, got %d", len(events))
+	}
+
+	if err := mgr.ResetCategory(ctx, "u1", "unknown"); err != nil {
+		t.Errorf("Expected resetting an unknown category to be a no-op, got %v", err)
+	}
+	if err := mgr.ResetAll(ctx, ""); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput, got %v", err)
+	}
+}
+
+func TestManager_Reset_RemovesReplacedKeys(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMockStorage()
+	mgr := newTestManager(t, deprecationDefinitions(false), WithStorage(storage))
+	storeRaw(t, storage, "u1", "dark_mode", true)
+
+	if err := mgr.Reset(ctx, "u1", "theme"); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	if pref, _ := mgr.Get(ctx, "u1", "theme"); pref.Value != "system" {
+		t.Errorf("Expected theme to fall back to its default rather than dark_mode, got %v", pref.Value)
+	}
+}
@@ -0,0 +1,121 @@
+// Package userprefs provides statistics on the distribution of preference values.
+package userprefs
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	// statsBuckets is the number of histogram buckets Stats uses for int and float preferences.
+	statsBuckets = 10
+	// statsMaxValues is the number of distinct values Stats reports for other preferences.
+	statsMaxValues = 100
+)
+
+// PreferenceStats describes how the users of a tenant have set a preference. It contains
+// counts only, never individual users.
+type PreferenceStats struct {
+	// Key is the preference key.
+	Key string `json:"key"`
+	// DefaultValue is the definition's DefaultValue.
+	DefaultValue interface{} `json:"default_value"`
+	// ExplicitCount is the number of users with a stored value, even one equal to the default.
+	ExplicitCount int `json:"explicit_count"`
+	// DefaultCount is the number of users without a stored value, who therefore see the
+	// default. Only users with some other preference stored are known and counted.
+	DefaultCount int `json:"default_count"`
+	// Values counts the most frequent explicit values, most frequent first. It is used for
+	// preferences that are not numeric, and for stored values of numeric preferences that are
+	// not numbers.
+	Values []ValueCount `json:"values,omitempty"`
+	// Buckets is a histogram of the explicit values of int and float preferences, in equal-width
+	// buckets spanning the smallest to the largest stored value.
+	Buckets []NumericBucket `json:"buckets,omitempty"`
+	// OtherCount is the number of explicit values that are in neither Values nor Buckets,
+	// because they are less frequent than the values listed.
+	OtherCount int `json:"other_count,omitempty"`
+}
+
+// ValueCount is the number of users who stored Value.
+type ValueCount struct {
+	// Value is the value in its stored form, as decoded from JSON.
+	Value interface{} `json:"value"`
+	// Count is the number of users with the value.
+	Count int `json:"count"`
+}
+
+// NumericBucket is a histogram bucket of numeric values v with Min <= v < Max. The last
+// bucket of a histogram also includes Max.
+type NumericBucket struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Count int     `json:"count"`
+}
+
+// Stats reports how the users of the tenant in ctx (see WithTenant) have set key: how many
+// chose a value, how many are on the default, and a histogram of the chosen values, e.g. to
+// see how many users switched to dark mode before changing the default theme.
+//
+// The statistics are computed by the storage backend, which must implement StatsProvider.
+// Values are counted as stored: values of rich types appear in their stored encoding, and
+// DefaultRules and DefaultFunc are not taken into account. Encrypted preferences are excluded,
+// as their distribution would reveal information about the protected values.
+//
+// Returns:
+//   - (*PreferenceStats, nil): On success.
+//   - (nil, ErrInvalidInput): If key is empty.
+//   - (nil, ErrPreferenceNotDefined): If the key has not been defined.
+//   - (nil, ErrNotSupported): If the preference is encrypted or the storage backend does not
+//     implement StatsProvider.
+//   - (nil, wrapped storage error): If the storage query fails.
+//
+// This method is thread-safe.
+func (m *Manager) Stats(ctx context.Context, key string) (_ *PreferenceStats, err error) {
+	ctx, span := m.startOperationSpan(ctx, "Stats", AttrKey.String(key))
+	defer func() { endSpan(span, err) }()
+	// Statistics count the users' own values, not device overrides.
+	ctx = withoutDevice(ctx)
+	if key == "" {
+		return nil, ErrInvalidInput
+	}
+
+	def, exists := m.contextDefinition(ctx, key)
+	if !exists {
+		return nil, fmt.Errorf("%w: '%s'", ErrPreferenceNotDefined, key)
+	}
+	if def.Encrypted {
+		return nil, fmt.Errorf("%w: preference '%s' is encrypted and has no statistics", ErrNotSupported, key)
+	}
+
+	provider, ok := m.config.storage.(StatsProvider)
+	if !ok {
+		return nil, fmt.Errorf("%w: storage does not implement StatsProvider", ErrNotSupported)
+	}
+	if err = m.checkScope(ctx); err != nil {
+		return nil, err
+	}
+
+	query := StatsQuery{Key: key, MaxValues: statsMaxValues}
+	if def.Type == IntType || def.Type == FloatType {
+		query.Buckets = statsBuckets
+	}
+	spanCtx, storageSpan := m.startSpan(ctx, "userprefs.storage.ValueStats", AttrKey.String(key))
+	stats, err := provider.ValueStats(spanCtx, query)
+	endSpan(storageSpan, err)
+	if err != nil {
+		m.config.logger.Error("Storage ValueStats failed", "key", key, "error", err)
+		return nil, fmt.Errorf("storage.ValueStats failed for key '%s': %w", key, err)
+	}
+
+	stats.Key = key
+	stats.DefaultValue = def.DefaultValue
+	stats.OtherCount = stats.ExplicitCount
+	for _, value := range stats.Values {
+		stats.OtherCount -= value.Count
+	}
+	for _, bucket := range stats.Buckets {
+		stats.OtherCount -= bucket.Count
+	}
+	return stats, nil
+}
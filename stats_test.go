@@ -0,0 +1,83 @@
+package userprefs
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestManager_Stats(t *testing.T) {
+	ctx := context.Background()
+	mgr := newTestManager(t, []PreferenceDefinition{
+		{Key: "theme", Type: StringType, DefaultValue: "light", AllowedValues: []interface{}{"light", "dark", "blue"}},
+		{Key: "font_size", Type: IntType, DefaultValue: 12},
+	})
+	set := func(userID, key string, value interface{}) {
+		t.Helper()
+		if err := mgr.Set(ctx, userID, key, value); err != nil {
+			t.Fatalf("Set(%s, %s) failed: %v", userID, key, err)
+		}
+	}
+	set("u1", "theme", "dark")
+	set("u2", "theme", "dark")
+	set("u3", "theme", "light")
+	set("u4", "font_size", 10)
+	set("u1", "font_size", 20)
+
+	stats, err := mgr.Stats(ctx, "theme")
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.Key != "theme" || stats.DefaultValue != "light" {
+		t.Errorf("Unexpected key or default: %+v", stats)
+	}
+	if stats.ExplicitCount != 3 || stats.DefaultCount != 1 {
+		t.Errorf("Expected 3 explicit and 1 default, got %d and %d", stats.ExplicitCount, stats.DefaultCount)
+	}
+	wantValues := []ValueCount{{Value: "dark", Count: 2}, {Value: "light", Count: 1}}
+	if !reflect.DeepEqual(stats.Values, wantValues) {
+		t.Errorf("Expected values %v, got %v", wantValues, stats.Values)
+	}
+	if stats.Buckets != nil || stats.OtherCount != 0 {
+		t.Errorf("Expected no buckets or other values, got %v and %d", stats.Buckets, stats.OtherCount)
+	}
+
+	stats, err = mgr.Stats(ctx, "font_size")
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.ExplicitCount != 2 || stats.DefaultCount != 2 {
+		t.Errorf("Expected 2 explicit and 2 default, got %d and %d", stats.ExplicitCount, stats.DefaultCount)
+	}
+	if len(stats.Buckets) != statsBuckets || stats.Buckets[0].Min != 10 || stats.Buckets[statsBuckets-1].Max != 20 {
+		t.Errorf("Expected %d buckets from 10 to 20, got %v", statsBuckets, stats.Buckets)
+	}
+	if stats.Buckets[0].Count != 1 || stats.Buckets[statsBuckets-1].Count != 1 {
+		t.Errorf("Expected the extremes in the first and last buckets, got %v", stats.Buckets)
+	}
+
+	if _, err := mgr.Stats(ctx, ""); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput, got %v", err)
+	}
+	if _, err := mgr.Stats(ctx, "missing"); !errors.Is(err, ErrPreferenceNotDefined) {
+		t.Errorf("Expected ErrPreferenceNotDefined, got %v", err)
+	}
+}
+
+func TestManager_Stats_NotSupported(t *testing.T) {
+	ctx := context.Background()
+	adapter, err := NewEncryptionAdapterWithKey([]byte("this-is-a-32-byte-key-for-test!!"))
+	if err != nil {
+		t.Fatalf("NewEncryptionAdapterWithKey failed: %v", err)
+	}
+	encrypted := newTestManager(t, []PreferenceDefinition{{Key: "token", Type: StringType, Encrypted: true}}, WithEncryption(adapter))
+	if _, err := encrypted.Stats(ctx, "token"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported for an encrypted key, got %v", err)
+	}
+
+	plain := newTestManager(t, []PreferenceDefinition{{Key: "theme", Type: StringType}}, WithStorage(storageOnly{NewMockStorage()}))
+	if _, err := plain.Stats(ctx, "theme"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported without StatsProvider, got %v", err)
+	}
+}
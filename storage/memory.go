@@ -2,6 +2,10 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
 	"sync"
 	"time"
 
@@ -14,34 +18,75 @@ import (
 //
 // MemoryStorage is safe for concurrent use by multiple goroutines due to its
 // internal use of a sync.RWMutex to synchronize access to the preferences map.
-// The internal map `prefs` stores preferences nested by userID and then by preference key.
+// The internal map `prefs` stores preferences nested by tenant and device, then by userID, and
+// then by preference key. The tenant and device are taken from the context with
+// userprefs.TenantFromContext and userprefs.DeviceFromContext; the users' own values are kept
+// under the empty device ID. Deletions are recorded in `tombstones`, nested the same way.
 type MemoryStorage struct {
-	mu    sync.RWMutex
-	prefs map[string]map[string]*userprefs.Preference // userID -> key -> Preference
+	mu          sync.RWMutex
+	prefs       map[memoryScope]map[string]map[string]*userprefs.Preference // scope -> userID -> key -> Preference
+	tombstones  map[memoryScope]map[string]map[string]time.Time             // scope -> userID -> key -> deletion time
+	definitions map[string]map[string][]byte                                // tenantID -> key -> JSON definition
+}
+
+// memoryScope identifies the tenant and device whose preferences a MemoryStorage map holds.
+type memoryScope struct {
+	tenantID string
+	deviceID string
 }
 
 // NewMemoryStorage creates and returns a new, initialized instance of MemoryStorage.
 // The returned MemoryStorage is ready for immediate use.
 func NewMemoryStorage() *MemoryStorage {
 	return &MemoryStorage{
-		prefs: make(map[string]map[string]*userprefs.Preference),
+		prefs:       make(map[memoryScope]map[string]map[string]*userprefs.Preference),
+		tombstones:  make(map[memoryScope]map[string]map[string]time.Time),
+		definitions: make(map[string]map[string][]byte),
+	}
+}
+
+// users returns the preferences of the tenant and device in ctx, keyed by user ID. If create
+// is true, a missing map is created. The caller must hold s.mu (for writing if create is true).
+func (s *MemoryStorage) users(ctx context.Context, create bool) map[string]map[string]*userprefs.Preference {
+	scope := memoryScope{tenantID: userprefs.TenantFromContext(ctx), deviceID: userprefs.DeviceFromContext(ctx)}
+	users, ok := s.prefs[scope]
+	if !ok && create {
+		users = make(map[string]map[string]*userprefs.Preference)
+		s.prefs[scope] = users
+	}
+	return users
+}
+
+// bury records the deletion of userID's keys in the tenant and device in ctx.
+// The caller must hold s.mu for writing.
+func (s *MemoryStorage) bury(ctx context.Context, userID string, keys ...string) {
+	scope := memoryScope{tenantID: userprefs.TenantFromContext(ctx), deviceID: userprefs.DeviceFromContext(ctx)}
+	if s.tombstones[scope] == nil {
+		s.tombstones[scope] = make(map[string]map[string]time.Time)
+	}
+	if s.tombstones[scope][userID] == nil {
+		s.tombstones[scope][userID] = make(map[string]time.Time)
+	}
+	now := time.Now()
+	for _, key := range keys {
+		s.tombstones[scope][userID][key] = now
 	}
 }
 
 // Get retrieves a specific preference for a given user ID and key.
-// The provided context.Context is not used by this in-memory implementation but is
-// part of the userprefs.Storage interface contract.
+// The provided context.Context selects the tenant and device; it is otherwise not used by this
+// in-memory implementation.
 //
 // If the preference is found, it returns a *copy* of the userprefs.Preference and a nil error.
 // Returning a copy ensures that modifications to the retrieved preference do not affect
 // the data stored in MemoryStorage.
 // If the preference for the given userID and key does not exist, it returns nil and
 // userprefs.ErrNotFound.
-func (s *MemoryStorage) Get(_ context.Context, userID, key string) (*userprefs.Preference, error) {
+func (s *MemoryStorage) Get(ctx context.Context, userID, key string) (*userprefs.Preference, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	userPrefs, ok := s.prefs[userID]
+	userPrefs, ok := s.users(ctx, false)[userID]
 	if !ok {
 		return nil, userprefs.ErrNotFound
 	}
@@ -57,42 +102,45 @@ func (s *MemoryStorage) Get(_ context.Context, userID, key string) (*userprefs.P
 }
 
 // Set stores or updates a user's preference.
-// The provided context.Context is not used by this in-memory implementation but is
-// part of the userprefs.Storage interface contract.
+// The provided context.Context selects the tenant and device; it is otherwise not used by this
+// in-memory implementation.
 //
 // A *copy* of the provided userprefs.Preference is stored to prevent external modifications
 // from affecting the data within MemoryStorage.
 // The UpdatedAt field of the stored preference is automatically set to the current time.
 // This method always returns a nil error.
-func (s *MemoryStorage) Set(_ context.Context, pref *userprefs.Preference) error {
+func (s *MemoryStorage) Set(ctx context.Context, pref *userprefs.Preference) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, ok := s.prefs[pref.UserID]; !ok {
-		s.prefs[pref.UserID] = make(map[string]*userprefs.Preference)
+	users := s.users(ctx, true)
+	if _, ok := users[pref.UserID]; !ok {
+		users[pref.UserID] = make(map[string]*userprefs.Preference)
 	}
 
 	// Make a copy to store, ensuring original pref is not modified by storage
 	// and to manage UpdatedAt consistently.
 	prefToStore := *pref
 	prefToStore.UpdatedAt = time.Now()
-	s.prefs[pref.UserID][pref.Key] = &prefToStore
+	users[pref.UserID][pref.Key] = &prefToStore
 	return nil
 }
 
 // Delete removes a specific preference for a given user ID and key.
-// The provided context.Context is not used by this in-memory implementation but is
-// part of the userprefs.Storage interface contract.
+// The provided context.Context selects the tenant and device; it is otherwise not used by this
+// in-memory implementation.
 //
 // If the preference for the given userID and key does not exist, it returns
-// userprefs.ErrNotFound. Otherwise, it deletes the preference and returns nil.
+// userprefs.ErrNotFound. Otherwise, it deletes the preference, records a tombstone for
+// ChangesSince, and returns nil.
 // If the deletion results in a user having no more preferences, the entry for
 // that user is removed from the internal map to save space.
-func (s *MemoryStorage) Delete(_ context.Context, userID, key string) error {
+func (s *MemoryStorage) Delete(ctx context.Context, userID, key string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	userPrefs, ok := s.prefs[userID]
+	users := s.users(ctx, false)
+	userPrefs, ok := users[userID]
 	if !ok {
 		return userprefs.ErrNotFound
 	}
@@ -102,27 +150,118 @@ func (s *MemoryStorage) Delete(_ context.Context, userID, key string) error {
 	}
 
 	delete(userPrefs, key)
+	s.bury(ctx, userID, key)
 	// If the user has no more preferences, remove the user's map entry
 	if len(userPrefs) == 0 {
-		delete(s.prefs, userID)
+		delete(users, userID)
 	}
 	return nil
 }
 
+// DeleteMany removes the preferences of userID stored under any of keys. It implements the
+// userprefs.BatchDeleter interface. Keys without a stored value are ignored; a tombstone is
+// recorded for each removed value. The provided context.Context selects the tenant and device.
+// This method always returns a nil error.
+func (s *MemoryStorage) DeleteMany(ctx context.Context, userID string, keys []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := s.users(ctx, false)
+	userPrefs, ok := users[userID]
+	if !ok {
+		return nil
+	}
+	for _, key := range keys {
+		if _, ok := userPrefs[key]; ok {
+			delete(userPrefs, key)
+			s.bury(ctx, userID, key)
+		}
+	}
+	if len(userPrefs) == 0 {
+		delete(users, userID)
+	}
+	return nil
+}
+
+// DeleteAll removes every preference of userID and returns the removed keys in sorted order.
+// It implements the userprefs.UserDeleter interface, and removes the overrides of every device
+// (see userprefs.WithDevice) and the user's tombstones as well. The provided context.Context
+// selects the tenant.
+// This method always returns a nil error.
+func (s *MemoryStorage) DeleteAll(ctx context.Context, userID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tenantID := userprefs.TenantFromContext(ctx)
+	seen := make(map[string]bool)
+	keys := []string{}
+	for scope, users := range s.prefs {
+		if scope.tenantID != tenantID {
+			continue
+		}
+		for key := range users[userID] {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		delete(users, userID)
+	}
+	for scope, users := range s.tombstones {
+		if scope.tenantID == tenantID {
+			delete(users, userID)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// GetForUsers retrieves the preferences stored under any of keys for any of userIDs, keyed
+// by user ID and then by key. It implements the userprefs.MultiUserGetter interface.
+// The provided context.Context selects the tenant and device.
+//
+// The returned preferences are *copies*. Users without any of the keys are absent from the result.
+// This method always returns a nil error.
+func (s *MemoryStorage) GetForUsers(ctx context.Context, userIDs, keys []string) (map[string]map[string]*userprefs.Preference, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := s.users(ctx, false)
+	result := make(map[string]map[string]*userprefs.Preference)
+	for _, userID := range userIDs {
+		userPrefs, ok := users[userID]
+		if !ok {
+			continue
+		}
+		for _, key := range keys {
+			pref, ok := userPrefs[key]
+			if !ok {
+				continue
+			}
+			if result[userID] == nil {
+				result[userID] = make(map[string]*userprefs.Preference)
+			}
+			prefCopy := *pref
+			result[userID][key] = &prefCopy
+		}
+	}
+	return result, nil
+}
+
 // GetAll retrieves all preferences associated with the given user ID.
-// The provided context.Context is not used by this in-memory implementation but is
-// part of the userprefs.Storage interface contract.
+// The provided context.Context selects the tenant and device; it is otherwise not used by this
+// in-memory implementation.
 //
 // It returns a map where keys are preference keys and values are *copies* of
 // userprefs.Preference objects. Returning copies ensures immutability of stored data.
 // If the user ID is not found or the user has no preferences, an empty map and a nil error
 // are returned.
 // This method always returns a nil error.
-func (s *MemoryStorage) GetAll(_ context.Context, userID string) (map[string]*userprefs.Preference, error) {
+func (s *MemoryStorage) GetAll(ctx context.Context, userID string) (map[string]*userprefs.Preference, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	userPrefs, ok := s.prefs[userID]
+	userPrefs, ok := s.users(ctx, false)[userID]
 	if !ok {
 		return make(map[string]*userprefs.Preference), nil // Return empty map if user not found
 	}
@@ -137,19 +276,19 @@ func (s *MemoryStorage) GetAll(_ context.Context, userID string) (map[string]*us
 }
 
 // GetByCategory retrieves all preferences for a given user ID that belong to the specified category.
-// The provided context.Context is not used by this in-memory implementation but is
-// part of the userprefs.Storage interface contract.
+// The provided context.Context selects the tenant and device; it is otherwise not used by this
+// in-memory implementation.
 //
 // It returns a map where keys are preference keys and values are *copies* of
 // userprefs.Preference objects matching the category. Returning copies ensures immutability.
 // If the user ID is not found, or if no preferences match the category for that user,
 // an empty map and a nil error are returned.
 // This method always returns a nil error.
-func (s *MemoryStorage) GetByCategory(_ context.Context, userID, category string) (map[string]*userprefs.Preference, error) {
+func (s *MemoryStorage) GetByCategory(ctx context.Context, userID, category string) (map[string]*userprefs.Preference, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	userPrefs, ok := s.prefs[userID]
+	userPrefs, ok := s.users(ctx, false)[userID]
 	if !ok {
 		return make(map[string]*userprefs.Preference), nil // Return empty map if user not found
 	}
@@ -164,6 +303,258 @@ func (s *MemoryStorage) GetByCategory(_ context.Context, userID, category string
 	return result, nil
 }
 
+// ListByKey returns up to limit preferences stored under key, ordered by user ID and
+// starting strictly after afterUserID. It implements the userprefs.KeyLister interface.
+// The provided context.Context selects the tenant and device.
+//
+// The returned preferences are *copies*, ensuring immutability of stored data.
+// A non-positive limit returns an empty slice. This method always returns a nil error.
+func (s *MemoryStorage) ListByKey(ctx context.Context, key, afterUserID string, limit int) ([]*userprefs.Preference, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := s.users(ctx, false)
+	userIDs := make([]string, 0, len(users))
+	for userID, userPrefs := range users {
+		if _, ok := userPrefs[key]; ok && userID > afterUserID {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	sort.Strings(userIDs)
+
+	if limit < 0 {
+		limit = 0
+	}
+	if len(userIDs) > limit {
+		userIDs = userIDs[:limit]
+	}
+
+	result := make([]*userprefs.Preference, 0, len(userIDs))
+	for _, userID := range userIDs {
+		prefCopy := *users[userID][key]
+		result = append(result, &prefCopy)
+	}
+	return result, nil
+}
+
+// FindUsers returns up to query.Limit IDs of users whose stored value for query.Key matches
+// query.Predicate, ordered by user ID and starting strictly after query.AfterUserID. With
+// query.IncludeMissing, users without a value for the key are returned as well. It implements
+// the userprefs.UserFinder interface with a scan of the tenant's preferences.
+// The provided context.Context selects the tenant; device overrides are not considered.
+// This method always returns a nil error.
+func (s *MemoryStorage) FindUsers(ctx context.Context, query userprefs.UserQuery) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	userIDs := make([]string, 0)
+	for userID, userPrefs := range s.users(userprefs.WithDevice(ctx, ""), false) {
+		if userID <= query.AfterUserID {
+			continue
+		}
+		pref, ok := userPrefs[query.Key]
+		if (ok && query.Predicate.Matches(pref.Value)) || (!ok && query.IncludeMissing) {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	sort.Strings(userIDs)
+
+	if query.Limit >= 0 && len(userIDs) > query.Limit {
+		userIDs = userIDs[:query.Limit]
+	}
+	return userIDs, nil
+}
+
+// ValueStats aggregates the stored values of query.Key: user counts, the most frequent values,
+// and, for query.Buckets > 0, a histogram of numeric values. It implements the
+// userprefs.StatsProvider interface with a scan of the tenant's preferences.
+// The provided context.Context selects the tenant; device overrides are not considered.
+// This method always returns a nil error.
+func (s *MemoryStorage) ValueStats(ctx context.Context, query userprefs.StatsQuery) (*userprefs.PreferenceStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := &userprefs.PreferenceStats{}
+	var numbers []float64
+	var values []userprefs.ValueCount
+	for _, userPrefs := range s.users(userprefs.WithDevice(ctx, ""), false) {
+		pref, ok := userPrefs[query.Key]
+		if !ok {
+			stats.DefaultCount++
+			continue
+		}
+		stats.ExplicitCount++
+		if n, ok := numericValue(pref.Value); ok && query.Buckets > 0 {
+			numbers = append(numbers, n)
+			continue
+		}
+		values = countValue(values, pref.Value)
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Count != values[j].Count {
+			return values[i].Count > values[j].Count
+		}
+		return fmt.Sprint(values[i].Value) < fmt.Sprint(values[j].Value)
+	})
+	if query.MaxValues >= 0 && len(values) > query.MaxValues {
+		values = values[:query.MaxValues]
+	}
+	stats.Values = values
+
+	if len(numbers) > 0 {
+		minValue, maxValue := numbers[0], numbers[0]
+		for _, n := range numbers {
+			minValue, maxValue = min(minValue, n), max(maxValue, n)
+		}
+		counts := make(map[int]int)
+		for _, n := range numbers {
+			counts[bucketIndex(n, minValue, maxValue, query.Buckets)]++
+		}
+		stats.Buckets = numericHistogram(minValue, maxValue, query.Buckets, counts)
+	}
+	return stats, nil
+}
+
+// countValue adds one occurrence of value to values.
+func countValue(values []userprefs.ValueCount, value interface{}) []userprefs.ValueCount {
+	for i := range values {
+		if reflect.DeepEqual(values[i].Value, value) {
+			values[i].Count++
+			return values
+		}
+	}
+	return append(values, userprefs.ValueCount{Value: value, Count: 1})
+}
+
+// numericValue converts Go numeric types to float64.
+func numericValue(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	default:
+		return 0, false
+	}
+}
+
+// ChangesSince returns copies of userID's preferences updated after since, and the tombstones
+// recorded after since for keys without a stored value, both in key order. It implements the
+// userprefs.ChangeTracker interface. The provided context.Context selects the tenant and device.
+// This method always returns a nil error.
+func (s *MemoryStorage) ChangesSince(ctx context.Context, userID string, since time.Time) ([]*userprefs.Preference, []userprefs.Tombstone, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	userPrefs := s.users(ctx, false)[userID]
+	prefs := make([]*userprefs.Preference, 0)
+	for _, pref := range userPrefs {
+		if pref.UpdatedAt.After(since) {
+			prefCopy := *pref
+			prefs = append(prefs, &prefCopy)
+		}
+	}
+	sort.Slice(prefs, func(i, j int) bool { return prefs[i].Key < prefs[j].Key })
+
+	scope := memoryScope{tenantID: userprefs.TenantFromContext(ctx), deviceID: userprefs.DeviceFromContext(ctx)}
+	tombstones := make([]userprefs.Tombstone, 0)
+	for key, deletedAt := range s.tombstones[scope][userID] {
+		if _, stored := userPrefs[key]; !stored && deletedAt.After(since) {
+			tombstones = append(tombstones, userprefs.Tombstone{Key: key, DeletedAt: deletedAt})
+		}
+	}
+	sort.Slice(tombstones, func(i, j int) bool { return tombstones[i].Key < tombstones[j].Key })
+	return prefs, tombstones, nil
+}
+
+// PruneTombstones removes the tombstones of every tenant, device, and user recorded before
+// before, and returns how many were removed. It implements the userprefs.TombstonePruner
+// interface. This method always returns a nil error.
+func (s *MemoryStorage) PruneTombstones(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pruned int64
+	for scope, users := range s.tombstones {
+		for userID, keys := range users {
+			for key, deletedAt := range keys {
+				if deletedAt.Before(before) {
+					delete(keys, key)
+					pruned++
+				}
+			}
+			if len(keys) == 0 {
+				delete(users, userID)
+			}
+		}
+		if len(users) == 0 {
+			delete(s.tombstones, scope)
+		}
+	}
+	return pruned, nil
+}
+
+// SaveDefinition creates or replaces the definition of def.Key in tenantID's catalogue.
+// It implements the userprefs.DefinitionStore interface. Definitions are kept as JSON, like
+// in the SQL backends, so that LoadDefinitions returns the same values they would.
+// It returns an error wrapping userprefs.ErrSerialization if def cannot be marshalled.
+func (s *MemoryStorage) SaveDefinition(ctx context.Context, tenantID string, def userprefs.PreferenceDefinition) error {
+	data, err := json.Marshal(def)
+	if err != nil {
+		return fmt.Errorf("%w: memory: failed to marshal definition for key '%s': %v", userprefs.ErrSerialization, def.Key, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.definitions[tenantID] == nil {
+		s.definitions[tenantID] = make(map[string][]byte)
+	}
+	s.definitions[tenantID][def.Key] = data
+	return nil
+}
+
+// DeleteDefinition removes key from tenantID's catalogue. It implements the
+// userprefs.DefinitionStore interface; deleting a key that is not stored is not an error.
+// This method always returns a nil error.
+func (s *MemoryStorage) DeleteDefinition(ctx context.Context, tenantID, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.definitions[tenantID], key)
+	if len(s.definitions[tenantID]) == 0 {
+		delete(s.definitions, tenantID)
+	}
+	return nil
+}
+
+// LoadDefinitions returns the stored definitions of every tenant, keyed by tenant ID and then
+// by key. It implements the userprefs.DefinitionStore interface.
+// Each call returns newly unmarshalled definitions that the caller may modify.
+func (s *MemoryStorage) LoadDefinitions(ctx context.Context) (map[string]map[string]userprefs.PreferenceDefinition, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	definitions := make(map[string]map[string]userprefs.PreferenceDefinition)
+	for tenantID, stored := range s.definitions {
+		for _, data := range stored {
+			if err := addDefinition(definitions, "memory", tenantID, data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return definitions, nil
+}
+
+// ScopesByContext reports that every operation is scoped to the tenant and device in its
+// context. It implements the userprefs.ScopedStorage interface.
+func (s *MemoryStorage) ScopesByContext() bool {
+	return true
+}
+
 // Close is a no-op for MemoryStorage.
 // Since MemoryStorage operates entirely in-memory without external resources like
 // database connections or file handles, there is nothing to release or clean up.
@@ -224,3 +224,326 @@ func TestMemoryStorage_GetAll_GetByCategory(t *testing.T) {
 		assert.Empty(t, retrieved, "GetByCategory for non-existent user should return an empty map")
 	})
 }
+
+func TestMemoryStorage_ListByKey(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+
+	for _, userID := range []string{"userC", "userA", "userB"} {
+		require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: userID, Key: "theme", Value: "dark", Version: 1}))
+	}
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "userA", Key: "other", Value: "x"}))
+
+	page, err := storage.ListByKey(ctx, "theme", "", 2)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, "userA", page[0].UserID)
+	assert.Equal(t, "userB", page[1].UserID)
+	assert.Equal(t, 1, page[0].Version)
+
+	page, err = storage.ListByKey(ctx, "theme", "userB", 2)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, "userC", page[0].UserID)
+
+	page, err = storage.ListByKey(ctx, "missing", "", 10)
+	require.NoError(t, err)
+	assert.Empty(t, page)
+}
+
+func TestMemoryStorage_DeleteMany(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+
+	for _, key := range []string{"theme", "volume"} {
+		require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u1", Key: key, Value: "x"}))
+	}
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u2", Key: "theme", Value: "x"}))
+
+	require.NoError(t, storage.DeleteMany(ctx, "u1", []string{"theme", "volume", "missing"}))
+	all, err := storage.GetAll(ctx, "u1")
+	require.NoError(t, err)
+	assert.Empty(t, all)
+
+	_, err = storage.Get(ctx, "u2", "theme")
+	assert.NoError(t, err, "DeleteMany should not affect other users")
+	assert.NoError(t, storage.DeleteMany(ctx, "unknown", []string{"theme"}))
+}
+
+func TestMemoryStorage_DeleteAll(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+
+	for _, key := range []string{"volume", "theme"} {
+		require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u1", Key: key, Value: "x"}))
+	}
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u2", Key: "theme", Value: "x"}))
+
+	keys, err := storage.DeleteAll(ctx, "u1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"theme", "volume"}, keys)
+
+	all, err := storage.GetAll(ctx, "u1")
+	require.NoError(t, err)
+	assert.Empty(t, all)
+	_, err = storage.Get(ctx, "u2", "theme")
+	assert.NoError(t, err, "DeleteAll should not affect other users")
+
+	keys, err = storage.DeleteAll(ctx, "u1")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestMemoryStorage_GetForUsers(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u1", Key: "theme", Value: "dark"}))
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u1", Key: "volume", Value: 3}))
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u2", Key: "theme", Value: "light"}))
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u3", Key: "theme", Value: "blue"}))
+	require.NoError(t, storage.Set(userprefs.WithTenant(ctx, "acme"), &userprefs.Preference{UserID: "u4", Key: "theme", Value: "red"}))
+
+	got, err := storage.GetForUsers(ctx, []string{"u1", "u2", "u4", "unknown"}, []string{"theme", "volume"})
+	require.NoError(t, err)
+	require.Len(t, got, 2, "Only users with stored values in the tenant should be returned")
+	assert.Equal(t, "dark", got["u1"]["theme"].Value)
+	assert.Equal(t, 3, got["u1"]["volume"].Value)
+	assert.Equal(t, "light", got["u2"]["theme"].Value)
+	assert.NotContains(t, got["u2"], "volume")
+
+	got["u1"]["theme"].Value = "mutated"
+	pref, err := storage.Get(ctx, "u1", "theme")
+	require.NoError(t, err)
+	assert.Equal(t, "dark", pref.Value, "GetForUsers should return copies")
+}
+
+func TestMemoryStorage_FindUsers(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u1", Key: "opt_in", Value: true}))
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u2", Key: "opt_in", Value: false}))
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u3", Key: "theme", Value: "dark"}))
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u4", Key: "opt_in", Value: true}))
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u1", Key: "age", Value: 30}))
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u2", Key: "age", Value: float64(17)}))
+	require.NoError(t, storage.Set(userprefs.WithTenant(ctx, "acme"), &userprefs.Preference{UserID: "u5", Key: "opt_in", Value: true}))
+
+	found, err := storage.FindUsers(ctx, userprefs.UserQuery{Key: "opt_in", Predicate: userprefs.Equals(true), Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"u1", "u4"}, found)
+
+	found, err = storage.FindUsers(ctx, userprefs.UserQuery{Key: "opt_in", Predicate: userprefs.Equals(true), IncludeMissing: true, Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"u1", "u3", "u4"}, found, "Users without the key should be included")
+
+	found, err = storage.FindUsers(ctx, userprefs.UserQuery{Key: "opt_in", Predicate: userprefs.Equals(true), IncludeMissing: true, AfterUserID: "u1", Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"u3"}, found)
+
+	found, err = storage.FindUsers(ctx, userprefs.UserQuery{Key: "age", Predicate: userprefs.InRange(18.0, nil), Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"u1"}, found)
+}
+
+func TestMemoryStorage_ValueStats(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+
+	set := func(userID, key string, value interface{}) {
+		require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: userID, Key: key, Value: value}))
+	}
+	set("u1", "theme", "dark")
+	set("u2", "theme", "dark")
+	set("u3", "theme", "light")
+	set("u4", "volume", 0)
+	set("u1", "volume", 10)
+	set("u2", "volume", 5.0)
+	set("u3", "volume", "loud")
+	set("u5", "language", "en")
+
+	stats, err := storage.ValueStats(ctx, userprefs.StatsQuery{Key: "theme", MaxValues: 10})
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.ExplicitCount)
+	assert.Equal(t, 2, stats.DefaultCount)
+	assert.Equal(t, []userprefs.ValueCount{{Value: "dark", Count: 2}, {Value: "light", Count: 1}}, stats.Values)
+	assert.Empty(t, stats.Buckets)
+
+	stats, err = storage.ValueStats(ctx, userprefs.StatsQuery{Key: "theme", MaxValues: 1})
+	require.NoError(t, err)
+	assert.Len(t, stats.Values, 1, "Values should be limited to MaxValues")
+
+	stats, err = storage.ValueStats(ctx, userprefs.StatsQuery{Key: "volume", Buckets: 2, MaxValues: 10})
+	require.NoError(t, err)
+	assert.Equal(t, 4, stats.ExplicitCount)
+	assert.Equal(t, 1, stats.DefaultCount)
+	assert.Equal(t, []userprefs.ValueCount{{Value: "loud", Count: 1}}, stats.Values)
+	assert.Equal(t, []userprefs.NumericBucket{{Min: 0, Max: 5, Count: 1}, {Min: 5, Max: 10, Count: 2}}, stats.Buckets)
+}
+
+func TestMemoryStorage_TenantIsolation(t *testing.T) {
+	storage := NewMemoryStorage()
+	acme := userprefs.WithTenant(context.Background(), "acme")
+	globex := userprefs.WithTenant(context.Background(), "globex")
+
+	require.NoError(t, storage.Set(acme, &userprefs.Preference{UserID: "u1", Key: "theme", Value: "dark", Category: "ui"}))
+	require.NoError(t, storage.Set(globex, &userprefs.Preference{UserID: "u1", Key: "theme", Value: "light", Category: "ui"}))
+
+	pref, err := storage.Get(acme, "u1", "theme")
+	require.NoError(t, err)
+	assert.Equal(t, "dark", pref.Value)
+
+	_, err = storage.Get(context.Background(), "u1", "theme")
+	assert.ErrorIs(t, err, userprefs.ErrNotFound, "The default tenant should not see other tenants' preferences")
+
+	byCategory, err := storage.GetByCategory(globex, "u1", "ui")
+	require.NoError(t, err)
+	assert.Equal(t, "light", byCategory["theme"].Value)
+
+	require.NoError(t, storage.Delete(acme, "u1", "theme"))
+	all, err := storage.GetAll(globex, "u1")
+	require.NoError(t, err)
+	assert.Len(t, all, 1, "Deleting in one tenant should not affect another")
+}
+
+func TestMemoryStorage_DeviceScoping(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+	mobile := userprefs.WithDevice(ctx, "mobile")
+
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u1", Key: "layout", Value: "comfortable", Category: "ui"}))
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u1", Key: "theme", Value: "dark", Category: "ui"}))
+	require.NoError(t, storage.Set(mobile, &userprefs.Preference{UserID: "u1", Key: "layout", Value: "compact", Category: "ui"}))
+
+	pref, err := storage.Get(mobile, "u1", "layout")
+	require.NoError(t, err)
+	assert.Equal(t, "compact", pref.Value)
+	pref, err = storage.Get(ctx, "u1", "layout")
+	require.NoError(t, err)
+	assert.Equal(t, "comfortable", pref.Value, "The device's override should not replace the user's own value")
+
+	byCategory, err := storage.GetByCategory(mobile, "u1", "ui")
+	require.NoError(t, err)
+	assert.Len(t, byCategory, 1, "A device should only see its own overrides")
+
+	users, err := storage.FindUsers(mobile, userprefs.UserQuery{Key: "layout", Predicate: userprefs.Equals("compact"), Limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, users, "FindUsers should only search the users' own values")
+
+	keys, err := storage.DeleteAll(ctx, "u1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"layout", "theme"}, keys, "DeleteAll should report each key once")
+	_, err = storage.Get(mobile, "u1", "layout")
+	assert.ErrorIs(t, err, userprefs.ErrNotFound, "DeleteAll should remove the overrides of every device")
+}
+
+func TestMemoryStorage_ChangesSince(t *testing.T) {
+	storage := NewMemoryStorage()
+
+	ctx := context.Background()
+	mobile := userprefs.WithDevice(ctx, "mobile")
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u1", Key: "theme", Value: "dark", Type: "string", UpdatedAt: time.Now()}))
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u1", Key: "volume", Value: float64(5), Type: "int", UpdatedAt: time.Now()}))
+	since := time.Now()
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u1", Key: "layout", Value: "comfortable", Type: "enum", UpdatedAt: time.Now()}))
+	require.NoError(t, storage.Set(mobile, &userprefs.Preference{UserID: "u1", Key: "layout", Value: "compact", Type: "enum", UpdatedAt: time.Now()}))
+	require.NoError(t, storage.Delete(ctx, "u1", "volume"))
+
+	changed, deleted, err := storage.ChangesSince(ctx, "u1", since)
+	require.NoError(t, err)
+	require.Len(t, changed, 1)
+	assert.Equal(t, "layout", changed[0].Key)
+	assert.Equal(t, "comfortable", changed[0].Value)
+	require.Len(t, deleted, 1)
+	assert.Equal(t, "volume", deleted[0].Key)
+	assert.True(t, deleted[0].DeletedAt.After(since))
+
+	changed, deleted, err = storage.ChangesSince(mobile, "u1", since)
+	require.NoError(t, err)
+	require.Len(t, changed, 1, "A device should only see its own changes")
+	assert.Equal(t, "compact", changed[0].Value)
+	assert.Empty(t, deleted)
+
+	changed, deleted, err = storage.ChangesSince(ctx, "u1", time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+	assert.Empty(t, deleted)
+
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u1", Key: "volume", Value: float64(7), Type: "int", UpdatedAt: time.Now()}))
+	require.NoError(t, storage.DeleteMany(ctx, "u1", []string{"theme", "missing"}))
+	changed, deleted, err = storage.ChangesSince(ctx, "u1", since)
+	require.NoError(t, err)
+	require.Len(t, changed, 2)
+	assert.Equal(t, "volume", changed[1].Key)
+	require.Len(t, deleted, 1, "A key set again after its deletion should not be reported as deleted")
+	assert.Equal(t, "theme", deleted[0].Key)
+
+	_, err = storage.DeleteAll(ctx, "u1")
+	require.NoError(t, err)
+	changed, deleted, err = storage.ChangesSince(ctx, "u1", since)
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+	assert.Empty(t, deleted, "DeleteAll should remove the user's tombstones")
+}
+
+func TestMemoryStorage_PruneTombstones(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+	acme := userprefs.WithTenant(ctx, "acme")
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u1", Key: "theme", Value: "dark", Type: "string", UpdatedAt: time.Now()}))
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u1", Key: "volume", Value: float64(5), Type: "int", UpdatedAt: time.Now()}))
+	require.NoError(t, storage.Set(acme, &userprefs.Preference{UserID: "u2", Key: "theme", Value: "dark", Type: "string", UpdatedAt: time.Now()}))
+	require.NoError(t, storage.Delete(ctx, "u1", "volume"))
+	require.NoError(t, storage.Delete(acme, "u2", "theme"))
+	time.Sleep(10 * time.Millisecond)
+	cutoff := time.Now()
+	require.NoError(t, storage.Delete(ctx, "u1", "theme"))
+
+	pruned, err := storage.PruneTombstones(ctx, cutoff)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), pruned, "Tombstones of every tenant recorded before the cutoff should be pruned")
+
+	_, deleted, err := storage.ChangesSince(ctx, "u1", time.Time{})
+	require.NoError(t, err)
+	require.Len(t, deleted, 1)
+	assert.Equal(t, "theme", deleted[0].Key, "Tombstones recorded after the cutoff should be kept")
+	_, deleted, err = storage.ChangesSince(acme, "u2", time.Time{})
+	require.NoError(t, err)
+	assert.Empty(t, deleted)
+
+	pruned, err = storage.PruneTombstones(ctx, cutoff)
+	require.NoError(t, err)
+	assert.Zero(t, pruned)
+}
+
+func TestMemoryStorage_Definitions(t *testing.T) {
+	storage := NewMemoryStorage()
+	ctx := context.Background()
+
+	theme := userprefs.PreferenceDefinition{Key: "theme", Type: "enum", DefaultValue: "light", AllowedValues: []interface{}{"light", "dark"}}
+	volume := userprefs.PreferenceDefinition{Key: "volume", Type: "int", DefaultValue: 5}
+	require.NoError(t, storage.SaveDefinition(ctx, "", theme))
+	require.NoError(t, storage.SaveDefinition(ctx, "", volume))
+	require.NoError(t, storage.SaveDefinition(ctx, "acme", volume))
+
+	volume.DefaultValue = 7
+	require.NoError(t, storage.SaveDefinition(ctx, "", volume), "Saving should replace the stored definition")
+
+	definitions, err := storage.LoadDefinitions(ctx)
+	require.NoError(t, err)
+	require.Len(t, definitions, 2)
+	assert.Equal(t, theme, definitions[""]["theme"])
+	assert.Equal(t, 7.0, definitions[""]["volume"].DefaultValue, "Numbers should be read back as decoded from JSON")
+	assert.Equal(t, 5.0, definitions["acme"]["volume"].DefaultValue)
+
+	require.NoError(t, storage.DeleteDefinition(ctx, "", "theme"))
+	require.NoError(t, storage.DeleteDefinition(ctx, "", "missing"), "Deleting a missing definition should succeed")
+	require.NoError(t, storage.DeleteDefinition(ctx, "acme", "volume"))
+
+	definitions, err = storage.LoadDefinitions(ctx)
+	require.NoError(t, err)
+	assert.Len(t, definitions, 1, "A tenant without definitions should not be returned")
+	assert.Len(t, definitions[""], 1)
+	assert.Contains(t, definitions[""], "volume")
+}
@@ -11,7 +11,7 @@ import (
 	"strings"
 	"time"
 
-	_ "github.com/lib/pq" // PostgreSQL driver
+	"github.com/lib/pq" // PostgreSQL driver
 
 	"github.com/CreativeUnicorns/userprefs"
 )
@@ -150,6 +150,7 @@ func WithPostgresConnMaxIdleTime(d time.Duration) PostgresOption {
 const (
 	createTableSQL = `
 		CREATE TABLE IF NOT EXISTS user_preferences (
+			tenant_id TEXT NOT NULL DEFAULT '',
 			user_id TEXT NOT NULL,
 			key TEXT NOT NULL,
 			value JSONB NOT NULL,
@@ -157,45 +158,241 @@ const (
 			type TEXT NOT NULL,
 			category TEXT,
 			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			PRIMARY KEY (user_id, key)
+			version INTEGER NOT NULL DEFAULT 0,
+			device_id TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (tenant_id, user_id, key, device_id)
 		);
 		
 		CREATE INDEX IF NOT EXISTS idx_user_preferences_category 
 		ON user_preferences(user_id, category);
+
+		ALTER TABLE user_preferences ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE user_preferences ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT '';
+		ALTER TABLE user_preferences ADD COLUMN IF NOT EXISTS device_id TEXT NOT NULL DEFAULT '';
+
+		DO $$
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.key_column_usage
+				WHERE table_schema = current_schema() AND table_name = 'user_preferences'
+					AND constraint_name = 'user_preferences_pkey' AND column_name = 'device_id'
+			) THEN
+				ALTER TABLE user_preferences DROP CONSTRAINT user_preferences_pkey;
+				ALTER TABLE user_preferences ADD PRIMARY KEY (tenant_id, user_id, key, device_id);
+			END IF;
+		END $$;
+
+		DROP INDEX IF EXISTS idx_user_preferences_key_value;
+
+		CREATE INDEX IF NOT EXISTS idx_user_preferences_key_scalar 
+		ON user_preferences(tenant_id, key, md5(value #>> '{}')) 
+		WHERE jsonb_typeof(value) IN ('string', 'number', 'boolean');
+
+		CREATE TABLE IF NOT EXISTS preference_tombstones (
+			tenant_id TEXT NOT NULL DEFAULT '',
+			device_id TEXT NOT NULL DEFAULT '',
+			user_id TEXT NOT NULL,
+			key TEXT NOT NULL,
+			deleted_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (tenant_id, user_id, key, device_id)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_preference_tombstones_deleted_at 
+		ON preference_tombstones(deleted_at);
+
+		CREATE TABLE IF NOT EXISTS preference_definitions (
+			tenant_id TEXT NOT NULL DEFAULT '',
+			key TEXT NOT NULL,
+			definition JSONB NOT NULL,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (tenant_id, key)
+		);
 	`
 
 	insertSQL = `
-		INSERT INTO user_preferences (user_id, key, value, default_value, type, category, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		ON CONFLICT (user_id, key) 
-		DO UPDATE SET value = $3, default_value = $4, updated_at = $7
+		INSERT INTO user_preferences (tenant_id, device_id, user_id, key, value, default_value, type, category, updated_at, version)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (tenant_id, user_id, key, device_id) 
+		DO UPDATE SET value = $5, default_value = $6, updated_at = $9, version = $10
 	`
 
 	selectSQL = `
-		SELECT user_id, key, value, default_value, type, category, updated_at 
+		SELECT user_id, key, value, default_value, type, category, updated_at, version 
 		FROM user_preferences 
-		WHERE user_id = $1 AND key = $2
+		WHERE tenant_id = $1 AND device_id = $2 AND user_id = $3 AND key = $4
 	`
 
 	selectByCategorySQL = `
-		SELECT user_id, key, value, default_value, type, category, updated_at 
+		SELECT user_id, key, value, default_value, type, category, updated_at, version 
 		FROM user_preferences 
-		WHERE user_id = $1 AND category = $2
+		WHERE tenant_id = $1 AND device_id = $2 AND user_id = $3 AND category = $4
 	`
 
 	selectAllSQL = `
-		SELECT user_id, key, value, default_value, type, category, updated_at 
+		SELECT user_id, key, value, default_value, type, category, updated_at, version 
 		FROM user_preferences 
-		WHERE user_id = $1
+		WHERE tenant_id = $1 AND device_id = $2 AND user_id = $3
 	`
 
+	selectByKeySQL = `
+		SELECT user_id, key, value, default_value, type, category, updated_at, version 
+		FROM user_preferences 
+		WHERE tenant_id = $1 AND device_id = $2 AND key = $3 AND user_id > $4
+		ORDER BY user_id
+		LIMIT $5
+	`
+
+	// deleteSQL deletes a preference and records its tombstone. Its affected row count is
+	// the number of deleted preferences.
 	deleteSQL = `
+		WITH deleted AS (
+			DELETE FROM user_preferences 
+			WHERE tenant_id = $1 AND device_id = $2 AND user_id = $3 AND key = $4
+			RETURNING tenant_id, device_id, user_id, key
+		)
+		INSERT INTO preference_tombstones (tenant_id, device_id, user_id, key, deleted_at)
+		SELECT tenant_id, device_id, user_id, key, $5 FROM deleted
+		ON CONFLICT (tenant_id, user_id, key, device_id) 
+		DO UPDATE SET deleted_at = EXCLUDED.deleted_at
+	`
+
+	// deleteAllSQL deletes every preference and tombstone of a user and returns the deleted keys.
+	deleteAllSQL = `
+		WITH tombstones AS (
+			DELETE FROM preference_tombstones 
+			WHERE tenant_id = $1 AND user_id = $2
+		)
 		DELETE FROM user_preferences 
-		WHERE user_id = $1 AND key = $2
+		WHERE tenant_id = $1 AND user_id = $2
+		RETURNING key
+	`
+
+	selectForUsersSQL = `
+		SELECT user_id, key, value, default_value, type, category, updated_at, version 
+		FROM user_preferences 
+		WHERE tenant_id = $1 AND device_id = $2 AND user_id = ANY($3) AND key = ANY($4)
+	`
+
+	// findUsersSQL is completed with the condition on value of findUsersEqualSQL or findUsersRangeSQL.
+	findUsersSQL = `
+		SELECT DISTINCT user_id 
+		FROM user_preferences p 
+		WHERE tenant_id = $1 AND device_id = '' AND user_id > $2 AND (
+			(key = $3 AND %s) OR
+			($4 AND NOT EXISTS (
+				SELECT 1 FROM user_preferences q 
+				WHERE q.tenant_id = p.tenant_id AND q.device_id = '' AND q.user_id = p.user_id AND q.key = $3
+			))
+		)
+		ORDER BY user_id 
+		LIMIT $5
+	`
+
+	// findUsersScalarEqualSQL matches strings, numbers, and booleans through the
+	// idx_user_preferences_key_scalar index. The index holds a hash of the value's text, so
+	// that values of any size can be indexed; the JSONB comparison rules out hash collisions
+	// and a string matching a number or boolean with the same text.
+	findUsersScalarEqualSQL = `jsonb_typeof(value) IN ('string', 'number', 'boolean') 
+			AND md5(value #>> '{}') = md5($6::jsonb #>> '{}') AND value = $6::jsonb`
+
+	// findUsersEqualSQL matches objects, arrays, and null, which are not indexed.
+	findUsersEqualSQL = `value = $6::jsonb`
+
+	findUsersRangeSQL = `jsonb_typeof(value) = 'number' 
+			AND ($6::numeric IS NULL OR (CASE WHEN jsonb_typeof(value) = 'number' THEN (value #>> '{}')::numeric END) >= $6::numeric) 
+			AND ($7::numeric IS NULL OR (CASE WHEN jsonb_typeof(value) = 'number' THEN (value #>> '{}')::numeric END) <= $7::numeric)`
+
+	statsCountsSQL = `
+		SELECT COUNT(DISTINCT user_id), COUNT(CASE WHEN key = $2 THEN 1 END) 
+		FROM user_preferences 
+		WHERE tenant_id = $1 AND device_id = ''
+	`
+
+	statsValuesSQL = `
+		SELECT value, COUNT(*) AS n 
+		FROM user_preferences 
+		WHERE tenant_id = $1 AND device_id = '' AND key = $2 AND (NOT $3 OR jsonb_typeof(value) <> 'number') 
+		GROUP BY value 
+		ORDER BY n DESC, value 
+		LIMIT $4
+	`
+
+	statsNumericRangeSQL = `
+		SELECT MIN((value #>> '{}')::float8), MAX((value #>> '{}')::float8), COUNT(*) 
+		FROM (
+			SELECT value FROM user_preferences 
+			WHERE tenant_id = $1 AND device_id = '' AND key = $2 AND jsonb_typeof(value) = 'number'
+			OFFSET 0
+		) numbers
+	`
+
+	statsBucketsSQL = `
+		SELECT LEAST(FLOOR(((value #>> '{}')::float8 - $3::float8) * $5::int / ($4::float8 - $3::float8))::int, $5::int - 1) AS bucket, COUNT(*) 
+		FROM (
+			SELECT value FROM user_preferences 
+			WHERE tenant_id = $1 AND device_id = '' AND key = $2 AND jsonb_typeof(value) = 'number'
+			OFFSET 0
+		) numbers 
+		GROUP BY bucket
+	`
+
+	// deleteManySQL deletes preferences like deleteSQL, for an array of keys.
+	deleteManySQL = `
+		WITH deleted AS (
+			DELETE FROM user_preferences 
+			WHERE tenant_id = $1 AND device_id = $2 AND user_id = $3 AND key = ANY($4)
+			RETURNING tenant_id, device_id, user_id, key
+		)
+		INSERT INTO preference_tombstones (tenant_id, device_id, user_id, key, deleted_at)
+		SELECT tenant_id, device_id, user_id, key, $5 FROM deleted
+		ON CONFLICT (tenant_id, user_id, key, device_id) 
+		DO UPDATE SET deleted_at = EXCLUDED.deleted_at
+	`
+
+	changedSinceSQL = `
+		SELECT user_id, key, value, default_value, type, category, updated_at, version 
+		FROM user_preferences 
+		WHERE tenant_id = $1 AND device_id = $2 AND user_id = $3 AND updated_at > $4
+		ORDER BY key
+	`
+
+	tombstonesSinceSQL = `
+		SELECT key, deleted_at 
+		FROM preference_tombstones t 
+		WHERE tenant_id = $1 AND device_id = $2 AND user_id = $3 AND deleted_at > $4 AND NOT EXISTS (
+			SELECT 1 FROM user_preferences p 
+			WHERE p.tenant_id = t.tenant_id AND p.device_id = t.device_id AND p.user_id = t.user_id AND p.key = t.key
+		)
+		ORDER BY key
+	`
+
+	pruneTombstonesSQL = `
+		DELETE FROM preference_tombstones 
+		WHERE deleted_at < $1
+	`
+
+	saveDefinitionSQL = `
+		INSERT INTO preference_definitions (tenant_id, key, definition, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (tenant_id, key) 
+		DO UPDATE SET definition = $3, updated_at = $4
+	`
+
+	deleteDefinitionSQL = `
+		DELETE FROM preference_definitions 
+		WHERE tenant_id = $1 AND key = $2
+	`
+
+	loadDefinitionsSQL = `
+		SELECT tenant_id, definition 
+		FROM preference_definitions
 	`
 )
 
 // PostgresStorage implements the Storage interface using PostgreSQL.
+// Rows are keyed by (tenant_id, user_id, key, device_id); every operation is scoped to the tenant
+// returned by userprefs.TenantFromContext and, except DeleteAll, to the device returned by
+// userprefs.DeviceFromContext.
 type PostgresStorage struct {
 	db *sql.DB
 }
@@ -324,7 +521,7 @@ func (s *PostgresStorage) Get(ctx context.Context, userID, key string) (*userpre
 	var defaultValueJSON []byte // Added for DefaultValue
 	var category sql.NullString // Use sql.NullString for nullable category
 
-	err := s.db.QueryRowContext(ctx, selectSQL, userID, key).Scan(
+	err := s.db.QueryRowContext(ctx, selectSQL, userprefs.TenantFromContext(ctx), userprefs.DeviceFromContext(ctx), userID, key).Scan(
 		&pref.UserID,
 		&pref.Key,
 		&valueJSON,
@@ -332,6 +529,7 @@ func (s *PostgresStorage) Get(ctx context.Context, userID, key string) (*userpre
 		&pref.Type,
 		&category, // Scan into sql.NullString
 		&pref.UpdatedAt,
+		&pref.Version,
 	)
 
 	if err == sql.ErrNoRows {
@@ -368,7 +566,7 @@ func (s *PostgresStorage) Get(ctx context.Context, userID, key string) (*userpre
 // The pref.Value and pref.DefaultValue fields are marshalled to JSONB for storage.
 // This operation is an "upsert": if a preference with the given userID and key
 // already exists, it is updated; otherwise, a new preference is created.
-// The UpdatedAt field of the preference is set to the current time by the database.
+// The UpdatedAt field of the preference is stored in UTC, so that ChangesSince can compare it.
 //
 // Returns nil on successful creation or update.
 // Returns an error if marshalling to JSON fails (wrapping userprefs.ErrSerialization),
@@ -390,13 +588,16 @@ func (s *PostgresStorage) Set(ctx context.Context, pref *userprefs.Preference) e
 	}
 
 	_, err = s.db.ExecContext(ctx, insertSQL,
+		userprefs.TenantFromContext(ctx),
+		userprefs.DeviceFromContext(ctx),
 		pref.UserID,
 		pref.Key,
 		valueJSON,
 		defaultValueJSON,
 		pref.Type,
 		pref.Category,
-		pref.UpdatedAt,
+		pref.UpdatedAt.UTC(),
+		pref.Version,
 	)
 
 	if err != nil {
@@ -416,7 +617,7 @@ func (s *PostgresStorage) Set(ctx context.Context, pref *userprefs.Preference) e
 // If any stored preference value or default value cannot be unmarshalled from JSON,
 // it returns nil and an error wrapping userprefs.ErrSerialization.
 func (s *PostgresStorage) GetByCategory(ctx context.Context, userID, category string) (map[string]*userprefs.Preference, error) {
-	rows, err := s.db.QueryContext(ctx, selectByCategorySQL, userID, category)
+	rows, err := s.db.QueryContext(ctx, selectByCategorySQL, userprefs.TenantFromContext(ctx), userprefs.DeviceFromContext(ctx), userID, category)
 	if err != nil {
 		return nil, fmt.Errorf("postgres: failed to query preferences by category for user '%s', category '%s': %w", userID, category, err)
 	}
@@ -434,7 +635,7 @@ func (s *PostgresStorage) GetByCategory(ctx context.Context, userID, category st
 // If any stored preference value or default value cannot be unmarshalled from JSON,
 // it returns nil and an error wrapping userprefs.ErrSerialization.
 func (s *PostgresStorage) GetAll(ctx context.Context, userID string) (map[string]*userprefs.Preference, error) {
-	rows, err := s.db.QueryContext(ctx, selectAllSQL, userID)
+	rows, err := s.db.QueryContext(ctx, selectAllSQL, userprefs.TenantFromContext(ctx), userprefs.DeviceFromContext(ctx), userID)
 	if err != nil {
 		return nil, fmt.Errorf("postgres: failed to query all preferences for user '%s': %w", userID, err)
 	}
@@ -442,14 +643,113 @@ func (s *PostgresStorage) GetAll(ctx context.Context, userID string) (map[string
 	return s.scanPreferences(ctx, rows)
 }
 
-// Delete removes a specific preference for a given user ID and key.
+// ListByKey returns up to limit preferences stored under key, ordered by user ID and
+// starting strictly after afterUserID. It implements the userprefs.KeyLister interface.
+// The provided context.Context can be used for cancellation or timeouts.
+//
+// If there's an issue with database interaction, a wrapped error is returned.
+// If any stored value cannot be unmarshalled from JSON, it returns nil and an error
+// wrapping userprefs.ErrSerialization.
+func (s *PostgresStorage) ListByKey(ctx context.Context, key, afterUserID string, limit int) ([]*userprefs.Preference, error) {
+	rows, err := s.db.QueryContext(ctx, selectByKeySQL, userprefs.TenantFromContext(ctx), userprefs.DeviceFromContext(ctx), key, afterUserID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: failed to list preferences for key '%s': %w", key, err)
+	}
+	return s.scanPreferenceList(ctx, rows)
+}
+
+// FindUsers returns up to query.Limit IDs of users whose stored value for query.Key matches
+// query.Predicate, ordered by user ID and starting strictly after query.AfterUserID. With
+// query.IncludeMissing, users with other preferences but no value for the key are returned
+// as well. It implements the userprefs.UserFinder interface. Values are compared as JSONB;
+// equality lookups for strings, numbers, and booleans use an index on a hash of the value.
+// The provided context.Context can be used for cancellation or timeouts.
+//
+// Returns userprefs.ErrInvalidInput for predicates other than equality and range.
+// If there's an issue with database interaction, a wrapped error is returned.
+func (s *PostgresStorage) FindUsers(ctx context.Context, query userprefs.UserQuery) ([]string, error) {
+	args := []interface{}{userprefs.TenantFromContext(ctx), query.AfterUserID, query.Key, query.IncludeMissing, query.Limit}
+	var condition string
+	switch query.Predicate.Op {
+	case userprefs.PredicateEqual:
+		value, err := json.Marshal(query.Predicate.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to marshal predicate value for key '%s': %v", userprefs.ErrSerialization, query.Key, err)
+		}
+		condition = findUsersEqualSQL
+		if isJSONScalar(value) {
+			condition = findUsersScalarEqualSQL
+		}
+		args = append(args, string(value))
+	case userprefs.PredicateRange:
+		condition = findUsersRangeSQL
+		args = append(args, query.Predicate.Min, query.Predicate.Max)
+	default:
+		return nil, fmt.Errorf("%w: unsupported predicate operator '%s'", userprefs.ErrInvalidInput, query.Predicate.Op)
+	}
+
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(findUsersSQL, condition), args...)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: failed to find users for key '%s': %w", query.Key, err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	userIDs := make([]string, 0)
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("postgres: failed to scan user ID: %w", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: error iterating users for key '%s': %w", query.Key, err)
+	}
+	return userIDs, nil
+}
+
+// ValueStats aggregates the stored values of query.Key with SQL aggregate queries: user
+// counts, the most frequent values, and, for query.Buckets > 0, a histogram of numeric values.
+// It implements the userprefs.StatsProvider interface.
+// The provided context.Context can be used for cancellation or timeouts.
+//
+// If there's an issue with database interaction, a wrapped error is returned.
+func (s *PostgresStorage) ValueStats(ctx context.Context, query userprefs.StatsQuery) (*userprefs.PreferenceStats, error) {
+	stmts := statsSQL{counts: statsCountsSQL, values: statsValuesSQL, numericRange: statsNumericRangeSQL, buckets: statsBucketsSQL}
+	return queryValueStats(ctx, s.db, "postgres", stmts, userprefs.TenantFromContext(ctx), query)
+}
+
+// GetForUsers retrieves the preferences stored under any of keys for any of userIDs in a
+// single query, keyed by user ID and then by key. It implements the userprefs.MultiUserGetter
+// interface. The provided context.Context can be used for cancellation or timeouts.
+//
+// If there's an issue with database interaction, a wrapped error is returned.
+// If any stored value cannot be unmarshalled from JSON, it returns nil and an error
+// wrapping userprefs.ErrSerialization.
+func (s *PostgresStorage) GetForUsers(ctx context.Context, userIDs, keys []string) (map[string]map[string]*userprefs.Preference, error) {
+	if len(userIDs) == 0 || len(keys) == 0 {
+		return make(map[string]map[string]*userprefs.Preference), nil
+	}
+	rows, err := s.db.QueryContext(ctx, selectForUsersSQL, userprefs.TenantFromContext(ctx), userprefs.DeviceFromContext(ctx), pq.Array(userIDs), pq.Array(keys))
+	if err != nil {
+		return nil, fmt.Errorf("postgres: failed to query %d keys for %d users: %w", len(keys), len(userIDs), err)
+	}
+	prefs, err := s.scanPreferenceList(ctx, rows)
+	if err != nil {
+		return nil, err
+	}
+	return groupByUser(prefs), nil
+}
+
+// Delete removes a specific preference for a given user ID and key, and records a tombstone
+// for it, in a single statement.
 // The provided context.Context can be used for cancellation or timeouts.
 //
 // Returns nil on successful deletion.
 // If the preference to be deleted is not found, it returns userprefs.ErrNotFound.
 // If there's an issue with the database operation, a wrapped error is returned.
 func (s *PostgresStorage) Delete(ctx context.Context, userID, key string) error {
-	result, err := s.db.ExecContext(ctx, deleteSQL, userID, key)
+	result, err := s.db.ExecContext(ctx, deleteSQL, userprefs.TenantFromContext(ctx), userprefs.DeviceFromContext(ctx), userID, key, time.Now().UTC())
 	if err != nil {
 		return fmt.Errorf("postgres: failed to execute delete for user '%s', key '%s': %w", userID, key, err)
 	}
@@ -466,6 +766,146 @@ func (s *PostgresStorage) Delete(ctx context.Context, userID, key string) error
 	return nil
 }
 
+// DeleteMany removes the preferences of userID stored under any of keys, and records tombstones
+// for them, in a single statement. It implements the userprefs.BatchDeleter interface.
+// Keys without a stored value are ignored.
+// The provided context.Context can be used for cancellation or timeouts.
+//
+// If there's an issue with the database operation, a wrapped error is returned.
+func (s *PostgresStorage) DeleteMany(ctx context.Context, userID string, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if _, err := s.db.ExecContext(ctx, deleteManySQL, userprefs.TenantFromContext(ctx), userprefs.DeviceFromContext(ctx), userID, pq.Array(keys), time.Now().UTC()); err != nil {
+		return fmt.Errorf("postgres: failed to execute delete of %d keys for user '%s': %w", len(keys), userID, err)
+	}
+	return nil
+}
+
+// DeleteAll removes every preference of userID, including the overrides of every device, and
+// the user's tombstones in a single statement, and returns the removed keys. It implements the
+// userprefs.UserDeleter interface.
+// The provided context.Context can be used for cancellation or timeouts.
+//
+// If there's an issue with the database operation, a wrapped error is returned.
+func (s *PostgresStorage) DeleteAll(ctx context.Context, userID string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, deleteAllSQL, userprefs.TenantFromContext(ctx), userID)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: failed to execute delete of all preferences for user '%s': %w", userID, err)
+	}
+	keys, err := scanKeys(rows)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: failed to read deleted keys for user '%s': %w", userID, err)
+	}
+	return keys, nil
+}
+
+// ChangesSince returns the preferences of userID updated after since, and the tombstones of
+// keys deleted after since that have not been set again, both ordered by key. It implements
+// the userprefs.ChangeTracker interface.
+// The provided context.Context can be used for cancellation or timeouts, and selects the
+// tenant and device.
+//
+// If there's an issue with the database operation, a wrapped error is returned.
+func (s *PostgresStorage) ChangesSince(ctx context.Context, userID string, since time.Time) ([]*userprefs.Preference, []userprefs.Tombstone, error) {
+	tenantID, deviceID := userprefs.TenantFromContext(ctx), userprefs.DeviceFromContext(ctx)
+	rows, err := s.db.QueryContext(ctx, changedSinceSQL, tenantID, deviceID, userID, since.UTC())
+	if err != nil {
+		return nil, nil, fmt.Errorf("postgres: failed to query changes for user '%s': %w", userID, err)
+	}
+	changed, err := s.scanPreferenceList(ctx, rows)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = s.db.QueryContext(ctx, tombstonesSinceSQL, tenantID, deviceID, userID, since.UTC())
+	if err != nil {
+		return nil, nil, fmt.Errorf("postgres: failed to query tombstones for user '%s': %w", userID, err)
+	}
+	defer rows.Close()
+
+	var deleted []userprefs.Tombstone
+	for rows.Next() {
+		tombstone := userprefs.Tombstone{DeviceID: deviceID}
+		if err := rows.Scan(&tombstone.Key, &tombstone.DeletedAt); err != nil {
+			return nil, nil, fmt.Errorf("postgres: failed to scan tombstone for user '%s': %w", userID, err)
+		}
+		deleted = append(deleted, tombstone)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("postgres: failed to read tombstones for user '%s': %w", userID, err)
+	}
+	return changed, deleted, nil
+}
+
+// PruneTombstones removes the tombstones of every tenant, device, and user recorded before
+// before, and returns how many were removed. It implements the userprefs.TombstonePruner
+// interface.
+// The provided context.Context can be used for cancellation or timeouts.
+//
+// If there's an issue with the database operation, a wrapped error is returned.
+func (s *PostgresStorage) PruneTombstones(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, pruneTombstonesSQL, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("postgres: failed to prune tombstones: %w", err)
+	}
+	pruned, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("postgres: failed to count pruned tombstones: %w", err)
+	}
+	return pruned, nil
+}
+
+// SaveDefinition creates or replaces the definition of def.Key in tenantID's catalogue.
+// It implements the userprefs.DefinitionStore interface. The definition is stored as JSONB,
+// without its function fields. The provided context.Context can be used for cancellation or timeouts.
+//
+// Returns an error if marshalling to JSON fails (wrapping userprefs.ErrSerialization),
+// or if the database operation fails (wrapped error).
+func (s *PostgresStorage) SaveDefinition(ctx context.Context, tenantID string, def userprefs.PreferenceDefinition) error {
+	data, err := json.Marshal(def)
+	if err != nil {
+		return fmt.Errorf("%w: postgres: failed to marshal definition for key '%s': %v", userprefs.ErrSerialization, def.Key, err)
+	}
+	if _, err := s.db.ExecContext(ctx, saveDefinitionSQL, tenantID, def.Key, data, time.Now()); err != nil {
+		return fmt.Errorf("postgres: failed to save definition for key '%s': %w", def.Key, err)
+	}
+	return nil
+}
+
+// DeleteDefinition removes key from tenantID's catalogue. It implements the
+// userprefs.DefinitionStore interface; deleting a key that is not stored is not an error.
+// The provided context.Context can be used for cancellation or timeouts.
+//
+// If there's an issue with the database operation, a wrapped error is returned.
+func (s *PostgresStorage) DeleteDefinition(ctx context.Context, tenantID, key string) error {
+	if _, err := s.db.ExecContext(ctx, deleteDefinitionSQL, tenantID, key); err != nil {
+		return fmt.Errorf("postgres: failed to delete definition for key '%s': %w", key, err)
+	}
+	return nil
+}
+
+// LoadDefinitions returns the stored definitions of every tenant, keyed by tenant ID and then
+// by key. It implements the userprefs.DefinitionStore interface.
+// The provided context.Context can be used for cancellation or timeouts.
+//
+// If there's an issue with database interaction, a wrapped error is returned.
+// If a stored definition cannot be unmarshalled from JSON, it returns nil and an error
+// wrapping userprefs.ErrSerialization.
+func (s *PostgresStorage) LoadDefinitions(ctx context.Context) (map[string]map[string]userprefs.PreferenceDefinition, error) {
+	rows, err := s.db.QueryContext(ctx, loadDefinitionsSQL)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: failed to query definitions: %w", err)
+	}
+	return scanDefinitions(rows, "postgres")
+}
+
+// ScopesByContext reports that every operation is scoped to the tenant and device in its
+// context. It implements the userprefs.ScopedStorage interface.
+func (s *PostgresStorage) ScopesByContext() bool {
+	return true
+}
+
 // Close closes the underlying PostgreSQL database connection pool.
 // It is important to call Close when the PostgresStorage is no longer needed
 // to release database resources.
@@ -475,10 +915,25 @@ func (s *PostgresStorage) Close() error {
 	return s.db.Close()
 }
 
-// scanPreferences scans rows and constructs a map of preferences.
+// scanPreferences scans rows and constructs a map of preferences keyed by preference key.
+// It is intended for queries scoped to a single user.
+func (s *PostgresStorage) scanPreferences(ctx context.Context, rows *sql.Rows) (map[string]*userprefs.Preference, error) {
+	prefs, err := s.scanPreferenceList(ctx, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	prefsMap := make(map[string]*userprefs.Preference, len(prefs))
+	for _, pref := range prefs {
+		prefsMap[pref.Key] = pref
+	}
+	return prefsMap, nil
+}
+
+// scanPreferenceList scans rows into a slice of preferences, preserving row order.
 // It handles closing the rows object, returning any error from rows.Close()
 // if no other error occurred, or wrapping it if another error was primary.
-func (s *PostgresStorage) scanPreferences(ctx context.Context, rows *sql.Rows) (prefsMap map[string]*userprefs.Preference, err error) {
+func (s *PostgresStorage) scanPreferenceList(ctx context.Context, rows *sql.Rows) (prefsList []*userprefs.Preference, err error) {
 	defer func() {
 		closeErr := rows.Close()
 		if closeErr != nil {
@@ -491,14 +946,14 @@ func (s *PostgresStorage) scanPreferences(ctx context.Context, rows *sql.Rows) (
 		}
 	}()
 
-	prefsMap = make(map[string]*userprefs.Preference)
+	prefsList = make([]*userprefs.Preference, 0)
 
 	for rows.Next() {
 		// Check for context cancellation before processing each row
 		select {
 		case <-ctx.Done():
 			err = ctx.Err()
-			return // prefsMap will be nil, err will be set by defer or here
+			return nil, err
 		default:
 			// Continue processing
 		}
@@ -516,6 +971,7 @@ func (s *PostgresStorage) scanPreferences(ctx context.Context, rows *sql.Rows) (
 			&pref.Type,
 			&category, // Scan into sql.NullString
 			&pref.UpdatedAt,
+			&pref.Version,
 		)
 		if scanErr != nil {
 			err = fmt.Errorf("postgres: failed to scan preference row: %w", scanErr)
@@ -543,7 +999,7 @@ func (s *PostgresStorage) scanPreferences(ctx context.Context, rows *sql.Rows) (
 			pref.DefaultValue = nil
 		}
 
-		prefsMap[pref.Key] = &pref
+		prefsList = append(prefsList, &pref)
 	}
 
 	// Check for errors encountered during iteration.
@@ -552,5 +1008,18 @@ func (s *PostgresStorage) scanPreferences(ctx context.Context, rows *sql.Rows) (
 		return
 	}
 
-	return // prefsMap will be returned, err is nil or set by defer
+	return // prefsList will be returned, err is nil or set by defer
+}
+
+// isJSONScalar reports whether data encodes a JSON string, number, or boolean.
+func isJSONScalar(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	switch data[0] {
+	case '{', '[', 'n':
+		return false
+	default:
+		return true
+	}
 }
@@ -5,11 +5,14 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -20,6 +23,7 @@ import (
 const (
 	testCreateTableSQL = `
 		CREATE TABLE IF NOT EXISTS user_preferences (
+			tenant_id TEXT NOT NULL DEFAULT '',
 			user_id TEXT NOT NULL,
 			key TEXT NOT NULL,
 			value JSONB NOT NULL,
@@ -27,41 +31,224 @@ const (
 			type TEXT NOT NULL,
 			category TEXT,
 			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			PRIMARY KEY (user_id, key)
+			version INTEGER NOT NULL DEFAULT 0,
+			device_id TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (tenant_id, user_id, key, device_id)
 		);
 		
 		CREATE INDEX IF NOT EXISTS idx_user_preferences_category 
 		ON user_preferences(user_id, category);
+
+		ALTER TABLE user_preferences ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE user_preferences ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT '';
+		ALTER TABLE user_preferences ADD COLUMN IF NOT EXISTS device_id TEXT NOT NULL DEFAULT '';
+
+		DO $$
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.key_column_usage
+				WHERE table_schema = current_schema() AND table_name = 'user_preferences'
+					AND constraint_name = 'user_preferences_pkey' AND column_name = 'device_id'
+			) THEN
+				ALTER TABLE user_preferences DROP CONSTRAINT user_preferences_pkey;
+				ALTER TABLE user_preferences ADD PRIMARY KEY (tenant_id, user_id, key, device_id);
+			END IF;
+		END $$;
+
+		DROP INDEX IF EXISTS idx_user_preferences_key_value;
+
+		CREATE INDEX IF NOT EXISTS idx_user_preferences_key_scalar 
+		ON user_preferences(tenant_id, key, md5(value #>> '{}')) 
+		WHERE jsonb_typeof(value) IN ('string', 'number', 'boolean');
+
+		CREATE TABLE IF NOT EXISTS preference_tombstones (
+			tenant_id TEXT NOT NULL DEFAULT '',
+			device_id TEXT NOT NULL DEFAULT '',
+			user_id TEXT NOT NULL,
+			key TEXT NOT NULL,
+			deleted_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (tenant_id, user_id, key, device_id)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_preference_tombstones_deleted_at 
+		ON preference_tombstones(deleted_at);
+
+		CREATE TABLE IF NOT EXISTS preference_definitions (
+			tenant_id TEXT NOT NULL DEFAULT '',
+			key TEXT NOT NULL,
+			definition JSONB NOT NULL,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (tenant_id, key)
+		);
 	`
 
 	testInsertSQL = `
-		INSERT INTO user_preferences (user_id, key, value, default_value, type, category, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		ON CONFLICT (user_id, key) 
-		DO UPDATE SET value = $3, default_value = $4, updated_at = $7
+		INSERT INTO user_preferences (tenant_id, device_id, user_id, key, value, default_value, type, category, updated_at, version)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (tenant_id, user_id, key, device_id) 
+		DO UPDATE SET value = $5, default_value = $6, updated_at = $9, version = $10
 	`
 
 	testSelectSQL = `
-		SELECT user_id, key, value, default_value, type, category, updated_at 
+		SELECT user_id, key, value, default_value, type, category, updated_at, version 
 		FROM user_preferences 
-		WHERE user_id = $1 AND key = $2
+		WHERE tenant_id = $1 AND device_id = $2 AND user_id = $3 AND key = $4
 	`
 
 	testSelectByCategorySQL = `
-		SELECT user_id, key, value, default_value, type, category, updated_at 
+		SELECT user_id, key, value, default_value, type, category, updated_at, version 
 		FROM user_preferences 
-		WHERE user_id = $1 AND category = $2
+		WHERE tenant_id = $1 AND device_id = $2 AND user_id = $3 AND category = $4
 	`
 
 	testSelectAllSQL = `
-		SELECT user_id, key, value, default_value, type, category, updated_at 
+		SELECT user_id, key, value, default_value, type, category, updated_at, version 
+		FROM user_preferences 
+		WHERE tenant_id = $1 AND device_id = $2 AND user_id = $3
+	`
+
+	testSelectByKeySQL = `
+		SELECT user_id, key, value, default_value, type, category, updated_at, version 
 		FROM user_preferences 
-		WHERE user_id = $1
+		WHERE tenant_id = $1 AND device_id = $2 AND key = $3 AND user_id > $4
+		ORDER BY user_id
+		LIMIT $5
 	`
 
 	testDeleteSQL = `
+		WITH deleted AS (
+			DELETE FROM user_preferences 
+			WHERE tenant_id = $1 AND device_id = $2 AND user_id = $3 AND key = $4
+			RETURNING tenant_id, device_id, user_id, key
+		)
+		INSERT INTO preference_tombstones (tenant_id, device_id, user_id, key, deleted_at)
+		SELECT tenant_id, device_id, user_id, key, $5 FROM deleted
+		ON CONFLICT (tenant_id, user_id, key, device_id) 
+		DO UPDATE SET deleted_at = EXCLUDED.deleted_at
+	`
+
+	testDeleteAllSQL = `
+		WITH tombstones AS (
+			DELETE FROM preference_tombstones 
+			WHERE tenant_id = $1 AND user_id = $2
+		)
 		DELETE FROM user_preferences 
-		WHERE user_id = $1 AND key = $2
+		WHERE tenant_id = $1 AND user_id = $2
+		RETURNING key
+	`
+
+	testSelectForUsersSQL = `
+		SELECT user_id, key, value, default_value, type, category, updated_at, version 
+		FROM user_preferences 
+		WHERE tenant_id = $1 AND device_id = $2 AND user_id = ANY($3) AND key = ANY($4)
+	`
+
+	testFindUsersSQL = `
+		SELECT DISTINCT user_id 
+		FROM user_preferences p 
+		WHERE tenant_id = $1 AND device_id = '' AND user_id > $2 AND (
+			(key = $3 AND %s) OR
+			($4 AND NOT EXISTS (
+				SELECT 1 FROM user_preferences q 
+				WHERE q.tenant_id = p.tenant_id AND q.device_id = '' AND q.user_id = p.user_id AND q.key = $3
+			))
+		)
+		ORDER BY user_id 
+		LIMIT $5
+	`
+
+	testFindUsersScalarEqualSQL = `jsonb_typeof(value) IN ('string', 'number', 'boolean') 
+			AND md5(value #>> '{}') = md5($6::jsonb #>> '{}') AND value = $6::jsonb`
+
+	testFindUsersEqualSQL = `value = $6::jsonb`
+
+	testFindUsersRangeSQL = `jsonb_typeof(value) = 'number' 
+			AND ($6::numeric IS NULL OR (CASE WHEN jsonb_typeof(value) = 'number' THEN (value #>> '{}')::numeric END) >= $6::numeric) 
+			AND ($7::numeric IS NULL OR (CASE WHEN jsonb_typeof(value) = 'number' THEN (value #>> '{}')::numeric END) <= $7::numeric)`
+
+	testStatsCountsSQL = `
+		SELECT COUNT(DISTINCT user_id), COUNT(CASE WHEN key = $2 THEN 1 END) 
+		FROM user_preferences 
+		WHERE tenant_id = $1 AND device_id = ''
+	`
+
+	testStatsValuesSQL = `
+		SELECT value, COUNT(*) AS n 
+		FROM user_preferences 
+		WHERE tenant_id = $1 AND device_id = '' AND key = $2 AND (NOT $3 OR jsonb_typeof(value) <> 'number') 
+		GROUP BY value 
+		ORDER BY n DESC, value 
+		LIMIT $4
+	`
+
+	testStatsNumericRangeSQL = `
+		SELECT MIN((value #>> '{}')::float8), MAX((value #>> '{}')::float8), COUNT(*) 
+		FROM (
+			SELECT value FROM user_preferences 
+			WHERE tenant_id = $1 AND device_id = '' AND key = $2 AND jsonb_typeof(value) = 'number'
+			OFFSET 0
+		) numbers
+	`
+
+	testStatsBucketsSQL = `
+		SELECT LEAST(FLOOR(((value #>> '{}')::float8 - $3::float8) * $5::int / ($4::float8 - $3::float8))::int, $5::int - 1) AS bucket, COUNT(*) 
+		FROM (
+			SELECT value FROM user_preferences 
+			WHERE tenant_id = $1 AND device_id = '' AND key = $2 AND jsonb_typeof(value) = 'number'
+			OFFSET 0
+		) numbers 
+		GROUP BY bucket
+	`
+
+	testDeleteManySQL = `
+		WITH deleted AS (
+			DELETE FROM user_preferences 
+			WHERE tenant_id = $1 AND device_id = $2 AND user_id = $3 AND key = ANY($4)
+			RETURNING tenant_id, device_id, user_id, key
+		)
+		INSERT INTO preference_tombstones (tenant_id, device_id, user_id, key, deleted_at)
+		SELECT tenant_id, device_id, user_id, key, $5 FROM deleted
+		ON CONFLICT (tenant_id, user_id, key, device_id) 
+		DO UPDATE SET deleted_at = EXCLUDED.deleted_at
+	`
+
+	testSaveDefinitionSQL = `
+		INSERT INTO preference_definitions (tenant_id, key, definition, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (tenant_id, key) 
+		DO UPDATE SET definition = $3, updated_at = $4
+	`
+
+	testDeleteDefinitionSQL = `
+		DELETE FROM preference_definitions 
+		WHERE tenant_id = $1 AND key = $2
+	`
+
+	testLoadDefinitionsSQL = `
+		SELECT tenant_id, definition 
+		FROM preference_definitions
+	`
+
+	testChangedSinceSQL = `
+		SELECT user_id, key, value, default_value, type, category, updated_at, version 
+		FROM user_preferences 
+		WHERE tenant_id = $1 AND device_id = $2 AND user_id = $3 AND updated_at > $4
+		ORDER BY key
+	`
+
+	testTombstonesSinceSQL = `
+		SELECT key, deleted_at 
+		FROM preference_tombstones t 
+		WHERE tenant_id = $1 AND device_id = $2 AND user_id = $3 AND deleted_at > $4 AND NOT EXISTS (
+			SELECT 1 FROM user_preferences p 
+			WHERE p.tenant_id = t.tenant_id AND p.device_id = t.device_id AND p.user_id = t.user_id AND p.key = t.key
+		)
+		ORDER BY key
+	`
+
+	testPruneTombstonesSQL = `
+		DELETE FROM preference_tombstones 
+		WHERE deleted_at < $1
 	`
 )
 
@@ -169,7 +356,7 @@ func TestPostgresStorage_Set(t *testing.T) {
 
 	t.Run("successful set", func(t *testing.T) {
 		mock.ExpectExec(regexp.QuoteMeta(testInsertSQL)).
-			WithArgs(pref.UserID, pref.Key, valueJSON, defaultValueJSON, pref.Type, pref.Category, pref.UpdatedAt).
+			WithArgs("", "", pref.UserID, pref.Key, valueJSON, defaultValueJSON, pref.Type, pref.Category, pref.UpdatedAt.UTC(), pref.Version).
 			WillReturnResult(sqlmock.NewResult(1, 1))
 
 		err := storage.Set(ctx, pref)
@@ -203,7 +390,7 @@ func TestPostgresStorage_Set(t *testing.T) {
 
 	t.Run("db exec error", func(t *testing.T) {
 		mock.ExpectExec(regexp.QuoteMeta(testInsertSQL)).
-			WithArgs(pref.UserID, pref.Key, valueJSON, defaultValueJSON, pref.Type, pref.Category, pref.UpdatedAt).
+			WithArgs("", "", pref.UserID, pref.Key, valueJSON, defaultValueJSON, pref.Type, pref.Category, pref.UpdatedAt.UTC(), pref.Version).
 			WillReturnError(errors.New("db exec error"))
 
 		err := storage.Set(ctx, pref)
@@ -236,7 +423,7 @@ func TestPostgresStorage_Set_Error(t *testing.T) {
 
 	t.Run("db exec error", func(t *testing.T) {
 		mock.ExpectExec(regexp.QuoteMeta(testInsertSQL)).
-			WithArgs(pref.UserID, pref.Key, valueJSON, defaultValueJSON, pref.Type, pref.Category, pref.UpdatedAt).
+			WithArgs("", "", pref.UserID, pref.Key, valueJSON, defaultValueJSON, pref.Type, pref.Category, pref.UpdatedAt.UTC(), pref.Version).
 			WillReturnError(errors.New("db exec error"))
 
 		err := storage.Set(ctx, pref)
@@ -247,6 +434,38 @@ func TestPostgresStorage_Set_Error(t *testing.T) {
 	})
 }
 
+func TestPostgresStorage_LargeValue(t *testing.T) {
+	storage, mock := newTestPostgresStorage(t)
+	defer func() { _ = storage.db.Close() }()
+
+	ctx := context.Background()
+	// Btree index entries are limited to about 2.7KB, so no index may hold whole values.
+	assert.NotContains(t, createTableSQL, "(tenant_id, key, value)")
+
+	notes := strings.Repeat("lorem ipsum ", 800) // ~9.4KB, larger than a page
+	for _, value := range []interface{}{notes, map[string]interface{}{"notes": notes}} {
+		pref := &userprefs.Preference{UserID: "user1", Key: "notes", Value: value, Type: "json", UpdatedAt: time.Now().Truncate(time.Second)}
+		valueJSON, err := json.Marshal(value)
+		require.NoError(t, err)
+		require.Greater(t, len(valueJSON), 8*1024)
+
+		mock.ExpectExec(regexp.QuoteMeta(testInsertSQL)).
+			WithArgs("", "", pref.UserID, pref.Key, valueJSON, []byte("null"), pref.Type, "", pref.UpdatedAt.UTC(), 0).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		require.NoError(t, storage.Set(ctx, pref))
+	}
+
+	notesJSON, err := json.Marshal(notes)
+	require.NoError(t, err)
+	mock.ExpectQuery(regexp.QuoteMeta(fmt.Sprintf(testFindUsersSQL, testFindUsersScalarEqualSQL))).
+		WithArgs("", "", "notes", false, 10, string(notesJSON)).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("user1"))
+	found, err := storage.FindUsers(ctx, userprefs.UserQuery{Key: "notes", Predicate: userprefs.Equals(notes), Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user1"}, found)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestPostgresStorage_Get(t *testing.T) {
 	storage, mock := newTestPostgresStorage(t)
 	defer func() { _ = storage.Close() }()
@@ -264,10 +483,10 @@ func TestPostgresStorage_Get(t *testing.T) {
 
 	t.Run("successful get", func(t *testing.T) {
 		// Note: column order must match testSelectSQL
-		rows := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at"}).
-			AddRow(userID, key, valueJSON, defaultValueJSON, "string", "appearance", testTime)
+		rows := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at", "version"}).
+			AddRow(userID, key, valueJSON, defaultValueJSON, "string", "appearance", testTime, 0)
 		mock.ExpectQuery(regexp.QuoteMeta(testSelectSQL)).
-			WithArgs(userID, key).
+			WithArgs("", "", userID, key).
 			WillReturnRows(rows)
 
 		retPref, err := storage.Get(ctx, userID, key)
@@ -285,7 +504,7 @@ func TestPostgresStorage_Get(t *testing.T) {
 
 	t.Run("get not found", func(t *testing.T) {
 		mock.ExpectQuery(regexp.QuoteMeta(testSelectSQL)).
-			WithArgs(userID, "nonexistentkey").
+			WithArgs("", "", userID, "nonexistentkey").
 			WillReturnError(sql.ErrNoRows)
 
 		_, err := storage.Get(ctx, userID, "nonexistentkey")
@@ -296,7 +515,7 @@ func TestPostgresStorage_Get(t *testing.T) {
 
 	t.Run("db query error", func(t *testing.T) {
 		mock.ExpectQuery(regexp.QuoteMeta(testSelectSQL)).
-			WithArgs(userID, key).
+			WithArgs("", "", userID, key).
 			WillReturnError(errors.New("db query error"))
 
 		_, err := storage.Get(ctx, userID, key)
@@ -309,10 +528,10 @@ func TestPostgresStorage_Get(t *testing.T) {
 	t.Run("json unmarshal value error", func(t *testing.T) {
 		malformedValueJSON := []byte("this is not json value")
 		// default_value can be valid here as we are testing value unmarshal error
-		rows := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at"}).
-			AddRow(userID, key, malformedValueJSON, defaultValueJSON, "string", "appearance", testTime)
+		rows := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at", "version"}).
+			AddRow(userID, key, malformedValueJSON, defaultValueJSON, "string", "appearance", testTime, 0)
 		mock.ExpectQuery(regexp.QuoteMeta(testSelectSQL)).
-			WithArgs(userID, key).
+			WithArgs("", "", userID, key).
 			WillReturnRows(rows)
 
 		_, err := storage.Get(ctx, userID, key)
@@ -324,10 +543,10 @@ func TestPostgresStorage_Get(t *testing.T) {
 	t.Run("json unmarshal default_value error", func(t *testing.T) {
 		malformedDefaultValueJSON := []byte("this is not json default_value")
 		// value can be valid here
-		rows := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at"}).
-			AddRow(userID, key, valueJSON, malformedDefaultValueJSON, "string", "appearance", testTime)
+		rows := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at", "version"}).
+			AddRow(userID, key, valueJSON, malformedDefaultValueJSON, "string", "appearance", testTime, 0)
 		mock.ExpectQuery(regexp.QuoteMeta(testSelectSQL)).
-			WithArgs(userID, key).
+			WithArgs("", "", userID, key).
 			WillReturnRows(rows)
 
 		_, err := storage.Get(ctx, userID, key)
@@ -346,7 +565,7 @@ func TestPostgresStorage_Get_NotFound(t *testing.T) {
 
 	t.Run("get not found", func(t *testing.T) {
 		mock.ExpectQuery(regexp.QuoteMeta(testSelectSQL)).
-			WithArgs(userID, "nonexistentkey").
+			WithArgs("", "", userID, "nonexistentkey").
 			WillReturnError(sql.ErrNoRows)
 
 		_, err := storage.Get(ctx, userID, "nonexistentkey")
@@ -366,7 +585,7 @@ func TestPostgresStorage_Delete(t *testing.T) {
 
 	t.Run("successful delete", func(t *testing.T) {
 		mock.ExpectExec(regexp.QuoteMeta(testDeleteSQL)).
-			WithArgs(userID, key).
+			WithArgs("", "", userID, key, sqlmock.AnyArg()).
 			WillReturnResult(sqlmock.NewResult(0, 1))
 
 		err := storage.Delete(ctx, userID, key)
@@ -376,7 +595,7 @@ func TestPostgresStorage_Delete(t *testing.T) {
 
 	t.Run("delete not found", func(t *testing.T) {
 		mock.ExpectExec(regexp.QuoteMeta(testDeleteSQL)).
-			WithArgs(userID, "nonexistentkey").
+			WithArgs("", "", userID, "nonexistentkey", sqlmock.AnyArg()).
 			WillReturnResult(sqlmock.NewResult(0, 0))
 
 		err := storage.Delete(ctx, userID, "nonexistentkey")
@@ -387,7 +606,7 @@ func TestPostgresStorage_Delete(t *testing.T) {
 
 	t.Run("db exec error", func(t *testing.T) {
 		mock.ExpectExec(regexp.QuoteMeta(testDeleteSQL)).
-			WithArgs(userID, key).
+			WithArgs("", "", userID, key, sqlmock.AnyArg()).
 			WillReturnError(errors.New("db delete error"))
 
 		err := storage.Delete(ctx, userID, key)
@@ -399,7 +618,7 @@ func TestPostgresStorage_Delete(t *testing.T) {
 
 	t.Run("rows affected error", func(t *testing.T) {
 		mock.ExpectExec(regexp.QuoteMeta(testDeleteSQL)).
-			WithArgs(userID, key).
+			WithArgs("", "", userID, key, sqlmock.AnyArg()).
 			WillReturnResult(sqlmock.NewErrorResult(errors.New("result error")))
 
 		err := storage.Delete(ctx, userID, key)
@@ -432,12 +651,12 @@ func TestPostgresStorage_GetAll(t *testing.T) {
 
 	t.Run("successful getall", func(t *testing.T) {
 		// Note: column order must match testSelectAllSQL
-		mockRows := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at"}).
-			AddRow(pref1.UserID, pref1.Key, pref1ValueJSON, pref1DefaultValueJSON, pref1.Type, pref1.Category, pref1.UpdatedAt).
-			AddRow(pref2.UserID, pref2.Key, pref2ValueJSON, pref2DefaultValueJSON, pref2.Type, pref2.Category, pref2.UpdatedAt)
+		mockRows := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at", "version"}).
+			AddRow(pref1.UserID, pref1.Key, pref1ValueJSON, pref1DefaultValueJSON, pref1.Type, pref1.Category, pref1.UpdatedAt, 0).
+			AddRow(pref2.UserID, pref2.Key, pref2ValueJSON, pref2DefaultValueJSON, pref2.Type, pref2.Category, pref2.UpdatedAt, 0)
 
 		mock.ExpectQuery(regexp.QuoteMeta(testSelectAllSQL)).
-			WithArgs(userID).
+			WithArgs("", "", userID).
 			WillReturnRows(mockRows)
 
 		resultPrefs, err := storage.GetAll(ctx, userID)
@@ -462,9 +681,9 @@ func TestPostgresStorage_GetAll(t *testing.T) {
 	})
 
 	t.Run("getall no preferences", func(t *testing.T) {
-		emptyRows := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at"})
+		emptyRows := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at", "version"})
 		mock.ExpectQuery(regexp.QuoteMeta(testSelectAllSQL)).
-			WithArgs(userID).
+			WithArgs("", "", userID).
 			WillReturnRows(emptyRows)
 
 		resultPrefs, err := storage.GetAll(ctx, userID)
@@ -476,7 +695,7 @@ func TestPostgresStorage_GetAll(t *testing.T) {
 
 	t.Run("getall db query error", func(t *testing.T) {
 		mock.ExpectQuery(regexp.QuoteMeta(testSelectAllSQL)).
-			WithArgs(userID).
+			WithArgs("", "", userID).
 			WillReturnError(errors.New("db getall error"))
 
 		_, err := storage.GetAll(ctx, userID)
@@ -489,12 +708,12 @@ func TestPostgresStorage_GetAll(t *testing.T) {
 	t.Run("getall rows scan error", func(t *testing.T) {
 		dummyDefaultValueJSON, err := json.Marshal("default")
 		require.NoError(t, err)
-		rowsWithError := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at"}).
-			AddRow(userID, "key1", []byte(`"value1"`), dummyDefaultValueJSON, "string", "cat1", testTime)
+		rowsWithError := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at", "version"}).
+			AddRow(userID, "key1", []byte(`"value1"`), dummyDefaultValueJSON, "string", "cat1", testTime, 0)
 		rowsWithError.CloseError(errors.New("rows iteration error"))
 
 		mock.ExpectQuery(regexp.QuoteMeta(testSelectAllSQL)).
-			WithArgs(userID).
+			WithArgs("", "", userID).
 			WillReturnRows(rowsWithError)
 
 		_, err = storage.GetAll(ctx, userID)
@@ -509,12 +728,12 @@ func TestPostgresStorage_GetAll(t *testing.T) {
 		defaultValue1JSON, _ := json.Marshal("default1")
 		defaultValue2JSON, _ := json.Marshal("default2")
 
-		mockRows := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at"}).
-			AddRow(userID, "key1", validValue1JSON, defaultValue1JSON, "string", "cat1", testTime).
-			AddRow(userID, "key2", malformedValueJSON, defaultValue2JSON, "string", "cat2", testTime)
+		mockRows := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at", "version"}).
+			AddRow(userID, "key1", validValue1JSON, defaultValue1JSON, "string", "cat1", testTime, 0).
+			AddRow(userID, "key2", malformedValueJSON, defaultValue2JSON, "string", "cat2", testTime, 0)
 
 		mock.ExpectQuery(regexp.QuoteMeta(testSelectAllSQL)).
-			WithArgs(userID).
+			WithArgs("", "", userID).
 			WillReturnRows(mockRows)
 
 		_, err := storage.GetAll(ctx, userID)
@@ -531,12 +750,12 @@ func TestPostgresStorage_GetAll(t *testing.T) {
 		validDefaultValue1JSON, _ := json.Marshal("validDefault1")
 
 		// For key2, value is valid, default_value is malformed.
-		mockRows := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at"}).
-			AddRow(userID, "key1", validValue1JSON, validDefaultValue1JSON, "string", "cat1", testTime).
-			AddRow(userID, "key2", validValue2JSON, malformedDefaultValueJSON, "string", "cat2", testTime)
+		mockRows := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at", "version"}).
+			AddRow(userID, "key1", validValue1JSON, validDefaultValue1JSON, "string", "cat1", testTime, 0).
+			AddRow(userID, "key2", validValue2JSON, malformedDefaultValueJSON, "string", "cat2", testTime, 0)
 
 		mock.ExpectQuery(regexp.QuoteMeta(testSelectAllSQL)).
-			WithArgs(userID).
+			WithArgs("", "", userID).
 			WillReturnRows(mockRows)
 
 		_, err := storage.GetAll(ctx, userID)
@@ -570,12 +789,12 @@ func TestPostgresStorage_GetByCategory(t *testing.T) {
 	require.NoError(t, err)
 
 	t.Run("successful getbycategory", func(t *testing.T) {
-		mockRows := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at"}).
-			AddRow(pref1.UserID, pref1.Key, pref1ValueJSON, pref1DefaultValueJSON, pref1.Type, pref1.Category, pref1.UpdatedAt).
-			AddRow(pref2.UserID, pref2.Key, pref2ValueJSON, pref2DefaultValueJSON, pref2.Type, pref2.Category, pref2.UpdatedAt)
+		mockRows := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at", "version"}).
+			AddRow(pref1.UserID, pref1.Key, pref1ValueJSON, pref1DefaultValueJSON, pref1.Type, pref1.Category, pref1.UpdatedAt, 0).
+			AddRow(pref2.UserID, pref2.Key, pref2ValueJSON, pref2DefaultValueJSON, pref2.Type, pref2.Category, pref2.UpdatedAt, 0)
 
 		mock.ExpectQuery(regexp.QuoteMeta(testSelectByCategorySQL)).
-			WithArgs(userID, category).
+			WithArgs("", "", userID, category).
 			WillReturnRows(mockRows)
 
 		resultPrefs, err := storage.GetByCategory(ctx, userID, category)
@@ -600,9 +819,9 @@ func TestPostgresStorage_GetByCategory(t *testing.T) {
 	})
 
 	t.Run("getbycategory no preferences", func(t *testing.T) {
-		emptyRows := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at"})
+		emptyRows := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at", "version"})
 		mock.ExpectQuery(regexp.QuoteMeta(testSelectByCategorySQL)).
-			WithArgs(userID, "nonexistent_category").
+			WithArgs("", "", userID, "nonexistent_category").
 			WillReturnRows(emptyRows)
 
 		resultPrefs, err := storage.GetByCategory(ctx, userID, "nonexistent_category")
@@ -614,7 +833,7 @@ func TestPostgresStorage_GetByCategory(t *testing.T) {
 
 	t.Run("getbycategory db query error", func(t *testing.T) {
 		mock.ExpectQuery(regexp.QuoteMeta(testSelectByCategorySQL)).
-			WithArgs(userID, category).
+			WithArgs("", "", userID, category).
 			WillReturnError(errors.New("db getbycategory error"))
 
 		_, err := storage.GetByCategory(ctx, userID, category)
@@ -627,12 +846,12 @@ func TestPostgresStorage_GetByCategory(t *testing.T) {
 	t.Run("getbycategory rows scan error", func(t *testing.T) {
 		dummyDefaultValueJSON, err := json.Marshal("default")
 		require.NoError(t, err)
-		rowsWithError := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at"}).
-			AddRow(userID, "key1", []byte(`"value1"`), dummyDefaultValueJSON, "string", category, testTime)
+		rowsWithError := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at", "version"}).
+			AddRow(userID, "key1", []byte(`"value1"`), dummyDefaultValueJSON, "string", category, testTime, 0)
 		rowsWithError.CloseError(errors.New("rows iteration error for category"))
 
 		mock.ExpectQuery(regexp.QuoteMeta(testSelectByCategorySQL)).
-			WithArgs(userID, category).
+			WithArgs("", "", userID, category).
 			WillReturnRows(rowsWithError)
 
 		_, err = storage.GetByCategory(ctx, userID, category)
@@ -647,12 +866,12 @@ func TestPostgresStorage_GetByCategory(t *testing.T) {
 		defaultValue1JSON, _ := json.Marshal("default1")
 		defaultValue2JSON, _ := json.Marshal("default2")
 
-		mockRows := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at"}).
-			AddRow(userID, "key1", validValueJSON, defaultValue1JSON, "string", category, testTime).
-			AddRow(userID, "key2", malformedValueJSON, defaultValue2JSON, "string", category, testTime)
+		mockRows := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at", "version"}).
+			AddRow(userID, "key1", validValueJSON, defaultValue1JSON, "string", category, testTime, 0).
+			AddRow(userID, "key2", malformedValueJSON, defaultValue2JSON, "string", category, testTime, 0)
 
 		mock.ExpectQuery(regexp.QuoteMeta(testSelectByCategorySQL)).
-			WithArgs(userID, category).
+			WithArgs("", "", userID, category).
 			WillReturnRows(mockRows)
 
 		_, err := storage.GetByCategory(ctx, userID, category)
@@ -667,12 +886,12 @@ func TestPostgresStorage_GetByCategory(t *testing.T) {
 		validValueJSON, _ := json.Marshal("validValue")
 		validDefaultValue1JSON, _ := json.Marshal("validDefault1")
 		// For key2, value is valid, default_value is malformed.
-		mockRows := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at"}).
-			AddRow(userID, "key1", validValueJSON, validDefaultValue1JSON, "string", category, testTime).
-			AddRow(userID, "key2", validValueJSON, malformedDefaultValueJSON, "string", category, testTime)
+		mockRows := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at", "version"}).
+			AddRow(userID, "key1", validValueJSON, validDefaultValue1JSON, "string", category, testTime, 0).
+			AddRow(userID, "key2", validValueJSON, malformedDefaultValueJSON, "string", category, testTime, 0)
 
 		mock.ExpectQuery(regexp.QuoteMeta(testSelectByCategorySQL)).
-			WithArgs(userID, category).
+			WithArgs("", "", userID, category).
 			WillReturnRows(mockRows)
 
 		_, err := storage.GetByCategory(ctx, userID, category)
@@ -682,3 +901,427 @@ func TestPostgresStorage_GetByCategory(t *testing.T) {
 		assert.NoError(t, mock.ExpectationsWereMet())
 	})
 }
+
+func TestPostgresStorage_ListByKey(t *testing.T) {
+	storage, mock := newTestPostgresStorage(t)
+	defer func() { _ = storage.db.Close() }()
+
+	ctx := context.Background()
+	testTime := time.Now().Truncate(time.Millisecond)
+
+	t.Run("successful listing", func(t *testing.T) {
+		rows := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at", "version"}).
+			AddRow("userA", "layout", []byte(`"compact"`), []byte(`null`), "json", "appearance", testTime, 0).
+			AddRow("userB", "layout", []byte(`{"mode":"cozy"}`), []byte(`null`), "json", "appearance", testTime, 1)
+		mock.ExpectQuery(regexp.QuoteMeta(testSelectByKeySQL)).
+			WithArgs("", "", "layout", "", 2).
+			WillReturnRows(rows)
+
+		prefs, err := storage.ListByKey(ctx, "layout", "", 2)
+		require.NoError(t, err)
+		require.Len(t, prefs, 2)
+		assert.Equal(t, "userA", prefs[0].UserID)
+		assert.Equal(t, "compact", prefs[0].Value)
+		assert.Equal(t, 0, prefs[0].Version)
+		assert.Equal(t, "userB", prefs[1].UserID)
+		assert.Equal(t, 1, prefs[1].Version)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("query error", func(t *testing.T) {
+		dbErr := errors.New("db error")
+		mock.ExpectQuery(regexp.QuoteMeta(testSelectByKeySQL)).
+			WithArgs("", "", "layout", "userB", 2).
+			WillReturnError(dbErr)
+
+		_, err := storage.ListByKey(ctx, "layout", "userB", 2)
+		assert.ErrorIs(t, err, dbErr)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPostgresStorage_DeleteMany(t *testing.T) {
+	storage, mock := newTestPostgresStorage(t)
+	defer func() { _ = storage.db.Close() }()
+
+	ctx := context.Background()
+
+	t.Run("successful delete", func(t *testing.T) {
+		mock.ExpectExec(regexp.QuoteMeta(testDeleteManySQL)).
+			WithArgs("", "", "user1", pq.Array([]string{"theme", "volume"}), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		require.NoError(t, storage.DeleteMany(ctx, "user1", []string{"theme", "volume"}))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("no keys", func(t *testing.T) {
+		require.NoError(t, storage.DeleteMany(ctx, "user1", nil))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("exec error", func(t *testing.T) {
+		dbErr := errors.New("db error")
+		mock.ExpectExec(regexp.QuoteMeta(testDeleteManySQL)).
+			WithArgs("", "", "user1", pq.Array([]string{"theme"}), sqlmock.AnyArg()).
+			WillReturnError(dbErr)
+
+		err := storage.DeleteMany(ctx, "user1", []string{"theme"})
+		assert.ErrorIs(t, err, dbErr)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPostgresStorage_GetForUsers(t *testing.T) {
+	storage, mock := newTestPostgresStorage(t)
+	defer func() { _ = storage.db.Close() }()
+
+	ctx := context.Background()
+	testTime := time.Now().Truncate(time.Millisecond)
+	userIDs := []string{"userA", "userB", "userC"}
+	keys := []string{"theme", "volume"}
+
+	t.Run("successful read", func(t *testing.T) {
+		rows := sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at", "version"}).
+			AddRow("userA", "theme", []byte(`"dark"`), []byte(`"light"`), "string", "ui", testTime, 0).
+			AddRow("userA", "volume", []byte(`7`), []byte(`5`), "int", "audio", testTime, 0).
+			AddRow("userB", "theme", []byte(`"blue"`), []byte(`"light"`), "string", "ui", testTime, 0)
+		mock.ExpectQuery(regexp.QuoteMeta(testSelectForUsersSQL)).
+			WithArgs("", "", pq.Array(userIDs), pq.Array(keys)).
+			WillReturnRows(rows)
+
+		got, err := storage.GetForUsers(ctx, userIDs, keys)
+		require.NoError(t, err)
+		require.Len(t, got, 2)
+		assert.Equal(t, "dark", got["userA"]["theme"].Value)
+		assert.Len(t, got["userA"], 2)
+		assert.Equal(t, "blue", got["userB"]["theme"].Value)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("query error", func(t *testing.T) {
+		dbErr := errors.New("db error")
+		mock.ExpectQuery(regexp.QuoteMeta(testSelectForUsersSQL)).
+			WithArgs("", "", pq.Array(userIDs), pq.Array(keys)).
+			WillReturnError(dbErr)
+
+		_, err := storage.GetForUsers(ctx, userIDs, keys)
+		assert.ErrorIs(t, err, dbErr)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("no users", func(t *testing.T) {
+		got, err := storage.GetForUsers(ctx, nil, keys)
+		require.NoError(t, err)
+		assert.Empty(t, got)
+	})
+}
+
+func TestPostgresStorage_FindUsers(t *testing.T) {
+	storage, mock := newTestPostgresStorage(t)
+	defer func() { _ = storage.db.Close() }()
+
+	ctx := context.Background()
+	findUsersQuery := func(condition string) string {
+		return regexp.QuoteMeta(fmt.Sprintf(testFindUsersSQL, condition))
+	}
+
+	t.Run("equality", func(t *testing.T) {
+		mock.ExpectQuery(findUsersQuery(testFindUsersScalarEqualSQL)).
+			WithArgs("", "", "opt_in", true, 11, "true").
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("userA").AddRow("userB"))
+
+		found, err := storage.FindUsers(ctx, userprefs.UserQuery{Key: "opt_in", Predicate: userprefs.Equals(true), IncludeMissing: true, Limit: 11})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"userA", "userB"}, found)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("equality with an object", func(t *testing.T) {
+		mock.ExpectQuery(findUsersQuery(testFindUsersEqualSQL)).
+			WithArgs("", "", "layout", false, 5, `{"mode":"compact"}`).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("userA"))
+
+		found, err := storage.FindUsers(ctx, userprefs.UserQuery{Key: "layout", Predicate: userprefs.Equals(map[string]interface{}{"mode": "compact"}), Limit: 5})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"userA"}, found)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("range", func(t *testing.T) {
+		mock.ExpectQuery(findUsersQuery(testFindUsersRangeSQL)).
+			WithArgs("", "userA", "age", false, 5, 18.0, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+
+		found, err := storage.FindUsers(ctx, userprefs.UserQuery{Key: "age", Predicate: userprefs.InRange(18.0, nil), AfterUserID: "userA", Limit: 5})
+		require.NoError(t, err)
+		assert.Empty(t, found)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("query error", func(t *testing.T) {
+		dbErr := errors.New("db error")
+		mock.ExpectQuery(findUsersQuery(testFindUsersScalarEqualSQL)).WillReturnError(dbErr)
+
+		_, err := storage.FindUsers(ctx, userprefs.UserQuery{Key: "plan", Predicate: userprefs.Equals("pro"), Limit: 5})
+		assert.ErrorIs(t, err, dbErr)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("unsupported predicate", func(t *testing.T) {
+		_, err := storage.FindUsers(ctx, userprefs.UserQuery{Key: "plan", Predicate: userprefs.Predicate{Op: "like"}, Limit: 5})
+		assert.ErrorIs(t, err, userprefs.ErrInvalidInput)
+	})
+}
+
+func TestPostgresStorage_ValueStats(t *testing.T) {
+	storage, mock := newTestPostgresStorage(t)
+	defer func() { _ = storage.db.Close() }()
+
+	ctx := context.Background()
+
+	t.Run("distinct values", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(testStatsCountsSQL)).
+			WithArgs("", "theme").
+			WillReturnRows(sqlmock.NewRows([]string{"users", "explicit"}).AddRow(10, 4))
+		mock.ExpectQuery(regexp.QuoteMeta(testStatsValuesSQL)).
+			WithArgs("", "theme", false, 100).
+			WillReturnRows(sqlmock.NewRows([]string{"value", "n"}).AddRow([]byte(`"dark"`), 3).AddRow([]byte(`"light"`), 1))
+
+		stats, err := storage.ValueStats(ctx, userprefs.StatsQuery{Key: "theme", MaxValues: 100})
+		require.NoError(t, err)
+		assert.Equal(t, 4, stats.ExplicitCount)
+		assert.Equal(t, 6, stats.DefaultCount)
+		assert.Equal(t, []userprefs.ValueCount{{Value: "dark", Count: 3}, {Value: "light", Count: 1}}, stats.Values)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("numeric buckets", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(testStatsCountsSQL)).
+			WithArgs("", "volume").
+			WillReturnRows(sqlmock.NewRows([]string{"users", "explicit"}).AddRow(5, 3))
+		mock.ExpectQuery(regexp.QuoteMeta(testStatsValuesSQL)).
+			WithArgs("", "volume", true, 100).
+			WillReturnRows(sqlmock.NewRows([]string{"value", "n"}))
+		mock.ExpectQuery(regexp.QuoteMeta(testStatsNumericRangeSQL)).
+			WithArgs("", "volume").
+			WillReturnRows(sqlmock.NewRows([]string{"min", "max", "count"}).AddRow(0.0, 10.0, 3))
+		mock.ExpectQuery(regexp.QuoteMeta(testStatsBucketsSQL)).
+			WithArgs("", "volume", 0.0, 10.0, 2).
+			WillReturnRows(sqlmock.NewRows([]string{"bucket", "count"}).AddRow(0, 1).AddRow(1, 2))
+
+		stats, err := storage.ValueStats(ctx, userprefs.StatsQuery{Key: "volume", Buckets: 2, MaxValues: 100})
+		require.NoError(t, err)
+		assert.Equal(t, []userprefs.NumericBucket{{Min: 0, Max: 5, Count: 1}, {Min: 5, Max: 10, Count: 2}}, stats.Buckets)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("query error", func(t *testing.T) {
+		dbErr := errors.New("db error")
+		mock.ExpectQuery(regexp.QuoteMeta(testStatsCountsSQL)).WillReturnError(dbErr)
+
+		_, err := storage.ValueStats(ctx, userprefs.StatsQuery{Key: "theme", MaxValues: 100})
+		assert.ErrorIs(t, err, dbErr)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPostgresStorage_DeleteAll(t *testing.T) {
+	storage, mock := newTestPostgresStorage(t)
+	defer func() { _ = storage.db.Close() }()
+
+	ctx := context.Background()
+
+	t.Run("successful delete", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(testDeleteAllSQL)).
+			WithArgs("", "user1").
+			WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("volume").AddRow("theme"))
+
+		keys, err := storage.DeleteAll(ctx, "user1")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"theme", "volume"}, keys)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("keys of several devices are reported once", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(testDeleteAllSQL)).
+			WithArgs("", "user1").
+			WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("layout").AddRow("theme").AddRow("layout"))
+
+		keys, err := storage.DeleteAll(userprefs.WithDevice(ctx, "mobile"), "user1")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"layout", "theme"}, keys)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("query error", func(t *testing.T) {
+		dbErr := errors.New("db error")
+		mock.ExpectQuery(regexp.QuoteMeta(testDeleteAllSQL)).
+			WithArgs("", "user1").
+			WillReturnError(dbErr)
+
+		_, err := storage.DeleteAll(ctx, "user1")
+		assert.ErrorIs(t, err, dbErr)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPostgresStorage_DeviceScoping(t *testing.T) {
+	storage, mock := newTestPostgresStorage(t)
+	defer func() { _ = storage.db.Close() }()
+
+	mobile := userprefs.WithDevice(userprefs.WithTenant(context.Background(), "acme"), "mobile")
+	pref := &userprefs.Preference{UserID: "user1", Key: "layout", Value: "compact", Type: "enum", Category: "ui", UpdatedAt: time.Now()}
+	valueJSON, _ := json.Marshal(pref.Value)
+	defaultValueJSON, _ := json.Marshal(pref.DefaultValue)
+
+	mock.ExpectExec(regexp.QuoteMeta(testInsertSQL)).
+		WithArgs("acme", "mobile", pref.UserID, pref.Key, valueJSON, defaultValueJSON, pref.Type, pref.Category, pref.UpdatedAt.UTC(), pref.Version).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	require.NoError(t, storage.Set(mobile, pref))
+
+	mock.ExpectQuery(regexp.QuoteMeta(testSelectAllSQL)).
+		WithArgs("acme", "mobile", "user1").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at", "version"}).
+			AddRow("user1", "layout", valueJSON, defaultValueJSON, "enum", "ui", pref.UpdatedAt, 0))
+	all, err := storage.GetAll(mobile, "user1")
+	require.NoError(t, err)
+	assert.Equal(t, "compact", all["layout"].Value)
+
+	mock.ExpectExec(regexp.QuoteMeta(testDeleteSQL)).
+		WithArgs("acme", "mobile", "user1", "layout", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, storage.Delete(mobile, "user1", "layout"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStorage_ChangesSince(t *testing.T) {
+	storage, mock := newTestPostgresStorage(t)
+	defer func() { _ = storage.db.Close() }()
+
+	mobile := userprefs.WithDevice(userprefs.WithTenant(context.Background(), "acme"), "mobile")
+	since := time.Now().Add(-time.Hour)
+	updatedAt := since.Add(time.Minute).UTC().Truncate(time.Second)
+	deletedAt := since.Add(2 * time.Minute).UTC().Truncate(time.Second)
+
+	t.Run("changes and tombstones", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(testChangedSinceSQL)).
+			WithArgs("acme", "mobile", "user1", since.UTC()).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at", "version"}).
+				AddRow("user1", "layout", []byte(`"compact"`), []byte(`"comfortable"`), "enum", "ui", updatedAt, 2))
+		mock.ExpectQuery(regexp.QuoteMeta(testTombstonesSinceSQL)).
+			WithArgs("acme", "mobile", "user1", since.UTC()).
+			WillReturnRows(sqlmock.NewRows([]string{"key", "deleted_at"}).AddRow("density", deletedAt))
+
+		changed, deleted, err := storage.ChangesSince(mobile, "user1", since)
+		require.NoError(t, err)
+		require.Len(t, changed, 1)
+		assert.Equal(t, "compact", changed[0].Value)
+		assert.Equal(t, 2, changed[0].Version)
+		assert.Equal(t, []userprefs.Tombstone{{Key: "density", DeviceID: "mobile", DeletedAt: deletedAt}}, deleted)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("query error", func(t *testing.T) {
+		dbErr := errors.New("db error")
+		mock.ExpectQuery(regexp.QuoteMeta(testChangedSinceSQL)).
+			WithArgs("acme", "mobile", "user1", since.UTC()).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id", "key", "value", "default_value", "type", "category", "updated_at", "version"}))
+		mock.ExpectQuery(regexp.QuoteMeta(testTombstonesSinceSQL)).
+			WithArgs("acme", "mobile", "user1", since.UTC()).
+			WillReturnError(dbErr)
+
+		_, _, err := storage.ChangesSince(mobile, "user1", since)
+		assert.ErrorIs(t, err, dbErr)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPostgresStorage_PruneTombstones(t *testing.T) {
+	storage, mock := newTestPostgresStorage(t)
+	defer func() { _ = storage.db.Close() }()
+
+	ctx := context.Background()
+	before := time.Now().Add(-24 * time.Hour)
+
+	t.Run("success", func(t *testing.T) {
+		mock.ExpectExec(regexp.QuoteMeta(testPruneTombstonesSQL)).
+			WithArgs(before.UTC()).
+			WillReturnResult(sqlmock.NewResult(0, 3))
+
+		pruned, err := storage.PruneTombstones(ctx, before)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), pruned)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("exec error", func(t *testing.T) {
+		dbErr := errors.New("db error")
+		mock.ExpectExec(regexp.QuoteMeta(testPruneTombstonesSQL)).
+			WithArgs(before.UTC()).
+			WillReturnError(dbErr)
+
+		_, err := storage.PruneTombstones(ctx, before)
+		assert.ErrorIs(t, err, dbErr)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPostgresStorage_Definitions(t *testing.T) {
+	storage, mock := newTestPostgresStorage(t)
+	defer func() { _ = storage.db.Close() }()
+
+	ctx := context.Background()
+
+	t.Run("save", func(t *testing.T) {
+		mock.ExpectExec(regexp.QuoteMeta(testSaveDefinitionSQL)).
+			WithArgs("acme", "theme", []byte(`{"key":"theme","type":"string","default_value":"light"}`), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err := storage.SaveDefinition(ctx, "acme", userprefs.PreferenceDefinition{Key: "theme", Type: "string", DefaultValue: "light"})
+		require.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("load", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(testLoadDefinitionsSQL)).
+			WillReturnRows(sqlmock.NewRows([]string{"tenant_id", "definition"}).
+				AddRow("", []byte(`{"key":"volume","type":"int","default_value":5}`)).
+				AddRow("acme", []byte(`{"key":"theme","type":"string","default_value":"light"}`)))
+
+		definitions, err := storage.LoadDefinitions(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]map[string]userprefs.PreferenceDefinition{
+			"":     {"volume": {Key: "volume", Type: "int", DefaultValue: 5.0}},
+			"acme": {"theme": {Key: "theme", Type: "string", DefaultValue: "light"}},
+		}, definitions)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("load malformed definition", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(testLoadDefinitionsSQL)).
+			WillReturnRows(sqlmock.NewRows([]string{"tenant_id", "definition"}).AddRow("", []byte(`{`)))
+
+		_, err := storage.LoadDefinitions(ctx)
+		assert.ErrorIs(t, err, userprefs.ErrSerialization)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		mock.ExpectExec(regexp.QuoteMeta(testDeleteDefinitionSQL)).
+			WithArgs("acme", "theme").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		require.NoError(t, storage.DeleteDefinition(ctx, "acme", "theme"), "Deleting a missing definition should succeed")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("delete error", func(t *testing.T) {
+		dbErr := errors.New("db error")
+		mock.ExpectExec(regexp.QuoteMeta(testDeleteDefinitionSQL)).WillReturnError(dbErr)
+
+		err := storage.DeleteDefinition(ctx, "acme", "theme")
+		assert.ErrorIs(t, err, dbErr)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -28,6 +28,7 @@ var sqliteOpen sqliteOpenFuncType = sql.Open
 const (
 	sqliteCreateTableSQL = `
 		CREATE TABLE IF NOT EXISTS user_preferences (
+			tenant_id TEXT NOT NULL DEFAULT '',
 			user_id TEXT NOT NULL,
 			key TEXT NOT NULL,
 			value TEXT NOT NULL,
@@ -35,41 +36,215 @@ const (
 			type TEXT NOT NULL,
 			category TEXT,
 			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			PRIMARY KEY (user_id, key)
+			version INTEGER NOT NULL DEFAULT 0,
+			device_id TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (tenant_id, user_id, key, device_id)
 		);
 		
 		CREATE INDEX IF NOT EXISTS idx_user_preferences_category 
 		ON user_preferences(user_id, category);
 	`
 
+	// sqliteCreateIndexesSQL creates indexes on columns added by migrations; it runs after them.
+	sqliteCreateIndexesSQL = `
+		CREATE INDEX IF NOT EXISTS idx_user_preferences_key 
+		ON user_preferences(tenant_id, key);
+	`
+
 	sqliteInsertSQL = `
-		INSERT INTO user_preferences (user_id, key, value, default_value, type, category, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-		ON CONFLICT(user_id, key) 
-		DO UPDATE SET value = ?, default_value = ?, updated_at = ?
+		INSERT INTO user_preferences (tenant_id, device_id, user_id, key, value, default_value, type, category, updated_at, version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(tenant_id, user_id, key, device_id) 
+		DO UPDATE SET value = ?, default_value = ?, updated_at = ?, version = ?
 	`
 
 	sqliteSelectSQL = `
-		SELECT user_id, key, value, default_value, type, category, updated_at 
+		SELECT user_id, key, value, default_value, type, category, updated_at, version 
 		FROM user_preferences 
-		WHERE user_id = ? AND key = ?
+		WHERE tenant_id = ? AND device_id = ? AND user_id = ? AND key = ?
 	`
 
 	sqliteSelectByCategorySQL = `
-		SELECT user_id, key, value, default_value, type, category, updated_at 
+		SELECT user_id, key, value, default_value, type, category, updated_at, version 
 		FROM user_preferences 
-		WHERE user_id = ? AND category = ?
+		WHERE tenant_id = ? AND device_id = ? AND user_id = ? AND category = ?
 	`
 
 	sqliteSelectAllSQL = `
-		SELECT user_id, key, value, default_value, type, category, updated_at 
+		SELECT user_id, key, value, default_value, type, category, updated_at, version 
+		FROM user_preferences 
+		WHERE tenant_id = ? AND device_id = ? AND user_id = ?
+	`
+
+	sqliteSelectByKeySQL = `
+		SELECT user_id, key, value, default_value, type, category, updated_at, version 
 		FROM user_preferences 
-		WHERE user_id = ?
+		WHERE tenant_id = ? AND device_id = ? AND key = ? AND user_id > ?
+		ORDER BY user_id
+		LIMIT ?
 	`
 
 	sqliteDeleteSQL = `
 		DELETE FROM user_preferences 
-		WHERE user_id = ? AND key = ?
+		WHERE tenant_id = ? AND device_id = ? AND user_id = ? AND key = ?
+	`
+
+	sqliteDeleteAllSQL = `
+		DELETE FROM user_preferences 
+		WHERE tenant_id = ? AND user_id = ?
+		RETURNING key
+	`
+
+	// sqliteSelectForUsersSQL is completed with one "?" placeholder per user ID and one per key.
+	sqliteSelectForUsersSQL = `
+		SELECT user_id, key, value, default_value, type, category, updated_at, version 
+		FROM user_preferences 
+		WHERE tenant_id = ? AND device_id = ? AND user_id IN (%s) AND key IN (%s)
+	`
+
+	// sqliteFindUsersSQL is completed with the condition on value of sqliteFindUsersEqualSQL
+	// or sqliteFindUsersRangeSQL, whose arguments follow the key.
+	sqliteFindUsersSQL = `
+		SELECT DISTINCT user_id 
+		FROM user_preferences p 
+		WHERE tenant_id = ?1 AND device_id = '' AND user_id > ?2 AND (
+			(key = ?3 AND %s) OR
+			(?4 AND NOT EXISTS (
+				SELECT 1 FROM user_preferences q 
+				WHERE q.tenant_id = p.tenant_id AND q.device_id = '' AND q.user_id = p.user_id AND q.key = ?3
+			))
+		)
+		ORDER BY user_id 
+		LIMIT ?5
+	`
+
+	sqliteFindUsersEqualSQL = `json_extract(value, '$') = ?6`
+
+	sqliteFindUsersRangeSQL = `json_type(value) IN ('integer', 'real') 
+			AND (?6 IS NULL OR json_extract(value, '$') >= ?6) 
+			AND (?7 IS NULL OR json_extract(value, '$') <= ?7)`
+
+	sqliteStatsCountsSQL = `
+		SELECT COUNT(DISTINCT user_id), COUNT(CASE WHEN key = ?2 THEN 1 END) 
+		FROM user_preferences 
+		WHERE tenant_id = ?1 AND device_id = ''
+	`
+
+	sqliteStatsValuesSQL = `
+		SELECT value, COUNT(*) AS n 
+		FROM user_preferences 
+		WHERE tenant_id = ?1 AND device_id = '' AND key = ?2 AND (NOT ?3 OR json_type(value) NOT IN ('integer', 'real')) 
+		GROUP BY value 
+		ORDER BY n DESC, value 
+		LIMIT ?4
+	`
+
+	sqliteStatsNumericRangeSQL = `
+		SELECT MIN(json_extract(value, '$')), MAX(json_extract(value, '$')), COUNT(*) 
+		FROM user_preferences 
+		WHERE tenant_id = ?1 AND device_id = '' AND key = ?2 AND json_type(value) IN ('integer', 'real')
+	`
+
+	sqliteStatsBucketsSQL = `
+		SELECT MIN(CAST((json_extract(value, '$') - ?3) * ?5 / (?4 - ?3) AS INTEGER), ?5 - 1) AS bucket, COUNT(*) 
+		FROM user_preferences 
+		WHERE tenant_id = ?1 AND device_id = '' AND key = ?2 AND json_type(value) IN ('integer', 'real') 
+		GROUP BY bucket
+	`
+
+	// sqliteDeleteManySQL is completed with one "?" placeholder per key.
+	sqliteDeleteManySQL = `
+		DELETE FROM user_preferences 
+		WHERE tenant_id = ? AND device_id = ? AND user_id = ? AND key IN (%s)
+	`
+
+	sqliteCreateTombstonesTableSQL = `
+		CREATE TABLE IF NOT EXISTS preference_tombstones (
+			tenant_id TEXT NOT NULL DEFAULT '',
+			device_id TEXT NOT NULL DEFAULT '',
+			user_id TEXT NOT NULL,
+			key TEXT NOT NULL,
+			deleted_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (tenant_id, user_id, key, device_id)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_preference_tombstones_deleted_at 
+		ON preference_tombstones(deleted_at);
+	`
+
+	// sqliteBurySQL records tombstones for the rows that sqliteDeleteSQL is about to delete.
+	sqliteBurySQL = `
+		INSERT INTO preference_tombstones (tenant_id, device_id, user_id, key, deleted_at)
+		SELECT tenant_id, device_id, user_id, key, ? 
+		FROM user_preferences 
+		WHERE tenant_id = ? AND device_id = ? AND user_id = ? AND key = ?
+		ON CONFLICT(tenant_id, user_id, key, device_id) 
+		DO UPDATE SET deleted_at = excluded.deleted_at
+	`
+
+	// sqliteBuryManySQL records tombstones for the rows that sqliteDeleteManySQL is about to
+	// delete. It is completed with one "?" placeholder per key.
+	sqliteBuryManySQL = `
+		INSERT INTO preference_tombstones (tenant_id, device_id, user_id, key, deleted_at)
+		SELECT tenant_id, device_id, user_id, key, ? 
+		FROM user_preferences 
+		WHERE tenant_id = ? AND device_id = ? AND user_id = ? AND key IN (%s)
+		ON CONFLICT(tenant_id, user_id, key, device_id) 
+		DO UPDATE SET deleted_at = excluded.deleted_at
+	`
+
+	sqliteDeleteTombstonesSQL = `
+		DELETE FROM preference_tombstones 
+		WHERE tenant_id = ? AND user_id = ?
+	`
+
+	sqlitePruneTombstonesSQL = `
+		DELETE FROM preference_tombstones 
+		WHERE deleted_at < ?
+	`
+
+	sqliteChangedSinceSQL = `
+		SELECT user_id, key, value, default_value, type, category, updated_at, version 
+		FROM user_preferences 
+		WHERE tenant_id = ? AND device_id = ? AND user_id = ? AND updated_at > ?
+		ORDER BY key
+	`
+
+	sqliteTombstonesSinceSQL = `
+		SELECT key, deleted_at 
+		FROM preference_tombstones t 
+		WHERE tenant_id = ? AND device_id = ? AND user_id = ? AND deleted_at > ? AND NOT EXISTS (
+			SELECT 1 FROM user_preferences p 
+			WHERE p.tenant_id = t.tenant_id AND p.device_id = t.device_id AND p.user_id = t.user_id AND p.key = t.key
+		)
+		ORDER BY key
+	`
+
+	sqliteCreateDefinitionsTableSQL = `
+		CREATE TABLE IF NOT EXISTS preference_definitions (
+			tenant_id TEXT NOT NULL DEFAULT '',
+			key TEXT NOT NULL,
+			definition TEXT NOT NULL,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (tenant_id, key)
+		);
+	`
+
+	sqliteSaveDefinitionSQL = `
+		INSERT INTO preference_definitions (tenant_id, key, definition, updated_at)
+		VALUES (?1, ?2, ?3, ?4)
+		ON CONFLICT(tenant_id, key) 
+		DO UPDATE SET definition = ?3, updated_at = ?4
+	`
+
+	sqliteDeleteDefinitionSQL = `
+		DELETE FROM preference_definitions 
+		WHERE tenant_id = ? AND key = ?
+	`
+
+	sqliteLoadDefinitionsSQL = `
+		SELECT tenant_id, definition 
+		FROM preference_definitions
 	`
 )
 
@@ -145,6 +320,9 @@ func WithSQLiteExtraParam(key, value string) SQLiteOption {
 }
 
 // SQLiteStorage implements the Storage interface using SQLite.
+// Rows are keyed by (tenant_id, user_id, key, device_id); every operation is scoped to the tenant
+// returned by userprefs.TenantFromContext and, except DeleteAll, to the device returned by
+// userprefs.DeviceFromContext.
 type SQLiteStorage struct {
 	db *sql.DB
 }
@@ -239,12 +417,122 @@ func NewSQLiteStorage(filePath string, opts ...SQLiteOption) (*SQLiteStorage, er
 	return storage, nil
 }
 
+// sqliteAddedColumns lists columns introduced after the original schema, in the order they were added.
+// migrate adds any that are missing so databases created by older releases keep working.
+var sqliteAddedColumns = []struct {
+	name       string
+	definition string
+}{
+	{name: "version", definition: "INTEGER NOT NULL DEFAULT 0"},
+}
+
 // migrate runs the necessary database migrations.
 func (s *SQLiteStorage) migrate() error {
 	_, err := s.db.Exec(sqliteCreateTableSQL)
 	if err != nil {
 		return fmt.Errorf("sqlite: failed to execute create table statement: %w", err)
 	}
+
+	for _, col := range sqliteAddedColumns {
+		if err := s.addColumnIfMissing(col.name, col.definition); err != nil {
+			return err
+		}
+	}
+	if err := s.addTenantColumn(); err != nil {
+		return err
+	}
+	if err := s.addDeviceColumn(); err != nil {
+		return err
+	}
+
+	if _, err := s.db.Exec(sqliteCreateIndexesSQL); err != nil {
+		return fmt.Errorf("sqlite: failed to create indexes: %w", err)
+	}
+
+	if _, err := s.db.Exec(sqliteCreateTombstonesTableSQL); err != nil {
+		return fmt.Errorf("sqlite: failed to create tombstones table: %w", err)
+	}
+
+	if _, err := s.db.Exec(sqliteCreateDefinitionsTableSQL); err != nil {
+		return fmt.Errorf("sqlite: failed to create definitions table: %w", err)
+	}
+	return nil
+}
+
+// addTenantColumn upgrades tables created before multi-tenancy, which are keyed by
+// (user_id, key) only. Existing rows are assigned to the default tenant.
+func (s *SQLiteStorage) addTenantColumn() error {
+	return s.rebuildWithColumn("tenant_id",
+		`INSERT INTO user_preferences (tenant_id, user_id, key, value, default_value, type, category, updated_at, version)
+		SELECT '', user_id, key, value, default_value, type, category, updated_at, version FROM user_preferences_pre_tenant_id`)
+}
+
+// addDeviceColumn upgrades tables created before per-device overrides, which are keyed by
+// (tenant_id, user_id, key). Existing rows become the users' own values.
+func (s *SQLiteStorage) addDeviceColumn() error {
+	return s.rebuildWithColumn("device_id",
+		`INSERT INTO user_preferences (tenant_id, device_id, user_id, key, value, default_value, type, category, updated_at, version)
+		SELECT tenant_id, '', user_id, key, value, default_value, type, category, updated_at, version FROM user_preferences_pre_device_id`)
+}
+
+// rebuildWithColumn adds column, which is part of the primary key, to a user_preferences table
+// that lacks it. SQLite cannot change a primary key in place, so the table is renamed to
+// user_preferences_pre_{column}, recreated with the current schema, and filled by copySQL.
+func (s *SQLiteStorage) rebuildWithColumn(column, copySQL string) (err error) {
+	var count int
+	err = s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('user_preferences') WHERE name = ?`, column).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("sqlite: failed to inspect column '%s': %w", column, err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("sqlite: failed to begin migration of column '%s': %w", column, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	steps := []string{
+		`ALTER TABLE user_preferences RENAME TO user_preferences_pre_` + column,
+		sqliteCreateTableSQL,
+		copySQL,
+		`DROP TABLE user_preferences_pre_` + column,
+		// The category index moved with the renamed table and was dropped with it.
+		sqliteCreateTableSQL,
+	}
+	for _, step := range steps {
+		if _, err = tx.Exec(step); err != nil {
+			return fmt.Errorf("sqlite: failed to add column '%s': %w", column, err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("sqlite: failed to commit migration of column '%s': %w", column, err)
+	}
+	return nil
+}
+
+// addColumnIfMissing adds a column to user_preferences unless it already exists.
+// SQLite has no ADD COLUMN IF NOT EXISTS, so the table info is consulted first.
+func (s *SQLiteStorage) addColumnIfMissing(name, definition string) error {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('user_preferences') WHERE name = ?`, name).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("sqlite: failed to inspect column '%s': %w", name, err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	// #nosec G201 -- name and definition come from the package-level sqliteAddedColumns list.
+	if _, err := s.db.Exec(fmt.Sprintf("ALTER TABLE user_preferences ADD COLUMN %s %s", name, definition)); err != nil {
+		return fmt.Errorf("sqlite: failed to add column '%s': %w", name, err)
+	}
 	return nil
 }
 
@@ -263,7 +551,7 @@ func (s *SQLiteStorage) Get(ctx context.Context, userID, key string) (*userprefs
 	var defaultValueJSON sql.NullString // Added for DefaultValue
 	var category sql.NullString         // Use sql.NullString for nullable category
 
-	err := s.db.QueryRowContext(ctx, sqliteSelectSQL, userID, key).Scan(
+	err := s.db.QueryRowContext(ctx, sqliteSelectSQL, userprefs.TenantFromContext(ctx), userprefs.DeviceFromContext(ctx), userID, key).Scan(
 		&pref.UserID,
 		&pref.Key,
 		&valueJSON,
@@ -271,6 +559,7 @@ func (s *SQLiteStorage) Get(ctx context.Context, userID, key string) (*userprefs
 		&pref.Type,
 		&category, // Scan into sql.NullString
 		&pref.UpdatedAt,
+		&pref.Version,
 	)
 
 	if err == sql.ErrNoRows {
@@ -307,7 +596,7 @@ func (s *SQLiteStorage) Get(ctx context.Context, userID, key string) (*userprefs
 // The pref.Value and pref.DefaultValue fields are marshalled to JSON (as TEXT) for storage.
 // This operation is an "upsert" (INSERT ON CONFLICT DO UPDATE): if a preference with the
 // given userID and key already exists, it is updated; otherwise, a new preference is created.
-// The UpdatedAt field of the preference is set to the current time by the database.
+// The UpdatedAt field of the preference is stored in UTC, so that ChangesSince can compare it.
 //
 // Returns nil on successful creation or update.
 // Returns an error if marshalling to JSON fails (wrapping userprefs.ErrSerialization),
@@ -329,16 +618,20 @@ func (s *SQLiteStorage) Set(ctx context.Context, pref *userprefs.Preference) err
 	}
 
 	_, err = s.db.ExecContext(ctx, sqliteInsertSQL,
+		userprefs.TenantFromContext(ctx),
+		userprefs.DeviceFromContext(ctx),
 		pref.UserID,
 		pref.Key,
 		string(valueJSON),        // value for INSERT
 		string(defaultValueJSON), // default_value for INSERT
 		pref.Type,
 		pref.Category,
-		pref.UpdatedAt,           // updated_at for INSERT
+		pref.UpdatedAt.UTC(),     // updated_at for INSERT
+		pref.Version,             // version for INSERT
 		string(valueJSON),        // value for UPDATE
 		string(defaultValueJSON), // default_value for UPDATE
-		pref.UpdatedAt,           // updated_at for UPDATE
+		pref.UpdatedAt.UTC(),     // updated_at for UPDATE
+		pref.Version,             // version for UPDATE
 	)
 
 	if err != nil {
@@ -358,7 +651,7 @@ func (s *SQLiteStorage) Set(ctx context.Context, pref *userprefs.Preference) err
 // If any stored preference value or default value cannot be unmarshalled from JSON,
 // it returns nil and an error wrapping userprefs.ErrSerialization.
 func (s *SQLiteStorage) GetByCategory(ctx context.Context, userID, category string) (map[string]*userprefs.Preference, error) {
-	rows, err := s.db.QueryContext(ctx, sqliteSelectByCategorySQL, userID, category)
+	rows, err := s.db.QueryContext(ctx, sqliteSelectByCategorySQL, userprefs.TenantFromContext(ctx), userprefs.DeviceFromContext(ctx), userID, category)
 	if err != nil {
 		return nil, fmt.Errorf("sqlite: failed to query preferences by category for user '%s', category '%s': %w", userID, category, err)
 	}
@@ -376,7 +669,7 @@ func (s *SQLiteStorage) GetByCategory(ctx context.Context, userID, category stri
 // If any stored preference value or default value cannot be unmarshalled from JSON,
 // it returns nil and an error wrapping userprefs.ErrSerialization.
 func (s *SQLiteStorage) GetAll(ctx context.Context, userID string) (map[string]*userprefs.Preference, error) {
-	rows, err := s.db.QueryContext(ctx, sqliteSelectAllSQL, userID)
+	rows, err := s.db.QueryContext(ctx, sqliteSelectAllSQL, userprefs.TenantFromContext(ctx), userprefs.DeviceFromContext(ctx), userID)
 	if err != nil {
 		return nil, fmt.Errorf("sqlite: failed to query all preferences for user '%s': %w", userID, err)
 	}
@@ -384,7 +677,112 @@ func (s *SQLiteStorage) GetAll(ctx context.Context, userID string) (map[string]*
 	return s.scanPreferences(ctx, rows) // scanPreferences now handles rows.Close()
 }
 
-// Delete removes a specific preference for a given user ID and key.
+// ListByKey returns up to limit preferences stored under key, ordered by user ID and
+// starting strictly after afterUserID. It implements the userprefs.KeyLister interface.
+// The provided context.Context can be used for cancellation or timeouts.
+//
+// If there's an issue with database interaction, a wrapped error is returned.
+// If any stored value cannot be unmarshalled from JSON, it returns nil and an error
+// wrapping userprefs.ErrSerialization.
+func (s *SQLiteStorage) ListByKey(ctx context.Context, key, afterUserID string, limit int) ([]*userprefs.Preference, error) {
+	rows, err := s.db.QueryContext(ctx, sqliteSelectByKeySQL, userprefs.TenantFromContext(ctx), userprefs.DeviceFromContext(ctx), key, afterUserID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: failed to list preferences for key '%s': %w", key, err)
+	}
+	return s.scanPreferenceList(ctx, rows)
+}
+
+// FindUsers returns up to query.Limit IDs of users whose stored value for query.Key matches
+// query.Predicate, ordered by user ID and starting strictly after query.AfterUserID. With
+// query.IncludeMissing, users with other preferences but no value for the key are returned
+// as well. It implements the userprefs.UserFinder interface. Values are compared with
+// json_extract on the key's rows, which the (tenant_id, key) index selects.
+// The provided context.Context can be used for cancellation or timeouts.
+//
+// Returns userprefs.ErrInvalidInput for predicates other than equality and range.
+// If there's an issue with database interaction, a wrapped error is returned.
+func (s *SQLiteStorage) FindUsers(ctx context.Context, query userprefs.UserQuery) ([]string, error) {
+	args := []interface{}{userprefs.TenantFromContext(ctx), query.AfterUserID, query.Key, query.IncludeMissing, query.Limit}
+	var condition string
+	switch query.Predicate.Op {
+	case userprefs.PredicateEqual:
+		condition = sqliteFindUsersEqualSQL
+		args = append(args, query.Predicate.Value)
+	case userprefs.PredicateRange:
+		condition = sqliteFindUsersRangeSQL
+		args = append(args, query.Predicate.Min, query.Predicate.Max)
+	default:
+		return nil, fmt.Errorf("%w: unsupported predicate operator '%s'", userprefs.ErrInvalidInput, query.Predicate.Op)
+	}
+
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(sqliteFindUsersSQL, condition), args...)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: failed to find users for key '%s': %w", query.Key, err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	userIDs := make([]string, 0)
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("sqlite: failed to scan user ID: %w", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlite: error iterating users for key '%s': %w", query.Key, err)
+	}
+	return userIDs, nil
+}
+
+// ValueStats aggregates the stored values of query.Key with SQL aggregate queries: user
+// counts, the most frequent values, and, for query.Buckets > 0, a histogram of numeric values.
+// It implements the userprefs.StatsProvider interface.
+// The provided context.Context can be used for cancellation or timeouts.
+//
+// If there's an issue with database interaction, a wrapped error is returned.
+func (s *SQLiteStorage) ValueStats(ctx context.Context, query userprefs.StatsQuery) (*userprefs.PreferenceStats, error) {
+	stmts := statsSQL{counts: sqliteStatsCountsSQL, values: sqliteStatsValuesSQL, numericRange: sqliteStatsNumericRangeSQL, buckets: sqliteStatsBucketsSQL}
+	return queryValueStats(ctx, s.db, "sqlite", stmts, userprefs.TenantFromContext(ctx), query)
+}
+
+// GetForUsers retrieves the preferences stored under any of keys for any of userIDs in a
+// single query, keyed by user ID and then by key. It implements the userprefs.MultiUserGetter
+// interface. The provided context.Context can be used for cancellation or timeouts.
+//
+// Callers should keep len(userIDs)+len(keys) below SQLite's limit on bound parameters.
+// If there's an issue with database interaction, a wrapped error is returned.
+// If any stored value cannot be unmarshalled from JSON, it returns nil and an error
+// wrapping userprefs.ErrSerialization.
+func (s *SQLiteStorage) GetForUsers(ctx context.Context, userIDs, keys []string) (map[string]map[string]*userprefs.Preference, error) {
+	if len(userIDs) == 0 || len(keys) == 0 {
+		return make(map[string]map[string]*userprefs.Preference), nil
+	}
+
+	args := make([]interface{}, 0, len(userIDs)+len(keys)+2)
+	args = append(args, userprefs.TenantFromContext(ctx), userprefs.DeviceFromContext(ctx))
+	for _, userID := range userIDs {
+		args = append(args, userID)
+	}
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	userPlaceholders := strings.TrimSuffix(strings.Repeat("?, ", len(userIDs)), ", ")
+	keyPlaceholders := strings.TrimSuffix(strings.Repeat("?, ", len(keys)), ", ")
+
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(sqliteSelectForUsersSQL, userPlaceholders, keyPlaceholders), args...)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: failed to query %d keys for %d users: %w", len(keys), len(userIDs), err)
+	}
+	prefs, err := s.scanPreferenceList(ctx, rows)
+	if err != nil {
+		return nil, err
+	}
+	return groupByUser(prefs), nil
+}
+
+// Delete removes a specific preference for a given user ID and key, and records a tombstone
+// for it, in a single transaction.
 // The provided context.Context can be used for cancellation or timeouts.
 //
 // Returns nil on successful deletion.
@@ -392,24 +790,218 @@ func (s *SQLiteStorage) GetAll(ctx context.Context, userID string) (map[string]*
 // it returns userprefs.ErrNotFound.
 // If there's an issue with the database operation, a wrapped error is returned.
 func (s *SQLiteStorage) Delete(ctx context.Context, userID, key string) error {
-	result, err := s.db.ExecContext(ctx, sqliteDeleteSQL, userID, key)
+	tenantID, deviceID := userprefs.TenantFromContext(ctx), userprefs.DeviceFromContext(ctx)
+	var rowsAffected int64
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, sqliteBurySQL, time.Now().UTC(), tenantID, deviceID, userID, key); err != nil {
+			return fmt.Errorf("sqlite: failed to record tombstone for user '%s', key '%s': %w", userID, key, err)
+		}
+		result, err := tx.ExecContext(ctx, sqliteDeleteSQL, tenantID, deviceID, userID, key)
+		if err != nil {
+			return fmt.Errorf("sqlite: failed to execute delete for user '%s', key '%s': %w", userID, key, err)
+		}
+		if rowsAffected, err = result.RowsAffected(); err != nil {
+			// This error means we don't know if the delete succeeded or not, which is a problem.
+			return fmt.Errorf("sqlite: failed to get affected rows for delete user '%s', key '%s': %w", userID, key, err)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("sqlite: failed to execute delete for user '%s', key '%s': %w", userID, key, err)
+		return err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	if rowsAffected == 0 {
+		return userprefs.ErrNotFound // No rows were deleted, so the preference was not found.
+	}
+
+	return nil
+}
+
+// DeleteMany removes the preferences of userID stored under any of keys, and records tombstones
+// for them, in a single transaction. It implements the userprefs.BatchDeleter interface.
+// Keys without a stored value are ignored.
+// The provided context.Context can be used for cancellation or timeouts.
+//
+// If there's an issue with the database operation, a wrapped error is returned.
+func (s *SQLiteStorage) DeleteMany(ctx context.Context, userID string, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, 0, len(keys)+3)
+	args = append(args, userprefs.TenantFromContext(ctx), userprefs.DeviceFromContext(ctx), userID)
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(keys)), ", ")
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		buryArgs := append([]interface{}{time.Now().UTC()}, args...)
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(sqliteBuryManySQL, placeholders), buryArgs...); err != nil {
+			return fmt.Errorf("sqlite: failed to record tombstones of %d keys for user '%s': %w", len(keys), userID, err)
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(sqliteDeleteManySQL, placeholders), args...); err != nil {
+			return fmt.Errorf("sqlite: failed to execute delete of %d keys for user '%s': %w", len(keys), userID, err)
+		}
+		return nil
+	})
+}
+
+// DeleteAll removes every preference of userID, including the overrides of every device, and
+// the user's tombstones in a single transaction, and returns the removed keys. It implements
+// the userprefs.UserDeleter interface.
+// The provided context.Context can be used for cancellation or timeouts.
+//
+// If there's an issue with the database operation, a wrapped error is returned.
+func (s *SQLiteStorage) DeleteAll(ctx context.Context, userID string) ([]string, error) {
+	tenantID := userprefs.TenantFromContext(ctx)
+	var keys []string
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, sqliteDeleteTombstonesSQL, tenantID, userID); err != nil {
+			return fmt.Errorf("sqlite: failed to delete tombstones for user '%s': %w", userID, err)
+		}
+		rows, err := tx.QueryContext(ctx, sqliteDeleteAllSQL, tenantID, userID)
+		if err != nil {
+			return fmt.Errorf("sqlite: failed to execute delete of all preferences for user '%s': %w", userID, err)
+		}
+		if keys, err = scanKeys(rows); err != nil {
+			return fmt.Errorf("sqlite: failed to read deleted keys for user '%s': %w", userID, err)
+		}
+		return nil
+	})
 	if err != nil {
-		// This error means we don't know if the delete succeeded or not, which is a problem.
-		return fmt.Errorf("sqlite: failed to get affected rows for delete user '%s', key '%s': %w", userID, key, err)
+		return nil, err
 	}
+	return keys, nil
+}
 
-	if rowsAffected == 0 {
-		return userprefs.ErrNotFound // No rows were deleted, so the preference was not found.
+// ChangesSince returns the preferences of userID updated after since, and the tombstones of
+// keys deleted after since that have not been set again, both ordered by key. It implements
+// the userprefs.ChangeTracker interface.
+// The provided context.Context can be used for cancellation or timeouts, and selects the
+// tenant and device.
+//
+// If there's an issue with the database operation, a wrapped error is returned.
+func (s *SQLiteStorage) ChangesSince(ctx context.Context, userID string, since time.Time) ([]*userprefs.Preference, []userprefs.Tombstone, error) {
+	tenantID, deviceID := userprefs.TenantFromContext(ctx), userprefs.DeviceFromContext(ctx)
+	rows, err := s.db.QueryContext(ctx, sqliteChangedSinceSQL, tenantID, deviceID, userID, since.UTC())
+	if err != nil {
+		return nil, nil, fmt.Errorf("sqlite: failed to query changes for user '%s': %w", userID, err)
 	}
+	changed, err := s.scanPreferenceList(ctx, rows)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = s.db.QueryContext(ctx, sqliteTombstonesSinceSQL, tenantID, deviceID, userID, since.UTC())
+	if err != nil {
+		return nil, nil, fmt.Errorf("sqlite: failed to query tombstones for user '%s': %w", userID, err)
+	}
+	defer rows.Close()
+
+	var deleted []userprefs.Tombstone
+	for rows.Next() {
+		tombstone := userprefs.Tombstone{DeviceID: deviceID}
+		if err := rows.Scan(&tombstone.Key, &tombstone.DeletedAt); err != nil {
+			return nil, nil, fmt.Errorf("sqlite: failed to scan tombstone for user '%s': %w", userID, err)
+		}
+		deleted = append(deleted, tombstone)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("sqlite: failed to read tombstones for user '%s': %w", userID, err)
+	}
+	return changed, deleted, nil
+}
+
+// PruneTombstones removes the tombstones of every tenant, device, and user recorded before
+// before, and returns how many were removed. It implements the userprefs.TombstonePruner
+// interface.
+// The provided context.Context can be used for cancellation or timeouts.
+//
+// If there's an issue with the database operation, a wrapped error is returned.
+func (s *SQLiteStorage) PruneTombstones(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, sqlitePruneTombstonesSQL, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("sqlite: failed to prune tombstones: %w", err)
+	}
+	pruned, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("sqlite: failed to count pruned tombstones: %w", err)
+	}
+	return pruned, nil
+}
 
+// inTx runs fn in a transaction, which is committed if fn succeeds and rolled back otherwise.
+func (s *SQLiteStorage) inTx(ctx context.Context, fn func(tx *sql.Tx) error) (err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("sqlite: failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("sqlite: failed to commit transaction: %w", err)
+	}
 	return nil
 }
 
+// SaveDefinition creates or replaces the definition of def.Key in tenantID's catalogue.
+// It implements the userprefs.DefinitionStore interface. The definition is stored as JSON,
+// without its function fields. The provided context.Context can be used for cancellation or timeouts.
+//
+// Returns an error if marshalling to JSON fails (wrapping userprefs.ErrSerialization),
+// or if the database operation fails (wrapped error).
+func (s *SQLiteStorage) SaveDefinition(ctx context.Context, tenantID string, def userprefs.PreferenceDefinition) error {
+	data, err := json.Marshal(def)
+	if err != nil {
+		return fmt.Errorf("%w: sqlite: failed to marshal definition for key '%s': %v", userprefs.ErrSerialization, def.Key, err)
+	}
+	if _, err := s.db.ExecContext(ctx, sqliteSaveDefinitionSQL, tenantID, def.Key, string(data), time.Now()); err != nil {
+		return fmt.Errorf("sqlite: failed to save definition for key '%s': %w", def.Key, err)
+	}
+	return nil
+}
+
+// DeleteDefinition removes key from tenantID's catalogue. It implements the
+// userprefs.DefinitionStore interface; deleting a key that is not stored is not an error.
+// The provided context.Context can be used for cancellation or timeouts.
+//
+// If there's an issue with the database operation, a wrapped error is returned.
+func (s *SQLiteStorage) DeleteDefinition(ctx context.Context, tenantID, key string) error {
+	if _, err := s.db.ExecContext(ctx, sqliteDeleteDefinitionSQL, tenantID, key); err != nil {
+		return fmt.Errorf("sqlite: failed to delete definition for key '%s': %w", key, err)
+	}
+	return nil
+}
+
+// LoadDefinitions returns the stored definitions of every tenant, keyed by tenant ID and then
+// by key. It implements the userprefs.DefinitionStore interface.
+// The provided context.Context can be used for cancellation or timeouts.
+//
+// If there's an issue with database interaction, a wrapped error is returned.
+// If a stored definition cannot be unmarshalled from JSON, it returns nil and an error
+// wrapping userprefs.ErrSerialization.
+func (s *SQLiteStorage) LoadDefinitions(ctx context.Context) (map[string]map[string]userprefs.PreferenceDefinition, error) {
+	rows, err := s.db.QueryContext(ctx, sqliteLoadDefinitionsSQL)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: failed to query definitions: %w", err)
+	}
+	return scanDefinitions(rows, "sqlite")
+}
+
+// ScopesByContext reports that every operation is scoped to the tenant and device in its
+// context. It implements the userprefs.ScopedStorage interface.
+func (s *SQLiteStorage) ScopesByContext() bool {
+	return true
+}
+
 // Close closes the underlying SQLite database connection.
 // It is important to call Close when the SQLiteStorage is no longer needed
 // to release database resources, especially for file-based databases.
@@ -420,10 +1012,25 @@ func (s *SQLiteStorage) Close() error {
 	return s.db.Close()
 }
 
-// scanPreferences scans rows and constructs a map of preferences.
+// scanPreferences scans rows and constructs a map of preferences keyed by preference key.
+// It is intended for queries scoped to a single user.
+func (s *SQLiteStorage) scanPreferences(ctx context.Context, rows *sql.Rows) (map[string]*userprefs.Preference, error) {
+	prefs, err := s.scanPreferenceList(ctx, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	prefsMap := make(map[string]*userprefs.Preference, len(prefs))
+	for _, pref := range prefs {
+		prefsMap[pref.Key] = pref
+	}
+	return prefsMap, nil
+}
+
+// scanPreferenceList scans rows into a slice of preferences, preserving row order.
 // It handles closing the rows object, returning any error from rows.Close()
 // if no other error occurred, or wrapping it if another error was primary.
-func (s *SQLiteStorage) scanPreferences(ctx context.Context, rows *sql.Rows) (prefsMap map[string]*userprefs.Preference, err error) {
+func (s *SQLiteStorage) scanPreferenceList(ctx context.Context, rows *sql.Rows) (prefsList []*userprefs.Preference, err error) {
 	defer func() {
 		closeErr := rows.Close()
 		if closeErr != nil {
@@ -436,14 +1043,14 @@ func (s *SQLiteStorage) scanPreferences(ctx context.Context, rows *sql.Rows) (pr
 		}
 	}()
 
-	prefsMap = make(map[string]*userprefs.Preference)
+	prefsList = make([]*userprefs.Preference, 0)
 
 	for rows.Next() {
 		// Check for context cancellation before processing each row
 		select {
 		case <-ctx.Done():
 			err = ctx.Err()
-			return // prefsMap will be nil, err will be set by defer or here
+			return nil, err
 		default:
 			// Continue processing
 		}
@@ -460,6 +1067,7 @@ func (s *SQLiteStorage) scanPreferences(ctx context.Context, rows *sql.Rows) (pr
 			&pref.Type,
 			&category, // Scan into sql.NullString
 			&pref.UpdatedAt,
+			&pref.Version,
 		)
 		if scanErr != nil {
 			err = fmt.Errorf("sqlite: failed to scan preference row: %w", scanErr)
@@ -486,7 +1094,7 @@ func (s *SQLiteStorage) scanPreferences(ctx context.Context, rows *sql.Rows) (pr
 			pref.DefaultValue = nil
 		}
 
-		prefsMap[pref.Key] = &pref
+		prefsList = append(prefsList, &pref)
 	}
 
 	// Check for errors encountered during iteration.
@@ -495,5 +1103,5 @@ func (s *SQLiteStorage) scanPreferences(ctx context.Context, rows *sql.Rows) (pr
 		return
 	}
 
-	return // prefsMap will be returned, err is nil or set by defer
+	return // prefsList will be returned, err is nil or set by defer
 }
@@ -364,6 +364,167 @@ func TestSQLiteStorage_Delete(t *testing.T) {
 	assert.ErrorIs(t, err, userprefs.ErrNotFound, "Expected ErrNotFound when deleting non-existent key")
 }
 
+func TestSQLiteStorage_DeleteMany(t *testing.T) {
+	storage, cleanup := setupSQLiteTest(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	for _, key := range []string{"theme", "volume", "language"} {
+		require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u1", Key: key, Value: "x", Type: "string", UpdatedAt: time.Now()}))
+	}
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u2", Key: "theme", Value: "x", Type: "string", UpdatedAt: time.Now()}))
+
+	require.NoError(t, storage.DeleteMany(ctx, "u1", []string{"theme", "volume", "missing"}))
+
+	all, err := storage.GetAll(ctx, "u1")
+	require.NoError(t, err)
+	assert.Len(t, all, 1)
+	assert.Contains(t, all, "language")
+
+	_, err = storage.Get(ctx, "u2", "theme")
+	assert.NoError(t, err, "DeleteMany should not affect other users")
+	assert.NoError(t, storage.DeleteMany(ctx, "u1", nil))
+}
+
+func TestSQLiteStorage_DeleteAll(t *testing.T) {
+	storage, cleanup := setupSQLiteTest(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	acme := userprefs.WithTenant(ctx, "acme")
+	for _, key := range []string{"volume", "theme"} {
+		require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u1", Key: key, Value: "x", Type: "string", UpdatedAt: time.Now()}))
+	}
+	require.NoError(t, storage.Set(acme, &userprefs.Preference{UserID: "u1", Key: "theme", Value: "x", Type: "string", UpdatedAt: time.Now()}))
+
+	keys, err := storage.DeleteAll(ctx, "u1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"theme", "volume"}, keys)
+
+	all, err := storage.GetAll(ctx, "u1")
+	require.NoError(t, err)
+	assert.Empty(t, all)
+	_, err = storage.Get(acme, "u1", "theme")
+	assert.NoError(t, err, "DeleteAll should not affect other tenants")
+
+	keys, err = storage.DeleteAll(ctx, "u1")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestSQLiteStorage_GetForUsers(t *testing.T) {
+	storage, cleanup := setupSQLiteTest(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	acme := userprefs.WithTenant(ctx, "acme")
+	set := func(ctx context.Context, userID, key string, value interface{}) {
+		require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: userID, Key: key, Value: value, Type: "string", UpdatedAt: time.Now()}))
+	}
+	set(ctx, "u1", "theme", "dark")
+	set(ctx, "u1", "language", "en")
+	set(ctx, "u1", "unrequested", "x")
+	set(ctx, "u2", "theme", "light")
+	set(ctx, "u3", "theme", "blue")
+	set(acme, "u2", "language", "fr")
+
+	got, err := storage.GetForUsers(ctx, []string{"u1", "u2", "unknown"}, []string{"theme", "language"})
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	require.Len(t, got["u1"], 2)
+	assert.Equal(t, "dark", got["u1"]["theme"].Value)
+	assert.Equal(t, "en", got["u1"]["language"].Value)
+	require.Len(t, got["u2"], 1, "Values of other tenants should not be returned")
+	assert.Equal(t, "light", got["u2"]["theme"].Value)
+
+	empty, err := storage.GetForUsers(ctx, nil, []string{"theme"})
+	require.NoError(t, err)
+	assert.Empty(t, empty)
+}
+
+func TestSQLiteStorage_FindUsers(t *testing.T) {
+	storage, cleanup := setupSQLiteTest(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	set := func(ctx context.Context, userID, key string, value interface{}) {
+		require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: userID, Key: key, Value: value, Type: "string", UpdatedAt: time.Now()}))
+	}
+	set(ctx, "u1", "opt_in", true)
+	set(ctx, "u2", "opt_in", false)
+	set(ctx, "u3", "theme", "dark")
+	set(ctx, "u4", "opt_in", true)
+	set(ctx, "u1", "age", 30)
+	set(ctx, "u2", "age", 17.5)
+	set(ctx, "u4", "age", "30")
+	set(ctx, "u3", "plan", "pro")
+	set(ctx, "u4", "plan", "free")
+	set(userprefs.WithTenant(ctx, "acme"), "u5", "opt_in", true)
+
+	testCases := []struct {
+		name  string
+		query userprefs.UserQuery
+		want  []string
+	}{
+		{"bool equality", userprefs.UserQuery{Key: "opt_in", Predicate: userprefs.Equals(true), Limit: 10}, []string{"u1", "u4"}},
+		{"false equality", userprefs.UserQuery{Key: "opt_in", Predicate: userprefs.Equals(false), Limit: 10}, []string{"u2"}},
+		{"string equality", userprefs.UserQuery{Key: "plan", Predicate: userprefs.Equals("pro"), Limit: 10}, []string{"u3"}},
+		{"int equality matches float", userprefs.UserQuery{Key: "age", Predicate: userprefs.Equals(30.0), Limit: 10}, []string{"u1"}},
+		{"include missing", userprefs.UserQuery{Key: "opt_in", Predicate: userprefs.Equals(true), IncludeMissing: true, Limit: 10}, []string{"u1", "u3", "u4"}},
+		{"pagination", userprefs.UserQuery{Key: "opt_in", Predicate: userprefs.Equals(true), IncludeMissing: true, AfterUserID: "u1", Limit: 1}, []string{"u3"}},
+		{"range", userprefs.UserQuery{Key: "age", Predicate: userprefs.InRange(17.0, 18.0), Limit: 10}, []string{"u2"}},
+		{"open range ignores strings", userprefs.UserQuery{Key: "age", Predicate: userprefs.InRange(nil, 100.0), Limit: 10}, []string{"u1", "u2"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			found, err := storage.FindUsers(ctx, tc.query)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, found)
+		})
+	}
+
+	_, err := storage.FindUsers(ctx, userprefs.UserQuery{Key: "age", Predicate: userprefs.Predicate{Op: "like"}, Limit: 10})
+	assert.ErrorIs(t, err, userprefs.ErrInvalidInput)
+}
+
+func TestSQLiteStorage_ValueStats(t *testing.T) {
+	storage, cleanup := setupSQLiteTest(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	set := func(ctx context.Context, userID, key string, value interface{}) {
+		require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: userID, Key: key, Value: value, Type: "string", UpdatedAt: time.Now()}))
+	}
+	set(ctx, "u1", "theme", "dark")
+	set(ctx, "u2", "theme", "dark")
+	set(ctx, "u3", "theme", "light")
+	set(ctx, "u4", "volume", 0)
+	set(ctx, "u1", "volume", 10)
+	set(ctx, "u2", "volume", 5.5)
+	set(ctx, "u3", "volume", "loud")
+	set(ctx, "u5", "language", "en")
+	set(userprefs.WithTenant(ctx, "acme"), "u6", "theme", "dark")
+
+	stats, err := storage.ValueStats(ctx, userprefs.StatsQuery{Key: "theme", MaxValues: 10})
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.ExplicitCount)
+	assert.Equal(t, 2, stats.DefaultCount)
+	assert.Equal(t, []userprefs.ValueCount{{Value: "dark", Count: 2}, {Value: "light", Count: 1}}, stats.Values)
+
+	stats, err = storage.ValueStats(ctx, userprefs.StatsQuery{Key: "volume", Buckets: 2, MaxValues: 10})
+	require.NoError(t, err)
+	assert.Equal(t, 4, stats.ExplicitCount)
+	assert.Equal(t, []userprefs.ValueCount{{Value: "loud", Count: 1}}, stats.Values)
+	assert.Equal(t, []userprefs.NumericBucket{{Min: 0, Max: 5, Count: 1}, {Min: 5, Max: 10, Count: 2}}, stats.Buckets)
+
+	stats, err = storage.ValueStats(ctx, userprefs.StatsQuery{Key: "unused", Buckets: 2, MaxValues: 10})
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.ExplicitCount)
+	assert.Equal(t, 5, stats.DefaultCount)
+	assert.Empty(t, stats.Values)
+	assert.Empty(t, stats.Buckets)
+}
+
 func TestSQLiteStorage_GetByCategory(t *testing.T) {
 	storage, cleanup := setupSQLiteTest(t)
 	defer cleanup()
@@ -711,3 +872,302 @@ func TestSQLiteStorage_CloseBehavior(t *testing.T) {
 		t.Logf("Warning: Failed to remove test database %s: %v", dbPath, errRemove)
 	}
 }
+
+func TestSQLiteStorage_ListByKey(t *testing.T) {
+	storage, cleanup := setupSQLiteTest(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	for _, userID := range []string{"userC", "userA", "userB"} {
+		require.NoError(t, storage.Set(ctx, &userprefs.Preference{
+			UserID: userID, Key: "layout", Value: "compact", Type: "json", UpdatedAt: time.Now(), Version: 2,
+		}))
+	}
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "userA", Key: "other", Value: "x", Type: "string", UpdatedAt: time.Now()}))
+
+	page, err := storage.ListByKey(ctx, "layout", "", 2)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, "userA", page[0].UserID)
+	assert.Equal(t, "userB", page[1].UserID)
+	assert.Equal(t, 2, page[0].Version)
+
+	page, err = storage.ListByKey(ctx, "layout", "userB", 2)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, "userC", page[0].UserID)
+}
+
+func TestSQLiteStorage_MigratesLegacySchema(t *testing.T) {
+	dbPath := fmt.Sprintf("test_legacy_schema_%d.db", time.Now().UnixNano())
+	defer func() { _ = os.Remove(dbPath) }()
+
+	// Create a table using the schema shipped before the version column existed.
+	db, err := sql.Open("sqlite3", dbPath)
+	require.NoError(t, err)
+	_, err = db.Exec(`
+		CREATE TABLE user_preferences (
+			user_id TEXT NOT NULL,
+			key TEXT NOT NULL,
+			value TEXT NOT NULL,
+			default_value TEXT,
+			type TEXT NOT NULL,
+			category TEXT,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, key)
+		);
+		INSERT INTO user_preferences (user_id, key, value, type, updated_at) VALUES ('u1', 'layout', '"compact"', 'json', CURRENT_TIMESTAMP);
+	`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	storage, err := NewSQLiteStorage(dbPath)
+	require.NoError(t, err)
+	defer func() { _ = storage.Close() }()
+
+	pref, err := storage.Get(context.Background(), "u1", "layout")
+	require.NoError(t, err)
+	assert.Equal(t, "compact", pref.Value)
+	assert.Equal(t, 0, pref.Version, "Legacy rows should default to version 0")
+
+	// The rebuilt table is keyed by tenant, so another tenant can store the same user and key.
+	tenantCtx := userprefs.WithTenant(context.Background(), "acme")
+	require.NoError(t, storage.Set(tenantCtx, &userprefs.Preference{UserID: "u1", Key: "layout", Value: "wide", Type: "json", UpdatedAt: time.Now()}))
+	pref, err = storage.Get(context.Background(), "u1", "layout")
+	require.NoError(t, err)
+	assert.Equal(t, "compact", pref.Value, "Legacy rows should belong to the default tenant")
+}
+
+func TestSQLiteStorage_TenantIsolation(t *testing.T) {
+	storage, cleanup := setupSQLiteTest(t)
+	defer cleanup()
+
+	acme := userprefs.WithTenant(context.Background(), "acme")
+	globex := userprefs.WithTenant(context.Background(), "globex")
+	require.NoError(t, storage.Set(acme, &userprefs.Preference{UserID: "u1", Key: "theme", Value: "dark", Type: "string", Category: "ui", UpdatedAt: time.Now()}))
+	require.NoError(t, storage.Set(globex, &userprefs.Preference{UserID: "u1", Key: "theme", Value: "light", Type: "string", Category: "ui", UpdatedAt: time.Now()}))
+
+	pref, err := storage.Get(acme, "u1", "theme")
+	require.NoError(t, err)
+	assert.Equal(t, "dark", pref.Value)
+
+	_, err = storage.Get(context.Background(), "u1", "theme")
+	assert.ErrorIs(t, err, userprefs.ErrNotFound, "The default tenant should not see other tenants' rows")
+
+	all, err := storage.GetAll(globex, "u1")
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	assert.Equal(t, "light", all["theme"].Value)
+
+	byCategory, err := storage.GetByCategory(globex, "u1", "ui")
+	require.NoError(t, err)
+	assert.Len(t, byCategory, 1)
+
+	page, err := storage.ListByKey(acme, "theme", "", 10)
+	require.NoError(t, err)
+	assert.Len(t, page, 1)
+
+	require.NoError(t, storage.Delete(acme, "u1", "theme"))
+	pref, err = storage.Get(globex, "u1", "theme")
+	require.NoError(t, err)
+	assert.Equal(t, "light", pref.Value, "Deleting in one tenant should not affect another")
+}
+
+func TestSQLiteStorage_MigratesTenantSchema(t *testing.T) {
+	dbPath := fmt.Sprintf("test_tenant_schema_%d.db", time.Now().UnixNano())
+	defer func() { _ = os.Remove(dbPath) }()
+
+	// Create a table using the schema shipped before the device_id column existed.
+	db, err := sql.Open("sqlite3", dbPath)
+	require.NoError(t, err)
+	_, err = db.Exec(`
+		CREATE TABLE user_preferences (
+			tenant_id TEXT NOT NULL DEFAULT '',
+			user_id TEXT NOT NULL,
+			key TEXT NOT NULL,
+			value TEXT NOT NULL,
+			default_value TEXT,
+			type TEXT NOT NULL,
+			category TEXT,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			version INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (tenant_id, user_id, key)
+		);
+		INSERT INTO user_preferences (tenant_id, user_id, key, value, type, updated_at, version) VALUES ('acme', 'u1', 'layout', '"compact"', 'json', CURRENT_TIMESTAMP, 3);
+	`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	storage, err := NewSQLiteStorage(dbPath)
+	require.NoError(t, err)
+	defer func() { _ = storage.Close() }()
+
+	acme := userprefs.WithTenant(context.Background(), "acme")
+	pref, err := storage.Get(acme, "u1", "layout")
+	require.NoError(t, err)
+	assert.Equal(t, "compact", pref.Value)
+	assert.Equal(t, 3, pref.Version)
+
+	// The rebuilt table is keyed by device, so a device can override the same user and key.
+	mobile := userprefs.WithDevice(acme, "mobile")
+	require.NoError(t, storage.Set(mobile, &userprefs.Preference{UserID: "u1", Key: "layout", Value: "wide", Type: "json", UpdatedAt: time.Now()}))
+	pref, err = storage.Get(acme, "u1", "layout")
+	require.NoError(t, err)
+	assert.Equal(t, "compact", pref.Value, "Existing rows should be the user's own values")
+}
+
+func TestSQLiteStorage_DeviceScoping(t *testing.T) {
+	storage, cleanup := setupSQLiteTest(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	mobile := userprefs.WithDevice(ctx, "mobile")
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u1", Key: "layout", Value: "comfortable", Type: "enum", Category: "ui", UpdatedAt: time.Now()}))
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u1", Key: "theme", Value: "dark", Type: "string", Category: "ui", UpdatedAt: time.Now()}))
+	require.NoError(t, storage.Set(mobile, &userprefs.Preference{UserID: "u1", Key: "layout", Value: "compact", Type: "enum", Category: "ui", UpdatedAt: time.Now()}))
+
+	pref, err := storage.Get(mobile, "u1", "layout")
+	require.NoError(t, err)
+	assert.Equal(t, "compact", pref.Value)
+	pref, err = storage.Get(ctx, "u1", "layout")
+	require.NoError(t, err)
+	assert.Equal(t, "comfortable", pref.Value, "The device's override should not replace the user's own value")
+	_, err = storage.Get(mobile, "u1", "theme")
+	assert.ErrorIs(t, err, userprefs.ErrNotFound, "A device should only see its own overrides")
+
+	byCategory, err := storage.GetByCategory(mobile, "u1", "ui")
+	require.NoError(t, err)
+	assert.Len(t, byCategory, 1)
+	all, err := storage.GetAll(ctx, "u1")
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+
+	users, err := storage.FindUsers(mobile, userprefs.UserQuery{Key: "layout", Predicate: userprefs.Equals("compact"), Limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, users, "FindUsers should only search the users' own values")
+
+	require.NoError(t, storage.Delete(mobile, "u1", "layout"))
+	_, err = storage.Get(ctx, "u1", "layout")
+	assert.NoError(t, err, "Deleting a device's override should keep the user's own value")
+
+	require.NoError(t, storage.Set(mobile, &userprefs.Preference{UserID: "u1", Key: "layout", Value: "compact", Type: "enum", UpdatedAt: time.Now()}))
+	keys, err := storage.DeleteAll(ctx, "u1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"layout", "theme"}, keys, "DeleteAll should report each key once")
+	_, err = storage.Get(mobile, "u1", "layout")
+	assert.ErrorIs(t, err, userprefs.ErrNotFound, "DeleteAll should remove the overrides of every device")
+}
+
+func TestSQLiteStorage_ChangesSince(t *testing.T) {
+	storage, cleanup := setupSQLiteTest(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	mobile := userprefs.WithDevice(ctx, "mobile")
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u1", Key: "theme", Value: "dark", Type: "string", UpdatedAt: time.Now()}))
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u1", Key: "volume", Value: float64(5), Type: "int", UpdatedAt: time.Now()}))
+	since := time.Now()
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u1", Key: "layout", Value: "comfortable", Type: "enum", UpdatedAt: time.Now()}))
+	require.NoError(t, storage.Set(mobile, &userprefs.Preference{UserID: "u1", Key: "layout", Value: "compact", Type: "enum", UpdatedAt: time.Now()}))
+	require.NoError(t, storage.Delete(ctx, "u1", "volume"))
+
+	changed, deleted, err := storage.ChangesSince(ctx, "u1", since)
+	require.NoError(t, err)
+	require.Len(t, changed, 1)
+	assert.Equal(t, "layout", changed[0].Key)
+	assert.Equal(t, "comfortable", changed[0].Value)
+	require.Len(t, deleted, 1)
+	assert.Equal(t, "volume", deleted[0].Key)
+	assert.True(t, deleted[0].DeletedAt.After(since))
+
+	changed, deleted, err = storage.ChangesSince(mobile, "u1", since)
+	require.NoError(t, err)
+	require.Len(t, changed, 1, "A device should only see its own changes")
+	assert.Equal(t, "compact", changed[0].Value)
+	assert.Empty(t, deleted)
+
+	changed, deleted, err = storage.ChangesSince(ctx, "u1", time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+	assert.Empty(t, deleted)
+
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u1", Key: "volume", Value: float64(7), Type: "int", UpdatedAt: time.Now()}))
+	require.NoError(t, storage.DeleteMany(ctx, "u1", []string{"theme", "missing"}))
+	changed, deleted, err = storage.ChangesSince(ctx, "u1", since)
+	require.NoError(t, err)
+	require.Len(t, changed, 2)
+	assert.Equal(t, "volume", changed[1].Key)
+	require.Len(t, deleted, 1, "A key set again after its deletion should not be reported as deleted")
+	assert.Equal(t, "theme", deleted[0].Key)
+
+	_, err = storage.DeleteAll(ctx, "u1")
+	require.NoError(t, err)
+	changed, deleted, err = storage.ChangesSince(ctx, "u1", since)
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+	assert.Empty(t, deleted, "DeleteAll should remove the user's tombstones")
+}
+
+func TestSQLiteStorage_PruneTombstones(t *testing.T) {
+	storage, cleanup := setupSQLiteTest(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	acme := userprefs.WithTenant(ctx, "acme")
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u1", Key: "theme", Value: "dark", Type: "string", UpdatedAt: time.Now()}))
+	require.NoError(t, storage.Set(ctx, &userprefs.Preference{UserID: "u1", Key: "volume", Value: float64(5), Type: "int", UpdatedAt: time.Now()}))
+	require.NoError(t, storage.Set(acme, &userprefs.Preference{UserID: "u2", Key: "theme", Value: "dark", Type: "string", UpdatedAt: time.Now()}))
+	require.NoError(t, storage.Delete(ctx, "u1", "volume"))
+	require.NoError(t, storage.Delete(acme, "u2", "theme"))
+	time.Sleep(10 * time.Millisecond)
+	cutoff := time.Now()
+	require.NoError(t, storage.Delete(ctx, "u1", "theme"))
+
+	pruned, err := storage.PruneTombstones(ctx, cutoff)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), pruned, "Tombstones of every tenant recorded before the cutoff should be pruned")
+
+	_, deleted, err := storage.ChangesSince(ctx, "u1", time.Time{})
+	require.NoError(t, err)
+	require.Len(t, deleted, 1)
+	assert.Equal(t, "theme", deleted[0].Key, "Tombstones recorded after the cutoff should be kept")
+	_, deleted, err = storage.ChangesSince(acme, "u2", time.Time{})
+	require.NoError(t, err)
+	assert.Empty(t, deleted)
+
+	pruned, err = storage.PruneTombstones(ctx, cutoff)
+	require.NoError(t, err)
+	assert.Zero(t, pruned)
+}
+
+func TestSQLiteStorage_Definitions(t *testing.T) {
+	storage, cleanup := setupSQLiteTest(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	theme := userprefs.PreferenceDefinition{Key: "theme", Type: "enum", DefaultValue: "light", AllowedValues: []interface{}{"light", "dark"}}
+	volume := userprefs.PreferenceDefinition{Key: "volume", Type: "int", DefaultValue: 5}
+	require.NoError(t, storage.SaveDefinition(ctx, "", theme))
+	require.NoError(t, storage.SaveDefinition(ctx, "", volume))
+	require.NoError(t, storage.SaveDefinition(ctx, "acme", volume))
+
+	volume.DefaultValue = 7
+	require.NoError(t, storage.SaveDefinition(ctx, "", volume), "Saving should replace the stored definition")
+
+	definitions, err := storage.LoadDefinitions(ctx)
+	require.NoError(t, err)
+	require.Len(t, definitions, 2)
+	assert.Equal(t, theme, definitions[""]["theme"])
+	assert.Equal(t, 7.0, definitions[""]["volume"].DefaultValue, "Numbers should be read back as decoded from JSON")
+	assert.Equal(t, 5.0, definitions["acme"]["volume"].DefaultValue)
+
+	require.NoError(t, storage.DeleteDefinition(ctx, "", "theme"))
+	require.NoError(t, storage.DeleteDefinition(ctx, "", "missing"), "Deleting a missing definition should succeed")
+	require.NoError(t, storage.DeleteDefinition(ctx, "acme", "volume"))
+
+	definitions, err = storage.LoadDefinitions(ctx)
+	require.NoError(t, err)
+	assert.Len(t, definitions, 1, "A tenant without definitions should not be returned")
+	assert.Len(t, definitions[""], 1)
+	assert.Contains(t, definitions[""], "volume")
+}
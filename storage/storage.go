@@ -3,6 +3,10 @@ package storage
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
 
 	"github.com/CreativeUnicorns/userprefs"
 )
@@ -16,3 +20,188 @@ type Storage interface {
 	GetByCategory(ctx context.Context, userID, category string) (map[string]*userprefs.Preference, error)
 	Close() error
 }
+
+// scanKeys reads a single key column from rows, closes them, and returns the distinct keys in
+// sorted order. A key appears once even if rows of several devices were stored under it.
+func scanKeys(rows *sql.Rows) ([]string, error) {
+	defer func() { _ = rows.Close() }()
+
+	keys := []string{}
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// groupByUser arranges prefs by user ID and then by key.
+func groupByUser(prefs []*userprefs.Preference) map[string]map[string]*userprefs.Preference {
+	grouped := make(map[string]map[string]*userprefs.Preference)
+	for _, pref := range prefs {
+		if grouped[pref.UserID] == nil {
+			grouped[pref.UserID] = make(map[string]*userprefs.Preference)
+		}
+		grouped[pref.UserID][pref.Key] = pref
+	}
+	return grouped
+}
+
+// addDefinition unmarshals a definition stored as JSON and adds it to definitions, keyed by
+// tenant ID and then by key. backend prefixes error messages.
+func addDefinition(definitions map[string]map[string]userprefs.PreferenceDefinition, backend, tenantID string, data []byte) error {
+	var def userprefs.PreferenceDefinition
+	if err := json.Unmarshal(data, &def); err != nil {
+		return fmt.Errorf("%w: %s: failed to unmarshal definition for tenant '%s': %v", userprefs.ErrSerialization, backend, tenantID, err)
+	}
+	if definitions[tenantID] == nil {
+		definitions[tenantID] = make(map[string]userprefs.PreferenceDefinition)
+	}
+	definitions[tenantID][def.Key] = def
+	return nil
+}
+
+// scanDefinitions reads tenant ID and JSON definition columns from rows, closes them, and
+// returns the definitions keyed by tenant ID and then by key. backend prefixes error messages.
+func scanDefinitions(rows *sql.Rows, backend string) (map[string]map[string]userprefs.PreferenceDefinition, error) {
+	defer func() { _ = rows.Close() }()
+
+	definitions := make(map[string]map[string]userprefs.PreferenceDefinition)
+	for rows.Next() {
+		var tenantID string
+		var data []byte
+		if err := rows.Scan(&tenantID, &data); err != nil {
+			return nil, fmt.Errorf("%s: failed to scan definition: %w", backend, err)
+		}
+		if err := addDefinition(definitions, backend, tenantID, data); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: error iterating definitions: %w", backend, err)
+	}
+	return definitions, nil
+}
+
+// statsSQL holds the statements a SQL backend uses to compute userprefs.PreferenceStats.
+// Their arguments are, in order:
+//   - counts: tenant ID, key; yields the number of users and the number with a value for key.
+//   - values: tenant ID, key, whether numbers are bucketed, limit; yields value and count rows.
+//   - numericRange: tenant ID, key; yields the minimum, maximum, and number of numeric values.
+//   - buckets: tenant ID, key, minimum, maximum, bucket count; yields bucket index and count rows.
+type statsSQL struct {
+	counts       string
+	values       string
+	numericRange string
+	buckets      string
+}
+
+// queryValueStats computes userprefs.PreferenceStats with the statements of a SQL backend.
+// backend prefixes error messages.
+func queryValueStats(ctx context.Context, db *sql.DB, backend string, stmts statsSQL, tenantID string, query userprefs.StatsQuery) (*userprefs.PreferenceStats, error) {
+	stats := &userprefs.PreferenceStats{}
+
+	var users int
+	if err := db.QueryRowContext(ctx, stmts.counts, tenantID, query.Key).Scan(&users, &stats.ExplicitCount); err != nil {
+		return nil, fmt.Errorf("%s: failed to count values for key '%s': %w", backend, query.Key, err)
+	}
+	stats.DefaultCount = users - stats.ExplicitCount
+	if stats.ExplicitCount == 0 {
+		return stats, nil
+	}
+
+	rows, err := db.QueryContext(ctx, stmts.values, tenantID, query.Key, query.Buckets > 0, query.MaxValues)
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to count distinct values for key '%s': %w", backend, query.Key, err)
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var raw []byte
+		var count int
+		if err := rows.Scan(&raw, &count); err != nil {
+			return nil, fmt.Errorf("%s: failed to scan value count: %w", backend, err)
+		}
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, fmt.Errorf("%w: %s: failed to unmarshal value for key '%s': %v", userprefs.ErrSerialization, backend, query.Key, err)
+		}
+		stats.Values = append(stats.Values, userprefs.ValueCount{Value: value, Count: count})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: error iterating value counts for key '%s': %w", backend, query.Key, err)
+	}
+	if query.Buckets <= 0 {
+		return stats, nil
+	}
+
+	var minValue, maxValue sql.NullFloat64
+	var numbers int
+	if err := db.QueryRowContext(ctx, stmts.numericRange, tenantID, query.Key).Scan(&minValue, &maxValue, &numbers); err != nil {
+		return nil, fmt.Errorf("%s: failed to read numeric range for key '%s': %w", backend, query.Key, err)
+	}
+	if numbers == 0 {
+		return stats, nil
+	}
+	counts := map[int]int{0: numbers}
+	if maxValue.Float64 > minValue.Float64 {
+		counts, err = queryBucketCounts(ctx, db, stmts.buckets, tenantID, query.Key, minValue.Float64, maxValue.Float64, query.Buckets)
+		if err != nil {
+			return nil, fmt.Errorf("%s: failed to count numeric buckets for key '%s': %w", backend, query.Key, err)
+		}
+	}
+	stats.Buckets = numericHistogram(minValue.Float64, maxValue.Float64, query.Buckets, counts)
+	return stats, nil
+}
+
+// queryBucketCounts runs a statsSQL buckets statement and returns the counts by bucket index.
+func queryBucketCounts(ctx context.Context, db *sql.DB, stmt, tenantID, key string, minValue, maxValue float64, buckets int) (map[int]int, error) {
+	rows, err := db.QueryContext(ctx, stmt, tenantID, key, minValue, maxValue, buckets)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	counts := make(map[int]int)
+	for rows.Next() {
+		var index, count int
+		if err := rows.Scan(&index, &count); err != nil {
+			return nil, err
+		}
+		counts[index] = count
+	}
+	return counts, rows.Err()
+}
+
+// bucketIndex returns the index of the equal-width bucket between minValue and maxValue
+// that holds v, matching the index computed by the statsSQL buckets statements.
+func bucketIndex(v, minValue, maxValue float64, buckets int) int {
+	if maxValue <= minValue {
+		return 0
+	}
+	return min(int((v-minValue)*float64(buckets)/(maxValue-minValue)), buckets-1)
+}
+
+// numericHistogram builds the histogram of buckets equal-width buckets spanning minValue to
+// maxValue from counts keyed by bucket index. A single bucket is returned when all values are equal.
+func numericHistogram(minValue, maxValue float64, buckets int, counts map[int]int) []userprefs.NumericBucket {
+	if maxValue <= minValue {
+		return []userprefs.NumericBucket{{Min: minValue, Max: maxValue, Count: counts[0]}}
+	}
+	width := (maxValue - minValue) / float64(buckets)
+	histogram := make([]userprefs.NumericBucket, buckets)
+	for i := range histogram {
+		histogram[i] = userprefs.NumericBucket{Min: minValue + float64(i)*width, Max: minValue + float64(i+1)*width, Count: counts[i]}
+	}
+	histogram[buckets-1].Max = maxValue
+	return histogram
+}
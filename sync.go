@@ -0,0 +1,524 @@
+// Package userprefs provides incremental synchronization for offline-first clients.
+package userprefs
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Tombstone records that a stored value of a preference was deleted, so that clients
+// synchronizing with Manager.ChangesSince can drop their copy.
+type Tombstone struct {
+	// Key is the preference key whose stored value was deleted.
+	Key string `json:"key"`
+	// DeviceID is the device whose override was deleted (see WithDevice). It is empty for the
+	// user's own values.
+	DeviceID string `json:"device_id,omitempty"`
+	// DeletedAt is when the value was deleted.
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// SyncChanges reports what changed in a user's stored preferences since a cursor. It is
+// returned by Manager.ChangesSince and, as SyncResult.Changes, by Manager.ApplyClientChanges.
+type SyncChanges struct {
+	// Changed holds the values written since the cursor, ordered by key and then by device.
+	// Entries with a DeviceID are device overrides of PerDevice preferences.
+	Changed []*Preference `json:"changed"`
+	// Deleted holds the values deleted since the cursor, ordered like Changed. A client falls
+	// back to the user's own value for a deleted device override, and to the default otherwise.
+	Deleted []Tombstone `json:"deleted"`
+	// Cursor is passed to the next ChangesSince or ApplyClientChanges call to receive only
+	// later changes. It is empty if nothing was ever changed.
+	Cursor string `json:"cursor"`
+}
+
+// ClientChange is a change a client made to one of the user's preferences, e.g. while offline.
+type ClientChange struct {
+	// Key is the preference key that was changed.
+	Key string `json:"key"`
+	// Value is the new value. It is ignored if Deleted is set.
+	Value interface{} `json:"value,omitempty"`
+	// Deleted reports that the client removed its value, falling back to the default.
+	Deleted bool `json:"deleted,omitempty"`
+	// ChangedAt is when the change was made, by the client's clock. Conflicts without a
+	// MergeFunc are won by the change made last.
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// SyncResult reports the outcome of Manager.ApplyClientChanges.
+type SyncResult struct {
+	// Applied lists the keys whose client change was written. Changes of deprecated keys are
+	// listed under the key they were redirected to.
+	Applied []string `json:"applied"`
+	// Merged lists the keys whose client change conflicted with a server change and was
+	// combined with it by the definition's MergeFunc.
+	Merged []string `json:"merged"`
+	// Rejected lists the keys whose client change conflicted with a later server change,
+	// which was kept.
+	Rejected []string `json:"rejected"`
+	// Unknown lists the keys that have no definition and were ignored.
+	Unknown []string `json:"unknown"`
+	// Changes holds the server's changes since the client's cursor, including the applied and
+	// merged values, and the cursor for the next synchronization.
+	Changes *SyncChanges `json:"changes"`
+}
+
+// syncKey identifies a stored value by preference key and device.
+type syncKey struct {
+	key      string
+	deviceID string
+}
+
+// syncSafetyWindow is how far before a cursor ChangesSince looks for changes. The time of a
+// change is taken before it is written, so a write that commits after a later one carries an
+// earlier time than a cursor returned in between. Rescanning the window reports such writes
+// as long as they commit within it.
+const syncSafetyWindow = 5 * time.Second
+
+// defaultTombstoneRetention is how long tombstones are kept unless WithTombstoneRetention says otherwise.
+const defaultTombstoneRetention = 30 * 24 * time.Hour
+
+// maxTombstonePruneInterval bounds how long tombstones outlive the retention before being pruned.
+const maxTombstonePruneInterval = time.Hour
+
+// WithTombstoneRetention is a functional option that sets how long the storage backend keeps
+// the tombstones of deleted values for ChangesSince and ApplyClientChanges. If the backend
+// implements TombstonePruner, older tombstones are pruned in the background until Close, and
+// cursors older than the retention are rejected with ErrCursorExpired, because deletions since
+// them may no longer be reported; the client then synchronizes again from an empty cursor.
+// A retention of 0 or less keeps tombstones forever. Without this option, tombstones are kept
+// for 30 days.
+// This option is optional.
+func WithTombstoneRetention(retention time.Duration) Option {
+	return func(c *Config) {
+		c.tombstoneRetention = retention
+	}
+}
+
+// syncCursor is the decoded form of a ChangesSince cursor.
+type syncCursor struct {
+	// Time is the latest change time the client has seen.
+	Time time.Time `json:"t"`
+	// Seen lists the changes within syncSafetyWindow before Time that were already reported,
+	// so that rescanning the window does not report them again.
+	Seen []syncSeen `json:"s,omitempty"`
+}
+
+// syncSeen identifies a reported change by key, device, and change time.
+type syncSeen struct {
+	Key      string `json:"k"`
+	DeviceID string `json:"d,omitempty"`
+	At       int64  `json:"t"` // Unix nanoseconds.
+}
+
+// serverChange is the latest server-side change of a stored value since a client's cursor.
+type serverChange struct {
+	value   interface{}
+	deleted bool
+	at      time.Time
+}
+
+// validateMerge checks that only JSON preferences have a MergeFunc.
+func validateMerge(def PreferenceDefinition) error {
+	if def.MergeFunc != nil && def.Type != JSONType {
+		return fmt.Errorf("%w: preference '%s' has a MergeFunc but is not of type '%s'", ErrInvalidInput, def.Key, JSONType)
+	}
+	return nil
+}
+
+// ChangesSince returns the user's stored values written, and the tombstones of those deleted,
+// since cursor in the tenant in ctx (see WithTenant), so that an offline-first client can keep
+// a local copy up to date. Pass an empty cursor for a full synchronization and the returned
+// Cursor afterwards. With a device in ctx (see WithDevice), the device's overrides of
+// PerDevice preferences are included alongside the user's own values.
+//
+// Values are decrypted, upgraded to the definition's current Version, and decoded like those
+// returned by Get. Stored values and tombstones without a definition are skipped, as are
+// Hidden preferences when hidden filtering applies (see WithHiddenFiltering). Changes are
+// selected by the time the server took for them, which precedes the commit of the write, so a
+// slow write can commit after a later one has advanced a client's cursor past it. Each call
+// therefore rescans a few seconds before the cursor and skips the changes the cursor records
+// as reported; a write that takes longer than that to commit can still be missed.
+//
+// The storage backend must implement ChangeTracker.
+//
+// Returns:
+//   - (*SyncChanges, nil): On success.
+//   - (nil, ErrInvalidInput): If userID is empty or the cursor is malformed.
+//   - (nil, ErrNotSupported): If the storage backend does not implement ChangeTracker.
+//   - (nil, ErrCursorExpired): If the cursor is older than the tombstone retention (see WithTombstoneRetention).
+//   - (nil, ErrEncryptionFailed): If a value cannot be decrypted.
+//   - (nil, ErrMigrationFailed): If a value written with an older definition Version cannot be upgraded.
+//   - (nil, wrapped storage error): If the storage query fails.
+//
+// This method is thread-safe.
+func (m *Manager) ChangesSince(ctx context.Context, userID, cursor string) (_ *SyncChanges, err error) {
+	ctx, span := m.startOperationSpan(ctx, "ChangesSince")
+	defer func() { endSpan(span, err) }()
+	if userID == "" {
+		return nil, ErrInvalidInput
+	}
+	since, err := decodeSyncCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	tracker, ok := m.config.storage.(ChangeTracker)
+	if !ok {
+		return nil, fmt.Errorf("%w: storage does not implement ChangeTracker", ErrNotSupported)
+	}
+	if err := m.checkCursorAge(since); err != nil {
+		return nil, err
+	}
+	return m.changesSince(ctx, tracker, userID, since)
+}
+
+// ApplyClientChanges writes the changes a client made to the user's preferences in the tenant
+// in ctx (see WithTenant) since it last synchronized at cursor, and returns the server's
+// changes since then (see ChangesSince). With a device in ctx (see WithDevice), changes of
+// PerDevice preferences are written as the device's overrides.
+//
+// A client change conflicts with the server if the same stored value was written or deleted
+// since cursor, e.g. by another device. Changes without a conflict are written. Conflicts are
+// resolved per key: if the definition has a MergeFunc and neither side deleted the value, the
+// merged value is written; otherwise the side changed last wins, comparing the client's
+// ChangedAt with the time the server wrote its change. An empty cursor treats every stored
+// value as a conflict.
+//
+// Values are validated as in SetMany, including the MergeFunc results, and nothing is written
+// unless every value passes. Deletions are checked for write access like the values, and the
+// defaults they fall back to against Required dependencies and CrossFieldValidators. Keys without a definition are ignored and reported in
+// SyncResult.Unknown. Values are then written, and deletions applied as in Delete, one key at a
+// time in key order; a storage failure part-way through leaves the earlier keys written, and
+// the client can retry with the same cursor.
+//
+// The storage backend must implement ChangeTracker.
+//
+// Returns:
+//   - (*SyncResult, nil): On success.
+//   - (nil, ErrInvalidInput): If userID is empty, the cursor is malformed, a change has no key
+//     or no ChangedAt, or a key is changed more than once.
+//   - (nil, ErrNotSupported): If the storage backend does not implement ChangeTracker.
+//   - (nil, ErrCursorExpired): If the cursor is older than the tombstone retention (see WithTombstoneRetention).
+//   - (nil, ErrForbidden): If the actor in the context may not change one of the keys.
+//   - (nil, ValidationErrors matching ErrInvalidValue): If any value, or a default a deleted
+//     key falls back to, fails validation.
+//   - (nil, wrapped MergeFunc error): If a MergeFunc fails.
+//   - (nil, ErrEncryptionFailed): If encryption or decryption is required but fails.
+//   - (nil, wrapped storage error): If a storage operation fails.
+//
+// This method is thread-safe.
+func (m *Manager) ApplyClientChanges(ctx context.Context, userID, cursor string, changes []ClientChange) (_ *SyncResult, err error) {
+	ctx, span := m.startOperationSpan(ctx, "ApplyClientChanges")
+	defer func() { endSpan(span, err) }()
+	if userID == "" {
+		return nil, ErrInvalidInput
+	}
+	since, err := decodeSyncCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	tracker, ok := m.config.storage.(ChangeTracker)
+	if !ok {
+		return nil, fmt.Errorf("%w: storage does not implement ChangeTracker", ErrNotSupported)
+	}
+	if err := m.checkCursorAge(since); err != nil {
+		return nil, err
+	}
+
+	server, err := m.changesSince(ctx, tracker, userID, since)
+	if err != nil {
+		return nil, err
+	}
+	latest := make(map[syncKey]serverChange, len(server.Changed)+len(server.Deleted))
+	for _, pref := range server.Changed {
+		latest[syncKey{pref.Key, pref.DeviceID}] = serverChange{value: pref.Value, at: pref.UpdatedAt}
+	}
+	for _, tombstone := range server.Deleted {
+		latest[syncKey{tombstone.Key, tombstone.DeviceID}] = serverChange{deleted: true, at: tombstone.DeletedAt}
+	}
+
+	result := &SyncResult{Applied: []string{}, Merged: []string{}, Rejected: []string{}, Unknown: []string{}}
+	defs := make(map[string]PreferenceDefinition, len(changes))
+	values := make(map[string]interface{}, len(changes))
+	deleted := make(map[string]bool)
+	var verrs ValidationErrors
+	for _, change := range changes {
+		if change.Key == "" || change.ChangedAt.IsZero() {
+			return nil, ErrInvalidInput
+		}
+		def, exists := m.contextDefinition(ctx, change.Key)
+		if !exists {
+			result.Unknown = append(result.Unknown, change.Key)
+			continue
+		}
+
+		var value interface{}
+		var err error
+		if !change.Deleted {
+			def, value, err = m.redirectWrite(ctx, def, change.Value)
+		}
+		if _, dup := defs[def.Key]; dup {
+			return nil, fmt.Errorf("%w: '%s' is changed more than once", ErrInvalidInput, def.Key)
+		}
+		defs[def.Key] = def
+		if err == nil {
+			if err := checkWriteAccess(ctx, def); err != nil {
+				return nil, err
+			}
+			if !change.Deleted {
+				value, err = checkValue(value, def)
+			}
+		}
+
+		_, deviceID := deviceScope(ctx, def)
+		current, conflict := latest[syncKey{def.Key, deviceID}]
+		switch {
+		case err != nil:
+		case !conflict:
+			result.Applied = append(result.Applied, def.Key)
+		case def.MergeFunc != nil && !change.Deleted && !current.deleted:
+			merged, mergeErr := def.MergeFunc(current.value, value)
+			if mergeErr != nil {
+				return nil, fmt.Errorf("merging the values of '%s' failed: %w", def.Key, mergeErr)
+			}
+			value, err = checkValue(merged, def)
+			result.Merged = append(result.Merged, def.Key)
+		case change.ChangedAt.After(current.at):
+			result.Applied = append(result.Applied, def.Key)
+		default:
+			result.Rejected = append(result.Rejected, def.Key)
+			continue
+		}
+		if err != nil {
+			keyErrs, ok := toValidationErrors(err, def.Key, "", "")
+			if !ok {
+				return nil, err
+			}
+			verrs = append(verrs, keyErrs...)
+			continue
+		}
+		if change.Deleted {
+			deleted[def.Key] = true
+		} else {
+			values[def.Key] = value
+		}
+	}
+	if len(verrs) > 0 {
+		return nil, verrs
+	}
+	if len(values) > 0 || len(deleted) > 0 {
+		// Deleted keys fall back to their default, or to the user's own value on a device.
+		resets := make(map[string]interface{}, len(deleted))
+		for key := range deleted {
+			value, err := m.fallbackValue(ctx, userID, defs[key])
+			if err != nil {
+				return nil, err
+			}
+			resets[key] = value
+		}
+		if err := m.validateChanges(ctx, userID, values, resets); err != nil {
+			return nil, err
+		}
+	}
+
+	keys := make([]string, 0, len(values)+len(deleted))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	for key := range deleted {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if deleted[key] {
+			if err := m.deletePreference(ctx, userID, key); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := m.write(ctx, userID, defs[key], values[key]); err != nil {
+			return nil, err
+		}
+		m.notifyChange(ctx, userID, defs[key], ChangeSet, values[key])
+	}
+	sort.Strings(result.Applied)
+	sort.Strings(result.Merged)
+	sort.Strings(result.Rejected)
+
+	if result.Changes, err = m.changesSince(ctx, tracker, userID, since); err != nil {
+		return nil, err
+	}
+	m.config.logger.Info("Applied client changes", "userID", userID, "applied", len(result.Applied), "merged", len(result.Merged), "rejected", len(result.Rejected), "unknown", len(result.Unknown))
+	return result, nil
+}
+
+// checkCursorAge returns ErrCursorExpired if tombstones that since depends on may have been pruned.
+func (m *Manager) checkCursorAge(since syncCursor) error {
+	if _, ok := m.config.storage.(TombstonePruner); !ok || m.config.tombstoneRetention <= 0 || since.Time.IsZero() {
+		return nil
+	}
+	// Changes are read from the start of the safety window, so that is what must be retained.
+	if since.Time.Add(-syncSafetyWindow).Before(time.Now().Add(-m.config.tombstoneRetention)) {
+		return fmt.Errorf("%w: cursor is older than the tombstone retention of %s", ErrCursorExpired, m.config.tombstoneRetention)
+	}
+	return nil
+}
+
+// pruneTombstones removes tombstones older than retention from pruner, right away and then
+// periodically, until stop is closed.
+func (m *Manager) pruneTombstones(pruner TombstonePruner, retention time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	interval := retention
+	if interval > maxTombstonePruneInterval {
+		interval = maxTombstonePruneInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		pruned, err := pruner.PruneTombstones(context.Background(), time.Now().Add(-retention))
+		if err != nil {
+			// The next tick tries again.
+			m.config.logger.Error("Storage PruneTombstones failed", "error", err)
+		} else if pruned > 0 {
+			m.config.logger.Debug("Pruned tombstones", "count", pruned)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// changesSince implements ChangesSince for a decoded cursor.
+func (m *Manager) changesSince(ctx context.Context, tracker ChangeTracker, userID string, since syncCursor) (*SyncChanges, error) {
+	changes := &SyncChanges{Changed: []*Preference{}, Deleted: []Tombstone{}}
+	latest := since.Time
+	from := since.Time
+	if !from.IsZero() {
+		from = from.Add(-syncSafetyWindow)
+	}
+	reported := make(map[syncSeen]bool, len(since.Seen))
+	for _, seen := range since.Seen {
+		reported[seen] = true
+	}
+	// scanned collects every change read, to record those within the window in the next cursor.
+	var scanned []syncSeen
+	scopes := []context.Context{withoutDevice(ctx)}
+	if DeviceFromContext(ctx) != "" {
+		scopes = append(scopes, ctx)
+	}
+	hideHidden := m.config.filterHidden && actorRole(ctx) == ActorUser
+
+	for _, scopeCtx := range scopes {
+		if err := m.checkScope(scopeCtx); err != nil {
+			return nil, err
+		}
+		deviceID := DeviceFromContext(scopeCtx)
+		spanCtx, span := m.startSpan(scopeCtx, "userprefs.storage.ChangesSince")
+		prefs, tombstones, err := tracker.ChangesSince(spanCtx, userID, from)
+		endSpan(span, err)
+		if err != nil {
+			m.config.logger.Error("Storage ChangesSince failed", "userID", userID, "deviceID", deviceID, "error", err)
+			return nil, fmt.Errorf("storage.ChangesSince failed for userID '%s': %w", userID, err)
+		}
+
+		// include reports whether a change of key in this scope is reported to the client.
+		include := func(key string) (PreferenceDefinition, bool) {
+			def, exists := m.contextDefinition(ctx, key)
+			if !exists || (deviceID != "" && !def.PerDevice) || (hideHidden && def.Hidden) {
+				return def, false
+			}
+			return def, true
+		}
+		for _, pref := range prefs {
+			if pref.UpdatedAt.After(latest) {
+				latest = pref.UpdatedAt
+			}
+			seen := syncSeen{Key: pref.Key, DeviceID: deviceID, At: pref.UpdatedAt.UnixNano()}
+			scanned = append(scanned, seen)
+			def, ok := include(pref.Key)
+			if !ok || reported[seen] {
+				continue
+			}
+			if err := m.decodeStored(scopeCtx, pref, def); err != nil {
+				m.config.logger.Error("Failed to decode changed preference", "userID", userID, "key", def.Key, "deviceID", deviceID, "error", err)
+				return nil, err
+			}
+			pref.DeviceID = deviceID
+			pref.DefaultValue = def.DefaultValue
+			pref.Type = def.Type
+			pref.Category = def.Category
+			changes.Changed = append(changes.Changed, pref)
+		}
+		for _, tombstone := range tombstones {
+			if tombstone.DeletedAt.After(latest) {
+				latest = tombstone.DeletedAt
+			}
+			seen := syncSeen{Key: tombstone.Key, DeviceID: deviceID, At: tombstone.DeletedAt.UnixNano()}
+			scanned = append(scanned, seen)
+			if _, ok := include(tombstone.Key); !ok || reported[seen] {
+				continue
+			}
+			tombstone.DeviceID = deviceID
+			changes.Deleted = append(changes.Deleted, tombstone)
+		}
+	}
+
+	sort.Slice(changes.Changed, func(i, j int) bool {
+		a, b := changes.Changed[i], changes.Changed[j]
+		return a.Key < b.Key || (a.Key == b.Key && a.DeviceID < b.DeviceID)
+	})
+	sort.Slice(changes.Deleted, func(i, j int) bool {
+		a, b := changes.Deleted[i], changes.Deleted[j]
+		return a.Key < b.Key || (a.Key == b.Key && a.DeviceID < b.DeviceID)
+	})
+	next := syncCursor{Time: latest}
+	for _, seen := range scanned {
+		if seen.At > latest.Add(-syncSafetyWindow).UnixNano() {
+			next.Seen = append(next.Seen, seen)
+		}
+	}
+	changes.Cursor = encodeSyncCursor(next)
+	return changes, nil
+}
+
+// encodeSyncCursor returns the cursor of changes made after c.Time, or "" for the zero time.
+func encodeSyncCursor(c syncCursor) string {
+	if c.Time.IsZero() {
+		return ""
+	}
+	c.Time = c.Time.UTC()
+	sort.Slice(c.Seen, func(i, j int) bool {
+		a, b := c.Seen[i], c.Seen[j]
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		if a.DeviceID != b.DeviceID {
+			return a.DeviceID < b.DeviceID
+		}
+		return a.At < b.At
+	})
+	data, _ := json.Marshal(c) // A cursor holds only strings, numbers, and a UTC time, which always marshal.
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeSyncCursor returns the position a ChangesSince cursor resumes after.
+func decodeSyncCursor(cursor string) (syncCursor, error) {
+	var c syncCursor
+	if cursor == "" {
+		return c, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidInput)
+	}
+	if err := json.Unmarshal(raw, &c); err != nil || c.Time.IsZero() {
+		return syncCursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidInput)
+	}
+	return c, nil
+}
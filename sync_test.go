@@ -0,0 +1,462 @@
+package userprefs
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncStorage adds ChangeTracker to MockStorage by recording a tombstone for each Delete.
+type syncStorage struct {
+	*MockStorage
+	mu         sync.Mutex
+	tombstones map[string]map[string]time.Time // Deletion times by scope and user, then by key.
+}
+
+func newSyncStorage() *syncStorage {
+	return &syncStorage{MockStorage: NewMockStorage(), tombstones: make(map[string]map[string]time.Time)}
+}
+
+// scope identifies the tenant, device, and user of a stored value.
+func (s *syncStorage) scope(ctx context.Context, userID string) string {
+	return TenantFromContext(ctx) + "\x00" + DeviceFromContext(ctx) + "\x00" + userID
+}
+
+func (s *syncStorage) Delete(ctx context.Context, userID, key string) error {
+	if err := s.MockStorage.Delete(ctx, userID, key); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scope := s.scope(ctx, userID)
+	if s.tombstones[scope] == nil {
+		s.tombstones[scope] = make(map[string]time.Time)
+	}
+	s.tombstones[scope][key] = time.Now()
+	return nil
+}
+
+func (s *syncStorage) ChangesSince(ctx context.Context, userID string, since time.Time) ([]*Preference, []Tombstone, error) {
+	all, err := s.GetAll(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var changed []*Preference
+	for _, pref := range all {
+		if pref.UpdatedAt.After(since) {
+			changed = append(changed, pref)
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i].Key < changed[j].Key })
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var deleted []Tombstone
+	for key, deletedAt := range s.tombstones[s.scope(ctx, userID)] {
+		if _, stored := all[key]; !stored && deletedAt.After(since) {
+			deleted = append(deleted, Tombstone{Key: key, DeletedAt: deletedAt})
+		}
+	}
+	sort.Slice(deleted, func(i, j int) bool { return deleted[i].Key < deleted[j].Key })
+	return changed, deleted, nil
+}
+
+// pruningSyncStorage adds TombstonePruner to syncStorage.
+type pruningSyncStorage struct {
+	*syncStorage
+}
+
+func (s pruningSyncStorage) PruneTombstones(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pruned int64
+	for _, keys := range s.tombstones {
+		for key, deletedAt := range keys {
+			if deletedAt.Before(before) {
+				delete(keys, key)
+				pruned++
+			}
+		}
+	}
+	return pruned, nil
+}
+
+// tombstoneCount returns the number of tombstones s holds.
+func (s *syncStorage) tombstoneCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for _, keys := range s.tombstones {
+		count += len(keys)
+	}
+	return count
+}
+
+// mergeTags merges two JSON objects of tags, keeping the keys of both.
+func mergeTags(server, client interface{}) (interface{}, error) {
+	serverTags, ok := server.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("server tags are not an object")
+	}
+	clientTags, ok := client.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("client tags are not an object")
+	}
+	merged := make(map[string]interface{}, len(serverTags)+len(clientTags))
+	for key, value := range serverTags {
+		merged[key] = value
+	}
+	for key, value := range clientTags {
+		merged[key] = value
+	}
+	return merged, nil
+}
+
+// syncDefinitions returns "theme", a per-device "layout", and "tags" with a MergeFunc.
+func syncDefinitions() []PreferenceDefinition {
+	return []PreferenceDefinition{
+		{Key: "theme", Type: StringType, DefaultValue: "light", AllowedValues: []interface{}{"light", "dark", "system"}},
+		{Key: "layout", Type: EnumType, DefaultValue: "comfortable", AllowedValues: []interface{}{"compact", "comfortable"}, PerDevice: true},
+		{Key: "tags", Type: JSONType, DefaultValue: map[string]interface{}{}, MergeFunc: mergeTags},
+	}
+}
+
+func TestManager_ChangesSince(t *testing.T) {
+	ctx := context.Background()
+	mobile := WithDevice(ctx, "mobile")
+	mgr := newTestManager(t, syncDefinitions(), WithStorage(newSyncStorage()))
+
+	changes, err := mgr.ChangesSince(ctx, "u1", "")
+	if err != nil {
+		t.Fatalf("ChangesSince failed: %v", err)
+	}
+	if len(changes.Changed) != 0 || len(changes.Deleted) != 0 || changes.Cursor != "" {
+		t.Errorf("Expected no changes and an empty cursor, got %+v", changes)
+	}
+
+	if err := mgr.Set(ctx, "u1", "theme", "dark"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := mgr.Set(mobile, "u1", "layout", "compact"); err != nil {
+		t.Fatalf("Set on mobile failed: %v", err)
+	}
+	changes, err = mgr.ChangesSince(mobile, "u1", "")
+	if err != nil {
+		t.Fatalf("ChangesSince failed: %v", err)
+	}
+	if len(changes.Changed) != 2 || changes.Changed[0].Key != "layout" || changes.Changed[0].DeviceID != "mobile" || changes.Changed[1].Value != "dark" {
+		t.Fatalf("Expected the mobile layout override and the theme, got %+v", changes.Changed)
+	}
+	if changes.Changed[1].Type != StringType || changes.Changed[1].DefaultValue != "light" {
+		t.Errorf("Expected changed preferences to carry their definition's metadata, got %+v", changes.Changed[1])
+	}
+	withoutDevice, err := mgr.ChangesSince(ctx, "u1", "")
+	if err != nil {
+		t.Fatalf("ChangesSince failed: %v", err)
+	}
+	if len(withoutDevice.Changed) != 1 {
+		t.Errorf("Expected only the theme without a device, got %+v", withoutDevice.Changed)
+	}
+
+	cursor := changes.Cursor
+	changes, err = mgr.ChangesSince(mobile, "u1", cursor)
+	if err != nil {
+		t.Fatalf("ChangesSince failed: %v", err)
+	}
+	if len(changes.Changed) != 0 || len(changes.Deleted) != 0 || changes.Cursor != cursor {
+		t.Errorf("Expected no changes after the cursor, got %+v", changes)
+	}
+
+	if err := mgr.Delete(ctx, "u1", "theme"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	changes, err = mgr.ChangesSince(mobile, "u1", cursor)
+	if err != nil {
+		t.Fatalf("ChangesSince failed: %v", err)
+	}
+	if len(changes.Changed) != 0 || len(changes.Deleted) != 1 || changes.Deleted[0].Key != "theme" || changes.Deleted[0].DeviceID != "" {
+		t.Errorf("Expected the deletion of the theme, got %+v", changes)
+	}
+	if changes.Cursor == cursor {
+		t.Error("Expected the cursor to advance past the deletion")
+	}
+}
+
+func TestManager_ChangesSince_LateCommit(t *testing.T) {
+	ctx := context.Background()
+	storage := newSyncStorage()
+	mgr := newTestManager(t, syncDefinitions(), WithStorage(storage))
+
+	if err := mgr.Set(ctx, "u1", "theme", "dark"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	changes, err := mgr.ChangesSince(ctx, "u1", "")
+	if err != nil {
+		t.Fatalf("ChangesSince failed: %v", err)
+	}
+	cursor := changes.Cursor
+
+	// A write stamped before the theme's but committed after the client synchronized.
+	stamped := changes.Changed[0].UpdatedAt.Add(-time.Second)
+	if err := storage.Set(ctx, &Preference{UserID: "u1", Key: "tags", Value: `{"late":true}`, UpdatedAt: stamped}); err != nil {
+		t.Fatalf("storage.Set failed: %v", err)
+	}
+	changes, err = mgr.ChangesSince(ctx, "u1", cursor)
+	if err != nil {
+		t.Fatalf("ChangesSince failed: %v", err)
+	}
+	if len(changes.Changed) != 1 || changes.Changed[0].Key != "tags" {
+		t.Fatalf("Expected only the late write, not the reported theme again, got %+v", changes.Changed)
+	}
+
+	// Both changes are now reported, so the next call returns neither.
+	changes, err = mgr.ChangesSince(ctx, "u1", changes.Cursor)
+	if err != nil {
+		t.Fatalf("ChangesSince failed: %v", err)
+	}
+	if len(changes.Changed) != 0 || len(changes.Deleted) != 0 {
+		t.Errorf("Expected no changes after the late write was reported, got %+v", changes)
+	}
+}
+
+func TestManager_ChangesSince_TombstoneRetention(t *testing.T) {
+	ctx := context.Background()
+	mgr := newTestManager(t, syncDefinitions(), WithStorage(pruningSyncStorage{newSyncStorage()}), WithTombstoneRetention(time.Hour))
+	defer func() { _ = mgr.Close(ctx) }()
+
+	expired := encodeSyncCursor(syncCursor{Time: time.Now().Add(-2 * time.Hour)})
+	if _, err := mgr.ChangesSince(ctx, "u1", expired); !errors.Is(err, ErrCursorExpired) {
+		t.Errorf("Expected ErrCursorExpired for a cursor older than the retention, got %v", err)
+	}
+	if _, err := mgr.ApplyClientChanges(ctx, "u1", expired, nil); !errors.Is(err, ErrCursorExpired) {
+		t.Errorf("Expected ErrCursorExpired for a cursor older than the retention, got %v", err)
+	}
+	recent := encodeSyncCursor(syncCursor{Time: time.Now().Add(-time.Minute)})
+	if _, err := mgr.ChangesSince(ctx, "u1", recent); err != nil {
+		t.Errorf("Expected a cursor within the retention to be accepted, got %v", err)
+	}
+
+	// Without a TombstonePruner, tombstones are never pruned, so old cursors stay valid.
+	unpruned := newTestManager(t, syncDefinitions(), WithStorage(newSyncStorage()), WithTombstoneRetention(time.Hour))
+	if _, err := unpruned.ChangesSince(ctx, "u1", expired); err != nil {
+		t.Errorf("Expected an old cursor to be accepted without pruning, got %v", err)
+	}
+}
+
+func TestManager_PruneTombstones(t *testing.T) {
+	ctx := context.Background()
+	storage := newSyncStorage()
+	mgr := newTestManager(t, syncDefinitions(), WithStorage(pruningSyncStorage{storage}), WithTombstoneRetention(10*time.Millisecond))
+
+	if err := mgr.Set(ctx, "u1", "theme", "dark"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := mgr.Delete(ctx, "u1", "theme"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for storage.tombstoneCount() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the tombstone to be pruned after the retention")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := mgr.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func TestManager_ChangesSince_Errors(t *testing.T) {
+	ctx := context.Background()
+	mgr := newTestManager(t, syncDefinitions(), WithStorage(newSyncStorage()))
+
+	if _, err := mgr.ChangesSince(ctx, "u1", "not a cursor!"); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for a malformed cursor, got %v", err)
+	}
+	if _, err := mgr.ChangesSince(ctx, "", ""); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for an empty userID, got %v", err)
+	}
+
+	plain := newTestManager(t, nil)
+	if _, err := plain.ChangesSince(ctx, "u1", ""); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported without a ChangeTracker, got %v", err)
+	}
+	if _, err := plain.ApplyClientChanges(ctx, "u1", "", nil); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported without a ChangeTracker, got %v", err)
+	}
+}
+
+func TestManager_ApplyClientChanges(t *testing.T) {
+	ctx := context.Background()
+	mgr := newTestManager(t, syncDefinitions(), WithStorage(newSyncStorage()))
+
+	if err := mgr.Set(ctx, "u1", "theme", "dark"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := mgr.Set(ctx, "u1", "tags", map[string]interface{}{"work": true}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	changes, err := mgr.ChangesSince(ctx, "u1", "")
+	if err != nil {
+		t.Fatalf("ChangesSince failed: %v", err)
+	}
+	cursor := changes.Cursor
+
+	// Another device changes the theme and the tags after the client synchronized.
+	if err := mgr.Set(ctx, "u1", "theme", "system"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := mgr.Set(ctx, "u1", "tags", map[string]interface{}{"work": false}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	offline := time.Now().Add(-time.Hour)
+	result, err := mgr.ApplyClientChanges(ctx, "u1", cursor, []ClientChange{
+		{Key: "theme", Value: "light", ChangedAt: offline},
+		{Key: "tags", Value: map[string]interface{}{"home": true}, ChangedAt: offline},
+		{Key: "layout", Value: "compact", ChangedAt: offline},
+		{Key: "removed", Value: 1, ChangedAt: offline},
+	})
+	if err != nil {
+		t.Fatalf("ApplyClientChanges failed: %v", err)
+	}
+	if len(result.Applied) != 1 || result.Applied[0] != "layout" {
+		t.Errorf("Expected the layout without a conflict to be applied, got %v", result.Applied)
+	}
+	if len(result.Merged) != 1 || result.Merged[0] != "tags" {
+		t.Errorf("Expected the tags to be merged, got %v", result.Merged)
+	}
+	if len(result.Rejected) != 1 || result.Rejected[0] != "theme" {
+		t.Errorf("Expected the older theme change to be rejected, got %v", result.Rejected)
+	}
+	if len(result.Unknown) != 1 || result.Unknown[0] != "removed" {
+		t.Errorf("Expected the undefined key to be reported, got %v", result.Unknown)
+	}
+
+	theme, err := mgr.Get(ctx, "u1", "theme")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if theme.Value != "system" {
+		t.Errorf("Expected the server's later theme to be kept, got %v", theme.Value)
+	}
+	tags, err := mgr.Get(ctx, "u1", "tags")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	merged, ok := tags.Value.(map[string]interface{})
+	if !ok || merged["work"] != false || merged["home"] != true {
+		t.Errorf("Expected the merged tags, got %v", tags.Value)
+	}
+	if len(result.Changes.Changed) != 3 {
+		t.Errorf("Expected the server's changes since the cursor to include the written values, got %+v", result.Changes.Changed)
+	}
+
+	// A later client change wins, and a deletion falls back to the default.
+	result, err = mgr.ApplyClientChanges(ctx, "u1", cursor, []ClientChange{
+		{Key: "theme", Deleted: true, ChangedAt: time.Now().Add(time.Hour)},
+	})
+	if err != nil {
+		t.Fatalf("ApplyClientChanges failed: %v", err)
+	}
+	if len(result.Applied) != 1 || result.Applied[0] != "theme" {
+		t.Errorf("Expected the later deletion to be applied, got %v", result.Applied)
+	}
+	theme, err = mgr.Get(ctx, "u1", "theme")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if theme.Value != "light" {
+		t.Errorf("Expected the default after the deletion, got %v", theme.Value)
+	}
+	if len(result.Changes.Deleted) != 1 || result.Changes.Deleted[0].Key != "theme" {
+		t.Errorf("Expected the deletion to be reported, got %+v", result.Changes.Deleted)
+	}
+}
+
+func TestManager_ApplyClientChanges_Invalid(t *testing.T) {
+	ctx := context.Background()
+	mgr := newTestManager(t, syncDefinitions(), WithStorage(newSyncStorage()))
+	now := time.Now()
+
+	_, err := mgr.ApplyClientChanges(ctx, "u1", "", []ClientChange{
+		{Key: "layout", Value: "compact", ChangedAt: now},
+		{Key: "theme", Value: "purple", ChangedAt: now},
+	})
+	var verrs ValidationErrors
+	if !errors.As(err, &verrs) || !errors.Is(err, ErrInvalidValue) || len(verrs) != 1 || verrs[0].Key != "theme" {
+		t.Fatalf("Expected a ValidationErrors for the theme, got %v", err)
+	}
+	if pref, err := mgr.Get(ctx, "u1", "layout"); err != nil || pref.Value != "comfortable" {
+		t.Errorf("Expected nothing to be written when a value is invalid, got %v (%v)", pref, err)
+	}
+
+	for name, changes := range map[string][]ClientChange{
+		"missing ChangedAt": {{Key: "theme", Value: "dark"}},
+		"duplicate key":     {{Key: "theme", Value: "dark", ChangedAt: now}, {Key: "theme", Deleted: true, ChangedAt: now}},
+	} {
+		if _, err := mgr.ApplyClientChanges(ctx, "u1", "", changes); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("%s: expected ErrInvalidInput, got %v", name, err)
+		}
+	}
+}
+
+func TestManager_ApplyClientChanges_Deletions(t *testing.T) {
+	ctx := context.Background()
+	defs := append(notificationDefinitions(),
+		PreferenceDefinition{Key: "plan", Type: StringType, DefaultValue: "free", ReadOnly: true})
+	mgr := newTestManager(t, defs, WithStorage(newSyncStorage()))
+	if err := mgr.SetMany(ctx, "u1", map[string]interface{}{"notifications": true, "notification_email": "ada@example.com", "plan": "pro"}); err != nil {
+		t.Fatalf("SetMany failed: %v", err)
+	}
+	later := time.Now().Add(time.Hour)
+
+	// The email is Required while notifications are on, and falls back to an empty default.
+	_, err := mgr.ApplyClientChanges(ctx, "u1", "", []ClientChange{
+		{Key: "notification_email", Deleted: true, ChangedAt: later},
+	})
+	var verrs ValidationErrors
+	if !errors.As(err, &verrs) || len(verrs) != 1 || verrs[0].Key != "notification_email" || verrs[0].Rule != RuleRequired {
+		t.Fatalf("Expected a Required error for the deleted email, got %v", err)
+	}
+	if pref, err := mgr.Get(ctx, "u1", "notification_email"); err != nil || pref.Value != "ada@example.com" {
+		t.Errorf("Expected the email to be kept, got %v (%v)", pref, err)
+	}
+
+	userCtx := WithActor(ctx, Actor{ID: "u1", Role: ActorUser})
+	if _, err := mgr.ApplyClientChanges(userCtx, "u1", "", []ClientChange{
+		{Key: "plan", Deleted: true, ChangedAt: later},
+	}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("Expected ErrForbidden for deleting a read-only preference, got %v", err)
+	}
+	if pref, err := mgr.Get(ctx, "u1", "plan"); err != nil || pref.Value != "pro" {
+		t.Errorf("Expected the plan to be kept, got %v (%v)", pref, err)
+	}
+
+	// Turning notifications off with the same sync lets the email fall back.
+	result, err := mgr.ApplyClientChanges(ctx, "u1", "", []ClientChange{
+		{Key: "notifications", Deleted: true, ChangedAt: later},
+		{Key: "notification_email", Deleted: true, ChangedAt: later},
+	})
+	if err != nil {
+		t.Fatalf("ApplyClientChanges failed: %v", err)
+	}
+	if len(result.Applied) != 2 {
+		t.Errorf("Expected both deletions to be applied, got %v", result.Applied)
+	}
+	if pref, err := mgr.Get(ctx, "u1", "notification_email"); err != nil || pref.Value != "" {
+		t.Errorf("Expected the email to fall back to its default, got %v (%v)", pref, err)
+	}
+}
+
+func TestDefinePreference_MergeFuncRequiresJSON(t *testing.T) {
+	mgr := newTestManager(t, nil)
+	err := mgr.DefinePreference(PreferenceDefinition{Key: "theme", Type: StringType, DefaultValue: "light", MergeFunc: mergeTags})
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for a MergeFunc on a string preference, got %v", err)
+	}
+}
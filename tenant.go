@@ -0,0 +1,212 @@
+// Package userprefs provides multi-tenant operation with per-tenant definitions and data.
+package userprefs
+
+import (
+	"context"
+	"fmt"
+)
+
+// DefaultTenant is the tenant used when none is set in the context. Definitions registered
+// with Manager.DefinePreference and data written without a tenant belong to it, so
+// single-tenant deployments never need to deal with tenants at all.
+const DefaultTenant = ""
+
+// tenantContextKey is the context key under which the current tenant ID is stored.
+type tenantContextKey struct{}
+
+// WithTenant returns a copy of ctx scoped to tenantID. Every Manager operation performed with
+// the returned context uses the tenant's own definitions, storage rows, and cache entries.
+// Storage backends read the tenant with TenantFromContext.
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenantID)
+}
+
+// TenantFromContext returns the tenant ID stored in ctx by WithTenant, or DefaultTenant if none is present.
+func TenantFromContext(ctx context.Context) string {
+	tenantID, _ := ctx.Value(tenantContextKey{}).(string)
+	return tenantID
+}
+
+// checkScope returns ErrNotSupported if ctx selects a tenant other than DefaultTenant or a
+// device and the storage backend does not declare that it keeps them apart (see ScopedStorage).
+func (m *Manager) checkScope(ctx context.Context) error {
+	if m.scoped {
+		return nil
+	}
+	if tenantID := TenantFromContext(ctx); tenantID != DefaultTenant {
+		return fmt.Errorf("%w: storage does not implement ScopedStorage, so it cannot store tenant '%s'", ErrNotSupported, tenantID)
+	}
+	if deviceID := DeviceFromContext(ctx); deviceID != "" {
+		return fmt.Errorf("%w: storage does not implement ScopedStorage, so it cannot store device '%s'", ErrNotSupported, deviceID)
+	}
+	return nil
+}
+
+// TenantManager scopes a Manager to a single tenant. It is an explicit alternative to
+// passing a context created by WithTenant: every method runs the corresponding Manager
+// method with the context scoped to the tenant. It is obtained with Manager.Tenant.
+type TenantManager struct {
+	manager  *Manager
+	tenantID string
+}
+
+// Tenant returns a TenantManager for tenantID. TenantManagers are lightweight and share the
+// Manager's storage, cache, and definitions registry; creating one has no side effects.
+func (m *Manager) Tenant(tenantID string) *TenantManager {
+	return &TenantManager{manager: m, tenantID: tenantID}
+}
+
+// ID returns the tenant ID this TenantManager is scoped to.
+func (t *TenantManager) ID() string {
+	return t.tenantID
+}
+
+// DefinePreference registers def in the tenant's own catalogue. Definitions of different
+// tenants are independent: the same key may have a different type, default, or constraints
+// in each tenant, and a key defined only for one tenant is unknown to the others.
+// It accepts and validates definitions exactly like Manager.DefinePreference.
+func (t *TenantManager) DefinePreference(def PreferenceDefinition) error {
+	return t.manager.definePreference(t.tenantID, def)
+}
+
+// DeleteDefinition removes the tenant's definition for key. See Manager.DeleteDefinition.
+func (t *TenantManager) DeleteDefinition(key string) error {
+	return t.manager.deleteDefinition(t.tenantID, key)
+}
+
+// GetDefinition retrieves the tenant's definition for key.
+func (t *TenantManager) GetDefinition(key string) (PreferenceDefinition, bool) {
+	return t.manager.getDefinition(t.tenantID, key)
+}
+
+// GetAllDefinitions retrieves all of the tenant's definitions.
+func (t *TenantManager) GetAllDefinitions(ctx context.Context) ([]*PreferenceDefinition, error) {
+	return t.manager.GetAllDefinitions(t.context(ctx))
+}
+
+// Get retrieves a user's preference within the tenant. See Manager.Get.
+func (t *TenantManager) Get(ctx context.Context, userID, key string) (*Preference, error) {
+	return t.manager.Get(t.context(ctx), userID, key)
+}
+
+// Set creates or updates a user's preference within the tenant. See Manager.Set.
+func (t *TenantManager) Set(ctx context.Context, userID, key string, value interface{}) error {
+	return t.manager.Set(t.context(ctx), userID, key, value)
+}
+
+// SetMany creates or updates several of a user's preferences within the tenant. See Manager.SetMany.
+func (t *TenantManager) SetMany(ctx context.Context, userID string, values map[string]interface{}) error {
+	return t.manager.SetMany(t.context(ctx), userID, values)
+}
+
+// Delete removes a user's preference within the tenant. See Manager.Delete.
+func (t *TenantManager) Delete(ctx context.Context, userID, key string) error {
+	return t.manager.Delete(t.context(ctx), userID, key)
+}
+
+// GetAll retrieves all of a user's preferences within the tenant. See Manager.GetAll.
+func (t *TenantManager) GetAll(ctx context.Context, userID string) (map[string]*Preference, error) {
+	return t.manager.GetAll(t.context(ctx), userID)
+}
+
+// GetForUsers retrieves the preference key of each of userIDs within the tenant. See Manager.GetForUsers.
+func (t *TenantManager) GetForUsers(ctx context.Context, key string, userIDs []string) (map[string]*Preference, error) {
+	return t.manager.GetForUsers(t.context(ctx), key, userIDs)
+}
+
+// GetManyForUsers retrieves the preferences keys of each of userIDs within the tenant. See Manager.GetManyForUsers.
+func (t *TenantManager) GetManyForUsers(ctx context.Context, keys, userIDs []string) (map[string]map[string]*Preference, error) {
+	return t.manager.GetManyForUsers(t.context(ctx), keys, userIDs)
+}
+
+// FindUsers returns the users of the tenant whose value for key matches pred. See Manager.FindUsers.
+func (t *TenantManager) FindUsers(ctx context.Context, key string, pred Predicate, cursor string) (*UserPage, error) {
+	return t.manager.FindUsers(t.context(ctx), key, pred, cursor)
+}
+
+// Stats reports how the tenant's users have set key. See Manager.Stats.
+func (t *TenantManager) Stats(ctx context.Context, key string) (*PreferenceStats, error) {
+	return t.manager.Stats(t.context(ctx), key)
+}
+
+// ChangesSince returns the changes of a user's stored preferences within the tenant since cursor. See Manager.ChangesSince.
+func (t *TenantManager) ChangesSince(ctx context.Context, userID, cursor string) (*SyncChanges, error) {
+	return t.manager.ChangesSince(t.context(ctx), userID, cursor)
+}
+
+// ApplyClientChanges writes a client's changes of a user's preferences within the tenant. See Manager.ApplyClientChanges.
+func (t *TenantManager) ApplyClientChanges(ctx context.Context, userID, cursor string, changes []ClientChange) (*SyncResult, error) {
+	return t.manager.ApplyClientChanges(t.context(ctx), userID, cursor, changes)
+}
+
+// GetByCategory retrieves a user's preferences in category within the tenant. See Manager.GetByCategory.
+func (t *TenantManager) GetByCategory(ctx context.Context, userID, category string) (map[string]*Preference, error) {
+	return t.manager.GetByCategory(t.context(ctx), userID, category)
+}
+
+// Reset restores a user's preferences for keys to their defaults within the tenant. See Manager.Reset.
+func (t *TenantManager) Reset(ctx context.Context, userID string, keys ...string) error {
+	return t.manager.Reset(t.context(ctx), userID, keys...)
+}
+
+// ResetCategory restores a user's preferences in category to their defaults within the tenant. See Manager.ResetCategory.
+func (t *TenantManager) ResetCategory(ctx context.Context, userID, category string) error {
+	return t.manager.ResetCategory(t.context(ctx), userID, category)
+}
+
+// ResetAll restores all of a user's preferences to their defaults within the tenant. See Manager.ResetAll.
+func (t *TenantManager) ResetAll(ctx context.Context, userID string) error {
+	return t.manager.ResetAll(t.context(ctx), userID)
+}
+
+// Export produces a portable document of a user's stored preferences within the tenant. See Manager.Export.
+func (t *TenantManager) Export(ctx context.Context, userID string, opts ExportOptions) (*ExportDocument, error) {
+	return t.manager.Export(t.context(ctx), userID, opts)
+}
+
+// Import writes the values of an exported document as a user's preferences within the tenant. See Manager.Import.
+func (t *TenantManager) Import(ctx context.Context, userID string, doc *ExportDocument, opts ImportOptions) (*ImportResult, error) {
+	return t.manager.Import(t.context(ctx), userID, doc, opts)
+}
+
+// DeleteUser erases every preference of a user within the tenant. See Manager.DeleteUser.
+func (t *TenantManager) DeleteUser(ctx context.Context, userID string) (*ErasureReceipt, error) {
+	return t.manager.DeleteUser(t.context(ctx), userID)
+}
+
+// context scopes ctx to the TenantManager's tenant.
+func (t *TenantManager) context(ctx context.Context) context.Context {
+	return WithTenant(ctx, t.tenantID)
+}
+
+// definitionsFor returns the definitions registry of tenantID, or nil if the tenant has
+// none yet. The caller must hold m.mu.
+func (m *Manager) definitionsFor(tenantID string) map[string]PreferenceDefinition {
+	if tenantID == DefaultTenant {
+		return m.config.definitions
+	}
+	return m.config.tenantDefinitions[tenantID]
+}
+
+// definition returns the definition of key in tenantID's catalogue.
+func (m *Manager) definition(tenantID, key string) (PreferenceDefinition, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	def, exists := m.definitionsFor(tenantID)[key]
+	return def, exists
+}
+
+// contextDefinition returns the definition of key for the tenant in ctx.
+func (m *Manager) contextDefinition(ctx context.Context, key string) (PreferenceDefinition, bool) {
+	return m.definition(TenantFromContext(ctx), key)
+}
+
+// prefCacheKey returns the cache key of a user's preference. The default tenant keeps the
+// original "pref:{userID}:{key}" layout so existing cache entries stay valid; other
+// tenants are namespaced as "tenant:{tenantID}:pref:{userID}:{key}".
+func prefCacheKey(tenantID, userID, key string) string {
+	if tenantID == DefaultTenant {
+		return fmt.Sprintf("pref:%s:%s", userID, key)
+	}
+	return fmt.Sprintf("tenant:%s:pref:%s:%s", tenantID, userID, key)
+}
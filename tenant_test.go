@@ -0,0 +1,133 @@
+package userprefs
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestManager_Tenants_Definitions(t *testing.T) {
+	ctx := context.Background()
+	mgr := newTestManager(t, []PreferenceDefinition{{Key: "theme", Type: StringType, DefaultValue: "light"}})
+	acme := mgr.Tenant("acme")
+	if err := acme.DefinePreference(PreferenceDefinition{Key: "theme", Type: IntType, DefaultValue: 3}); err != nil {
+		t.Fatalf("Tenant DefinePreference failed: %v", err)
+	}
+	if err := acme.DefinePreference(PreferenceDefinition{Key: "acme_only", Type: BoolType}); err != nil {
+		t.Fatalf("Tenant DefinePreference failed: %v", err)
+	}
+
+	if def, _ := mgr.GetDefinition("theme"); def.Type != StringType {
+		t.Errorf("Expected default tenant definition to be unchanged, got type %s", def.Type)
+	}
+	if def, _ := acme.GetDefinition("theme"); def.Type != IntType {
+		t.Errorf("Expected tenant definition of type int, got %s", def.Type)
+	}
+	if _, found := mgr.GetDefinition("acme_only"); found {
+		t.Error("Expected tenant-only key to be unknown to the default tenant")
+	}
+
+	defs, err := mgr.GetAllDefinitions(WithTenant(ctx, "acme"))
+	if err != nil || len(defs) != 2 {
+		t.Errorf("Expected 2 acme definitions, got %d (err %v)", len(defs), err)
+	}
+	defs, err = mgr.GetAllDefinitions(WithTenant(ctx, "unknown"))
+	if err != nil || len(defs) != 0 {
+		t.Errorf("Expected no definitions for an unknown tenant, got %d (err %v)", len(defs), err)
+	}
+
+	if err := mgr.Set(WithTenant(ctx, "globex"), "u1", "theme", "dark"); !errors.Is(err, ErrPreferenceNotDefined) {
+		t.Errorf("Expected ErrPreferenceNotDefined for a tenant without definitions, got %v", err)
+	}
+	if err := acme.Set(ctx, "u1", "theme", "dark"); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("Expected the tenant's int type to be enforced, got %v", err)
+	}
+}
+
+func TestManager_Tenants_DataIsolation(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMockCache()
+	mgr := newTestManager(t, nil, WithCache(cache))
+
+	def := PreferenceDefinition{Key: "theme", Type: StringType, DefaultValue: "light"}
+	for _, tenantID := range []string{DefaultTenant, "acme", "globex"} {
+		if err := mgr.Tenant(tenantID).DefinePreference(def); err != nil {
+			t.Fatalf("DefinePreference for tenant %q failed: %v", tenantID, err)
+		}
+	}
+
+	if err := mgr.Set(ctx, "u1", "theme", "default-dark"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := mgr.Set(WithTenant(ctx, "acme"), "u1", "theme", "acme-dark"); err != nil {
+		t.Fatalf("Set for acme failed: %v", err)
+	}
+
+	testCases := []struct {
+		tenantID string
+		expected string
+	}{
+		{DefaultTenant, "default-dark"},
+		{"acme", "acme-dark"},
+		{"globex", "light"},
+	}
+	for _, tc := range testCases {
+		pref, err := mgr.Tenant(tc.tenantID).Get(ctx, "u1", "theme")
+		if err != nil {
+			t.Fatalf("Get for tenant %q failed: %v", tc.tenantID, err)
+		}
+		if pref.Value != tc.expected {
+			t.Errorf("Tenant %q: expected %q, got %v", tc.tenantID, tc.expected, pref.Value)
+		}
+	}
+
+	cache.mu.RLock()
+	_, defaultCached := cache.data["pref:u1:theme"]
+	_, acmeCached := cache.data["tenant:acme:pref:u1:theme"]
+	cache.mu.RUnlock()
+	if !defaultCached || !acmeCached {
+		t.Errorf("Expected namespaced cache entries, default=%v acme=%v", defaultCached, acmeCached)
+	}
+
+	if err := mgr.Tenant("acme").Delete(ctx, "u1", "theme"); err != nil {
+		t.Fatalf("Delete for acme failed: %v", err)
+	}
+	all, err := mgr.GetAll(ctx, "u1")
+	if err != nil {
+		t.Fatalf("GetAll failed: %v", err)
+	}
+	if all["theme"].Value != "default-dark" {
+		t.Errorf("Expected default tenant value to survive a delete in acme, got %v", all["theme"].Value)
+	}
+}
+
+func TestManager_Tenants_RequireScopedStorage(t *testing.T) {
+	ctx := context.Background()
+	defs := []PreferenceDefinition{
+		{Key: "theme", Type: StringType, DefaultValue: "light"},
+		{Key: "layout", Type: StringType, DefaultValue: "comfortable", PerDevice: true},
+	}
+	// storageOnly hides ScopesByContext, like a backend written before tenants existed.
+	mgr := newTestManager(t, defs, WithStorage(storageOnly{NewMockStorage()}))
+
+	if err := mgr.Tenant("acme").DefinePreference(defs[0]); err != nil {
+		t.Fatalf("DefinePreference failed: %v", err)
+	}
+
+	if err := mgr.Set(ctx, "u1", "theme", "dark"); err != nil {
+		t.Fatalf("Set in the default tenant failed: %v", err)
+	}
+	if err := mgr.Set(WithTenant(ctx, "acme"), "u1", "theme", "dark"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported for another tenant, got %v", err)
+	}
+	if _, err := mgr.Tenant("acme").GetAll(ctx, "u1"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported for another tenant, got %v", err)
+	}
+	if err := mgr.Set(WithDevice(ctx, "mobile"), "u1", "layout", "compact"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported for a device override, got %v", err)
+	}
+	// Preferences that are not PerDevice ignore the device, so they are still stored.
+	if err := mgr.Set(WithDevice(ctx, "mobile"), "u1", "theme", "light"); err != nil {
+		t.Errorf("Set of a shared preference with a device failed: %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+// Package userprefs provides optional OpenTelemetry tracing of Manager operations.
+package userprefs
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// TracerName is the instrumentation name of the tracer the Manager obtains from the
+// TracerProvider given to WithTracerProvider.
+const TracerName = "github.com/CreativeUnicorns/userprefs"
+
+// Span attributes recorded by the Manager. Preference values are never recorded, and neither
+// are the messages of validation errors, which may quote them.
+const (
+	// AttrOperation is the Manager operation, e.g. "Get".
+	AttrOperation = attribute.Key("userprefs.operation")
+	// AttrTenant is the tenant the operation runs in; it is omitted for the DefaultTenant.
+	AttrTenant = attribute.Key("userprefs.tenant_id")
+	// AttrKey is the preference key being read or written.
+	AttrKey = attribute.Key("userprefs.key")
+	// AttrCategory is the preference category being read.
+	AttrCategory = attribute.Key("userprefs.category")
+	// AttrKeyCount is the number of keys written or deleted by a batch operation.
+	AttrKeyCount = attribute.Key("userprefs.key_count")
+	// AttrCacheHit reports whether a cache lookup found the preference.
+	AttrCacheHit = attribute.Key("userprefs.cache.hit")
+)
+
+// WithTracerProvider is a functional option that enables OpenTelemetry tracing. Every Manager
+// operation then creates a span named "userprefs.<Operation>", with child spans for cache
+// lookups ("userprefs.cache.*"), storage queries ("userprefs.storage.*"), and encryption and
+// decryption ("userprefs.encrypt", "userprefs.decrypt"). Spans carry preference keys and
+// categories but never values.
+// This option is optional; without it, no spans are created.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *Config) {
+		if tp != nil {
+			c.tracer = tp.Tracer(TracerName)
+		}
+	}
+}
+
+// startSpan starts a span named name as a child of the span in ctx.
+func (m *Manager) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer := m.config.tracer
+	if tracer == nil {
+		tracer = noop.Tracer{}
+	}
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// startOperationSpan starts the span of a Manager operation for the tenant in ctx.
+func (m *Manager) startOperationSpan(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, AttrOperation.String(operation))
+	if tenantID := TenantFromContext(ctx); tenantID != DefaultTenant {
+		attrs = append(attrs, AttrTenant.String(tenantID))
+	}
+	return m.startSpan(ctx, "userprefs."+operation, attrs...)
+}
+
+// operationAttributes returns the span attributes describing op.
+func operationAttributes(op *Operation) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	if op.Key != "" {
+		attrs = append(attrs, AttrKey.String(op.Key))
+	}
+	if op.Definition != nil {
+		attrs = append(attrs, AttrKey.String(op.Definition.Key))
+	}
+	if op.Category != "" {
+		attrs = append(attrs, AttrCategory.String(op.Category))
+	}
+	if op.Values != nil {
+		attrs = append(attrs, AttrKeyCount.Int(len(op.Values)))
+	}
+	return attrs
+}
+
+// endSpan records err on span, unless it is ErrNotFound, and ends it. Validation errors are
+// recorded as ErrInvalidValue alone, as their messages may quote the rejected value.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		if errors.Is(err, ErrInvalidValue) {
+			err = ErrInvalidValue
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// storageGet reads a stored preference within a "userprefs.storage.Get" span.
+func (m *Manager) storageGet(ctx context.Context, userID, key string) (*Preference, error) {
+	if err := m.checkScope(ctx); err != nil {
+		return nil, err
+	}
+	ctx, span := m.startSpan(ctx, "userprefs.storage.Get", AttrKey.String(key))
+	pref, err := m.config.storage.Get(ctx, userID, key)
+	endSpan(span, err)
+	return pref, err
+}
+
+// storageSet writes a preference within a "userprefs.storage.Set" span.
+func (m *Manager) storageSet(ctx context.Context, pref *Preference) error {
+	if err := m.checkScope(ctx); err != nil {
+		return err
+	}
+	ctx, span := m.startSpan(ctx, "userprefs.storage.Set", AttrKey.String(pref.Key))
+	err := m.config.storage.Set(ctx, pref)
+	endSpan(span, err)
+	return err
+}
+
+// storageGetAll reads all stored preferences of a user within a "userprefs.storage.GetAll" span.
+func (m *Manager) storageGetAll(ctx context.Context, userID string) (map[string]*Preference, error) {
+	if err := m.checkScope(ctx); err != nil {
+		return nil, err
+	}
+	ctx, span := m.startSpan(ctx, "userprefs.storage.GetAll")
+	prefs, err := m.config.storage.GetAll(ctx, userID)
+	endSpan(span, err)
+	return prefs, err
+}
+
+// storageGetByCategory reads a user's stored preferences in category within a
+// "userprefs.storage.GetByCategory" span.
+func (m *Manager) storageGetByCategory(ctx context.Context, userID, category string) (map[string]*Preference, error) {
+	if err := m.checkScope(ctx); err != nil {
+		return nil, err
+	}
+	ctx, span := m.startSpan(ctx, "userprefs.storage.GetByCategory", AttrCategory.String(category))
+	prefs, err := m.config.storage.GetByCategory(ctx, userID, category)
+	endSpan(span, err)
+	return prefs, err
+}
+
+// storageDelete deletes a stored preference within a "userprefs.storage.Delete" span.
+func (m *Manager) storageDelete(ctx context.Context, userID, key string) error {
+	if err := m.checkScope(ctx); err != nil {
+		return err
+	}
+	ctx, span := m.startSpan(ctx, "userprefs.storage.Delete", AttrKey.String(key))
+	err := m.config.storage.Delete(ctx, userID, key)
+	endSpan(span, err)
+	return err
+}
+
+// storageListByKey reads a page of the values stored for key within a
+// "userprefs.storage.ListByKey" span.
+func (m *Manager) storageListByKey(ctx context.Context, lister KeyLister, key, afterUserID string, limit int) ([]*Preference, error) {
+	if err := m.checkScope(ctx); err != nil {
+		return nil, err
+	}
+	ctx, span := m.startSpan(ctx, "userprefs.storage.ListByKey", AttrKey.String(key))
+	page, err := lister.ListByKey(ctx, key, afterUserID, limit)
+	endSpan(span, err)
+	return page, err
+}
+
+// cacheDelete removes a user's cached preference within a "userprefs.cache.Delete" span.
+func (m *Manager) cacheDelete(ctx context.Context, userID, key string) error {
+	cacheKey := prefCacheKey(TenantFromContext(ctx), userID, key)
+	m.warmer.forget(cacheKey)
+	ctx, span := m.startSpan(ctx, "userprefs.cache.Delete", AttrKey.String(key))
+	err := m.config.cache.Delete(ctx, cacheKey)
+	endSpan(span, err)
+	return err
+}
@@ -0,0 +1,124 @@
+package userprefs
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newSpanExporter returns a TracerProvider that records every ended span in the returned exporter.
+func newSpanExporter(t *testing.T) (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { _ = tp.Shutdown(context.Background()) })
+	return tp, exporter
+}
+
+// tracingDefinitions returns an encrypted preference and a constrained one.
+func tracingDefinitions() []PreferenceDefinition {
+	return []PreferenceDefinition{
+		{Key: "api_token", Type: StringType, Category: "secrets", Encrypted: true},
+		{Key: "theme", Type: StringType, DefaultValue: "light", AllowedValues: []interface{}{"light", "dark"}},
+	}
+}
+
+// spanNamed returns the first recorded span named name.
+func spanNamed(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	t.Helper()
+	for _, span := range spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	t.Fatalf("No span named %s among %d spans", name, len(spans))
+	return tracetest.SpanStub{}
+}
+
+func TestManager_Tracing(t *testing.T) {
+	ctx := context.Background()
+	tp, exporter := newSpanExporter(t)
+	em, err := NewEncryptionAdapterWithKey([]byte("this-is-a-32-byte-key-for-test!!"))
+	if err != nil {
+		t.Fatalf("NewEncryptionAdapterWithKey failed: %v", err)
+	}
+	mgr := newTestManager(t, tracingDefinitions(), WithEncryption(em), WithTracerProvider(tp))
+	exporter.Reset()
+
+	if err := mgr.Set(ctx, "u1", "api_token", "s3cret-value"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	// Evict the entry cached by Set so that Get reads and decrypts the stored value.
+	mgr.deleteFromCache(ctx, "u1", "api_token")
+	exporter.Reset()
+
+	if _, err := mgr.Get(ctx, "u1", "api_token"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	spans := exporter.GetSpans()
+	root := spanNamed(t, spans, "userprefs.Get")
+	for _, name := range []string{"userprefs.cache.Get", "userprefs.storage.Get", "userprefs.decrypt"} {
+		child := spanNamed(t, spans, name)
+		if child.Parent.SpanID() != root.SpanContext.SpanID() {
+			t.Errorf("Expected %s to be a child of userprefs.Get", name)
+		}
+	}
+	for _, attr := range spanNamed(t, spans, "userprefs.cache.Get").Attributes {
+		if attr.Key == AttrCacheHit && attr.Value.AsBool() {
+			t.Error("Expected a cache miss to be recorded")
+		}
+	}
+
+	var sawKey bool
+	for _, span := range spans {
+		for _, attr := range span.Attributes {
+			if strings.Contains(attr.Value.Emit(), "s3cret") {
+				t.Errorf("Span %s records the preference value in %s", span.Name, attr.Key)
+			}
+			sawKey = sawKey || (span.Name == "userprefs.Get" && attr.Key == AttrKey && attr.Value.AsString() == "api_token")
+		}
+	}
+	if !sawKey {
+		t.Error("Expected userprefs.Get to be tagged with the key")
+	}
+}
+
+func TestManager_Tracing_Errors(t *testing.T) {
+	ctx := context.Background()
+	tp, exporter := newSpanExporter(t)
+	em, err := NewEncryptionAdapterWithKey([]byte("this-is-a-32-byte-key-for-test!!"))
+	if err != nil {
+		t.Fatalf("NewEncryptionAdapterWithKey failed: %v", err)
+	}
+	mgr := newTestManager(t, tracingDefinitions(), WithEncryption(em), WithTracerProvider(tp))
+	exporter.Reset()
+
+	if err := mgr.Set(ctx, "u1", "theme", "neon-pink"); err == nil {
+		t.Fatal("Expected Set to reject a value outside AllowedValues")
+	}
+	span := spanNamed(t, exporter.GetSpans(), "userprefs.Set")
+	if span.Status.Code != codes.Error || span.Status.Description != ErrInvalidValue.Error() {
+		t.Errorf("Expected the span to record ErrInvalidValue only, got %+v", span.Status)
+	}
+	for _, event := range span.Events {
+		for _, attr := range event.Attributes {
+			if strings.Contains(attr.Value.Emit(), "neon-pink") {
+				t.Errorf("Span event records the rejected value in %s", attr.Key)
+			}
+		}
+	}
+
+	exporter.Reset()
+	if _, err := mgr.GetByCategory(ctx, "u1", "secrets"); err != nil {
+		t.Fatalf("GetByCategory failed: %v", err)
+	}
+	spans := exporter.GetSpans()
+	spanNamed(t, spans, "userprefs.storage.GetByCategory")
+	if span := spanNamed(t, spans, "userprefs.GetByCategory"); span.Status.Code == codes.Error {
+		t.Errorf("Expected a successful read not to be marked as an error, got %+v", span.Status)
+	}
+}
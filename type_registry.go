@@ -0,0 +1,380 @@
+// Package userprefs provides the registry of rich preference types and their built-in handlers.
+package userprefs
+
+import (
+	"fmt"
+	"math"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TypeHandler describes how values of a preference type are validated, stored, and restored.
+// Handlers are registered with RegisterType; the built-in rich types (EnumType, DurationType, etc.)
+// are implemented the same way.
+type TypeHandler struct {
+	// Validate reports whether value is acceptable for the type. It is called by Manager.Set
+	// before AllowedValues and ValidateFunc checks. A non-nil error rejects the value and is
+	// reported wrapped in ErrInvalidValue. Validate is required.
+	Validate func(value interface{}) error
+	// Encode converts a validated value into its canonical storage form, which must survive a
+	// JSON round trip. A nil Encode stores values unchanged.
+	Encode func(value interface{}) (interface{}, error)
+	// Decode converts a stored value back into the type's Go representation. It receives either
+	// the output of Encode or that output after a JSON round trip (e.g. []interface{} for a slice,
+	// float64 for a number), and must accept an already decoded value unchanged.
+	// A nil Decode returns stored values unchanged.
+	Decode func(stored interface{}) (interface{}, error)
+}
+
+var (
+	typeHandlersMu sync.RWMutex
+	// typeHandlers holds the built-in rich types and any types added with RegisterType.
+	typeHandlers = map[string]TypeHandler{
+		EnumType:       {Validate: validateEnum},
+		DurationType:   {Validate: validateDuration, Encode: encodeDuration, Decode: decodeDuration},
+		TimestampType:  {Validate: validateTimestamp, Encode: encodeTimestamp, Decode: decodeTimestamp},
+		TimezoneType:   {Validate: validateTimezone},
+		LocaleType:     {Validate: validateLocale, Encode: encodeLocale},
+		ColorType:      {Validate: validateColor, Encode: encodeColor},
+		EmailType:      {Validate: validateEmail, Encode: encodeEmail},
+		URLType:        {Validate: validateURL, Encode: encodeURL},
+		StringListType: {Validate: validateStringList, Encode: decodeStringList, Decode: decodeStringList},
+		IntListType:    {Validate: validateIntList, Encode: decodeIntList, Decode: decodeIntList},
+	}
+)
+
+// RegisterType makes a custom preference type available to every Manager in the process.
+// Definitions may then use name as their Type. RegisterType is typically called from an
+// init function, before any definition using the type is registered.
+//
+// Returns:
+//   - ErrInvalidInput: if name is empty, handler.Validate is nil, or name is already
+//     registered (including the built-in types).
+//   - nil: on success.
+//
+// This function is thread-safe.
+func RegisterType(name string, handler TypeHandler) error {
+	if name == "" || handler.Validate == nil {
+		return fmt.Errorf("%w: a type needs a name and a Validate function", ErrInvalidInput)
+	}
+
+	typeHandlersMu.Lock()
+	defer typeHandlersMu.Unlock()
+
+	if validTypes[name] || typeHandlers[name].Validate != nil {
+		return fmt.Errorf("%w: type '%s' is already registered", ErrInvalidInput, name)
+	}
+	typeHandlers[name] = handler
+	return nil
+}
+
+// lookupType returns the handler registered for a rich or custom type.
+func lookupType(name string) (TypeHandler, bool) {
+	typeHandlersMu.RLock()
+	defer typeHandlersMu.RUnlock()
+	handler, ok := typeHandlers[name]
+	return handler, ok
+}
+
+// encodeValue converts a validated value into the canonical storage form of def.Type.
+func encodeValue(value interface{}, def PreferenceDefinition) (interface{}, error) {
+	handler, ok := lookupType(def.Type)
+	if !ok || handler.Encode == nil || value == nil {
+		return value, nil
+	}
+	encoded, err := handler.Encode(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to encode %s value for key '%s': %v", ErrSerialization, def.Type, def.Key, err)
+	}
+	return encoded, nil
+}
+
+// decodeValue converts a stored or cached value back into the Go representation of def.Type.
+func decodeValue(value interface{}, def PreferenceDefinition) (interface{}, error) {
+	handler, ok := lookupType(def.Type)
+	if !ok || handler.Decode == nil || value == nil {
+		// Primitive values that went through JSON (e.g. ints as float64) get their canonical type back.
+		return canonicalValue(value, def, false), nil
+	}
+	decoded, err := handler.Decode(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decode %s value for key '%s': %v", ErrSerialization, def.Type, def.Key, err)
+	}
+	return decoded, nil
+}
+
+// validateEnum accepts strings; membership is checked against the definition's AllowedValues.
+func validateEnum(value interface{}) error {
+	if _, ok := value.(string); !ok {
+		return fmt.Errorf("expected string enum value")
+	}
+	return nil
+}
+
+// validateDuration accepts time.Duration values and strings understood by time.ParseDuration.
+func validateDuration(value interface{}) error {
+	_, err := decodeDuration(value)
+	return err
+}
+
+// encodeDuration stores durations in time.Duration.String form, e.g. "1h30m0s".
+func encodeDuration(value interface{}) (interface{}, error) {
+	d, err := decodeDuration(value)
+	if err != nil {
+		return nil, err
+	}
+	return d.(time.Duration).String(), nil
+}
+
+// decodeDuration accepts time.Duration values, duration strings, and nanosecond counts.
+func decodeDuration(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case time.Duration:
+		return v, nil
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("expected duration: %v", err)
+		}
+		return d, nil
+	case float64:
+		// A time.Duration that went through JSON is a nanosecond count.
+		if v != math.Trunc(v) {
+			return nil, fmt.Errorf("expected whole nanoseconds, got %v", v)
+		}
+		return time.Duration(v), nil
+	default:
+		return nil, fmt.Errorf("expected duration, got %T", value)
+	}
+}
+
+// validateTimestamp accepts time.Time values and RFC 3339 strings.
+func validateTimestamp(value interface{}) error {
+	_, err := decodeTimestamp(value)
+	return err
+}
+
+// encodeTimestamp stores timestamps as RFC 3339 strings in UTC.
+func encodeTimestamp(value interface{}) (interface{}, error) {
+	t, err := decodeTimestamp(value)
+	if err != nil {
+		return nil, err
+	}
+	return t.(time.Time).UTC().Format(time.RFC3339Nano), nil
+}
+
+// decodeTimestamp accepts time.Time values and RFC 3339 strings.
+func decodeTimestamp(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, fmt.Errorf("expected RFC 3339 timestamp: %v", err)
+		}
+		return t, nil
+	default:
+		return nil, fmt.Errorf("expected timestamp, got %T", value)
+	}
+}
+
+// validateTimezone accepts IANA time zone names such as "Europe/Berlin" or "UTC".
+// Names are resolved with time.LoadLocation, so the host's zoneinfo database (or an
+// import of time/tzdata) determines which zones are known.
+func validateTimezone(value interface{}) error {
+	name, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("expected time zone name, got %T", value)
+	}
+	if name == "" || name == "Local" {
+		return fmt.Errorf("expected IANA time zone name, got %q", name)
+	}
+	if _, err := time.LoadLocation(name); err != nil {
+		return fmt.Errorf("unknown time zone %q", name)
+	}
+	return nil
+}
+
+// localePattern matches the language, script, region, and variant subtags of a BCP 47 tag.
+var localePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z]{4})?(-(?:[a-zA-Z]{2}|[0-9]{3}))?(-(?:[a-zA-Z0-9]{5,8}|[0-9][a-zA-Z0-9]{3}))*$`)
+
+// validateLocale accepts BCP 47 language tags such as "en", "pt-BR", or "zh-Hant-TW".
+func validateLocale(value interface{}) error {
+	tag, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("expected locale tag, got %T", value)
+	}
+	if !localePattern.MatchString(tag) {
+		return fmt.Errorf("invalid BCP 47 locale tag %q", tag)
+	}
+	return nil
+}
+
+// encodeLocale stores locale tags in canonical case: lowercase language, title-case script,
+// uppercase region, lowercase variants.
+func encodeLocale(value interface{}) (interface{}, error) {
+	subtags := strings.Split(value.(string), "-")
+	for i, sub := range subtags {
+		switch {
+		case i == 0:
+			subtags[i] = strings.ToLower(sub)
+		case len(sub) == 4 && i == 1 && isLetters(sub):
+			subtags[i] = strings.ToUpper(sub[:1]) + strings.ToLower(sub[1:])
+		case len(sub) == 2:
+			subtags[i] = strings.ToUpper(sub)
+		default:
+			subtags[i] = strings.ToLower(sub)
+		}
+	}
+	return strings.Join(subtags, "-"), nil
+}
+
+// isLetters reports whether s consists only of ASCII letters.
+func isLetters(s string) bool {
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// colorPattern matches #rgb, #rrggbb, and #rrggbbaa hex colors.
+var colorPattern = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})$`)
+
+// validateColor accepts hex colors in #rgb, #rrggbb, or #rrggbbaa form.
+func validateColor(value interface{}) error {
+	color, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("expected hex color, got %T", value)
+	}
+	if !colorPattern.MatchString(color) {
+		return fmt.Errorf("invalid hex color %q", color)
+	}
+	return nil
+}
+
+// encodeColor stores colors as lowercase #rrggbb (or #rrggbbaa), expanding the #rgb shorthand.
+func encodeColor(value interface{}) (interface{}, error) {
+	color := strings.ToLower(value.(string))
+	if len(color) == 4 {
+		color = string([]byte{'#', color[1], color[1], color[2], color[2], color[3], color[3]})
+	}
+	return color, nil
+}
+
+// validateEmail accepts a bare email address without a display name.
+func validateEmail(value interface{}) error {
+	email, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("expected email address, got %T", value)
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		return fmt.Errorf("invalid email address %q", email)
+	}
+	return nil
+}
+
+// encodeEmail stores email addresses with a lowercase domain; the local part is case-sensitive.
+func encodeEmail(value interface{}) (interface{}, error) {
+	email := value.(string)
+	at := strings.LastIndex(email, "@")
+	return email[:at] + strings.ToLower(email[at:]), nil
+}
+
+// validateURL accepts absolute URLs with a scheme and host.
+func validateURL(value interface{}) error {
+	raw, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("expected URL, got %T", value)
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid absolute URL %q", raw)
+	}
+	return nil
+}
+
+// encodeURL stores URLs in the form produced by url.URL.String, with a lowercase scheme and host.
+func encodeURL(value interface{}) (interface{}, error) {
+	u, err := url.Parse(value.(string))
+	if err != nil {
+		return nil, err
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	return u.String(), nil
+}
+
+// validateStringList accepts []string and []interface{} holding only strings.
+func validateStringList(value interface{}) error {
+	_, err := decodeStringList(value)
+	return err
+}
+
+// decodeStringList converts supported list forms to []string.
+func decodeStringList(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case []string:
+		return v, nil
+	case []interface{}:
+		out := make([]string, len(v))
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected string list element at index %d, got %T", i, item)
+			}
+			out[i] = s
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("expected string list, got %T", value)
+	}
+}
+
+// validateIntList accepts []int, []int64, and []interface{} holding only whole numbers.
+func validateIntList(value interface{}) error {
+	_, err := decodeIntList(value)
+	return err
+}
+
+// decodeIntList converts supported list forms to []int.
+func decodeIntList(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case []int:
+		return v, nil
+	case []int64:
+		out := make([]int, len(v))
+		for i, n := range v {
+			out[i] = int(n)
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]int, len(v))
+		for i, item := range v {
+			switch n := item.(type) {
+			case int:
+				out[i] = n
+			case int64:
+				out[i] = int(n)
+			case float64:
+				// Integers that went through JSON come back as float64.
+				if n != math.Trunc(n) {
+					return nil, fmt.Errorf("expected integer list element at index %d, got %v", i, n)
+				}
+				out[i] = int(n)
+			default:
+				return nil, fmt.Errorf("expected integer list element at index %d, got %T", i, item)
+			}
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("expected integer list, got %T", value)
+	}
+}
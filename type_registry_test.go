@@ -0,0 +1,190 @@
+package userprefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateValue_RichTypes(t *testing.T) {
+	testCases := []struct {
+		typ     string
+		valid   []interface{}
+		invalid []interface{}
+	}{
+		{DurationType, []interface{}{90 * time.Minute, "1h30m"}, []interface{}{"soon", 5}},
+		{TimestampType, []interface{}{time.Now(), "2025-03-01T10:00:00Z"}, []interface{}{"2025-03-01", 1700000000}},
+		{TimezoneType, []interface{}{"Europe/Berlin", "UTC"}, []interface{}{"Mars/Olympus", "", "Local", 2}},
+		{LocaleType, []interface{}{"en", "pt-BR", "zh-Hant-TW", "es-419"}, []interface{}{"english", "en_US", "e", 1}},
+		{ColorType, []interface{}{"#fff", "#1A2b3C", "#11223344"}, []interface{}{"fff", "#ggg", "#12345", "red"}},
+		{EmailType, []interface{}{"ada@example.com"}, []interface{}{"Ada <ada@example.com>", "not-an-email", 7}},
+		{URLType, []interface{}{"https://example.com/path?q=1"}, []interface{}{"/relative", "example.com", "https://"}},
+		{StringListType, []interface{}{[]string{"a"}, []interface{}{"a", "b"}, []string{}}, []interface{}{"a", []interface{}{"a", 1}}},
+		{IntListType, []interface{}{[]int{1, 2}, []int64{3}, []interface{}{1, 2.0}}, []interface{}{[]interface{}{1.5}, []string{"1"}, 1}},
+	}
+
+	for _, tc := range testCases {
+		def := PreferenceDefinition{Key: "k", Type: tc.typ}
+		for _, v := range tc.valid {
+			if err := validateValue(v, def); err != nil {
+				t.Errorf("%s: expected %v to be valid, got %v", tc.typ, v, err)
+			}
+		}
+		for _, v := range tc.invalid {
+			if err := validateValue(v, def); !errors.Is(err, ErrInvalidValue) {
+				t.Errorf("%s: expected ErrInvalidValue for %v, got %v", tc.typ, v, err)
+			}
+		}
+	}
+}
+
+func TestValidateValue_EnumType(t *testing.T) {
+	def := PreferenceDefinition{Key: "density", Type: EnumType, AllowedValues: []interface{}{"compact", "comfortable"}}
+
+	if err := validateValue("compact", def); err != nil {
+		t.Errorf("Expected valid enum value, got %v", err)
+	}
+	if err := validateValue("spacious", def); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("Expected ErrInvalidValue for value outside AllowedValues, got %v", err)
+	}
+
+	mgr := newTestManager(t, nil)
+	if err := mgr.DefinePreference(PreferenceDefinition{Key: "density", Type: EnumType}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for enum without AllowedValues, got %v", err)
+	}
+}
+
+func TestEncodeValue_Canonical(t *testing.T) {
+	testCases := []struct {
+		typ      string
+		value    interface{}
+		expected interface{}
+	}{
+		{DurationType, "90m", "1h30m0s"},
+		{TimestampType, time.Date(2025, 3, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600)), "2025-03-01T11:00:00Z"},
+		{LocaleType, "ZH-hant-tw", "zh-Hant-TW"},
+		{ColorType, "#AbC", "#aabbcc"},
+		{EmailType, "Ada@Example.COM", "Ada@example.com"},
+		{URLType, "HTTPS://Example.com/Path", "https://example.com/Path"},
+		{IntListType, []interface{}{1, 2.0}, []int{1, 2}},
+	}
+	for _, tc := range testCases {
+		got, err := encodeValue(tc.value, PreferenceDefinition{Key: "k", Type: tc.typ})
+		if err != nil {
+			t.Fatalf("%s: encodeValue failed: %v", tc.typ, err)
+		}
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%s: expected %#v, got %#v", tc.typ, tc.expected, got)
+		}
+	}
+}
+
+func TestManager_RichTypes_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	key := []byte("this-is-a-32-byte-key-for-test!!")
+	adapter, err := NewEncryptionAdapterWithKey(key)
+	if err != nil {
+		t.Fatalf("NewEncryptionAdapterWithKey failed: %v", err)
+	}
+
+	testCases := []struct {
+		def      PreferenceDefinition
+		value    interface{}
+		expected interface{}
+	}{
+		{PreferenceDefinition{Key: "snooze", Type: DurationType}, "15m", 15 * time.Minute},
+		{PreferenceDefinition{Key: "snooze_secret", Type: DurationType, Encrypted: true}, 2 * time.Hour, 2 * time.Hour},
+		{PreferenceDefinition{Key: "trial_ends", Type: TimestampType}, "2025-03-01T10:00:00Z", time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)},
+		{PreferenceDefinition{Key: "tags", Type: StringListType}, []string{"go", "db"}, []string{"go", "db"}},
+		{PreferenceDefinition{Key: "pins", Type: IntListType, Encrypted: true}, []int{3, 1}, []int{3, 1}},
+		{PreferenceDefinition{Key: "code", Type: EnumType, AllowedValues: []interface{}{"123", "456"}, Encrypted: true}, "123", "123"},
+		{PreferenceDefinition{Key: "accent", Type: ColorType}, "#F0A", "#ff00aa"},
+	}
+
+	for _, withCache := range []bool{false, true} {
+		opts := []Option{WithStorage(NewMockStorage()), WithEncryption(adapter), WithLogger(&MockLogger{})}
+		if withCache {
+			opts = append(opts, WithCache(NewMockCache()))
+		}
+		mgr := New(opts...)
+
+		for _, tc := range testCases {
+			t.Run(fmt.Sprintf("%s/cache=%v", tc.def.Key, withCache), func(t *testing.T) {
+				if err := mgr.DefinePreference(tc.def); err != nil {
+					t.Fatalf("DefinePreference failed: %v", err)
+				}
+				if err := mgr.Set(ctx, "u1", tc.def.Key, tc.value); err != nil {
+					t.Fatalf("Set failed: %v", err)
+				}
+				// The first read is served by storage or the cache entry written by Set, the second
+				// by the cache when configured.
+				for i := 0; i < 2; i++ {
+					pref, err := mgr.Get(ctx, "u1", tc.def.Key)
+					if err != nil {
+						t.Fatalf("Get failed: %v", err)
+					}
+					if !reflect.DeepEqual(pref.Value, tc.expected) {
+						t.Errorf("Expected %#v, got %#v", tc.expected, pref.Value)
+					}
+				}
+			})
+		}
+
+		all, err := mgr.GetAll(ctx, "u1")
+		if err != nil {
+			t.Fatalf("GetAll failed: %v", err)
+		}
+		if got := all["snooze"].Value; got != 15*time.Minute {
+			t.Errorf("Expected GetAll to decode duration, got %#v", got)
+		}
+	}
+}
+
+func TestRegisterType(t *testing.T) {
+	ctx := context.Background()
+
+	semver := TypeHandler{
+		Validate: func(value interface{}) error {
+			s, ok := value.(string)
+			if !ok || len(strings.Split(strings.TrimPrefix(s, "v"), ".")) != 3 {
+				return fmt.Errorf("expected semantic version, got %v", value)
+			}
+			return nil
+		},
+		Encode: func(value interface{}) (interface{}, error) {
+			return strings.TrimPrefix(value.(string), "v"), nil
+		},
+	}
+	if err := RegisterType("test_semver", semver); err != nil {
+		t.Fatalf("RegisterType failed: %v", err)
+	}
+
+	if err := RegisterType("test_semver", semver); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for duplicate registration, got %v", err)
+	}
+	if err := RegisterType(StringType, semver); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for built-in type, got %v", err)
+	}
+	if err := RegisterType("test_novalidate", TypeHandler{}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput without Validate, got %v", err)
+	}
+
+	mgr := newTestManager(t, []PreferenceDefinition{{Key: "min_client", Type: "test_semver"}})
+	if err := mgr.Set(ctx, "u1", "min_client", "1.2"); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("Expected ErrInvalidValue, got %v", err)
+	}
+	if err := mgr.Set(ctx, "u1", "min_client", "v1.2.3"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	pref, err := mgr.Get(ctx, "u1", "min_client")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if pref.Value != "1.2.3" {
+		t.Errorf("Expected canonical value 1.2.3, got %v", pref.Value)
+	}
+}
@@ -2,7 +2,11 @@
 package userprefs
 
 import (
+	"context"
+	"encoding/json"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Preference represents a single user preference setting as stored and retrieved by the system.
@@ -27,6 +31,20 @@ type Preference struct {
 	Category string `json:"category,omitempty"`
 	// UpdatedAt records the time when this preference was last set or modified in storage.
 	UpdatedAt time.Time `json:"updated_at"`
+	// Version is the PreferenceDefinition.Version that Value was written with.
+	// Rows written before definitions were versioned carry version 0. The Manager
+	// upgrades older values through the definition's Migrations when they are read.
+	Version int `json:"version,omitempty"`
+	// DeviceID is the device whose override Value is (see WithDevice and
+	// PreferenceDefinition.PerDevice). It is empty for the user's own values and for defaults.
+	DeviceID string `json:"device_id,omitempty"`
+	// AppliedRule is the name of the PreferenceDefinition.DefaultRules entry that produced Value
+	// for a user without a stored value. It is empty for stored values and for the plain DefaultValue.
+	AppliedRule string `json:"applied_rule,omitempty"`
+	// Disabled reports that the preference's PreferenceDefinition.DependsOn parent is currently off.
+	// Disabled preferences cannot be set until the parent is turned on. It is computed on read
+	// and never persisted.
+	Disabled bool `json:"disabled,omitempty"`
 }
 
 // PreferenceDefinition defines the schema, constraints, and default behavior for a particular preference key.
@@ -59,6 +77,109 @@ type PreferenceDefinition struct {
 	// return nil if validation passes, or an error if it fails.
 	// The `json:"-"` tag indicates this field is not serialized to JSON.
 	ValidateFunc func(value interface{}) error `json:"-"`
+	// NormalizeFunc is an optional function that rewrites a value before it is validated and
+	// stored, e.g. to trim whitespace or lowercase a string. It receives the value already
+	// converted to the canonical Go type of Type (int for "int", float64 for "float") and is
+	// also applied to AllowedValues when the definition is registered. Returning an error
+	// rejects the value.
+	// The `json:"-"` tag indicates this field is not serialized to JSON.
+	NormalizeFunc func(value interface{}) (interface{}, error) `json:"-"`
+	// CoerceStrings, when true, lets Set accept string forms of int, float, and bool values
+	// (e.g. "42", "2.5", "true"), which are parsed into the canonical type before validation.
+	CoerceStrings bool `json:"coerce_strings,omitempty"`
+	// Version is the current schema version of this preference's value. It starts at 0 and
+	// should be incremented whenever the shape of the value changes in a way existing
+	// stored values no longer satisfy. Every value written by the Manager records the
+	// Version it was written with.
+	Version int `json:"version,omitempty"`
+	// Migrations upgrades values written with an older Version. Values are migrated lazily
+	// when read through Manager.Get, GetAll, and GetByCategory, or eagerly via Manager.MigrateAll.
+	// A version without a corresponding Migration is assumed to be compatible with the next one.
+	// The `json:"-"` tag indicates this field is not serialized to JSON.
+	Migrations []Migration `json:"-"`
+	// DefaultRules, if provided, choose the default for users without a stored value based on
+	// a stable percentage rollout and attributes supplied via WithAttributes. Rules are evaluated
+	// in order and the first one that applies wins; DefaultValue is used when none applies.
+	DefaultRules []DefaultRule `json:"default_rules,omitempty"`
+	// DefaultFunc, if provided, computes the default for users without a stored value, e.g.
+	// their locale from a profile service. It is consulted when no DefaultRules entry applies.
+	// Its results are cached for the TTL set by WithDefaultFuncTTL. DefaultValue is used when
+	// it returns an error, nil, or a value that fails validation.
+	// The `json:"-"` tag indicates this field is not serialized to JSON.
+	DefaultFunc func(ctx context.Context, userID string) (interface{}, error) `json:"-"`
+	// PerDevice, if true, lets each of a user's devices override the user's value, e.g. a compact
+	// layout on mobile and a comfortable one on desktop. With a device in the context (see
+	// WithDevice), reads resolve the device's override, then the user's value, then the default,
+	// and writes change the override. Without it, the device in the context is ignored.
+	PerDevice bool `json:"per_device,omitempty"`
+	// MergeFunc, if provided, resolves a conflict in Manager.ApplyClientChanges between the
+	// server's value and a client's value changed concurrently, e.g. by combining the fields of
+	// two JSON objects, instead of keeping the value changed last. It receives both values
+	// decoded and returns the value to store, which is validated like a value passed to Set.
+	// It is only allowed for JSONType preferences.
+	// The `json:"-"` tag indicates this field is not serialized to JSON.
+	MergeFunc func(server, client interface{}) (interface{}, error) `json:"-"`
+	// DependsOn, if provided, makes this preference conditional on another preference.
+	// While the parent is off, writes to this preference are rejected and reads hide or
+	// disable it; while the parent is on, Dependency.Required makes it mandatory.
+	DependsOn *Dependency `json:"depends_on,omitempty"`
+	// Min and Max, if set, bound numeric values (int, float, JSON numbers, and int_list elements).
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+	// MinLength and MaxLength, if set, bound the length in characters of string values
+	// (including string_list elements).
+	MinLength *int `json:"min_length,omitempty"`
+	MaxLength *int `json:"max_length,omitempty"`
+	// Pattern, if set, is a regular expression (Go RE2 syntax) that string values, including
+	// string_list elements, must match. Anchor it with ^ and $ to match the whole value.
+	Pattern string `json:"pattern,omitempty"`
+	// MaxItems, if set, limits the number of elements in list values (string_list, int_list, JSON arrays).
+	MaxItems *int `json:"max_items,omitempty"`
+	// Schema, if set, is a JSON Schema document that json-typed values must satisfy.
+	// A subset of the specification is supported: type, enum, properties, required,
+	// additionalProperties (boolean only), items, minimum, maximum, minLength, maxLength,
+	// pattern, minItems, and maxItems.
+	Schema json.RawMessage `json:"schema,omitempty"`
+	// ReadOnly makes the preference readable but not changeable by end users: Set and Delete
+	// return ErrForbidden when the actor in the context (see WithActor) is an ActorUser.
+	// Services and admins can still change it, e.g. a billing service setting the plan tier.
+	ReadOnly bool `json:"read_only,omitempty"`
+	// WritableBy, if provided, restricts Set and Delete to actors with one of the listed roles.
+	// Calls without an actor in the context are treated as coming from an ActorService.
+	WritableBy []ActorRole `json:"writable_by,omitempty"`
+	// Hidden marks an internal preference that should not be shown to end users. With the
+	// WithHiddenFiltering option, GetAll and GetByCategory omit it for ActorUser actors.
+	Hidden bool `json:"hidden,omitempty"`
+	// Deprecated marks a preference that should no longer be written. Writes still succeed
+	// but log a warning. Setting ReplacedBy implies Deprecated.
+	Deprecated bool `json:"deprecated,omitempty"`
+	// ReplacedBy, if provided, names the key that supersedes this one. Reads of the new key
+	// fall back to this key's stored value for users who have no value under the new key yet,
+	// and writes to this key are redirected to the new key.
+	ReplacedBy string `json:"replaced_by,omitempty"`
+	// Transform, if provided, converts a value of this (deprecated) preference into a value
+	// of its ReplacedBy preference, e.g. a bool "dark_mode" into a "theme" string. It is used
+	// by read fallback, write redirection, and Manager.RenameKey. Requires ReplacedBy.
+	// The `json:"-"` tag indicates this field is not serialized to JSON.
+	Transform func(value interface{}) (interface{}, error) `json:"-"`
+	// MigrateOnRead, when true, makes a read that falls back to this preference's stored value
+	// also write it under the ReplacedBy key and delete it from this key. Requires ReplacedBy.
+	MigrateOnRead bool `json:"migrate_on_read,omitempty"`
+	// Label is the name of the preference shown on a settings screen, e.g. "Color theme".
+	Label string `json:"label,omitempty"`
+	// Description is a longer explanation shown alongside the Label.
+	Description string `json:"description,omitempty"`
+	// DisplayOrder positions the preference on a settings screen; lower values come first.
+	// GetAllDefinitions returns definitions sorted by DisplayOrder, then by Key.
+	DisplayOrder int `json:"display_order,omitempty"`
+	// Widget hints which input control a settings screen should render for the preference.
+	Widget Widget `json:"widget,omitempty"`
+	// ValueLabels maps values to the text shown for them, keyed by the value's string form
+	// (fmt.Sprint), e.g. {"dark": "Dark mode"}. With AllowedValues, every key must name one.
+	ValueLabels map[string]string `json:"value_labels,omitempty"`
+	// Translations holds the Label, Description, and ValueLabels in other languages, keyed by
+	// BCP 47 language tag, e.g. "de" or "pt-BR". Use Localize to apply one.
+	Translations map[string]Translation `json:"translations,omitempty"`
 }
 
 // Config holds the internal configuration for a Manager instance.
@@ -74,8 +195,32 @@ type Config struct {
 	logger Logger
 	// definitions stores all registered PreferenceDefinition instances, keyed by their PreferenceDefinition.Key.
 	definitions map[string]PreferenceDefinition
+	// tenantDefinitions stores the definitions of tenants other than DefaultTenant, keyed by tenant ID and then by key.
+	tenantDefinitions map[string]map[string]PreferenceDefinition
 	// encryptionManager is the optional encryption implementation for encrypting preference values.
 	encryptionManager EncryptionManager
+	// crossFieldValidators are run against the user's current and proposed preferences on every write.
+	crossFieldValidators []CrossFieldValidator
+	// filterHidden makes GetAll and GetByCategory omit Hidden preferences for end-user actors.
+	filterHidden bool
+	// changeListeners are notified after every persisted change to a user's preference.
+	changeListeners []ChangeListener
+	// interceptors wrap the Manager's public operations, outermost first.
+	interceptors []Interceptor
+	// tracer creates the spans of Manager operations; nil disables tracing.
+	tracer trace.Tracer
+	// warmWorkers is the number of goroutines that write GetAll results to the cache; 0 disables warming.
+	warmWorkers int
+	// warmQueueSize bounds the number of preferences waiting to be written to the cache.
+	warmQueueSize int
+	// defaultFuncTTL is how long DefaultFunc results are cached; 0 disables their caching.
+	defaultFuncTTL time.Duration
+	// definitionStore persists the definition catalogue; nil keeps definitions in memory only.
+	definitionStore DefinitionStore
+	// definitionPollInterval is how often the catalogue is reloaded from definitionStore; 0 disables polling.
+	definitionPollInterval time.Duration
+	// tombstoneRetention is how long tombstones are kept by a TombstonePruner; 0 keeps them forever.
+	tombstoneRetention time.Duration
 }
 
 // Option defines the signature for a functional option that configures a Manager instance.
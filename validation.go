@@ -4,9 +4,11 @@ package userprefs
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
 )
 
-// validTypes maps valid preference types to a boolean for quick lookup.
+// validTypes maps the primitive preference types to a boolean for quick lookup.
+// Rich and custom types are held in the type registry (see RegisterType).
 var validTypes = map[string]bool{
 	StringType: true,
 	BoolType:   true,
@@ -15,57 +17,91 @@ var validTypes = map[string]bool{
 	JSONType:   true,
 }
 
-// isValidType checks if the provided type is valid.
+// isValidType checks if the provided type is a primitive or registered type.
 func isValidType(t string) bool {
-	return validTypes[t]
+	if validTypes[t] {
+		return true
+	}
+	_, ok := lookupType(t)
+	return ok
 }
 
 // validateValue ensures that the value conforms to the preference definition.
+// Failures are reported as a *ValidationError, or as ValidationErrors when the value
+// violates several declarative constraints at once; both match ErrInvalidValue.
 func validateValue(value interface{}, def PreferenceDefinition) error {
+	typeError := func(expected, message string) *ValidationError {
+		return &ValidationError{Key: def.Key, Rule: RuleType, Expected: expected, Actual: actualType(value), Message: message}
+	}
+
 	switch def.Type {
 	case StringType:
 		if _, ok := value.(string); !ok {
-			return fmt.Errorf("%w: expected string", ErrInvalidValue)
+			return typeError(StringType, "expected string")
 		}
 	case BoolType:
 		if _, ok := value.(bool); !ok {
-			return fmt.Errorf("%w: expected boolean", ErrInvalidValue)
+			return typeError(BoolType, "expected boolean")
 		}
 	case IntType:
 		switch value.(type) {
 		case int, int32, int64:
 			// Valid integer types
 		default:
-			return fmt.Errorf("%w: expected integer", ErrInvalidValue)
+			return typeError(IntType, "expected integer")
 		}
 	case FloatType:
 		switch value.(type) {
 		case float32, float64:
 			// Valid float types
 		default:
-			return fmt.Errorf("%w: expected float", ErrInvalidValue)
+			return typeError(FloatType, "expected float")
 		}
 	case JSONType:
 		if _, err := json.Marshal(value); err != nil {
-			return fmt.Errorf("%w: invalid JSON value", ErrInvalidValue)
+			return typeError(JSONType, "invalid JSON value")
 		}
 	default:
-		return fmt.Errorf("%w: unsupported type %s", ErrInvalidType, def.Type)
+		handler, ok := lookupType(def.Type)
+		if !ok {
+			return fmt.Errorf("%w: unsupported type %s", ErrInvalidType, def.Type)
+		}
+		if err := handler.Validate(value); err != nil {
+			verr := typeError(def.Type, err.Error())
+			verr.Err = err
+			return verr
+		}
+	}
+
+	if violations := checkConstraints(value, def); len(violations) > 0 {
+		return violations.asError()
 	}
 
 	// Check allowed values if specified
 	if len(def.AllowedValues) > 0 {
 		found := false
 		for _, allowed := range def.AllowedValues {
-			if value == allowed {
+			if valuesEqual(value, allowed) {
 				found = true
 				break
 			}
 		}
 		if !found {
-			return fmt.Errorf("%w: value not in allowed values", ErrInvalidValue)
+			return &ValidationError{Key: def.Key, Rule: RuleAllowedValues, Expected: def.AllowedValues, Actual: actualType(value), Message: "value not in allowed values"}
 		}
 	}
 
 	return nil
 }
+
+// valuesEqual compares two values with == when possible, falling back to reflect.DeepEqual
+// for uncomparable values such as slices.
+func valuesEqual(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if reflect.TypeOf(a).Comparable() && reflect.TypeOf(b).Comparable() {
+		return a == b
+	}
+	return reflect.DeepEqual(a, b)
+}
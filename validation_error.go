@@ -0,0 +1,171 @@
+// Package userprefs provides structured validation errors for preference writes.
+package userprefs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Rule codes reported in ValidationError.Rule.
+const (
+	// RuleType reports a value of the wrong Go type or an invalid rich-type value (e.g. a malformed email).
+	RuleType = "type"
+	// RuleAllowedValues reports a value outside the definition's AllowedValues.
+	RuleAllowedValues = "allowed_values"
+	// RuleCustom reports a failure returned by the definition's ValidateFunc.
+	RuleCustom = "custom"
+	// RuleNormalize reports a failure returned by the definition's NormalizeFunc.
+	RuleNormalize = "normalize"
+	// RuleTransform reports a failure returned by a deprecated definition's Transform.
+	RuleTransform = "transform"
+	// RuleMin reports a number below the definition's Min.
+	RuleMin = "min"
+	// RuleMax reports a number above the definition's Max.
+	RuleMax = "max"
+	// RuleMinLength reports a string shorter than the definition's MinLength.
+	RuleMinLength = "min_length"
+	// RuleMaxLength reports a string longer than the definition's MaxLength.
+	RuleMaxLength = "max_length"
+	// RulePattern reports a string that does not match the definition's Pattern.
+	RulePattern = "pattern"
+	// RuleMaxItems reports a list longer than the definition's MaxItems.
+	RuleMaxItems = "max_items"
+	// RuleSchema reports a JSON value that does not satisfy the definition's Schema.
+	RuleSchema = "schema"
+	// RuleDependency reports a write to a preference whose DependsOn parent is off.
+	RuleDependency = "dependency"
+	// RuleRequired reports a Required dependent left empty while its parent is on.
+	RuleRequired = "required"
+	// RuleCrossField reports a failure returned by a CrossFieldValidator.
+	RuleCrossField = "cross_field"
+)
+
+// ValidationError describes why a single preference value was rejected.
+// It matches ErrInvalidValue with errors.Is, as well as the underlying Err when one is set,
+// and can be extracted from any error returned by Manager.Set or SetMany with errors.As.
+type ValidationError struct {
+	// Key is the preference key whose value was rejected.
+	Key string `json:"key"`
+	// Rule is the code of the failed rule, one of the Rule* constants.
+	Rule string `json:"rule"`
+	// Path locates the offending element inside the value as a JSON Pointer (e.g. "/panels/0").
+	// It is empty when the value as a whole failed.
+	Path string `json:"path,omitempty"`
+	// Expected describes what the rule expected: a type name, a bound, a pattern, or the allowed values.
+	Expected interface{} `json:"expected,omitempty"`
+	// Actual is the Go type of the rejected value.
+	Actual string `json:"actual,omitempty"`
+	// Message is a human-readable description of the failure.
+	Message string `json:"message"`
+	// Err is the underlying error, such as one returned by a ValidateFunc or CrossFieldValidator.
+	Err error `json:"-"`
+}
+
+// Error implements the error interface.
+func (e *ValidationError) Error() string {
+	location := e.Key
+	if e.Path != "" {
+		location += e.Path
+	}
+	if location == "" {
+		return fmt.Sprintf("%v: %s", ErrInvalidValue, e.Message)
+	}
+	return fmt.Sprintf("%v: preference '%s': %s", ErrInvalidValue, location, e.Message)
+}
+
+// Unwrap returns ErrInvalidValue and, when set, the underlying Err.
+func (e *ValidationError) Unwrap() []error {
+	if e.Err != nil {
+		return []error{ErrInvalidValue, e.Err}
+	}
+	return []error{ErrInvalidValue}
+}
+
+// ValidationErrors aggregates every ValidationError found while validating a write.
+// Manager.SetMany always reports validation failures as ValidationErrors; Manager.Set does
+// so when a single value violates several rules.
+type ValidationErrors []*ValidationError
+
+// Error implements the error interface.
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, len(v))
+	for i, e := range v {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the individual errors so that errors.Is and errors.As see each of them.
+func (v ValidationErrors) Unwrap() []error {
+	errs := make([]error, len(v))
+	for i, e := range v {
+		errs[i] = e
+	}
+	return errs
+}
+
+// ForKey returns the errors reported for key.
+func (v ValidationErrors) ForKey(key string) ValidationErrors {
+	var out ValidationErrors
+	for _, e := range v {
+		if e.Key == key {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// asError returns nil for no errors, the single *ValidationError for one, and v otherwise.
+func (v ValidationErrors) asError() error {
+	switch len(v) {
+	case 0:
+		return nil
+	case 1:
+		return v[0]
+	default:
+		return v
+	}
+}
+
+// toValidationErrors flattens err into ValidationErrors. The boolean result is false when
+// err is not a validation failure, in which case it should be returned unchanged.
+// Bare errors produced by caller-supplied validators are wrapped with the given key, rule, and
+// actual type; an empty rule reports them as non-validation errors instead. Caller-built
+// ValidationErrors without a key or rule inherit the given ones.
+func toValidationErrors(err error, key, rule, actual string) (ValidationErrors, bool) {
+	var list ValidationErrors
+	if errors.As(err, &list) {
+		for _, e := range list {
+			fillValidationError(e, key, rule)
+		}
+		return list, true
+	}
+	var single *ValidationError
+	if errors.As(err, &single) {
+		fillValidationError(single, key, rule)
+		return ValidationErrors{single}, true
+	}
+	if rule == "" {
+		return nil, false
+	}
+	return ValidationErrors{{Key: key, Rule: rule, Actual: actual, Message: err.Error(), Err: err}}, true
+}
+
+// fillValidationError defaults the key and rule of a caller-built ValidationError.
+func fillValidationError(e *ValidationError, key, rule string) {
+	if e.Key == "" {
+		e.Key = key
+	}
+	if e.Rule == "" {
+		e.Rule = rule
+	}
+}
+
+// actualType returns the Go type name of value for ValidationError.Actual.
+func actualType(value interface{}) string {
+	if value == nil {
+		return "nil"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,86 @@
+package userprefs
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+var errReservedName = errors.New("reserved name")
+
+func TestValidateValue_ValidationError(t *testing.T) {
+	err := validateValue("loud", PreferenceDefinition{Key: "volume", Type: IntType})
+
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected *ValidationError, got %v", err)
+	}
+	if verr.Key != "volume" || verr.Rule != RuleType || verr.Expected != IntType || verr.Actual != "string" {
+		t.Errorf("Unexpected validation error fields: %+v", verr)
+	}
+	if !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("Expected error to match ErrInvalidValue")
+	}
+
+	err = validateValue("blue", PreferenceDefinition{Key: "theme", Type: StringType, AllowedValues: []interface{}{"light", "dark"}})
+	if !errors.As(err, &verr) || verr.Rule != RuleAllowedValues {
+		t.Errorf("Expected allowed_values rule, got %v", err)
+	}
+}
+
+func TestManager_Set_CustomValidationError(t *testing.T) {
+	ctx := context.Background()
+	mgr := newTestManager(t, []PreferenceDefinition{{
+		Key:  "nickname",
+		Type: StringType,
+		ValidateFunc: func(value interface{}) error {
+			if value == "admin" {
+				return errReservedName
+			}
+			return nil
+		},
+	}})
+
+	err := mgr.Set(ctx, "u1", "nickname", "admin")
+	var verr *ValidationError
+	if !errors.As(err, &verr) || verr.Rule != RuleCustom || verr.Key != "nickname" {
+		t.Fatalf("Expected custom rule failure for nickname, got %v", err)
+	}
+	if !errors.Is(err, errReservedName) || !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("Expected error to match both the custom error and ErrInvalidValue, got %v", err)
+	}
+}
+
+func TestManager_SetMany_AggregatesValidationErrors(t *testing.T) {
+	ctx := context.Background()
+	keyedFailure := func(_ context.Context, _ string, _, proposed map[string]interface{}) error {
+		if proposed["theme"] == "dark" && proposed["contrast"] == "low" {
+			return &ValidationError{Key: "contrast", Message: "low contrast is unavailable in dark mode"}
+		}
+		return nil
+	}
+	mgr := newTestManager(t, []PreferenceDefinition{
+		{Key: "theme", Type: StringType, AllowedValues: []interface{}{"light", "dark"}},
+		{Key: "contrast", Type: StringType},
+		{Key: "volume", Type: IntType, Max: floatPtr(10)},
+	}, WithCrossFieldValidator(keyedFailure))
+
+	err := mgr.SetMany(ctx, "u1", map[string]interface{}{"theme": "blue", "volume": 11, "contrast": 1})
+	var verrs ValidationErrors
+	if !errors.As(err, &verrs) {
+		t.Fatalf("Expected ValidationErrors, got %v", err)
+	}
+	if len(verrs) != 3 {
+		t.Fatalf("Expected one error per key, got %v", verrs)
+	}
+	for key, rule := range map[string]string{"theme": RuleAllowedValues, "volume": RuleMax, "contrast": RuleType} {
+		if got := verrs.ForKey(key); len(got) != 1 || got[0].Rule != rule {
+			t.Errorf("Expected %s rule for %s, got %v", rule, key, got)
+		}
+	}
+
+	err = mgr.SetMany(ctx, "u1", map[string]interface{}{"theme": "dark", "contrast": "low"})
+	if !errors.As(err, &verrs) || len(verrs) != 1 || verrs[0].Key != "contrast" || verrs[0].Rule != RuleCrossField {
+		t.Errorf("Expected keyed cross-field error, got %v", err)
+	}
+}
@@ -7,7 +7,7 @@ import (
 
 func TestIsValidType(t *testing.T) {
 	validTypesList := []string{StringType, BoolType, IntType, FloatType, JSONType}
-	invalidTypesList := []string{"invalid", "list", "", "integer", "boolean", "number", "enum"}
+	invalidTypesList := []string{"invalid", "list", "", "integer", "boolean", "number", "set"}
 
 	for _, tt := range validTypesList {
 		if !isValidType(tt) {